@@ -0,0 +1,126 @@
+package golightrag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Change is an entry of the change log of an entity or relationship, recorded by WithChangeLog
+// when a merge changes its description.
+type Change struct {
+	// Key identifies the entity or relationship whose change log the change belongs to.
+	Key string
+	// Entity is the name of the changed entity, empty for a relationship.
+	Entity string
+	// SourceEntity and TargetEntity are the entities of the changed relationship, empty for an
+	// entity.
+	SourceEntity string
+	TargetEntity string
+	// SourceID is the IDs of the source chunks whose extractions made the change, separated by
+	// GraphFieldSeparator.
+	SourceID string
+	// Description is the description after the change. When Appended is true, it only holds
+	// the descriptions the change appended to the previous one, separated by
+	// GraphFieldSeparator, so merges don't copy the whole description into the log.
+	Description string
+	// Appended reports whether the change appended Description to the previous description.
+	Appended bool
+	// PreviousDescription is the description the change replaced, such as by a summary. It's
+	// empty if the change created the entity or relationship, or appended to its description.
+	PreviousDescription string
+	// UpdateCount is the UpdateCount of the entity or relationship after the change.
+	UpdateCount int
+	Time        time.Time
+}
+
+// DescriptionAfter returns the whole description after the change, given the description
+// before it.
+func (c Change) DescriptionAfter(previous string) string {
+	if !c.Appended || previous == "" {
+		return c.Description
+	}
+	return previous + GraphFieldSeparator + c.Description
+}
+
+// descriptionChange sets the description fields of change from the description before and
+// after it, keeping only the appended descriptions when the description was added to.
+func descriptionChange(change Change, previous, current string) Change {
+	if appended, ok := strings.CutPrefix(current, previous+GraphFieldSeparator); ok && previous != "" {
+		change.Description = appended
+		change.Appended = true
+		return change
+	}
+	change.PreviousDescription = previous
+	change.Description = current
+	return change
+}
+
+// ChangeLogStorage is implemented by storages that can store the append-only change logs of
+// WithChangeLog. EntityChanges and RelationshipChanges read them back.
+type ChangeLogStorage interface {
+	// KVAppendChanges appends the changes, in order, to the change logs of their Key.
+	KVAppendChanges(changes []Change) error
+	// KVChanges retrieves the change log of the key, oldest first.
+	// If the key has no changes, it should return an empty log.
+	KVChanges(key string) ([]Change, error)
+}
+
+// WithChangeLog records a Change every time a merge of Insert, InsertBatch or
+// ProcessUnprocessedChunk changes the description of an entity or relationship, with the
+// storage's ChangeLogStorage.
+func WithChangeLog() Option {
+	return option("WithChangeLog", callsExtracting, func(o *options) {
+		o.changeLog = true
+	})
+}
+
+// EntityChanges returns the change log of the entity recorded by WithChangeLog, oldest first.
+// The storage must implement ChangeLogStorage.
+func EntityChanges(storage Storage, name string) ([]Change, error) {
+	return changes(storage, entityChangeKey(name))
+}
+
+// RelationshipChanges returns the change log of the relationship between the entities recorded
+// by WithChangeLog, oldest first. Relationships are undirected, so the entities may be given
+// either way round. The storage must implement ChangeLogStorage.
+func RelationshipChanges(storage Storage, sourceEntity, targetEntity string) ([]Change, error) {
+	return changes(storage, relationshipChangeKey(sourceEntity, targetEntity))
+}
+
+func changes(storage Storage, key string) ([]Change, error) {
+	changeLog, ok := storage.(ChangeLogStorage)
+	if !ok {
+		return nil, errors.New("change log requires a storage implementing ChangeLogStorage")
+	}
+	result, err := changeLog.KVChanges(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changes: %w", err)
+	}
+	return result, nil
+}
+
+func entityChangeKey(name string) string {
+	return "entity:" + name
+}
+
+// relationshipChangeKey orders the entities, so both directions of a relationship share a
+// change log.
+func relationshipChangeKey(sourceEntity, targetEntity string) string {
+	if targetEntity < sourceEntity {
+		sourceEntity, targetEntity = targetEntity, sourceEntity
+	}
+	return "relationship:" + sourceEntity + GraphFieldSeparator + targetEntity
+}
+
+// appendChange appends change to changeLog, unless the change log is disabled.
+func appendChange(changeLog ChangeLogStorage, change Change) error {
+	if changeLog == nil {
+		return nil
+	}
+	if err := changeLog.KVAppendChanges([]Change{change}); err != nil {
+		return fmt.Errorf("failed to append change: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package golightrag
+
+import (
+	"iter"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DocumentResult is the result of the insertion of a document by InsertBatch.
+type DocumentResult struct {
+	DocumentID string
+	// Chunks is the number of chunks the document was split into, zero if it failed before
+	// being chunked.
+	Chunks int
+	// Err is the error that failed the insertion of the document, nil if it succeeded.
+	Err      error
+	Duration time.Duration
+}
+
+// BatchProgress is the progress of InsertBatch, reported by WithProgress.
+type BatchProgress struct {
+	// Documents is the number of documents read from the batch so far, DocumentsDone the
+	// number of them inserted and DocumentsFailed the number of them whose insertion failed.
+	Documents       int
+	DocumentsDone   int
+	DocumentsFailed int
+	// Chunks is the number of chunks of the documents chunked so far, and ChunksDone the number
+	// of them whose extraction is done.
+	Chunks     int
+	ChunksDone int
+	Elapsed    time.Duration
+	// ETA is the estimated time left to insert the documents read so far, from the rate chunks
+	// were extracted at, and so the whole batch once it's all read. The chunks of the documents
+	// not chunked yet are estimated from the average of the others. It's zero until a chunk is
+	// done.
+	ETA time.Duration
+	// Result is the result of the document whose insertion just finished, nil if the progress
+	// isn't reported for a finished document.
+	Result *DocumentResult
+}
+
+// WithProgress calls fn with the progress of InsertBatch whenever a document is read, chunked
+// or finished, and whenever the extraction of a chunk is done. The calls are sequential, and
+// the batch waits for them, so fn should return quickly.
+func WithProgress(fn func(BatchProgress)) Option {
+	return option("WithProgress", callInsertBatch, func(o *options) {
+		o.progress = fn
+	})
+}
+
+// InsertBatch inserts the documents like Insert, with their chunks extracted on one pool of
+// ConcurrencyCount workers of the handler shared by the whole batch, rather than one document
+// at a time. The documents are read from docs as they're needed, up to ConcurrencyCount
+// documents being inserted at once, so docs can produce them lazily. Use slices.Values to
+// insert a slice.
+//
+// A document failing doesn't stop the others. InsertBatch returns the results of the documents
+// in the order they were read. It returns an error only if the batch couldn't be inserted,
+// such as the workspace of WithWorkspace not opening, or if updating the centrality or the
+// communities failed once the documents were inserted. Use WithProgress to follow the batch.
+func InsertBatch(
+	docs iter.Seq[Document],
+	handler DocumentHandler,
+	storage Storage,
+	llm LLM,
+	logger *slog.Logger,
+	opts ...Option,
+) ([]DocumentResult, error) {
+	o, err := newOptions(callInsertBatch, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	logger = logger.With(
+		slog.String("package", "golightrag"),
+		slog.String("function", "InsertBatch"),
+	)
+
+	o.scope = newMergeScope(storage, o.workspace)
+	storage, err = o.workspaceStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	llms := o.stageLLMs(llm)
+	workers := newWorkers(handler)
+	merged := &mergedEntities{}
+	tracker := batchTracker{start: time.Now(), report: o.progress}
+
+	var mu sync.Mutex
+	var results []DocumentResult
+	var wg sync.WaitGroup
+	// Limit the documents being inserted at once, so docs is read as the workers free up
+	documents := make(chan struct{}, cap(workers))
+	for doc := range docs {
+		documents <- struct{}{}
+
+		mu.Lock()
+		index := len(results)
+		results = append(results, DocumentResult{DocumentID: doc.ID})
+		mu.Unlock()
+		tracker.read()
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-documents
+				wg.Done()
+			}()
+
+			start := time.Now()
+			chunks := -1
+			err := insertDocument(doc, handler, storage, llms, o, workers, insertProgress{
+				chunked: func(count int) {
+					chunks = count
+					tracker.chunked(count)
+				},
+				chunkDone: tracker.chunkDone,
+				merged:    merged,
+			}, logger.With(slog.String("document", doc.ID)))
+			if err != nil {
+				logger.Error("Failed to insert document", "document", doc.ID, "error", err)
+			}
+
+			mu.Lock()
+			result := &results[index]
+			result.Chunks = max(chunks, 0)
+			result.Err = err
+			result.Duration = time.Since(start)
+			finished := *result
+			mu.Unlock()
+			tracker.finished(finished, chunks >= 0)
+		}()
+	}
+	wg.Wait()
+
+	logger.Info("Inserted batch", "documents", len(results), "failed", tracker.progress.DocumentsFailed)
+
+	if tracker.progress.DocumentsDone > 0 {
+		if err := updateGraphAnalytics(storage, o, llms, merged.list(), logger); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// batchTracker keeps the progress of InsertBatch and reports it.
+type batchTracker struct {
+	mu       sync.Mutex
+	start    time.Time
+	progress BatchProgress
+	// chunkedDocuments is the number of documents chunked so far, and unchunkedDocuments the
+	// number of the documents read but neither chunked nor failed yet.
+	chunkedDocuments   int
+	unchunkedDocuments int
+	report             func(BatchProgress)
+}
+
+func (t *batchTracker) read() {
+	t.update(nil, func(p *BatchProgress) {
+		p.Documents++
+		t.unchunkedDocuments++
+	})
+}
+
+func (t *batchTracker) chunked(count int) {
+	t.update(nil, func(p *BatchProgress) {
+		p.Chunks += count
+		t.chunkedDocuments++
+		t.unchunkedDocuments--
+	})
+}
+
+func (t *batchTracker) chunkDone() {
+	t.update(nil, func(p *BatchProgress) {
+		p.ChunksDone++
+	})
+}
+
+func (t *batchTracker) finished(result DocumentResult, chunked bool) {
+	t.update(&result, func(p *BatchProgress) {
+		if result.Err != nil {
+			p.DocumentsFailed++
+		} else {
+			p.DocumentsDone++
+		}
+		if !chunked {
+			t.unchunkedDocuments--
+		}
+	})
+}
+
+func (t *batchTracker) update(result *DocumentResult, fn func(p *BatchProgress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fn(&t.progress)
+	t.progress.Elapsed = time.Since(t.start)
+	t.progress.ETA = 0
+	if t.progress.ChunksDone > 0 && t.chunkedDocuments > 0 {
+		averageChunks := float64(t.progress.Chunks) / float64(t.chunkedDocuments)
+		remaining := float64(t.progress.Chunks-t.progress.ChunksDone) + float64(t.unchunkedDocuments)*averageChunks
+		t.progress.ETA = time.Duration(remaining * float64(t.progress.Elapsed) / float64(t.progress.ChunksDone))
+	}
+
+	if t.report != nil {
+		progress := t.progress
+		progress.Result = result
+		t.report(progress)
+	}
+}
@@ -0,0 +1,407 @@
+package golightrag
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+)
+
+// CentralityConfig configures the PageRank scores computed by UpdateCentrality, and the
+// personalized PageRank of Ranking.
+type CentralityConfig struct {
+	// Damping is the probability of following a relationship rather than jumping to a random
+	// entity. Defaults to 0.85.
+	Damping float64
+	// MaxIterations is the maximum number of power iterations. Defaults to 100.
+	MaxIterations int
+	// Tolerance stops the iterations when the scores change by less than it in total.
+	// Defaults to 1e-6.
+	Tolerance float64
+}
+
+// Ranking weighs the signals ordering the entities and relationships of the Query context.
+// Each signal is divided by its maximum among the entities of the context, and the rank of an
+// entity is the weighted sum of its signals. The rank of a relationship is the sum of the ranks
+// of its entities. Degree alone, the default without WithRanking, ranks as the reference counts
+// do.
+type Ranking struct {
+	// Degree weighs the number of relationships of the entity.
+	Degree float64
+	// PageRank and Betweenness weigh the scores stored on the entities by UpdateCentrality.
+	PageRank    float64
+	Betweenness float64
+	// PersonalizedPageRank weighs the PageRank seeded from the entities of the context, which
+	// favors the entities central to the query rather than to the whole graph. It's computed on
+	// every query, over the whole graph, so the storage must implement GraphListStorage.
+	// Every query then reads all the entities and relationships of the graph, and iterates over
+	// them up to Centrality.MaxIterations times, which is only practical for small graphs.
+	PersonalizedPageRank float64
+	// Centrality configures the personalized PageRank.
+	Centrality CentralityConfig
+}
+
+const (
+	defaultCentralityDamping       = 0.85
+	defaultCentralityMaxIterations = 100
+	defaultCentralityTolerance     = 1e-6
+)
+
+// UpdateCentrality computes the PageRank and betweenness of every entity of the graph, and
+// stores them in GraphEntity.PageRank and GraphEntity.Betweenness, where WithRanking can use
+// them. Only the entities whose scores changed are upserted.
+// The storage must implement GraphListStorage.
+//
+// Each score is stored under the merge lock of its entity, shared with the insertions into the
+// same storage. A storage returned by InWorkspace doesn't share it with the insertions into its
+// parent storage made with WithWorkspace.
+//
+// The scores depend on the whole graph, so it's read entirely and the betweenness takes
+// O(V·E) time, for V entities and E relationships. On large graphs, call it periodically rather
+// than after every insertion.
+func UpdateCentrality(storage Storage, config CentralityConfig, logger *slog.Logger) error {
+	logger = logger.With(
+		slog.String("package", "golightrag"),
+		slog.String("function", "UpdateCentrality"),
+	)
+	return updateCentrality(storage, config, newMergeScope(storage, ""), logger)
+}
+
+// WithCentralityUpdate updates the centrality scores as configured by c at the end of Insert,
+// InsertBatch and ProcessUnprocessedChunk, as UpdateCentrality does. Every insertion then
+// recomputes the scores of the whole graph, which takes O(V·E) time, so it's meant for small
+// graphs. Prefer calling UpdateCentrality periodically, or once after InsertBatch, otherwise.
+func WithCentralityUpdate(c CentralityConfig) Option {
+	return option("WithCentralityUpdate", callsExtracting, func(o *options) {
+		o.centrality = &c
+	})
+}
+
+// WithRanking orders the entities and relationships of the Query context by the signals
+// weighed by r, instead of their reference counts.
+func WithRanking(r Ranking) Option {
+	return option("WithRanking", callQuery, func(o *options) {
+		o.ranking = &r
+	})
+}
+
+func (c CentralityConfig) withDefaults() CentralityConfig {
+	if c.Damping == 0 {
+		c.Damping = defaultCentralityDamping
+	}
+	if c.MaxIterations == 0 {
+		c.MaxIterations = defaultCentralityMaxIterations
+	}
+	if c.Tolerance == 0 {
+		c.Tolerance = defaultCentralityTolerance
+	}
+	return c
+}
+
+func updateCentrality(storage Storage, config CentralityConfig, scope mergeScope, logger *slog.Logger) error {
+	listStorage, ok := storage.(GraphListStorage)
+	if !ok {
+		return errors.New("centrality requires a storage implementing GraphListStorage")
+	}
+
+	entities, err := listStorage.GraphAllEntities()
+	if err != nil {
+		return fmt.Errorf("failed to list entities: %w", err)
+	}
+	relationships, err := listStorage.GraphAllRelationships()
+	if err != nil {
+		return fmt.Errorf("failed to list relationships: %w", err)
+	}
+	graph, index := newEntityGraph(entities, relationships)
+	pageRanks := graph.pageRank(nil, config.withDefaults())
+	betweenness := graph.betweenness()
+
+	updated := 0
+	for _, entity := range entities {
+		i := index[entity.Name]
+		if entity.PageRank == pageRanks[i] && entity.Betweenness == betweenness[i] {
+			continue
+		}
+		ok, err := updateEntityCentrality(storage, scope, entity.Name, pageRanks[i], betweenness[i])
+		if err != nil {
+			return err
+		}
+		if ok {
+			updated++
+		}
+	}
+
+	logger.Info("Updated centrality", "entities", len(entities), "updated", updated)
+
+	return nil
+}
+
+// updateEntityCentrality stores the scores of the entity. The entity is read again under its merge
+// lock, so the scores don't overwrite a merge done by a concurrent insertion since the graph was
+// listed. It reports false if the entity was deleted in the meantime.
+func updateEntityCentrality(
+	storage Storage,
+	scope mergeScope,
+	name string,
+	pageRank, betweenness float64,
+) (bool, error) {
+	unlock := scope.lock(entityChangeKey(name))
+	defer unlock()
+
+	entity, err := storage.GraphEntity(name)
+	if errors.Is(err, ErrEntityNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get entity %s: %w", name, err)
+	}
+	entity.PageRank = pageRank
+	entity.Betweenness = betweenness
+	if err := storage.GraphUpsertEntity(entity); err != nil {
+		return false, fmt.Errorf("failed to upsert entity %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// sortedNeighbors returns the neighbors of every node in index order, without self-loops, so
+// sums over them are deterministic.
+func (g *weightedGraph) sortedNeighbors() [][]int {
+	neighbors := make([][]int, len(g.adj))
+	for i, edges := range g.adj {
+		neighbors[i] = slices.DeleteFunc(slices.Sorted(maps.Keys(edges)), func(j int) bool { return j == i })
+	}
+	return neighbors
+}
+
+// pageRank returns the weighted PageRank of every node. Random jumps land on the nodes in
+// proportion to personalization, or uniformly if it's nil or all zero, and so does the score of
+// the nodes without relationships.
+func (g *weightedGraph) pageRank(personalization []float64, config CentralityConfig) []float64 {
+	n := len(g.adj)
+	if n == 0 {
+		return nil
+	}
+	jump := make([]float64, n)
+	total := 0.0
+	for _, p := range personalization {
+		total += p
+	}
+	for i := range jump {
+		if total > 0 {
+			jump[i] = personalization[i] / total
+		} else {
+			jump[i] = 1 / float64(n)
+		}
+	}
+
+	neighbors := g.sortedNeighbors()
+	ranks := slices.Clone(jump)
+	next := make([]float64, n)
+	for range config.MaxIterations {
+		dangling := 0.0
+		for i, rank := range ranks {
+			if len(neighbors[i]) == 0 {
+				dangling += rank
+			}
+		}
+		for j := range next {
+			next[j] = (1 - config.Damping + config.Damping*dangling) * jump[j]
+		}
+		for i, rank := range ranks {
+			// Self-loops are left out of the walk, so only the edges to other nodes count.
+			out := g.degree[i] - g.adj[i][i]
+			for _, j := range neighbors[i] {
+				next[j] += config.Damping * rank * g.adj[i][j] / out
+			}
+		}
+
+		change := 0.0
+		for i := range ranks {
+			change += math.Abs(next[i] - ranks[i])
+		}
+		ranks, next = next, ranks
+		if change < config.Tolerance {
+			break
+		}
+	}
+	return ranks
+}
+
+// betweenness returns the betweenness centrality of every node, the fraction of the shortest
+// paths between other nodes going through it, computed with Brandes' algorithm over the
+// unweighted graph.
+func (g *weightedGraph) betweenness() []float64 {
+	n := len(g.adj)
+	neighbors := g.sortedNeighbors()
+	centrality := make([]float64, n)
+
+	for s := range n {
+		stack := make([]int, 0, n)
+		predecessors := make([][]int, n)
+		// paths is the number of shortest paths from s to each node, and distance their length.
+		paths := make([]float64, n)
+		distance := make([]int, n)
+		for i := range distance {
+			distance[i] = -1
+		}
+		paths[s] = 1
+		distance[s] = 0
+
+		queue := []int{s}
+		for len(queue) > 0 {
+			v := queue[0]
+			queue = queue[1:]
+			stack = append(stack, v)
+			for _, w := range neighbors[v] {
+				if distance[w] < 0 {
+					distance[w] = distance[v] + 1
+					queue = append(queue, w)
+				}
+				if distance[w] == distance[v]+1 {
+					paths[w] += paths[v]
+					predecessors[w] = append(predecessors[w], v)
+				}
+			}
+		}
+
+		dependency := make([]float64, n)
+		for i := len(stack) - 1; i >= 0; i-- {
+			w := stack[i]
+			for _, v := range predecessors[w] {
+				dependency[v] += paths[v] / paths[w] * (1 + dependency[w])
+			}
+			if w != s {
+				centrality[w] += dependency[w]
+			}
+		}
+	}
+
+	// Every path of the undirected graph is counted from both ends.
+	pairs := float64(n-1) * float64(n-2)
+	for i := range centrality {
+		if pairs > 0 {
+			centrality[i] /= pairs
+		} else {
+			centrality[i] = 0
+		}
+	}
+	return centrality
+}
+
+// rankContexts sets the Rank of the entities and relationships of result as weighed by
+// ranking.
+func rankContexts(result *QueryResult, ranking Ranking, storage Storage) error {
+	contexts := slices.Concat(result.LocalEntities, result.GlobalEntities)
+	names := make([]string, 0, len(contexts))
+	for _, entity := range contexts {
+		names = appendIfUnique(names, entity.Name)
+	}
+	seeds := slices.Clone(names)
+	for _, relationship := range slices.Concat(result.LocalRelationships, result.GlobalRelationships) {
+		names = appendIfUnique(names, relationship.Source)
+		names = appendIfUnique(names, relationship.Target)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	// signals holds the signals of each entity, in the order of their weights.
+	weights := []float64{ranking.Degree, ranking.PageRank, ranking.Betweenness, ranking.PersonalizedPageRank}
+	signals := make(map[string][]float64, len(names))
+	for _, name := range names {
+		signals[name] = make([]float64, len(weights))
+	}
+
+	if ranking.Degree != 0 {
+		degrees, err := storage.GraphCountEntitiesRelationships(names)
+		if err != nil {
+			return fmt.Errorf("failed to batch count relationships: %w", err)
+		}
+		for name, degree := range degrees {
+			if s, ok := signals[name]; ok {
+				s[0] = float64(degree)
+			}
+		}
+	}
+	if ranking.PageRank != 0 || ranking.Betweenness != 0 {
+		entities, err := storage.GraphEntities(names)
+		if err != nil {
+			return fmt.Errorf("failed to batch get entities: %w", err)
+		}
+		for name, entity := range entities {
+			if s, ok := signals[name]; ok {
+				s[1] = entity.PageRank
+				s[2] = entity.Betweenness
+			}
+		}
+	}
+	if ranking.PersonalizedPageRank != 0 {
+		ranks, err := personalizedPageRank(seeds, ranking.Centrality, storage)
+		if err != nil {
+			return err
+		}
+		for name, s := range signals {
+			s[3] = ranks[name]
+		}
+	}
+
+	maxSignals := make([]float64, len(weights))
+	for _, s := range signals {
+		for i, signal := range s {
+			maxSignals[i] = max(maxSignals[i], signal)
+		}
+	}
+	ranks := make(map[string]float64, len(signals))
+	for name, s := range signals {
+		for i, signal := range s {
+			if maxSignals[i] > 0 {
+				ranks[name] += weights[i] * signal / maxSignals[i]
+			}
+		}
+	}
+
+	for _, entities := range [][]EntityContext{result.LocalEntities, result.GlobalEntities} {
+		for i := range entities {
+			entities[i].Rank = ranks[entities[i].Name]
+		}
+	}
+	for _, relationships := range [][]RelationshipContext{result.LocalRelationships, result.GlobalRelationships} {
+		for i := range relationships {
+			relationships[i].Rank = ranks[relationships[i].Source] + ranks[relationships[i].Target]
+		}
+	}
+	return nil
+}
+
+// personalizedPageRank returns the PageRank of the entities of the graph seeded from seeds.
+func personalizedPageRank(seeds []string, config CentralityConfig, storage Storage) (map[string]float64, error) {
+	listStorage, ok := storage.(GraphListStorage)
+	if !ok {
+		return nil, errors.New("personalized PageRank requires a storage implementing GraphListStorage")
+	}
+	entities, err := listStorage.GraphAllEntities()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list entities: %w", err)
+	}
+	relationships, err := listStorage.GraphAllRelationships()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list relationships: %w", err)
+	}
+	graph, index := newEntityGraph(entities, relationships)
+
+	personalization := make([]float64, len(entities))
+	for _, seed := range seeds {
+		if i, ok := index[seed]; ok {
+			personalization[i] = 1
+		}
+	}
+	ranks := graph.pageRank(personalization, config.withDefaults())
+
+	result := make(map[string]float64, len(entities))
+	for name, i := range index {
+		result[name] = ranks[i]
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package golightrag_test
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+)
+
+func TestUpdateCentrality(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// A star centered on HUB, and an isolated entity.
+	storage := &MockStorage{
+		entities:      make(map[string]golightrag.GraphEntity),
+		relationships: make(map[string]golightrag.GraphRelationship),
+	}
+	for _, name := range []string{"HUB", "A", "B", "C", "ALONE"} {
+		_ = storage.GraphUpsertEntity(golightrag.GraphEntity{Name: name, Type: "PERSON"})
+	}
+	for _, leaf := range []string{"A", "B", "C"} {
+		_ = storage.GraphUpsertRelationship(golightrag.GraphRelationship{SourceEntity: "HUB", TargetEntity: leaf, Weight: 1})
+	}
+
+	if err := golightrag.UpdateCentrality(storage, golightrag.CentralityConfig{}, logger); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	total := 0.0
+	for _, entity := range storage.entities {
+		total += entity.PageRank
+	}
+	if math.Abs(total-1) > 1e-4 {
+		t.Errorf("Expected the PageRanks to sum to 1, got %f", total)
+	}
+	hub := storage.entities["HUB"]
+	for _, name := range []string{"A", "ALONE"} {
+		if entity := storage.entities[name]; entity.PageRank >= hub.PageRank {
+			t.Errorf("Expected HUB to outrank %s, got %f and %f", name, hub.PageRank, entity.PageRank)
+		}
+	}
+	// HUB is on the shortest paths of the 3 pairs of leaves, out of the 6 pairs of other entities.
+	if math.Abs(hub.Betweenness-0.5) > 1e-9 {
+		t.Errorf("Expected the betweenness of HUB to be 0.5, got %f", hub.Betweenness)
+	}
+	if leaf := storage.entities["A"]; leaf.Betweenness != 0 {
+		t.Errorf("Expected the betweenness of a leaf to be 0, got %f", leaf.Betweenness)
+	}
+
+	storage.graphUpsertEntityCalled = false
+	if err := golightrag.UpdateCentrality(storage, golightrag.CentralityConfig{}, logger); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if storage.graphUpsertEntityCalled {
+		t.Errorf("Expected no upserts when the scores are unchanged")
+	}
+}
+
+// concurrentMergeStorage merges a new description into an entity right after the graph is
+// listed, as a concurrent insertion would.
+type concurrentMergeStorage struct {
+	*MockStorage
+}
+
+func (s concurrentMergeStorage) GraphAllEntities() ([]golightrag.GraphEntity, error) {
+	entities, err := s.MockStorage.GraphAllEntities()
+	hub := s.entities["HUB"]
+	hub.Descriptions = "Merged after listing"
+	s.entities["HUB"] = hub
+	return entities, err
+}
+
+func TestUpdateCentralityKeepsConcurrentMerges(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	storage := concurrentMergeStorage{MockStorage: &MockStorage{
+		entities:      make(map[string]golightrag.GraphEntity),
+		relationships: make(map[string]golightrag.GraphRelationship),
+	}}
+	for _, name := range []string{"HUB", "A", "B"} {
+		_ = storage.GraphUpsertEntity(golightrag.GraphEntity{Name: name, Type: "PERSON", Descriptions: "Listed"})
+	}
+	for _, leaf := range []string{"A", "B"} {
+		_ = storage.GraphUpsertRelationship(golightrag.GraphRelationship{SourceEntity: "HUB", TargetEntity: leaf, Weight: 1})
+	}
+
+	if err := golightrag.UpdateCentrality(storage, golightrag.CentralityConfig{}, logger); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	hub := storage.entities["HUB"]
+	if hub.Descriptions != "Merged after listing" {
+		t.Errorf("Expected the concurrent merge to be kept, got description %q", hub.Descriptions)
+	}
+	if hub.PageRank == 0 || hub.Betweenness == 0 {
+		t.Errorf("Expected the scores of HUB to be stored, got %f and %f", hub.PageRank, hub.Betweenness)
+	}
+}
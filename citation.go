@@ -0,0 +1,169 @@
+package golightrag
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Citation is a source chunk supporting an entity or relationship of a QueryResult, with the
+// document it belongs to.
+type Citation struct {
+	SourceID   string
+	DocumentID string
+	Origin     Origin
+}
+
+// Reference is a document cited by a QueryResult, to render as a footnote of the answer.
+type Reference struct {
+	// Number is the position of the reference in QueryResult.References, starting at 1.
+	Number     int
+	DocumentID string
+	Origin     Origin
+	// SourceIDs are the IDs of the chunks of the document supporting the result.
+	SourceIDs []string
+}
+
+// References returns the documents cited by the entities, relationships and sources of the
+// result, each once, ordered by the number of elements citing them. Chunks whose document is
+// unknown are left out.
+func (q QueryResult) References() []Reference {
+	references := make(map[string]*Reference)
+	counts := make(map[string]int)
+	cite := func(citation Citation) {
+		key := referenceKey(citation.DocumentID, citation.Origin)
+		if key == "" {
+			return
+		}
+		reference, ok := references[key]
+		if !ok {
+			reference = &Reference{DocumentID: citation.DocumentID, Origin: citation.Origin}
+			references[key] = reference
+		}
+		reference.SourceIDs = appendIfUnique(reference.SourceIDs, citation.SourceID)
+		counts[key]++
+	}
+
+	for _, entity := range slices.Concat(q.LocalEntities, q.GlobalEntities) {
+		for _, citation := range entity.Citations {
+			cite(citation)
+		}
+	}
+	for _, relationship := range slices.Concat(q.LocalRelationships, q.GlobalRelationships) {
+		for _, citation := range relationship.Citations {
+			cite(citation)
+		}
+	}
+	for _, source := range slices.Concat(q.LocalSources, q.GlobalSources) {
+		cite(Citation{SourceID: source.SourceId, DocumentID: source.DocumentID, Origin: source.Origin})
+	}
+
+	keys := make([]string, 0, len(references))
+	for key := range references {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+
+	result := make([]Reference, len(keys))
+	for i, key := range keys {
+		reference := *references[key]
+		reference.Number = i + 1
+		slices.Sort(reference.SourceIDs)
+		result[i] = reference
+	}
+	return result
+}
+
+// String returns a CSV-formatted string representation of the Reference.
+func (r Reference) String() string {
+	return fmt.Sprintf("%q,%q,%q,%q,%q", strconv.Itoa(r.Number), r.DocumentID, r.Origin.Type, r.Origin.Location,
+		strings.Join(r.SourceIDs, GraphFieldSeparator))
+}
+
+// referenceKey identifies the document of a citation, by its ID or else its origin.
+func referenceKey(documentID string, origin Origin) string {
+	if documentID != "" {
+		return "document:" + documentID
+	}
+	if origin.Location != "" {
+		return "origin:" + string(origin.Type) + ":" + origin.Location
+	}
+	return ""
+}
+
+// sourceDocumentID returns the DocumentID of source, or for sources stored without one, the
+// document ID of the chunk IDs generated by Insert.
+func sourceDocumentID(source Source) string {
+	if source.DocumentID != "" {
+		return source.DocumentID
+	}
+	docID, _, found := strings.Cut(source.ID, "-chunk-")
+	if !found {
+		return ""
+	}
+	return docID
+}
+
+// splitSourceIDs returns the source IDs joined by GraphFieldSeparator in sourceIDs.
+func splitSourceIDs(sourceIDs string) []string {
+	result := make([]string, 0)
+	for id := range strings.SplitSeq(sourceIDs, GraphFieldSeparator) {
+		if id != "" {
+			result = appendIfUnique(result, id)
+		}
+	}
+	return result
+}
+
+// citeContexts sets the Citations of the entities and relationships of result from their
+// source IDs. The sources of the result are reused, and the other chunks are retrieved from
+// storage. Chunks missing from storage are cited without their document.
+func citeContexts(result *QueryResult, storage Storage) error {
+	citations := make(map[string]Citation)
+	for _, source := range slices.Concat(result.LocalSources, result.GlobalSources) {
+		citations[source.SourceId] = Citation{
+			SourceID:   source.SourceId,
+			DocumentID: source.DocumentID,
+			Origin:     source.Origin,
+		}
+	}
+	cite := func(sourceIDs []string) ([]Citation, error) {
+		cited := make([]Citation, 0, len(sourceIDs))
+		for _, id := range sourceIDs {
+			citation, ok := citations[id]
+			if !ok {
+				source, err := storage.KVSource(id)
+				if err != nil && !errors.Is(err, ErrSourceNotFound) {
+					return nil, fmt.Errorf("failed to get source with id %s: %w", id, err)
+				}
+				source.ID = id
+				citation = Citation{SourceID: id, DocumentID: sourceDocumentID(source), Origin: source.Origin}
+				citations[id] = citation
+			}
+			cited = append(cited, citation)
+		}
+		return cited, nil
+	}
+
+	var err error
+	for _, entities := range [][]EntityContext{result.LocalEntities, result.GlobalEntities} {
+		for i := range entities {
+			if entities[i].Citations, err = cite(entities[i].SourceIDs); err != nil {
+				return err
+			}
+		}
+	}
+	for _, relationships := range [][]RelationshipContext{result.LocalRelationships, result.GlobalRelationships} {
+		for i := range relationships {
+			if relationships[i].Citations, err = cite(relationships[i].SourceIDs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
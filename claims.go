@@ -0,0 +1,256 @@
+package golightrag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	llmod "github.com/MegaGrindStone/go-light-rag/llm"
+	jsonrepair "github.com/kaptinlin/jsonrepair"
+)
+
+// Claim is a factual assertion about an entity extracted from a source chunk, such as a
+// lawsuit, an acquisition or a regulatory fine, with the period it applies to. Unlike the
+// descriptions of entities, claims aren't merged or summarized, so each one keeps its status,
+// dates and the text it was extracted from.
+type Claim struct {
+	// ID identifies the claim by its source chunk and content, so extracting the same claim from
+	// the chunk again updates it rather than adding another one.
+	ID string
+	// Subject is the name of the entity the claim is about.
+	Subject string
+	// Object is the name of the entity affected by the claim, or empty if there is none.
+	Object string
+	// Type is a short category of the claim, such as ACQUISITION or LAWSUIT.
+	Type        string
+	Status      ClaimStatus
+	Description string
+	// StartDate and EndDate are the period the claim applies to, zero if the text doesn't
+	// state them.
+	StartDate time.Time
+	EndDate   time.Time
+	// SourceText is the quote of the source chunk supporting the claim.
+	SourceText string
+	SourceID   string
+}
+
+// ClaimStatus is whether a claim is confirmed, refuted or only suspected by its source.
+type ClaimStatus string
+
+// Defines the statuses of a Claim.
+const (
+	ClaimStatusTrue      ClaimStatus = "TRUE"
+	ClaimStatusFalse     ClaimStatus = "FALSE"
+	ClaimStatusSuspected ClaimStatus = "SUSPECTED"
+)
+
+// ClaimStorage is implemented by storages that can store the claims extracted with
+// WithClaimExtraction. Query returns the claims of the entities in its context when the
+// storage implements it.
+type ClaimStorage interface {
+	// KVUpsertClaims creates or updates multiple claims at once, by their ID.
+	KVUpsertClaims(claims []Claim) error
+	// KVClaimsByEntities batch retrieves the claims whose subject or object is one of the
+	// entities. Returns a map with entity names as keys and their claims as values.
+	// If an entity has no claims, it should be omitted from the result map.
+	KVClaimsByEntities(names []string) (map[string][]Claim, error)
+}
+
+// ClaimExtraction configures the extraction of claims from each chunk, after its entities
+// and relationships are extracted. The claims are about the entities extracted from the
+// same chunk, and are stored with the storage's ClaimStorage once the entities of the
+// document are merged, leaving out the claims whose subject wasn't stored.
+type ClaimExtraction struct {
+	// Description describes the claims to extract. Defaults to any claims or facts that could
+	// be relevant to information discovery.
+	Description string
+}
+
+const defaultClaimDescription = "Any claims or facts that could be relevant to information discovery."
+
+// claimDateLayout is the layout of the claim dates asked to the LLM.
+const claimDateLayout = "2006-01-02"
+
+type claimExtractionPromptData struct {
+	Description string
+	Entities    []string
+	Language    string
+	Input       string
+}
+
+// claimExtractionOutput is the claims parsed from a claim extraction response.
+type claimExtractionOutput struct {
+	Claims []struct {
+		Subject     string  `json:"subject"`
+		Object      *string `json:"object"`
+		Type        string  `json:"claim_type"`
+		Status      string  `json:"claim_status"`
+		Description string  `json:"claim_description"`
+		StartDate   *string `json:"start_date"`
+		EndDate     *string `json:"end_date"`
+		SourceText  string  `json:"source_text"`
+	} `json:"claims"`
+}
+
+// llmExtractClaims extracts the claims about entities from source. Claims whose subject isn't
+// one of entities are dropped, so every claim is linked to an entity of the graph.
+func llmExtractClaims(
+	source Source,
+	config ClaimExtraction,
+	entities []string,
+	language string,
+	policy ExtractionPolicy,
+	maxRetries int,
+	backoffDuration time.Duration,
+	llm LLM,
+	logger *slog.Logger,
+) ([]Claim, error) {
+	if len(entities) == 0 {
+		return nil, nil
+	}
+	description := config.Description
+	if description == "" {
+		description = defaultClaimDescription
+	}
+	slices.Sort(entities)
+	prompt, err := promptTemplate("extract-claims", claimExtractionPrompt, claimExtractionPromptData{
+		Description: description,
+		Entities:    entities,
+		Language:    language,
+		Input:       source.Content,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate claim extraction prompt: %w", err)
+	}
+
+	logger.Debug("Use LLM to extract claims from source", "claimPrompt", prompt, "source", source.ID)
+
+	var output claimExtractionOutput
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			time.Sleep(backoffDuration)
+		}
+		res, err := chatJSON(llm, []string{prompt}, claimExtractionSchema)
+		if err != nil {
+			if !llmod.IsRetryable(err) || retry >= maxRetries {
+				return nil, fmt.Errorf("failed to call LLM on claim extraction: %w", err)
+			}
+			logger.Warn("Retry claim extraction", "retry", retry+1, "error", err)
+			continue
+		}
+		repaired, _ := jsonrepair.JSONRepair(cleanExtractionResponse(res))
+		if err = json.Unmarshal([]byte(repaired), &output); err != nil {
+			if retry >= maxRetries {
+				return nil, fmt.Errorf("failed to unmarshal claim extraction output: %w", err)
+			}
+			logger.Warn("Retry parse claims", "retry", retry+1, "error", err)
+			continue
+		}
+		break
+	}
+
+	claims := make([]Claim, 0, len(output.Claims))
+	for _, extracted := range output.Claims {
+		subject, _ := policy.normalizeName(extracted.Subject)
+		if !slices.Contains(entities, subject) {
+			continue
+		}
+		claim := Claim{
+			Subject:     subject,
+			Type:        strings.ToUpper(strings.TrimSpace(extracted.Type)),
+			Status:      claimStatus(extracted.Status),
+			Description: strings.TrimSpace(extracted.Description),
+			StartDate:   claimDate(extracted.StartDate),
+			EndDate:     claimDate(extracted.EndDate),
+			SourceText:  strings.TrimSpace(extracted.SourceText),
+			SourceID:    source.ID,
+		}
+		if extracted.Object != nil && !strings.EqualFold(strings.TrimSpace(*extracted.Object), "NONE") {
+			claim.Object, _ = policy.normalizeName(*extracted.Object)
+		}
+		claim.ID = claimID(claim)
+		if slices.ContainsFunc(claims, func(c Claim) bool { return c.ID == claim.ID }) {
+			continue
+		}
+		claims = append(claims, claim)
+	}
+
+	logger.Info("Extracted claims from source", "source", source.ID, "claims", len(claims))
+
+	return claims, nil
+}
+
+// claimID returns the ID of claim, derived from its source chunk and the content extracted from
+// it.
+func claimID(claim Claim) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{claim.Subject, claim.Object, claim.Type,
+		claim.Description, claim.SourceText}, GraphFieldSeparator)))
+	return claim.SourceID + "-claim-" + hex.EncodeToString(hash[:8])
+}
+
+// claimStatus returns the ClaimStatus of status, defaulting to ClaimStatusSuspected for the
+// statuses that aren't recognized.
+func claimStatus(status string) ClaimStatus {
+	switch s := ClaimStatus(strings.ToUpper(strings.TrimSpace(status))); s {
+	case ClaimStatusTrue, ClaimStatusFalse:
+		return s
+	default:
+		return ClaimStatusSuspected
+	}
+}
+
+// claimDate parses a claim date, returning the zero time for unknown or invalid dates.
+func claimDate(date *string) time.Time {
+	if date == nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(claimDateLayout, strings.TrimSpace(*date))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// entitiesClaims returns the claims of the entities, deduplicated and ordered by start date.
+func entitiesClaims(entities []EntityContext, storage Storage) ([]Claim, error) {
+	claimStorage, ok := storage.(ClaimStorage)
+	if !ok || len(entities) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(entities))
+	for _, entity := range entities {
+		names = appendIfUnique(names, entity.Name)
+	}
+	claimsMap, err := claimStorage.KVClaimsByEntities(names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to batch get claims: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	claims := make([]Claim, 0)
+	for _, name := range names {
+		for _, claim := range claimsMap[name] {
+			if seen[claim.ID] {
+				continue
+			}
+			seen[claim.ID] = true
+			claims = append(claims, claim)
+		}
+	}
+	slices.SortStableFunc(claims, func(a, b Claim) int {
+		return a.StartDate.Compare(b.StartDate)
+	})
+	return claims, nil
+}
+
+// String returns a CSV-formatted string representation of the Claim.
+func (c Claim) String() string {
+	return fmt.Sprintf("%q,%q,%q,%q,%q,%q,%q,%q",
+		c.Subject, c.Object, c.Type, c.Status, c.Description, dateString(c.StartDate), dateString(c.EndDate),
+		c.SourceID)
+}
@@ -0,0 +1,699 @@
+package golightrag
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	llmod "github.com/MegaGrindStone/go-light-rag/llm"
+	jsonrepair "github.com/kaptinlin/jsonrepair"
+	"golang.org/x/sync/errgroup"
+)
+
+// GraphListStorage is implemented by graph storages that can list the whole graph.
+// UpdateCommunities uses it to detect the communities of the graph.
+type GraphListStorage interface {
+	// GraphAllEntities retrieves every entity of the graph.
+	GraphAllEntities() ([]GraphEntity, error)
+	// GraphAllRelationships retrieves every relationship of the graph, once per pair of
+	// entities.
+	GraphAllRelationships() ([]GraphRelationship, error)
+}
+
+// CommunityStorage is implemented by storages that can store the communities detected by
+// UpdateCommunities and their reports.
+type CommunityStorage interface {
+	// KVCommunities retrieves every stored community.
+	KVCommunities() ([]Community, error)
+	// KVUpsertCommunities creates or updates multiple communities at once, by their ID.
+	KVUpsertCommunities(communities []Community) error
+	// KVDeleteCommunities deletes the communities with the IDs. Missing IDs are ignored.
+	KVDeleteCommunities(ids []string) error
+}
+
+// Community is a group of closely related entities detected in the graph, at a level of the
+// community hierarchy, with a report of its entities and relationships written by the LLM.
+type Community struct {
+	// ID is derived from the level and the entities of the community, so it's stable as long as
+	// its entities don't change.
+	ID string
+	// Level is the level of the community in the hierarchy, 0 being the coarsest. Each
+	// community is split into communities of the next level.
+	Level int
+	// Parent is the ID of the community of the previous level containing this one, or empty at
+	// level 0.
+	Parent   string
+	Entities []string
+	Title    string
+	Summary  string
+	// Rating is the importance of the community from 0 to 10, as rated by the LLM.
+	Rating   float64
+	Findings []CommunityFinding
+	// Hash is the hash of the entities and relationships the report was written from. Reports
+	// are only rewritten when it changes.
+	Hash      string
+	UpdatedAt time.Time
+}
+
+// CommunityFinding is an insight about a community in its report.
+type CommunityFinding struct {
+	Summary     string `json:"summary"`
+	Explanation string `json:"explanation"`
+}
+
+// CommunityConfig configures the community detection and reports of UpdateCommunities.
+type CommunityConfig struct {
+	// MaxLevels is the maximum number of levels of the hierarchy, keeping the coarsest ones.
+	// Zero keeps every level detected.
+	MaxLevels int
+	// MinSize is the minimum number of entities of the communities that get a report.
+	// Defaults to 2.
+	MinSize int
+	// MaxReportEntities is the maximum number of entities, the most connected ones, described
+	// to the LLM when writing a report. The relationships between them are described up to
+	// twice this number. Defaults to 50.
+	MaxReportEntities int
+	// Language is the language of the reports. Defaults to English.
+	Language string
+	// ConcurrencyCount is the number of reports written concurrently. Defaults to 1.
+	ConcurrencyCount int
+	// MaxRetries is the number of times a report the LLM fails to write is retried.
+	MaxRetries int
+}
+
+// CommunityQuery configures QueryCommunities.
+type CommunityQuery struct {
+	// Level is the level of the communities whose reports are mapped. Defaults to 0, the
+	// coarsest. A level deeper than the hierarchy uses its deepest level.
+	Level int
+	// BatchSize is the number of reports given to each map call. Defaults to 8.
+	BatchSize int
+	// MaxPoints is the number of points, the highest scored ones, kept by the reduce.
+	// Defaults to 20.
+	MaxPoints int
+	// ConcurrencyCount is the number of concurrent map calls. Defaults to 1.
+	ConcurrencyCount int
+}
+
+// CommunityPoint is a point relevant to the query found in the community reports.
+type CommunityPoint struct {
+	Description string
+	// Score is the importance of the point to answer the query, from 0 to 100.
+	Score int
+	// Communities are the IDs of the communities whose reports support the point.
+	Communities []string
+}
+
+// CommunityQueryResult contains the points of the community reports relevant to a query,
+// ordered by score.
+type CommunityQueryResult struct {
+	Points []CommunityPoint
+}
+
+const (
+	defaultCommunityMinSize           = 2
+	defaultCommunityMaxReportEntities = 50
+	defaultCommunityQueryBatchSize    = 8
+	defaultCommunityQueryMaxPoints    = 20
+)
+
+type communityReportPromptData struct {
+	Language      string
+	Entities      string
+	Relationships string
+}
+
+type communityReportOutput struct {
+	Title    string             `json:"title"`
+	Summary  string             `json:"summary"`
+	Rating   float64            `json:"rating"`
+	Findings []CommunityFinding `json:"findings"`
+}
+
+type communityMapPromptData struct {
+	Query   string
+	Reports string
+}
+
+type communityMapOutput struct {
+	Points []struct {
+		Description string  `json:"description"`
+		Score       float64 `json:"score"`
+		Reports     []int   `json:"reports"`
+	} `json:"points"`
+}
+
+// detectedCommunity is a community detected in the graph, before its report is written.
+type detectedCommunity struct {
+	Community
+	relationships []GraphRelationship
+	entities      []GraphEntity
+}
+
+// UpdateCommunities detects the communities of the whole graph with the Louvain method, and
+// writes the reports of the communities whose entities, relationships or descriptions changed
+// since the last update. The communities that no longer exist are deleted.
+// The storage must implement GraphListStorage and CommunityStorage.
+func UpdateCommunities(storage Storage, llm LLM, config CommunityConfig, logger *slog.Logger, opts ...Option) error {
+	o, err := newOptions(callUpdateCommunities, opts)
+	if err != nil {
+		return err
+	}
+	logger = logger.With(
+		slog.String("package", "golightrag"),
+		slog.String("function", "UpdateCommunities"),
+	)
+	storage, err = o.workspaceStorage(storage)
+	if err != nil {
+		return err
+	}
+	return updateCommunities(storage, o.stageLLMs(llm).communityReport, config, nil, logger)
+}
+
+// WithCommunityUpdate updates the communities as configured by c at the end of Insert,
+// InsertBatch and ProcessUnprocessedChunk. Unlike UpdateCommunities, only the coarsest
+// communities holding the merged entities or their neighbors are detected again, among their own
+// entities, and reported, so the other communities and their reports are kept as they are.
+// The whole graph is still listed to find them. Run UpdateCommunities from time to time to
+// detect the communities of the whole graph again.
+func WithCommunityUpdate(c CommunityConfig) Option {
+	return option("WithCommunityUpdate", callsExtracting, func(o *options) {
+		o.communities = &c
+	})
+}
+
+// updateCommunities updates the communities of the graph, or only those of the touched entities
+// if touched isn't nil and communities were already detected.
+func updateCommunities(
+	storage Storage,
+	llm LLM,
+	config CommunityConfig,
+	touched []string,
+	logger *slog.Logger,
+) error {
+	listStorage, ok := storage.(GraphListStorage)
+	if !ok {
+		return errors.New("community detection requires a storage implementing GraphListStorage")
+	}
+	communityStorage, ok := storage.(CommunityStorage)
+	if !ok {
+		return errors.New("community detection requires a storage implementing CommunityStorage")
+	}
+	if config.MinSize == 0 {
+		config.MinSize = defaultCommunityMinSize
+	}
+	if config.MaxReportEntities == 0 {
+		config.MaxReportEntities = defaultCommunityMaxReportEntities
+	}
+	if config.Language == "" {
+		config.Language = "English"
+	}
+	if config.ConcurrencyCount == 0 {
+		config.ConcurrencyCount = 1
+	}
+
+	entities, err := listStorage.GraphAllEntities()
+	if err != nil {
+		return fmt.Errorf("failed to list entities: %w", err)
+	}
+	relationships, err := listStorage.GraphAllRelationships()
+	if err != nil {
+		return fmt.Errorf("failed to list relationships: %w", err)
+	}
+	existing, err := communityStorage.KVCommunities()
+	if err != nil {
+		return fmt.Errorf("failed to get communities: %w", err)
+	}
+	if touched != nil && len(existing) > 0 {
+		entities, relationships, existing = communityScope(entities, relationships, existing, touched)
+	}
+	detected := detectCommunities(entities, relationships, config)
+
+	existingByID := make(map[string]Community, len(existing))
+	for _, community := range existing {
+		existingByID[community.ID] = community
+	}
+
+	logger.Info("Detected communities", "communities", len(detected), "existing", len(existing))
+
+	eg := new(errgroup.Group)
+	eg.SetLimit(config.ConcurrencyCount)
+	updated := make([]Community, len(detected))
+	for i, community := range detected {
+		eg.Go(func() error {
+			if previous, ok := existingByID[community.ID]; ok && previous.Hash == community.Hash {
+				// The report still describes the community, only its parent may have changed.
+				previous.Parent = community.Parent
+				updated[i] = previous
+				return nil
+			}
+			report, err := llmCommunityReport(community, config, llm, logger)
+			if err != nil {
+				return fmt.Errorf("failed to write report of community %s: %w", community.ID, err)
+			}
+			updated[i] = report
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	if err := communityStorage.KVUpsertCommunities(updated); err != nil {
+		return fmt.Errorf("failed to upsert communities: %w", err)
+	}
+
+	stale := make([]string, 0)
+	for id := range existingByID {
+		if !slices.ContainsFunc(detected, func(c detectedCommunity) bool { return c.ID == id }) {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) > 0 {
+		if err := communityStorage.KVDeleteCommunities(stale); err != nil {
+			return fmt.Errorf("failed to delete communities: %w", err)
+		}
+	}
+
+	logger.Info("Updated communities", "communities", len(updated), "deleted", len(stale))
+
+	return nil
+}
+
+// communityScope restricts the graph to the touched entities, their neighbors and the entities
+// of the coarsest existing communities holding any of them, and returns the existing
+// communities of these entities, which the communities detected among them replace. The
+// coarsest communities don't overlap, so the others are left whole.
+func communityScope(
+	entities []GraphEntity,
+	relationships []GraphRelationship,
+	existing []Community,
+	touched []string,
+) ([]GraphEntity, []GraphRelationship, []Community) {
+	isTouched := make(map[string]bool, len(touched))
+	for _, name := range touched {
+		isTouched[name] = true
+	}
+	scope := maps.Clone(isTouched)
+	for _, relationship := range relationships {
+		if isTouched[relationship.SourceEntity] || isTouched[relationship.TargetEntity] {
+			scope[relationship.SourceEntity] = true
+			scope[relationship.TargetEntity] = true
+		}
+	}
+	inScope := func(name string) bool { return scope[name] }
+	for _, community := range existing {
+		if community.Level == 0 && slices.ContainsFunc(community.Entities, inScope) {
+			for _, name := range community.Entities {
+				scope[name] = true
+			}
+		}
+	}
+
+	scopedEntities := make([]GraphEntity, 0, len(scope))
+	for _, entity := range entities {
+		if scope[entity.Name] {
+			scopedEntities = append(scopedEntities, entity)
+		}
+	}
+	scopedRelationships := make([]GraphRelationship, 0)
+	for _, relationship := range relationships {
+		if scope[relationship.SourceEntity] && scope[relationship.TargetEntity] {
+			scopedRelationships = append(scopedRelationships, relationship)
+		}
+	}
+	replaced := make([]Community, 0)
+	for _, community := range existing {
+		if slices.ContainsFunc(community.Entities, inScope) {
+			replaced = append(replaced, community)
+		}
+	}
+	return scopedEntities, scopedRelationships, replaced
+}
+
+// detectCommunities returns the communities of the graph with at least config.MinSize entities,
+// ordered by level and ID.
+func detectCommunities(
+	entities []GraphEntity,
+	relationships []GraphRelationship,
+	config CommunityConfig,
+) []detectedCommunity {
+	graph, index := newEntityGraph(entities, relationships)
+
+	// Louvain returns the levels from the finest to the coarsest.
+	levels := louvain(graph)
+	slices.Reverse(levels)
+	if config.MaxLevels > 0 && len(levels) > config.MaxLevels {
+		levels = levels[:config.MaxLevels]
+	}
+
+	communities := make([]detectedCommunity, 0)
+	// parents is the ID of the community of each entity at the previous level.
+	parents := make([]string, len(entities))
+	for level, membership := range levels {
+		members := make(map[int][]int)
+		for node, community := range membership {
+			members[community] = append(members[community], node)
+		}
+
+		ids := make([]string, len(entities))
+		levelCommunities := make(map[int]*detectedCommunity)
+		for community, nodes := range members {
+			names := make([]string, len(nodes))
+			for i, node := range nodes {
+				names[i] = entities[node].Name
+			}
+			id := communityID(level, names)
+			for _, node := range nodes {
+				ids[node] = id
+			}
+			if len(nodes) < config.MinSize {
+				continue
+			}
+
+			detected := &detectedCommunity{
+				Community: Community{
+					ID:       id,
+					Level:    level,
+					Parent:   parents[nodes[0]],
+					Entities: names,
+				},
+			}
+			for _, node := range nodes {
+				detected.entities = append(detected.entities, entities[node])
+			}
+			levelCommunities[community] = detected
+		}
+		for _, relationship := range relationships {
+			source, sourceOK := index[relationship.SourceEntity]
+			target, targetOK := index[relationship.TargetEntity]
+			if !sourceOK || !targetOK || membership[source] != membership[target] {
+				continue
+			}
+			if detected, ok := levelCommunities[membership[source]]; ok {
+				detected.relationships = append(detected.relationships, relationship)
+			}
+		}
+		for _, detected := range levelCommunities {
+			detected.Hash = communityHash(detected.entities, detected.relationships)
+			communities = append(communities, *detected)
+		}
+		parents = ids
+	}
+
+	slices.SortFunc(communities, func(a, b detectedCommunity) int {
+		return cmp.Or(cmp.Compare(a.Level, b.Level), cmp.Compare(a.ID, b.ID))
+	})
+	return communities
+}
+
+func communityID(level int, names []string) string {
+	hash := sha256.Sum256([]byte(strconv.Itoa(level) + GraphFieldSeparator + strings.Join(names, GraphFieldSeparator)))
+	return hex.EncodeToString(hash[:8])
+}
+
+func communityHash(entities []GraphEntity, relationships []GraphRelationship) string {
+	hash := sha256.New()
+	for _, entity := range entities {
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00", entity.Name, entity.Type, entity.Descriptions)
+	}
+	rels := slices.Clone(relationships)
+	slices.SortFunc(rels, func(a, b GraphRelationship) int {
+		return cmp.Or(cmp.Compare(a.SourceEntity, b.SourceEntity), cmp.Compare(a.TargetEntity, b.TargetEntity))
+	})
+	for _, relationship := range rels {
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%g\x00",
+			relationship.SourceEntity, relationship.TargetEntity, relationship.Descriptions, relationship.Weight)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// llmCommunityReport writes the report of community with the LLM.
+func llmCommunityReport(
+	community detectedCommunity,
+	config CommunityConfig,
+	llm LLM,
+	logger *slog.Logger,
+) (Community, error) {
+	// The most connected entities describe the community best.
+	degrees := make(map[string]int)
+	for _, relationship := range community.relationships {
+		degrees[relationship.SourceEntity]++
+		degrees[relationship.TargetEntity]++
+	}
+	entities := slices.Clone(community.entities)
+	slices.SortStableFunc(entities, func(a, b GraphEntity) int {
+		return cmp.Compare(degrees[b.Name], degrees[a.Name])
+	})
+	entities = entities[:min(len(entities), config.MaxReportEntities)]
+	included := make(map[string]bool, len(entities))
+	entitiesCSV := "id,entity,type,description,degree\n"
+	for i, entity := range entities {
+		included[entity.Name] = true
+		entitiesCSV += fmt.Sprintf("%q,%q,%q,%q,%q\n",
+			strconv.Itoa(i), entity.Name, entity.Type, entity.Descriptions, strconv.Itoa(degrees[entity.Name]))
+	}
+
+	relationships := make([]GraphRelationship, 0, len(community.relationships))
+	for _, relationship := range community.relationships {
+		if included[relationship.SourceEntity] && included[relationship.TargetEntity] {
+			relationships = append(relationships, relationship)
+		}
+	}
+	slices.SortStableFunc(relationships, func(a, b GraphRelationship) int {
+		return cmp.Compare(b.Weight, a.Weight)
+	})
+	relationships = relationships[:min(len(relationships), 2*config.MaxReportEntities)]
+	relationshipsCSV := "id,source,target,description,weight\n"
+	for i, relationship := range relationships {
+		relationshipsCSV += fmt.Sprintf("%q,%q,%q,%q,%q\n", strconv.Itoa(i), relationship.SourceEntity,
+			relationship.TargetEntity, relationship.Descriptions, strconv.FormatFloat(relationship.Weight, 'f', 2, 64))
+	}
+
+	prompt, err := promptTemplate("community-report", communityReportPrompt, communityReportPromptData{
+		Language:      config.Language,
+		Entities:      entitiesCSV,
+		Relationships: relationshipsCSV,
+	})
+	if err != nil {
+		return Community{}, fmt.Errorf("failed to generate community report prompt: %w", err)
+	}
+
+	logger.Debug("Use LLM to write community report", "community", community.ID, "prompt", prompt)
+
+	var output communityReportOutput
+	if err := chatJSONWithRetries(llm, prompt, communityReportSchema, config.MaxRetries, &output, logger); err != nil {
+		return Community{}, err
+	}
+
+	report := community.Community
+	report.Title = strings.TrimSpace(output.Title)
+	report.Summary = strings.TrimSpace(output.Summary)
+	report.Rating = min(max(output.Rating, 0), 10)
+	report.Findings = output.Findings
+	report.UpdatedAt = time.Now()
+
+	logger.Info("Wrote community report", "community", community.ID, "level", community.Level,
+		"entities", len(community.Entities), "title", report.Title)
+
+	return report, nil
+}
+
+// chatJSONWithRetries calls llm with prompt and unmarshals its JSON response into output,
+// retrying up to maxRetries times when the call fails with a retryable error or the response
+// can't be parsed.
+func chatJSONWithRetries(
+	llm LLM,
+	prompt string,
+	schema llmod.Schema,
+	maxRetries int,
+	output any,
+	logger *slog.Logger,
+) error {
+	for retry := 0; ; retry++ {
+		res, err := chatJSON(llm, []string{prompt}, schema)
+		if err != nil {
+			if !llmod.IsRetryable(err) || retry >= maxRetries {
+				return fmt.Errorf("failed to call LLM: %w", err)
+			}
+			logger.Warn("Retry LLM call", "retry", retry+1, "error", err)
+			continue
+		}
+		repaired, _ := jsonrepair.JSONRepair(cleanExtractionResponse(res))
+		if err := json.Unmarshal([]byte(repaired), output); err != nil {
+			if retry >= maxRetries {
+				return fmt.Errorf("failed to unmarshal LLM output: %w", err)
+			}
+			logger.Warn("Retry parse LLM output", "retry", retry+1, "error", err)
+			continue
+		}
+		return nil
+	}
+}
+
+// QueryCommunities answers corpus-wide questions, such as the main themes of the documents, by
+// map-reducing over the community reports written by UpdateCommunities: the reports of the
+// configured level are given in batches to the LLM, which extracts the points relevant to the
+// query with an importance score, and the highest scored points are kept.
+// Like Query, it returns the context to answer the query with, whose String method formats it
+// for a prompt. The storage must implement CommunityStorage.
+func QueryCommunities(
+	conversations []QueryConversation,
+	config CommunityQuery,
+	storage Storage,
+	llm LLM,
+	logger *slog.Logger,
+	opts ...Option,
+) (CommunityQueryResult, error) {
+	o, err := newOptions(callQueryCommunities, opts)
+	if err != nil {
+		return CommunityQueryResult{}, err
+	}
+	logger = logger.With(
+		slog.String("package", "golightrag"),
+		slog.String("function", "QueryCommunities"),
+	)
+	storage, err = o.workspaceStorage(storage)
+	if err != nil {
+		return CommunityQueryResult{}, err
+	}
+	communityStorage, ok := storage.(CommunityStorage)
+	if !ok {
+		return CommunityQueryResult{}, errors.New("community query requires a storage implementing CommunityStorage")
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = defaultCommunityQueryBatchSize
+	}
+	if config.MaxPoints == 0 {
+		config.MaxPoints = defaultCommunityQueryMaxPoints
+	}
+	if config.ConcurrencyCount == 0 {
+		config.ConcurrencyCount = 1
+	}
+
+	query, _, err := extractQueryAndHistories(conversations)
+	if err != nil {
+		return CommunityQueryResult{}, fmt.Errorf("failed to extract query and histories: %w", err)
+	}
+
+	communities, err := communityStorage.KVCommunities()
+	if err != nil {
+		return CommunityQueryResult{}, fmt.Errorf("failed to get communities: %w", err)
+	}
+	deepest := 0
+	for _, community := range communities {
+		deepest = max(deepest, community.Level)
+	}
+	level := min(config.Level, deepest)
+	reports := make([]Community, 0, len(communities))
+	for _, community := range communities {
+		if community.Level == level {
+			reports = append(reports, community)
+		}
+	}
+	// The most important communities are mapped first, and win ties in the reduce.
+	slices.SortFunc(reports, func(a, b Community) int {
+		return cmp.Or(cmp.Compare(b.Rating, a.Rating), cmp.Compare(a.ID, b.ID))
+	})
+
+	logger.Info("Mapping community reports", "query", query, "level", level, "reports", len(reports))
+
+	mapLLM := o.stageLLMs(llm).communityQuery
+	batches := slices.Collect(slices.Chunk(reports, config.BatchSize))
+	batchPoints := make([][]CommunityPoint, len(batches))
+	eg := new(errgroup.Group)
+	eg.SetLimit(config.ConcurrencyCount)
+	for i, batch := range batches {
+		eg.Go(func() error {
+			points, err := llmMapCommunities(query, batch, mapLLM, logger)
+			if err != nil {
+				return fmt.Errorf("failed to map community reports: %w", err)
+			}
+			batchPoints[i] = points
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return CommunityQueryResult{}, err
+	}
+
+	// Reduce the points of every batch to the highest scored ones.
+	points := slices.Concat(batchPoints...)
+	slices.SortStableFunc(points, func(a, b CommunityPoint) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	points = points[:min(len(points), config.MaxPoints)]
+
+	logger.Info("Reduced community points", "points", len(points))
+
+	return CommunityQueryResult{Points: points}, nil
+}
+
+// llmMapCommunities returns the points of reports relevant to the query, without the ones
+// scored 0.
+func llmMapCommunities(query string, reports []Community, llm LLM, logger *slog.Logger) ([]CommunityPoint, error) {
+	reportsText := make([]string, len(reports))
+	for i, report := range reports {
+		findings := make([]string, len(report.Findings))
+		for j, finding := range report.Findings {
+			findings[j] = fmt.Sprintf("- %s: %s", finding.Summary, finding.Explanation)
+		}
+		reportsText[i] = fmt.Sprintf("Report %d: %s\n%s\n%s", i, report.Title, report.Summary, strings.Join(findings, "\n"))
+	}
+
+	prompt, err := promptTemplate("community-map", communityMapPrompt, communityMapPromptData{
+		Query:   query,
+		Reports: strings.Join(reportsText, "\n\n"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate community map prompt: %w", err)
+	}
+
+	var output communityMapOutput
+	if err := chatJSONWithRetries(llm, prompt, communityMapSchema, 0, &output, logger); err != nil {
+		return nil, err
+	}
+
+	points := make([]CommunityPoint, 0, len(output.Points))
+	for _, point := range output.Points {
+		score := int(math.Round(point.Score))
+		if score <= 0 || strings.TrimSpace(point.Description) == "" {
+			continue
+		}
+		communities := make([]string, 0, len(point.Reports))
+		for _, report := range point.Reports {
+			if report >= 0 && report < len(reports) {
+				communities = appendIfUnique(communities, reports[report].ID)
+			}
+		}
+		points = append(points, CommunityPoint{
+			Description: strings.TrimSpace(point.Description),
+			Score:       min(score, 100),
+			Communities: communities,
+		})
+	}
+	return points, nil
+}
+
+// String returns a CSV-formatted string representation of the CommunityQueryResult.
+func (r CommunityQueryResult) String() string {
+	points := "id,score,description\n"
+	for i, point := range r.Points {
+		points += fmt.Sprintf("%q,%q,%q\n", strconv.Itoa(i), strconv.Itoa(point.Score), point.Description)
+	}
+	return `
+-----Community Points-----
+` + threeBacktick("csv") + `
+` + points + `
+` + threeBacktick("")
+}
@@ -0,0 +1,245 @@
+package golightrag_test
+
+import (
+	"io"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+)
+
+func TestUpdateCommunities(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config := golightrag.CommunityConfig{MaxRetries: 1}
+	report := `{"title": "Community", "summary": "A community.", "rating": 7.5, ` +
+		`"findings": [{"summary": "Finding", "explanation": "Explanation."}]}`
+
+	// Two triangles of entities, without relationships between them.
+	newStorage := func() *MockStorage {
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+		for _, name := range []string{"A", "B", "C", "D", "E", "F"} {
+			_ = storage.GraphUpsertEntity(golightrag.GraphEntity{Name: name, Type: "PERSON", Descriptions: name})
+		}
+		for _, pair := range [][2]string{{"A", "B"}, {"B", "C"}, {"A", "C"}, {"D", "E"}, {"E", "F"}, {"D", "F"}} {
+			_ = storage.GraphUpsertRelationship(golightrag.GraphRelationship{
+				SourceEntity: pair[0],
+				TargetEntity: pair[1],
+				Weight:       1,
+				Descriptions: pair[0] + " knows " + pair[1],
+			})
+		}
+		return storage
+	}
+	communityEntities := func(storage *MockStorage) [][]string {
+		entities := make([][]string, 0, len(storage.communities))
+		for _, community := range storage.communities {
+			entities = append(entities, community.Entities)
+		}
+		slices.SortFunc(entities, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+		return entities
+	}
+
+	t.Run("Reports the detected communities", func(t *testing.T) {
+		storage := newStorage()
+		llm := &MockLLM{chatResponse: report, chatCalls: make([][]string, 0)}
+
+		if err := golightrag.UpdateCommunities(storage, llm, config, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		want := [][]string{{"A", "B", "C"}, {"D", "E", "F"}}
+		if got := communityEntities(storage); !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("Expected communities %v, got %v", want, got)
+		}
+		if len(llm.chatCalls) != 2 {
+			t.Errorf("Expected a report per community, got %d calls", len(llm.chatCalls))
+		}
+		for _, community := range storage.communities {
+			if community.Title != "Community" || community.Rating != 7.5 || len(community.Findings) != 1 {
+				t.Errorf("Expected the report to be stored, got %+v", community)
+			}
+			if community.Hash == "" || community.UpdatedAt.IsZero() {
+				t.Errorf("Expected the hash and update time to be set, got %+v", community)
+			}
+		}
+	})
+
+	t.Run("Only rewrites the reports of changed communities", func(t *testing.T) {
+		storage := newStorage()
+		llm := &MockLLM{chatResponse: report, chatCalls: make([][]string, 0)}
+		if err := golightrag.UpdateCommunities(storage, llm, config, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		llm.chatCalls = make([][]string, 0)
+		if err := golightrag.UpdateCommunities(storage, llm, config, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(llm.chatCalls) != 0 {
+			t.Errorf("Expected no reports for unchanged communities, got %d calls", len(llm.chatCalls))
+		}
+
+		d := storage.entities["D"]
+		d.Descriptions = "D moved to another city"
+		storage.entities["D"] = d
+		if err := golightrag.UpdateCommunities(storage, llm, config, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(llm.chatCalls) != 1 {
+			t.Fatalf("Expected a report for the changed community, got %d calls", len(llm.chatCalls))
+		}
+		if !strings.Contains(llm.chatCalls[0][0], "D moved to another city") {
+			t.Errorf("Expected the report prompt to describe the changed entity")
+		}
+	})
+
+	t.Run("Deletes the communities that no longer exist", func(t *testing.T) {
+		storage := newStorage()
+		llm := &MockLLM{chatResponse: report}
+		if err := golightrag.UpdateCommunities(storage, llm, config, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		delete(storage.entities, "F")
+		delete(storage.relationships, "E:F")
+		delete(storage.relationships, "D:F")
+		if err := golightrag.UpdateCommunities(storage, llm, config, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		want := [][]string{{"A", "B", "C"}, {"D", "E"}}
+		if got := communityEntities(storage); !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("Expected communities %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Insert only updates the communities of the merged entities", func(t *testing.T) {
+		storage := newStorage()
+		reportLLM := &MockLLM{chatResponse: report, chatCalls: make([][]string, 0)}
+		if err := golightrag.UpdateCommunities(storage, reportLLM, config, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		untouched := maps.Clone(storage.communities)
+
+		// D changes outside of the insertion, so only a full update would rewrite its report.
+		d := storage.entities["D"]
+		d.Descriptions = "D moved to another city"
+		storage.entities["D"] = d
+
+		extractionLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [{"entity_name": "X", "entity_type": "PERSON", "entity_description": "X knows A and B."}],
+				"relationships": [
+					{"source_entity": "X", "target_entity": "A", "relationship_description": "X knows A.",
+						"relationship_keywords": ["friendship"], "relationship_strength": 1},
+					{"source_entity": "X", "target_entity": "B", "relationship_description": "X knows B.",
+						"relationship_keywords": ["friendship"], "relationship_strength": 1}
+				]
+			}`,
+			chatCalls: make([][]string, 0),
+		}
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{{Content: "X knows A.", TokenSize: 3}},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON"},
+				Language:    "English",
+			},
+			maxRetries:  1,
+			maxTokenLen: 1000,
+		}
+		reportLLM.chatCalls = make([][]string, 0)
+		if err := golightrag.Insert(golightrag.Document{ID: "doc-x", Content: "X knows A."}, handler, storage,
+			extractionLLM, logger,
+			golightrag.WithCommunityUpdate(config),
+			golightrag.WithRouting(golightrag.Routing{CommunityReport: reportLLM})); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		want := [][]string{{"A", "B", "C", "X"}, {"D", "E", "F"}}
+		got := communityEntities(storage)
+		for _, entities := range got {
+			slices.Sort(entities)
+		}
+		if !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("Expected communities %v, got %v", want, got)
+		}
+		if len(reportLLM.chatCalls) != 1 || !strings.Contains(reportLLM.chatCalls[0][0], "X knows A.") {
+			t.Errorf("Expected a single report for the community of X, got %d calls", len(reportLLM.chatCalls))
+		}
+		for id, community := range untouched {
+			if slices.Contains(community.Entities, "D") && !reflect.DeepEqual(storage.communities[id], community) {
+				t.Errorf("Expected the community of D to be kept, got %+v", storage.communities[id])
+			}
+		}
+	})
+
+	t.Run("Requires a community storage", func(t *testing.T) {
+		storage := struct{ golightrag.Storage }{newStorage()}
+		err := golightrag.UpdateCommunities(storage, &MockLLM{chatResponse: report}, config, logger)
+		if err == nil {
+			t.Errorf("Expected an error for a storage without community support")
+		}
+	})
+}
+
+func TestQueryCommunities(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	storage := &MockStorage{}
+	_ = storage.KVUpsertCommunities([]golightrag.Community{
+		{ID: "minor", Level: 0, Title: "Minor", Summary: "A minor community.", Rating: 2},
+		{ID: "major", Level: 0, Title: "Major", Summary: "A major community.", Rating: 9},
+		{ID: "team", Level: 1, Parent: "major", Title: "Team", Summary: "A team.", Rating: 5},
+	})
+	// Reports are numbered from the highest rated, so 0 is the major community.
+	llm := &MockLLM{
+		chatResponse: `{"points": [` +
+			`{"description": "Low", "score": 40, "reports": [1]}, ` +
+			`{"description": "Irrelevant", "score": 0, "reports": [0]}, ` +
+			`{"description": "High", "score": 90, "reports": [0, 1, 7]}, ` +
+			`{"description": "Medium", "score": 70.4, "reports": [0]}]}`,
+		chatCalls: make([][]string, 0),
+	}
+	conversations := []golightrag.QueryConversation{{Role: golightrag.RoleUser, Message: "What are the main themes?"}}
+
+	config := golightrag.CommunityQuery{MaxPoints: 2}
+	result, err := golightrag.QueryCommunities(conversations, config, storage, llm, logger)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(llm.chatCalls) != 1 {
+		t.Fatalf("Expected the level 0 reports to be mapped in a single batch, got %d calls", len(llm.chatCalls))
+	}
+	prompt := llm.chatCalls[0][0]
+	if !strings.Contains(prompt, "Report 0: Major") || !strings.Contains(prompt, "Report 1: Minor") {
+		t.Errorf("Expected the reports ordered by rating in the prompt, got %s", prompt)
+	}
+	if strings.Contains(prompt, "Team") {
+		t.Errorf("Expected only the reports of level 0 in the prompt")
+	}
+
+	want := []golightrag.CommunityPoint{
+		{Description: "High", Score: 90, Communities: []string{"major", "minor"}},
+		{Description: "Medium", Score: 70, Communities: []string{"major"}},
+	}
+	if len(result.Points) != len(want) {
+		t.Fatalf("Expected %d points, got %+v", len(want), result.Points)
+	}
+	for i, point := range result.Points {
+		if point.Description != want[i].Description || point.Score != want[i].Score ||
+			!slices.Equal(point.Communities, want[i].Communities) {
+			t.Errorf("Expected point %d to be %+v, got %+v", i, want[i], point)
+		}
+	}
+	if !strings.Contains(result.String(), `"0","90","High"`) {
+		t.Errorf("Expected the points in the result string, got %s", result.String())
+	}
+}
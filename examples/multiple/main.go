@@ -4,12 +4,13 @@ import (
 	"bufio"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
-	"sync"
 	"text/template"
 	"time"
 
@@ -303,38 +304,30 @@ func processDocumentDirectory(
 
 	logger.Info("Found files", "count", len(files))
 
-	// Process files concurrently
-	var wg sync.WaitGroup
-	concurrencyLimit := 2
-	sem := make(chan struct{}, concurrencyLimit)
-	var errs []error
-	var errMu sync.Mutex
-
+	// Read the changed files, batched by the handler inserting them
+	var goDocs, defaultDocs []golightrag.Document
+	contents := make(map[string]string)
 	for _, path := range files {
-		sem <- struct{}{} // Acquire semaphore
-		wg.Add(1)
-
-		go func(filePath string) {
-			defer func() {
-				<-sem // Release semaphore
-				wg.Done()
-			}()
-
-			if err := processFile(filePath, docDir, kvDB, store, defaultHandler, goHandler, llm, logger); err != nil {
-				errMu.Lock()
-				errs = append(errs, fmt.Errorf("error processing file %s: %w", filePath, err))
-				errMu.Unlock()
-			}
-		}(path)
-	}
-
-	wg.Wait()
+		doc, changed, err := readFile(path, docDir, kvDB, logger)
+		if err != nil {
+			return fmt.Errorf("error processing file %s: %w", path, err)
+		}
+		if !changed {
+			continue
+		}
+		contents[doc.ID] = doc.Content
 
-	if len(errs) > 0 {
-		return errs[0] // Return the first error
+		if filepath.Ext(path) == ".go" {
+			goDocs = append(goDocs, doc)
+		} else {
+			defaultDocs = append(defaultDocs, doc)
+		}
 	}
 
-	return nil
+	if err := insertBatch(goDocs, goHandler, kvDB, store, contents, llm, logger); err != nil {
+		return err
+	}
+	return insertBatch(defaultDocs, defaultHandler, kvDB, store, contents, llm, logger)
 }
 
 func shouldIgnoreWithMatchers(path string, rootDir string, matchers map[string]*ignore.GitIgnore) bool {
@@ -377,20 +370,12 @@ func shouldIgnoreWithMatchers(path string, rootDir string, matchers map[string]*
 	return false
 }
 
-func processFile(
-	path string,
-	rootDir string,
-	kvDB storage.Bolt,
-	store golightrag.Storage,
-	defaultHandler handler.Default,
-	goHandler handler.Go,
-	llm golightrag.LLM,
-	logger *slog.Logger,
-) error {
+// readFile reads the file as a document, and reports whether it changed since it was inserted.
+func readFile(path, rootDir string, kvDB storage.Bolt, logger *slog.Logger) (golightrag.Document, bool, error) {
 	// Read file content
 	fileData, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("error reading file: %w", err)
+		return golightrag.Document{}, false, fmt.Errorf("error reading file: %w", err)
 	}
 
 	fileContent := string(fileData)
@@ -398,48 +383,24 @@ func processFile(
 	// Generate a file ID based on the relative path
 	relPath, err := filepath.Rel(rootDir, path)
 	if err != nil {
-		return fmt.Errorf("error determining relative path: %w", err)
+		return golightrag.Document{}, false, fmt.Errorf("error determining relative path: %w", err)
 	}
 	fileID := strings.ReplaceAll(relPath, string(filepath.Separator), "_")
 
 	// Check if file has changed by comparing hash
 	shouldInsert, err := checkFileHash(kvDB, fileID, fileContent)
 	if err != nil {
-		return fmt.Errorf("error checking file hash: %w", err)
+		return golightrag.Document{}, false, fmt.Errorf("error checking file hash: %w", err)
 	}
 
 	if !shouldInsert {
 		logger.Debug("File unchanged, skipping", "path", relPath)
-		return nil
-	}
-
-	logger.Info("Inserting file", "path", relPath)
-
-	// Determine handler based on file extension
-	var docHandler golightrag.DocumentHandler
-	ext := filepath.Ext(path)
-	if ext == ".go" {
-		docHandler = goHandler
-	} else {
-		docHandler = defaultHandler
 	}
 
-	// Insert document
-	doc := golightrag.Document{
+	return golightrag.Document{
 		ID:      fileID,
 		Content: fileContent,
-	}
-
-	if err := insert(doc, docHandler, store, llm, logger); err != nil {
-		return fmt.Errorf("error inserting document: %w", err)
-	}
-
-	// Save new hash
-	if err := saveFileHash(kvDB, fileID, fileContent); err != nil {
-		return fmt.Errorf("error saving file hash: %w", err)
-	}
-
-	return nil
+	}, shouldInsert, nil
 }
 
 func checkFileHash(kvDB storage.Bolt, fileID, content string) (bool, error) {
@@ -483,19 +444,48 @@ func saveFileHash(kvDB storage.Bolt, fileID, content string) error {
 	})
 }
 
-func insert(
-	doc golightrag.Document,
+// insertBatch inserts the documents with one shared pool of workers, and saves the hashes of the
+// inserted ones so they're skipped until they change.
+func insertBatch(
+	docs []golightrag.Document,
 	docHandler golightrag.DocumentHandler,
-	storage golightrag.Storage,
+	kvDB storage.Bolt,
+	store golightrag.Storage,
+	contents map[string]string,
 	llm golightrag.LLM,
 	logger *slog.Logger,
 ) error {
-	now := time.Now()
-	defer func() {
-		logger.Info("Inserted document", "id", doc.ID, "duration in milliseconds", time.Since(now).Milliseconds())
-	}()
+	if len(docs) == 0 {
+		return nil
+	}
+
+	results, err := golightrag.InsertBatch(slices.Values(docs), docHandler, store, llm, logger,
+		golightrag.WithProgress(func(p golightrag.BatchProgress) {
+			if p.Result == nil {
+				return
+			}
+			logger.Info("Inserted document", "id", p.Result.DocumentID,
+				"duration in milliseconds", p.Result.Duration.Milliseconds(),
+				"documents", fmt.Sprintf("%d/%d", p.DocumentsDone+p.DocumentsFailed, len(docs)),
+				"eta", p.ETA.Round(time.Second))
+		}))
+	if err != nil {
+		return fmt.Errorf("error inserting documents: %w", err)
+	}
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("error inserting document %s: %w", result.DocumentID, result.Err))
+			continue
+		}
+		// Save new hash
+		if err := saveFileHash(kvDB, result.DocumentID, contents[result.DocumentID]); err != nil {
+			return fmt.Errorf("error saving file hash: %w", err)
+		}
+	}
 
-	return golightrag.Insert(doc, docHandler, storage, llm, logger)
+	return errors.Join(errs...)
 }
 
 func query(
@@ -0,0 +1,204 @@
+package golightrag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	llmod "github.com/MegaGrindStone/go-light-rag/llm"
+	jsonrepair "github.com/kaptinlin/jsonrepair"
+)
+
+// extractionResult is the entities and relationships parsed from an extraction or glean response.
+type extractionResult struct {
+	Entities      []GraphEntity       `json:"entities"`
+	Relationships []GraphRelationship `json:"relationships"`
+}
+
+// extractionCodec holds the prompts of an extraction format and the functions to call the LLM
+// and parse its responses.
+type extractionCodec struct {
+	extractPrompt string
+	gleanPrompt   string
+	// chat calls the LLM for the extraction and glean prompts.
+	chat func(llm LLM, messages []string) (string, error)
+	// parse returns the result of a cleaned response, along with the response to keep in the
+	// conversation history.
+	parse func(response string) (extractionResult, string, error)
+}
+
+const (
+	tupleDelimiter      = "<|>"
+	tupleRecordDelim    = "##"
+	tupleCompletionMark = "<|COMPLETE|>"
+)
+
+// tupleRecordBoundary matches the end of a record and the start of the next one when the LLM
+// puts them on separate lines without the record delimiter.
+var tupleRecordBoundary = regexp.MustCompile(`\)\s*\n\s*\(`)
+
+var errNoTupleRecords = errors.New("no tuple records found")
+
+func newExtractionCodec(data EntityExtractionPromptData) (extractionCodec, error) {
+	var (
+		codec                          extractionCodec
+		extractTemplate, gleanTemplate string
+	)
+	switch data.Format {
+	case "", ExtractionFormatJSON:
+		extractTemplate, gleanTemplate = extractEntitiesPrompt, gleanEntitiesPrompt
+		// Providers that support structured output are constrained to the expected JSON,
+		// otherwise the prompt asks for it and the response is repaired before parsing.
+		schema := entityExtractionSchema(data.EntityTypes, data.Ontology)
+		codec.chat = func(llm LLM, messages []string) (string, error) {
+			return chatJSON(llm, messages, schema)
+		}
+		codec.parse = parseJSONExtraction
+	case ExtractionFormatTuple:
+		extractTemplate, gleanTemplate = extractEntitiesTuplePrompt, gleanEntitiesTuplePrompt
+		codec.chat = func(llm LLM, messages []string) (string, error) {
+			return llm.Chat(messages)
+		}
+		codec.parse = parseTupleExtraction
+	default:
+		return extractionCodec{}, fmt.Errorf("unknown extraction format %q", data.Format)
+	}
+
+	var err error
+	codec.extractPrompt, err = promptTemplate("extract-entities", extractTemplate+ontologyPromptTemplate, data)
+	if err != nil {
+		return extractionCodec{}, fmt.Errorf("failed to generate extract entities prompt: %w", err)
+	}
+	codec.gleanPrompt, err = promptTemplate("glean-entities", gleanTemplate+ontologyPromptTemplate, data)
+	if err != nil {
+		return extractionCodec{}, fmt.Errorf("failed to generate glean entities prompt: %w", err)
+	}
+	return codec, nil
+}
+
+// cleanExtractionResponse removes the markdown fences and reasoning of a response.
+func cleanExtractionResponse(response string) string {
+	res := llmod.RemoveMarkdownBackticks(response)
+	return llmod.RemoveThinkTags(res)
+}
+
+// jsonExtractionResult is the JSON response of an extraction, whose relationship predicates are
+// moved to the relationship properties and validity dates parsed.
+type jsonExtractionResult struct {
+	Entities      []GraphEntity `json:"entities"`
+	Relationships []struct {
+		GraphRelationship
+		Predicate string  `json:"relationship_predicate"`
+		ValidFrom *string `json:"valid_from"`
+		ValidTo   *string `json:"valid_to"`
+	} `json:"relationships"`
+}
+
+func parseJSONExtraction(response string) (extractionResult, string, error) {
+	repaired, _ := jsonrepair.JSONRepair(response)
+
+	var parsed jsonExtractionResult
+	if err := json.Unmarshal([]byte(repaired), &parsed); err != nil {
+		return extractionResult{}, repaired, fmt.Errorf("failed to parse llm result: %w", err)
+	}
+
+	result := extractionResult{
+		Entities:      parsed.Entities,
+		Relationships: make([]GraphRelationship, len(parsed.Relationships)),
+	}
+	for i, relationship := range parsed.Relationships {
+		result.Relationships[i] = relationship.GraphRelationship
+		if relationship.Predicate != "" {
+			result.Relationships[i].Properties = map[string]any{PredicateProperty: relationship.Predicate}
+		}
+		result.Relationships[i].ValidFrom = parseDate(relationship.ValidFrom, false)
+		result.Relationships[i].ValidTo = parseDate(relationship.ValidTo, true)
+	}
+	return result, repaired, nil
+}
+
+// parseTupleExtraction parses the tuple records of a response. It tolerates records split by
+// newlines instead of the record delimiter, quoted fields and a response cut off before the
+// completion marker, in which case the unterminated last record is dropped. A response without
+// any record is an error, unless it has the completion marker, which means nothing was found.
+func parseTupleExtraction(response string) (extractionResult, string, error) {
+	text, _, completed := strings.Cut(response, tupleCompletionMark)
+
+	text = tupleRecordBoundary.ReplaceAllString(text, ")"+tupleRecordDelim+"(")
+
+	var result extractionResult
+	for record := range strings.SplitSeq(text, tupleRecordDelim) {
+		record = strings.TrimSpace(record)
+		if !strings.HasPrefix(record, "(") || !strings.HasSuffix(record, ")") {
+			continue
+		}
+		fields := strings.Split(record[1:len(record)-1], tupleDelimiter)
+		for i, field := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(field), `"'`)
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "entity":
+			if len(fields) < 4 || fields[1] == "" {
+				continue
+			}
+			entity := GraphEntity{
+				Name:         fields[1],
+				Type:         fields[2],
+				Descriptions: fields[3],
+			}
+			// The attributes are a JSON object, which may hold the delimiter itself.
+			if len(fields) > 4 {
+				attributes, _ := jsonrepair.JSONRepair(strings.Join(fields[4:], tupleDelimiter))
+				_ = json.Unmarshal([]byte(attributes), &entity.Properties)
+			}
+			result.Entities = append(result.Entities, entity)
+		case "relationship":
+			if len(fields) < 5 || fields[1] == "" || fields[2] == "" {
+				continue
+			}
+			relationship := GraphRelationship{
+				SourceEntity: fields[1],
+				TargetEntity: fields[2],
+				Descriptions: fields[3],
+				Weight:       1.0,
+			}
+			for keyword := range strings.SplitSeq(fields[4], ",") {
+				if keyword = strings.TrimSpace(keyword); keyword != "" {
+					relationship.Keywords = append(relationship.Keywords, keyword)
+				}
+			}
+			if len(fields) > 5 {
+				if weight, err := strconv.ParseFloat(fields[5], 64); err == nil {
+					relationship.Weight = weight
+				}
+			}
+			// The validity dates come before the predicate, but a response may leave them out and
+			// end with the predicate, which is told apart by not being a date.
+			dates, predicate := fields[min(6, len(fields)):], ""
+			if len(dates) == 1 && dates[0] != "" && parseDate(&dates[0], false).IsZero() {
+				dates, predicate = nil, dates[0]
+			} else if len(dates) > 2 {
+				dates, predicate = dates[:2], dates[2]
+			}
+			if len(dates) > 0 {
+				relationship.ValidFrom = parseDate(&dates[0], false)
+			}
+			if len(dates) > 1 {
+				relationship.ValidTo = parseDate(&dates[1], true)
+			}
+			if predicate != "" {
+				relationship.Properties = map[string]any{PredicateProperty: predicate}
+			}
+			result.Relationships = append(result.Relationships, relationship)
+		}
+	}
+
+	if len(result.Entities) == 0 && len(result.Relationships) == 0 && !completed {
+		return extractionResult{}, response, errNoTupleRecords
+	}
+	return result, response, nil
+}
@@ -0,0 +1,62 @@
+package golightrag
+
+import (
+	"cmp"
+	"slices"
+)
+
+// weightedGraph is an undirected weighted graph of the entities, used by the community
+// detection and the centrality scores. adj[i][j] is the weight of the edge between i and j, and
+// adj[i][i] is twice the weight of the self-loop of i, so the degree of i is the sum of adj[i].
+type weightedGraph struct {
+	adj    []map[int]float64
+	degree []float64
+	// total is the sum of the degrees, twice the total weight of the edges.
+	total float64
+}
+
+func newWeightedGraph(n int) *weightedGraph {
+	g := &weightedGraph{
+		adj:    make([]map[int]float64, n),
+		degree: make([]float64, n),
+	}
+	for i := range g.adj {
+		g.adj[i] = make(map[int]float64)
+	}
+	return g
+}
+
+// newEntityGraph returns the graph of the entities and relationships, along with the index of
+// each entity in the graph. Entities are sorted by name, so the indexes are deterministic, and
+// relationships weigh at least 1.
+func newEntityGraph(entities []GraphEntity, relationships []GraphRelationship) (*weightedGraph, map[string]int) {
+	slices.SortFunc(entities, func(a, b GraphEntity) int { return cmp.Compare(a.Name, b.Name) })
+	index := make(map[string]int, len(entities))
+	for i, entity := range entities {
+		index[entity.Name] = i
+	}
+
+	graph := newWeightedGraph(len(entities))
+	for _, relationship := range relationships {
+		source, sourceOK := index[relationship.SourceEntity]
+		target, targetOK := index[relationship.TargetEntity]
+		if !sourceOK || !targetOK {
+			continue
+		}
+		graph.addEdge(source, target, max(relationship.Weight, 1))
+	}
+	return graph, index
+}
+
+func (g *weightedGraph) addEdge(i, j int, weight float64) {
+	if i == j {
+		g.adj[i][i] += 2 * weight
+		g.degree[i] += 2 * weight
+	} else {
+		g.adj[i][j] += weight
+		g.adj[j][i] += weight
+		g.degree[i] += weight
+		g.degree[j] += weight
+	}
+	g.total += 2 * weight
+}
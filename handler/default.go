@@ -6,7 +6,7 @@ import (
 	"time"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
-	"github.com/MegaGrindStone/go-light-rag/internal"
+	"github.com/MegaGrindStone/go-light-rag/llm"
 )
 
 // Default implements both DocumentHandler and QueryHandler interfaces for RAG operations.
@@ -20,6 +20,12 @@ type Default struct {
 	EntityTypes              []string
 	Language                 string
 	EntityExtractionExamples []golightrag.EntityExtractionPromptExample
+	// ExtractionFormat is the output format of entity extraction. Defaults to
+	// golightrag.ExtractionFormatJSON.
+	ExtractionFormat golightrag.ExtractionFormat
+	// Ontology declares the attributes of the entity types and the relationship predicates to
+	// extract. If EntityTypes is nil, the entity types of the ontology are extracted.
+	Ontology golightrag.Ontology
 
 	KeywordExtractionGoal     string
 	KeywordExtractionExamples []golightrag.KeywordExtractionPromptExample
@@ -35,6 +41,28 @@ type DocumentConfig struct {
 	ConcurrencyCount        int
 	GleanCount              int
 	MaxSummariesTokenLength int
+	// Tokenizer is used for every token count of the handler and the insert pipeline.
+	// If nil, the GPT-4o tiktoken tokenizer is used.
+	Tokenizer llm.Tokenizer
+	// ExtractionPolicy normalizes and validates the extracted entities and relationships.
+	// The zero value uppercases entity names, except for the Go handler, which preserves them.
+	ExtractionPolicy golightrag.ExtractionPolicy
+}
+
+// splitTokenizer is a tokenizer that can be used to split text on token boundaries.
+type splitTokenizer interface {
+	llm.Tokenizer
+	llm.Decoder
+}
+
+// failedTokenizer is returned by the handlers' Tokenizer methods when the tokenizer can't be
+// loaded, so its users get the load error instead of counting with another tokenizer.
+type failedTokenizer struct {
+	err error
+}
+
+func (f failedTokenizer) Encode(string) ([]int, error) {
+	return nil, f.err
 }
 
 const (
@@ -47,15 +75,27 @@ const (
 )
 
 // ChunksDocument splits a document's content into overlapping chunks of text.
-// It uses tiktoken to encode and decode tokens, and returns an array of Source objects.
+// It encodes and decodes tokens with the configured tokenizer, and returns an array of Source objects.
 // Each Source contains a portion of the original text with appropriate metadata.
+// If the configured tokenizer can't decode tokens, the chunk boundaries are computed with the
+// default tokenizer, while the TokenSize of each chunk is still counted with the configured one.
 // It returns an error if encoding or decoding fails.
 func (d Default) ChunksDocument(content string) ([]golightrag.Source, error) {
 	if content == "" {
 		return []golightrag.Source{}, nil
 	}
 
-	tokenIDs, err := internal.EncodeStringByTiktoken(content)
+	tk := d.Tokenizer()
+	splitter, ok := tk.(splitTokenizer)
+	if !ok {
+		def, err := llm.DefaultTokenizer()
+		if err != nil {
+			return nil, err
+		}
+		splitter, _ = def.(splitTokenizer)
+	}
+
+	tokenIDs, err := splitter.Encode(content)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode string: %w", err)
 	}
@@ -73,14 +113,14 @@ func (d Default) ChunksDocument(content string) ([]golightrag.Source, error) {
 	for index, start := 0, 0; start < len(tokenIDs); index, start = index+1, start+maxTokenSize-overlapTokenSize {
 		end := min(start+maxTokenSize, len(tokenIDs))
 
-		chunkContent, err := internal.DecodeTokensByTiktoken(tokenIDs[start:end])
+		chunkContent, err := splitter.Decode(tokenIDs[start:end])
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode tokens: %w", err)
 		}
 
 		trimmedContent := strings.TrimSpace(chunkContent)
 
-		tokenCount, err := internal.CountTokens(trimmedContent)
+		tokenCount, err := llm.CountTokens(tk, trimmedContent)
 		if err != nil {
 			return nil, fmt.Errorf("failed to count tokens: %w", err)
 		}
@@ -103,7 +143,7 @@ func (d Default) EntityExtractionPromptData() golightrag.EntityExtractionPromptD
 		goal = defaultEntityExtractionGoal
 	}
 	entityTypes := d.EntityTypes
-	if entityTypes == nil {
+	if entityTypes == nil && len(d.Ontology.EntityTypes) == 0 {
 		entityTypes = defaultEntityTypes
 	}
 	language := d.Language
@@ -119,6 +159,8 @@ func (d Default) EntityExtractionPromptData() golightrag.EntityExtractionPromptD
 		EntityTypes: entityTypes,
 		Language:    language,
 		Examples:    examples,
+		Format:      d.ExtractionFormat,
+		Ontology:    d.Ontology,
 	}
 }
 
@@ -161,6 +203,25 @@ func (d Default) MaxSummariesTokenLength() int {
 	return d.Config.MaxSummariesTokenLength
 }
 
+// Tokenizer returns the tokenizer configured in the DocumentConfig.
+// If not explicitly configured, it returns the default GPT-4o tiktoken tokenizer, or a tokenizer
+// that fails to encode with the load error if it can't be loaded.
+func (d Default) Tokenizer() llm.Tokenizer {
+	if d.Config.Tokenizer != nil {
+		return d.Config.Tokenizer
+	}
+	tk, err := llm.DefaultTokenizer()
+	if err != nil {
+		return failedTokenizer{err: err}
+	}
+	return tk
+}
+
+// ExtractionPolicy returns the extraction policy configured in the DocumentConfig.
+func (d Default) ExtractionPolicy() golightrag.ExtractionPolicy {
+	return d.Config.ExtractionPolicy
+}
+
 // KeywordExtractionPromptData returns the data needed to generate prompts for extracting
 // keywords from user queries and conversation history.
 func (d Default) KeywordExtractionPromptData() golightrag.KeywordExtractionPromptData {
@@ -7,6 +7,7 @@ import (
 	golightrag "github.com/MegaGrindStone/go-light-rag"
 	"github.com/MegaGrindStone/go-light-rag/handler"
 	"github.com/MegaGrindStone/go-light-rag/internal"
+	"github.com/MegaGrindStone/go-light-rag/llm"
 )
 
 func TestDefault_ChunksDocument(t *testing.T) {
@@ -225,3 +226,35 @@ func runChunksDocumentTests(t *testing.T, tests []struct {
 		})
 	}
 }
+
+// wordTokenizer counts whitespace separated words, and can't decode.
+type wordTokenizer struct{}
+
+func (wordTokenizer) Encode(text string) ([]int, error) {
+	return make([]int, len(strings.Fields(text))), nil
+}
+
+func TestDefault_Tokenizer(t *testing.T) {
+	t.Run("Defaults to tiktoken", func(t *testing.T) {
+		h := handler.Default{}
+		if _, ok := h.Tokenizer().(llm.Decoder); !ok {
+			t.Errorf("Expected default tokenizer to implement llm.Decoder, got %T", h.Tokenizer())
+		}
+	})
+
+	t.Run("Custom tokenizer counts chunk tokens", func(t *testing.T) {
+		h := handler.Default{
+			Config: handler.DocumentConfig{Tokenizer: wordTokenizer{}},
+		}
+
+		content := "one two three four five"
+		chunks, err := h.ChunksDocument(content)
+		if err != nil {
+			t.Fatalf("ChunksDocument() error = %v", err)
+		}
+		verifyChunkCount(t, chunks, 1)
+		if chunks[0].TokenSize != 5 {
+			t.Errorf("Expected TokenSize 5 from custom tokenizer, got %d", chunks[0].TokenSize)
+		}
+	})
+}
@@ -8,7 +8,7 @@ import (
 	"strings"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
-	"github.com/MegaGrindStone/go-light-rag/internal"
+	"github.com/MegaGrindStone/go-light-rag/llm"
 )
 
 // Go implements specialized document handling for Go source code.
@@ -61,7 +61,7 @@ func (g Go) ChunksDocument(content string) ([]golightrag.Source, error) {
 
 	// Create the package and imports chunk
 	headerCode := getCodeBetweenLines(content, 1, importEnd+1)
-	headerTokenSize, err := internal.CountTokens(headerCode)
+	headerTokenSize, err := llm.CountTokens(g.Tokenizer(), headerCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to count tokens on header: %w", err)
 	}
@@ -95,7 +95,7 @@ func (g Go) ChunksDocument(content string) ([]golightrag.Source, error) {
 			// Add comments and package prefix
 			functionCode = packagePrefix + comments + functionCode
 
-			tokenSize, err := internal.CountTokens(functionCode)
+			tokenSize, err := llm.CountTokens(g.Tokenizer(), functionCode)
 			if err != nil {
 				return nil, fmt.Errorf("failed to count tokens on function: %w", err)
 			}
@@ -125,7 +125,7 @@ func (g Go) ChunksDocument(content string) ([]golightrag.Source, error) {
 					// Add package prefix
 					typeCode = packagePrefix + comments + typeCode
 
-					tokenSize, err := internal.CountTokens(typeCode)
+					tokenSize, err := llm.CountTokens(g.Tokenizer(), typeCode)
 					if err != nil {
 						return nil, fmt.Errorf("failed to count tokens on type: %w", err)
 					}
@@ -145,7 +145,7 @@ func (g Go) ChunksDocument(content string) ([]golightrag.Source, error) {
 				// Add package prefix
 				declCode = packagePrefix + declCode
 
-				tokenSize, err := internal.CountTokens(declCode)
+				tokenSize, err := llm.CountTokens(g.Tokenizer(), declCode)
 				if err != nil {
 					return nil, fmt.Errorf("failed to count tokens on declaration: %w", err)
 				}
@@ -179,9 +179,22 @@ func (g Go) EntityExtractionPromptData() golightrag.EntityExtractionPromptData {
 		EntityTypes: goEntityTypes,
 		Language:    language,
 		Examples:    goEntityExtractionExamples,
+		Format:      g.ExtractionFormat,
+		Ontology:    g.Ontology,
 	}
 }
 
+// ExtractionPolicy returns the extraction policy configured in the DocumentConfig.
+// Unless a NameCase is configured, names are kept as extracted, as Go identifiers are
+// case-sensitive.
+func (g Go) ExtractionPolicy() golightrag.ExtractionPolicy {
+	policy := g.Config.ExtractionPolicy
+	if policy.NameCase == "" {
+		policy.NameCase = golightrag.NameCasePreserve
+	}
+	return policy
+}
+
 // KeywordExtractionPromptData returns the data needed to generate prompts for extracting
 // keywords from Go source code and related queries.
 // It provides Go-specific keyword extraction configurations with custom goals
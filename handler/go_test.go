@@ -348,3 +348,22 @@ func runGoChunksDocumentTests(t *testing.T, tests []struct {
 		})
 	}
 }
+
+func TestGo_ExtractionPolicy(t *testing.T) {
+	t.Run("Preserves names by default", func(t *testing.T) {
+		policy := handler.Go{}.ExtractionPolicy()
+		if policy.NameCase != golightrag.NameCasePreserve {
+			t.Errorf("Expected NameCasePreserve, got %q", policy.NameCase)
+		}
+	})
+
+	t.Run("Keeps the configured policy", func(t *testing.T) {
+		g := handler.Go{Default: handler.Default{Config: handler.DocumentConfig{
+			ExtractionPolicy: golightrag.ExtractionPolicy{NameCase: golightrag.NameCaseUpper, DropSelfLoops: true},
+		}}}
+		policy := g.ExtractionPolicy()
+		if policy.NameCase != golightrag.NameCaseUpper || !policy.DropSelfLoops {
+			t.Errorf("Expected the configured policy, got %+v", policy)
+		}
+	})
+}
@@ -8,7 +8,7 @@ import (
 	"unicode"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
-	"github.com/MegaGrindStone/go-light-rag/internal"
+	"github.com/MegaGrindStone/go-light-rag/llm"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
@@ -542,14 +542,16 @@ func (ac *ASTChunker) applyOverlap(chunks []Chunk, fullText string) []Chunk {
 	for i, chunk := range chunks {
 		newChunk := chunk
 
-		// Add overlap from previous chunk (suffix of previous)
-		if i > 0 {
+		// Add overlap from previous chunk (suffix of previous), as much as fits in MaxChunkSize
+		// with the joining space
+		overlapSize := min(ac.options.OverlapSize, ac.options.MaxChunkSize-len(chunk.Text)-1)
+		if i > 0 && overlapSize > 0 {
 			prevChunk := chunks[i-1]
 			overlapText := ""
 
 			// Get last N characters from previous chunk
-			if len(prevChunk.Text) > ac.options.OverlapSize {
-				overlapText = prevChunk.Text[len(prevChunk.Text)-ac.options.OverlapSize:]
+			if len(prevChunk.Text) > overlapSize {
+				overlapText = prevChunk.Text[len(prevChunk.Text)-overlapSize:]
 			} else {
 				overlapText = prevChunk.Text
 			}
@@ -638,25 +640,21 @@ func (ac *ASTChunker) chunkSectionByParagraphs(section Section) []Chunk {
 	// Add final chunk if there's remaining content
 	trimmedContent := strings.TrimSpace(currentContent)
 	if len(trimmedContent) > 0 {
-		// If the final chunk is too small, try to merge it with the previous chunk
-		if len(currentContent) < ac.options.MinChunkSize && len(chunks) > 0 {
-			// Merge with previous chunk
+		tail := trimmedContent
+		if ac.options.PreserveFormatting {
+			tail = currentContent
+		}
+		mergeSep := "\n\n"
+		// If the final chunk is too small, merge it with the previous chunk, as long as the merged
+		// chunk doesn't exceed MaxChunkSize
+		if len(currentContent) < ac.options.MinChunkSize && len(chunks) > 0 &&
+			len(chunks[len(chunks)-1].Text)+len(mergeSep)+len(tail) <= ac.options.MaxChunkSize {
 			lastChunk := &chunks[len(chunks)-1]
-			mergeSep := "\n\n"
-			if ac.options.PreserveFormatting {
-				lastChunk.Text = lastChunk.Text + mergeSep + currentContent
-			} else {
-				lastChunk.Text = lastChunk.Text + mergeSep + trimmedContent
-			}
+			lastChunk.Text = lastChunk.Text + mergeSep + tail
 			lastChunk.EndPos = section.EndPos
 		} else {
-			text := currentContent
-			if !ac.options.PreserveFormatting {
-				text = trimmedContent
-			}
-
 			chunks = append(chunks, Chunk{
-				Text:         text,
+				Text:         tail,
 				StartPos:     section.StartPos + (currentStart - len(currentContent)),
 				EndPos:       section.EndPos,
 				ChunkType:    "section_paragraph",
@@ -804,25 +802,21 @@ func (ac *ASTChunker) chunkSectionBySentences(section Section) []Chunk {
 	// Add final chunk if there's remaining content
 	trimmedContent := strings.TrimSpace(currentContent)
 	if len(trimmedContent) > 0 {
-		// If the final chunk is too small, try to merge it with the previous chunk
-		if len(currentContent) < ac.options.MinChunkSize && len(chunks) > 0 {
-			// Merge with previous chunk
+		tail := trimmedContent
+		if ac.options.PreserveFormatting {
+			tail = currentContent
+		}
+		mergeSep := " "
+		// If the final chunk is too small, merge it with the previous chunk, as long as the merged
+		// chunk doesn't exceed MaxChunkSize
+		if len(currentContent) < ac.options.MinChunkSize && len(chunks) > 0 &&
+			len(chunks[len(chunks)-1].Text)+len(mergeSep)+len(tail) <= ac.options.MaxChunkSize {
 			lastChunk := &chunks[len(chunks)-1]
-			mergeSep := " "
-			if ac.options.PreserveFormatting {
-				lastChunk.Text = lastChunk.Text + mergeSep + currentContent
-			} else {
-				lastChunk.Text = lastChunk.Text + mergeSep + trimmedContent
-			}
+			lastChunk.Text = lastChunk.Text + mergeSep + tail
 			lastChunk.EndPos = section.EndPos
 		} else {
-			text := currentContent
-			if !ac.options.PreserveFormatting {
-				text = trimmedContent
-			}
-
 			chunks = append(chunks, Chunk{
-				Text:         text,
+				Text:         tail,
 				StartPos:     section.StartPos + (currentStart - len(currentContent)),
 				EndPos:       section.EndPos,
 				ChunkType:    "section_sentence",
@@ -1017,7 +1011,16 @@ type MarkdownAst struct {
 	EntityTypes              []string
 	Language                 string
 	EntityExtractionExamples []golightrag.EntityExtractionPromptExample
-	EmbeddingModel           string
+	// ExtractionFormat is the output format of entity extraction. Defaults to
+	// golightrag.ExtractionFormatJSON.
+	ExtractionFormat golightrag.ExtractionFormat
+	// Ontology declares the attributes of the entity types and the relationship predicates to
+	// extract. If EntityTypes is nil, the entity types of the ontology are extracted.
+	Ontology golightrag.Ontology
+	// EmbeddingModel names the tokenizer used to count chunk tokens when Config.Tokenizer is nil.
+	// It is resolved through llm.DefaultTokenizerRegistry, so it can be a tiktoken model name,
+	// a local tokenizer directory, or a Hugging Face model ID.
+	EmbeddingModel string
 
 	// Configuration for RAG operations
 	Config DocumentConfig
@@ -1044,6 +1047,11 @@ func (m *MarkdownAst) ChunksDocument(content string) ([]golightrag.Source, error
 	// Perform section-aware chunking
 	sectionChunks := chunker.ChunkMarkdown(content)
 
+	tk, err := m.tokenizer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokenizer: %w", err)
+	}
+
 	// Convert to golightrag.Source format, filtering out empty or syntax-only chunks
 	var results []golightrag.Source
 	for _, chunk := range sectionChunks {
@@ -1055,7 +1063,7 @@ func (m *MarkdownAst) ChunksDocument(content string) ([]golightrag.Source, error
 			continue
 		}
 
-		tokenCount, err := internal.CountTokensTokenizers(trimmedContent, m.EmbeddingModel)
+		tokenCount, err := llm.CountTokens(tk, trimmedContent)
 		if err != nil {
 			return nil, fmt.Errorf("failed to count tokens for chunk: %w", err)
 		}
@@ -1077,7 +1085,7 @@ func (m *MarkdownAst) EntityExtractionPromptData() golightrag.EntityExtractionPr
 		goal = defaultEntityExtractionGoal
 	}
 	entityTypes := m.EntityTypes
-	if entityTypes == nil {
+	if entityTypes == nil && len(m.Ontology.EntityTypes) == 0 {
 		entityTypes = defaultEntityTypes
 	}
 	language := m.Language
@@ -1093,6 +1101,8 @@ func (m *MarkdownAst) EntityExtractionPromptData() golightrag.EntityExtractionPr
 		EntityTypes: entityTypes,
 		Language:    language,
 		Examples:    examples,
+		Format:      m.ExtractionFormat,
+		Ontology:    m.Ontology,
 	}
 }
 
@@ -1130,6 +1140,30 @@ func (m *MarkdownAst) MaxSummariesTokenLength() int {
 	return m.Config.MaxSummariesTokenLength
 }
 
+// Tokenizer implements golightrag.TokenizerProvider.
+// It returns Config.Tokenizer if set, otherwise the tokenizer named by EmbeddingModel, which is
+// the default GPT-4o tiktoken tokenizer if EmbeddingModel is empty. If EmbeddingModel can't be
+// loaded, the returned tokenizer fails to encode with the load error, like ChunksDocument does.
+func (m *MarkdownAst) Tokenizer() llm.Tokenizer {
+	tk, err := m.tokenizer()
+	if err != nil {
+		return failedTokenizer{err: fmt.Errorf("failed to load tokenizer: %w", err)}
+	}
+	return tk
+}
+
+// ExtractionPolicy implements golightrag.ExtractionPolicyProvider, returning Config.ExtractionPolicy.
+func (m *MarkdownAst) ExtractionPolicy() golightrag.ExtractionPolicy {
+	return m.Config.ExtractionPolicy
+}
+
+func (m *MarkdownAst) tokenizer() (llm.Tokenizer, error) {
+	if m.Config.Tokenizer != nil {
+		return m.Config.Tokenizer, nil
+	}
+	return llm.LoadTokenizer(m.EmbeddingModel)
+}
+
 // DisplayChunkInfo provides detailed information about chunks for debugging
 func DisplayChunkInfo(chunks []Chunk) {
 	fmt.Printf("Generated %d chunks:\n\n", len(chunks))
@@ -464,6 +464,73 @@ func TestMarkdownAst_ConfigMethods(t *testing.T) {
 	}
 }
 
+func TestMarkdownAst_Tokenizer(t *testing.T) {
+	m := handler.NewMarkdownAst(handler.DocumentConfig{})
+	if _, err := m.Tokenizer().Encode("hello world"); err != nil {
+		t.Errorf("Encode() with the default tokenizer error = %v", err)
+	}
+
+	m.EmbeddingModel = "unknown-tokenizer-model"
+	if _, err := m.Tokenizer().Encode("hello world"); err == nil {
+		t.Error("Encode() with an unloadable tokenizer expected an error")
+	}
+	if _, err := m.ChunksDocument("hello world"); err == nil {
+		t.Error("ChunksDocument() with an unloadable tokenizer expected an error")
+	}
+}
+
+func TestASTChunker_MaxChunkSize(t *testing.T) {
+	// section returns a Markdown section of size characters, with a single word as its content.
+	section := func(heading string, size int) string {
+		return "# " + heading + "\n\n" + strings.Repeat("x", size-len(heading)-4)
+	}
+	paragraph := strings.Repeat("word ", 9) + "end."
+	sentence := "Word word word word word word word word word end. "
+
+	tests := []struct {
+		name        string
+		content     string
+		overlapSize int
+	}{
+		{
+			// The second section leaves no room for overlap (MaxChunkSize-len-1 is zero), the third
+			// one a negative room, and the last one some room.
+			name: "Overlap",
+			content: section("A", 40) + "\n\n" + section("B", 49) + "\n\n" + section("C", 50) + "\n\n" +
+				section("D", 30),
+			overlapSize: 30,
+		},
+		{
+			name:    "Short paragraph tail",
+			content: "# T\n\n" + paragraph + "\n\n" + paragraph + "\n\nShort tail.",
+		},
+		{
+			name:    "Short sentence tail",
+			content: sentence + sentence + "Tail.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := handler.DefaultMarkdownChunkingOptions()
+			options.MaxChunkSize = 50
+			options.MinChunkSize = 20
+			options.OverlapSize = tt.overlapSize
+
+			chunks := handler.NewASTChunker(options).ChunkMarkdown(tt.content)
+			if len(chunks) < 2 {
+				t.Fatalf("ChunkMarkdown() returned %d chunks, want several", len(chunks))
+			}
+			for i, chunk := range chunks {
+				if len(chunk.Text) > options.MaxChunkSize {
+					t.Errorf("Chunk %d has %d characters, want at most %d: %q", i, len(chunk.Text),
+						options.MaxChunkSize, chunk.Text)
+				}
+			}
+		})
+	}
+}
+
 func TestMarkdownAst_InterfaceImplementation(t *testing.T) {
 	// This test ensures MarkdownAst correctly implements DocumentHandler interface
 	var _ golightrag.DocumentHandler = (*handler.MarkdownAst)(nil)
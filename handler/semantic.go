@@ -6,7 +6,6 @@ import (
 	"strings"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
-	"github.com/MegaGrindStone/go-light-rag/internal"
 	"github.com/MegaGrindStone/go-light-rag/llm"
 )
 
@@ -20,6 +19,7 @@ type Semantic struct {
 
 	// LLM is the language model to use for semantic chunking.
 	// This field is required and must be set before using the handler.
+	// Wrap it with golightrag.UsageCollector.Track to record its token usage.
 	LLM golightrag.LLM
 
 	// TokenThreshold is the maximum number of tokens that can be sent to the LLM
@@ -45,6 +45,32 @@ type semanticChunkResponse struct {
 
 const defaultSemanticTokenthreshold = 8000
 
+var semanticChunkSchema = llm.Schema{
+	Name:        "semantic_chunks",
+	Description: "Semantic sections of the text.",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "sections": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "section_summary": {"type": "string"},
+          "start_position": {"type": "integer"},
+          "end_position": {"type": "integer"}
+        },
+        "required": ["section_summary", "start_position", "end_position"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["sections"],
+  "additionalProperties": false
+}`),
+	Strict: true,
+}
+
 // ChunksDocument splits a document's content into semantically meaningful chunks
 // using the configured LLM to identify natural content boundaries.
 //
@@ -69,7 +95,7 @@ func (s Semantic) ChunksDocument(content string) ([]golightrag.Source, error) {
 		s.TokenThreshold = defaultSemanticTokenthreshold
 	}
 
-	tokenCount, err := internal.CountTokens(content)
+	tokenCount, err := llm.CountTokens(s.Tokenizer(), content)
 	if err != nil {
 		return nil, fmt.Errorf("failed to count tokens: %w", err)
 	}
@@ -109,12 +135,20 @@ func (s Semantic) ChunksDocument(content string) ([]golightrag.Source, error) {
 	return s.semanticChunk(content)
 }
 
+// ChunksDocumentWithLLM splits content like ChunksDocument, using llm instead of the LLM field.
+// It implements golightrag.LLMChunker, so Insert can route semantic chunking to another model.
+func (s Semantic) ChunksDocumentWithLLM(content string, llm golightrag.LLM) ([]golightrag.Source, error) {
+	s.LLM = llm
+	return s.ChunksDocument(content)
+}
+
 func (s Semantic) semanticChunk(content string) ([]golightrag.Source, error) {
 	// Prepare the prompt with the content
 	prompt := strings.ReplaceAll(semanticChunkingPrompt, "{{.Content}}", content)
 
-	// Call the LLM to generate the semantic chunks
-	response, err := s.LLM.Chat([]string{prompt})
+	// Call the LLM to generate the semantic chunks, constrained to the response schema when
+	// the LLM supports structured output
+	response, _, err := llm.ChatStructured(s.LLM, []string{prompt}, semanticChunkSchema)
 	response = llm.RemoveMarkdownBackticks(response)
 	response = llm.RemoveThinkTags(response)
 	if err != nil {
@@ -166,7 +200,7 @@ func (s Semantic) semanticChunk(content string) ([]golightrag.Source, error) {
 		}
 
 		// Count tokens for the section
-		tokenCount, err := internal.CountTokens(sectionText)
+		tokenCount, err := llm.CountTokens(s.Tokenizer(), sectionText)
 		if err != nil {
 			return nil, fmt.Errorf("failed to count tokens for section: %w", err)
 		}
@@ -177,6 +211,7 @@ func (s Semantic) semanticChunk(content string) ([]golightrag.Source, error) {
 			tempDefault := Default{
 				ChunkMaxTokenSize:     s.MaxChunkSize,
 				ChunkOverlapTokenSize: min(s.MaxChunkSize/4, 20), // Reasonable overlap that won't exceed MaxChunkSize
+				Config:                DocumentConfig{Tokenizer: s.Config.Tokenizer},
 			}
 
 			// If a section is too large, further split it using the Default chunker
@@ -0,0 +1,141 @@
+package golightrag
+
+import "fmt"
+
+// Hooks are called at defined points of Insert, InsertBatch, ProcessUnprocessedChunk and Query,
+// set with WithHooks, to observe the pipeline, such as for audit logging or progress, or to
+// change it. Each hook receives a pointer to its event, whose items it can modify or remove,
+// and the merge events can be vetoed with Skip. A hook returning an error fails the call.
+// Nil hooks are skipped.
+//
+// The hooks of the chunk extractions and of the merges are called concurrently, up to the
+// ConcurrencyCount of the handler.
+type Hooks struct {
+	// AfterChunking is called with the chunks of a document before they're stored by Insert
+	// and InsertBatch.
+	AfterChunking func(*ChunkingEvent) error
+	// AfterExtraction is called with the entities and relationships extracted from each chunk,
+	// after the ExtractionPolicy and the entity resolution are applied.
+	AfterExtraction func(*ExtractionEvent) error
+	// BeforeEntityMerge and AfterEntityMerge are called before an extracted entity is merged
+	// with the stored one, and after, before the merged entity is stored.
+	BeforeEntityMerge func(*EntityMergeEvent) error
+	AfterEntityMerge  func(*EntityMergeEvent) error
+	// BeforeRelationshipMerge and AfterRelationshipMerge are called before an extracted
+	// relationship is merged with the stored one, and after, before the merged relationship is
+	// stored.
+	BeforeRelationshipMerge func(*RelationshipMergeEvent) error
+	AfterRelationshipMerge  func(*RelationshipMergeEvent) error
+	// AfterSummarization is called when the LLM summarized the descriptions of an entity or
+	// relationship exceeding MaxSummariesTokenLength.
+	AfterSummarization func(*SummarizationEvent) error
+	// AfterKeywordExtraction is called with the keywords the LLM extracted from the query.
+	AfterKeywordExtraction func(*KeywordExtractionEvent) error
+	// AfterRetrieval is called after each retrieval stage of Query.
+	AfterRetrieval func(*RetrievalEvent) error
+}
+
+// ChunkingEvent holds the chunks of a document. Removing a chunk skips it.
+type ChunkingEvent struct {
+	Document Document
+	Chunks   []Source
+}
+
+// ExtractionEvent holds the entities extracted from a chunk, grouped by name, and its
+// relationships, grouped by "SOURCE-TARGET" key. Removing an entity or relationship skips it.
+type ExtractionEvent struct {
+	Source        Source
+	Entities      map[string][]GraphEntity
+	Relationships map[string][]GraphRelationship
+}
+
+// EntityMergeEvent holds the merge of the extractions of an entity from the chunks of a
+// document.
+type EntityMergeEvent struct {
+	Name string
+	// Extracted are the extractions of the entity, and SourceIDs the IDs of their chunks.
+	Extracted []GraphEntity
+	SourceIDs []string
+	// Existing is the stored entity, nil if the entity is new.
+	Existing *GraphEntity
+	// Merged is the entity to store, only set after the merge.
+	Merged GraphEntity
+	// Skip leaves the stored entity as it is.
+	Skip bool
+}
+
+// RelationshipMergeEvent holds the merge of the extractions of a relationship from the chunks of
+// a document.
+type RelationshipMergeEvent struct {
+	SourceEntity string
+	TargetEntity string
+	// Extracted are the extractions of the relationship, and SourceIDs the IDs of their chunks.
+	Extracted []GraphRelationship
+	SourceIDs []string
+	// Existing is the stored relationship, nil if the relationship is new.
+	Existing *GraphRelationship
+	// Merged is the relationship to store, only set after the merge.
+	Merged GraphRelationship
+	// Skip leaves the stored relationship as it is.
+	Skip bool
+}
+
+// SummarizationEvent holds the summary the LLM made of the descriptions of an entity or
+// relationship. Name is the entity name, or the "SOURCE-TARGET" key of the relationship.
+type SummarizationEvent struct {
+	Name         string
+	Descriptions []string
+	Summary      string
+}
+
+// KeywordExtractionEvent holds the keywords extracted from a query.
+type KeywordExtractionEvent struct {
+	Query             string
+	HighLevelKeywords []string
+	LowLevelKeywords  []string
+}
+
+// RetrievalStage identifies a retrieval stage of Query.
+type RetrievalStage string
+
+// Defines the retrieval stages of Query.
+const (
+	// RetrievalStageLocal retrieves the entities matching the low-level keywords, with their
+	// relationships and sources.
+	RetrievalStageLocal RetrievalStage = "local"
+	// RetrievalStageGlobal retrieves the relationships matching the high-level keywords, with
+	// their entities and sources.
+	RetrievalStageGlobal RetrievalStage = "global"
+	// RetrievalStageClaims retrieves the claims of the retrieved entities.
+	RetrievalStageClaims RetrievalStage = "claims"
+)
+
+// RetrievalEvent holds what a retrieval stage retrieved: the entities, relationships and
+// sources of the local and global stages, and the claims of the claims stage. Removing an item
+// leaves it out of the QueryResult.
+type RetrievalEvent struct {
+	Query         string
+	Stage         RetrievalStage
+	Entities      []EntityContext
+	Relationships []RelationshipContext
+	Sources       []SourceContext
+	Claims        []Claim
+}
+
+// WithHooks calls the hooks of h during Insert, InsertBatch, ProcessUnprocessedChunk and Query.
+func WithHooks(h Hooks) Option {
+	return option("WithHooks", callsExtracting|callQuery, func(o *options) {
+		o.hooks = h
+	})
+}
+
+// callHook calls the hook with the event, if it's set.
+func callHook[E any](name string, hook func(*E) error, event *E) error {
+	if hook == nil {
+		return nil
+	}
+	if err := hook(event); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
@@ -1,19 +1,19 @@
 package golightrag
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"reflect"
 	"regexp"
 	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/MegaGrindStone/go-light-rag/internal"
 	llmod "github.com/MegaGrindStone/go-light-rag/llm"
-	jsonrepair "github.com/kaptinlin/jsonrepair"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -45,11 +45,34 @@ type DocumentHandler interface {
 	MaxSummariesTokenLength() int
 }
 
+// TokenizerProvider is implemented by document handlers that count tokens with their own
+// tokenizer, such as the handlers of the handler package. Insert then checks descriptions
+// against MaxSummariesTokenLength with it. Handlers that don't implement it use the default
+// tokenizer.
+type TokenizerProvider interface {
+	Tokenizer() llmod.Tokenizer
+}
+
+// LLMChunker is implemented by document handlers that chunk documents with an LLM, such as
+// handler.Semantic. Insert uses it when Routing.SemanticChunking is set.
+type LLMChunker interface {
+	// ChunksDocumentWithLLM splits content like ChunksDocument, using llm instead of the
+	// handler's own LLM.
+	ChunksDocumentWithLLM(content string, llm LLM) ([]Source, error)
+}
+
 // Document represents a text document to be processed and stored.
 // It contains an ID for unique identification and the content to be analyzed.
 type Document struct {
 	ID      string
 	Content string
+	// Origin is where the document was sourced from, stored with its chunks so query results
+	// can cite it.
+	Origin Origin
+	// Metadata is arbitrary metadata of the document, such as its tags, author, date or tenant.
+	// It's stored with its chunks, propagated to the entities and relationships extracted from
+	// them, and matched by WithMetadataFilter.
+	Metadata map[string]string
 }
 
 type summarizeDescriptionsPromptData struct {
@@ -64,7 +87,11 @@ const GraphFieldSeparator = "<SEP>"
 // InsertChunks processes an array of ContentChunk objects and stores them in the provided storage.
 // It converts ContentChunks to Source objects and stores them for later processing.
 // This is useful when you have pre-chunked content from an external source.
-func InsertChunks(chunks []ContentChunk, storage Storage, logger *slog.Logger) error {
+func InsertChunks(chunks []ContentChunk, storage Storage, logger *slog.Logger, opts ...Option) error {
+	o, err := newOptions(callInsertChunks, opts)
+	if err != nil {
+		return err
+	}
 	logger = logger.With(
 		slog.String("package", "golightrag"),
 		slog.String("function", "InsertChunks"),
@@ -75,6 +102,11 @@ func InsertChunks(chunks []ContentChunk, storage Storage, logger *slog.Logger) e
 		return nil
 	}
 
+	storage, err = o.workspaceStorage(storage)
+	if err != nil {
+		return err
+	}
+
 	// Convert ContentChunks to Source objects
 	sources := make([]Source, len(chunks))
 	for i, chunk := range chunks {
@@ -83,6 +115,9 @@ func InsertChunks(chunks []ContentChunk, storage Storage, logger *slog.Logger) e
 			Content:    chunk.Text,
 			TokenSize:  0, // Will be calculated if needed
 			OrderIndex: chunk.ChunkIndex,
+			DocumentID: chunk.ContentID,
+			Origin:     chunk.Origin,
+			Metadata:   chunk.Metadata,
 		}
 	}
 
@@ -99,7 +134,13 @@ func InsertChunks(chunks []ContentChunk, storage Storage, logger *slog.Logger) e
 	return nil
 }
 
-func InsertChunk(doc Document, handler DocumentHandler, storage Storage, logger *slog.Logger) error {
+func InsertChunk(
+	doc Document, handler DocumentHandler, storage Storage, logger *slog.Logger, opts ...Option,
+) error {
+	o, err := newOptions(callInsertChunk, opts)
+	if err != nil {
+		return err
+	}
 	content := cleanContent(doc.Content)
 
 	logger = logger.With(
@@ -107,6 +148,11 @@ func InsertChunk(doc Document, handler DocumentHandler, storage Storage, logger
 		slog.String("function", "ChunkDocument"),
 	)
 
+	storage, err = o.workspaceStorage(storage)
+	if err != nil {
+		return err
+	}
+
 	chunks, err := handler.ChunksDocument(content)
 	if err != nil {
 		return fmt.Errorf("failed to chunk string: %w", err)
@@ -123,6 +169,9 @@ func InsertChunk(doc Document, handler DocumentHandler, storage Storage, logger
 			Content:    chunk.Content,
 			TokenSize:  chunk.TokenSize,
 			OrderIndex: chunk.OrderIndex,
+			DocumentID: doc.ID,
+			Origin:     doc.Origin,
+			Metadata:   doc.Metadata,
 		}
 	}
 
@@ -139,50 +188,110 @@ func InsertChunk(doc Document, handler DocumentHandler, storage Storage, logger
 	return nil
 }
 
-func ProcessUnprocessedChunk(sources []Source, handler DocumentHandler, storage Storage, llm LLM, logger *slog.Logger) error {
+func ProcessUnprocessedChunk(
+	sources []Source,
+	handler DocumentHandler,
+	storage Storage,
+	llm LLM,
+	logger *slog.Logger,
+	opts ...Option,
+) error {
+	o, err := newOptions(callProcessUnprocessedChunk, opts)
+	if err != nil {
+		return err
+	}
 	logger = logger.With(
 		slog.String("package", "golightrag"),
 		slog.String("function", "Insert"),
 	)
 
-	llmConcurrencyCount := handler.ConcurrencyCount()
-	if llmConcurrencyCount == 0 {
-		llmConcurrencyCount = 1
+	o.scope = newMergeScope(storage, o.workspace)
+	storage, err = o.workspaceStorage(storage)
+	if err != nil {
+		return err
 	}
 
-	// Extract document ID from the first source ID for entity extraction
-	// Source IDs are in format "docID-chunk-N", so extract the docID part
-	var docID string
-	if len(sources) > 0 {
-		// Parse docID from source ID (format: "docID-chunk-N")
-		parts := strings.Split(sources[0].ID, "-chunk-")
-		if len(parts) > 0 {
-			docID = parts[0]
-		}
+	// Entities and relationships take the metadata of the stored sources.
+	sources, err = withStoredDocuments(sources, storage)
+	if err != nil {
+		return fmt.Errorf("failed to get source documents: %w", err)
 	}
 
-	if err := extractEntities(docID, sources, llm,
-		handler.EntityExtractionPromptData(), handler.MaxRetries(), llmConcurrencyCount, handler.GleanCount(),
-		handler.MaxSummariesTokenLength(), handler.BackoffDuration(), storage, logger); err != nil {
+	llms := o.stageLLMs(llm)
+	merged := &mergedEntities{}
+	// The sources may come from several documents, so their extractions keep the sources' own
+	// IDs.
+	cfg := newExtractionConfig("", handler, llms, o, newWorkers(handler), insertProgress{merged: merged})
+	if err := extractEntities(sources, cfg, storage, logger); err != nil {
 		return fmt.Errorf("failed to extract entities: %w", err)
 	}
 
-	return nil
+	return updateGraphAnalytics(storage, o, llms, merged.list(), logger)
 }
 
 // Insert processes a document and stores it in the provided storage.
 // It chunks the document content, extracts entities and relationships using the provided
 // document handler, and stores the results in the appropriate storage.
 // It returns an error if any step in the process fails.
-func Insert(doc Document, handler DocumentHandler, storage Storage, llm LLM, logger *slog.Logger) error {
-	content := cleanContent(doc.Content)
+// Use WithUsage to record the token usage of the LLM calls made during the insertion.
+func Insert(doc Document, handler DocumentHandler, storage Storage, llm LLM, logger *slog.Logger, opts ...Option) error {
+	o, err := newOptions(callInsert, opts)
+	if err != nil {
+		return err
+	}
 
 	logger = logger.With(
 		slog.String("package", "golightrag"),
 		slog.String("function", "Insert"),
 	)
 
-	chunks, err := handler.ChunksDocument(content)
+	o.scope = newMergeScope(storage, o.workspace)
+	storage, err = o.workspaceStorage(storage)
+	if err != nil {
+		return err
+	}
+
+	llms := o.stageLLMs(llm)
+	merged := &mergedEntities{}
+	progress := insertProgress{merged: merged}
+	if err := insertDocument(doc, handler, storage, llms, o, newWorkers(handler), progress, logger); err != nil {
+		return err
+	}
+
+	return updateGraphAnalytics(storage, o, llms, merged.list(), logger)
+}
+
+// insertProgress receives the progress of insertDocument. Its functions may be nil.
+type insertProgress struct {
+	// chunked is called with the number of chunks of the document once they're stored.
+	chunked func(count int)
+	// chunkDone is called once the extraction of each chunk is done, whether it failed or not.
+	chunkDone func()
+	// merged collects the names of the merged entities, if not nil.
+	merged *mergedEntities
+}
+
+// insertDocument chunks the document, stores its chunks and extracts their entities and
+// relationships, with the LLM calls of the extraction bounded by workers.
+func insertDocument(
+	doc Document,
+	handler DocumentHandler,
+	storage Storage,
+	llms stageLLMs,
+	o options,
+	workers chan struct{},
+	progress insertProgress,
+	logger *slog.Logger,
+) error {
+	content := cleanContent(doc.Content)
+
+	var chunks []Source
+	var err error
+	if chunker, ok := handler.(LLMChunker); ok && llms.semanticChunking != nil {
+		chunks, err = chunker.ChunksDocumentWithLLM(content, llms.semanticChunking)
+	} else {
+		chunks, err = handler.ChunksDocument(content)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to chunk string: %w", err)
 	}
@@ -198,39 +307,137 @@ func Insert(doc Document, handler DocumentHandler, storage Storage, llm LLM, log
 			Content:    chunk.Content,
 			TokenSize:  chunk.TokenSize,
 			OrderIndex: chunk.OrderIndex,
+			DocumentID: doc.ID,
+			Origin:     doc.Origin,
+			Metadata:   doc.Metadata,
 		}
 	}
 
-	logger.Info("Upserting sources", "count", len(chunks))
+	chunking := ChunkingEvent{Document: doc, Chunks: chunksWithID}
+	if err := callHook("after chunking", o.hooks.AfterChunking, &chunking); err != nil {
+		return err
+	}
+	chunksWithID = chunking.Chunks
+
+	logger.Info("Upserting sources", "count", len(chunksWithID))
 
 	if err := storage.KVUpsertSources(chunksWithID); err != nil {
 		return fmt.Errorf("failed to upsert sources kv: %w", err)
 	}
+	if progress.chunked != nil {
+		progress.chunked(len(chunksWithID))
+	}
+
+	cfg := newExtractionConfig(doc.ID, handler, llms, o, workers, progress)
+	if err := extractEntities(chunksWithID, cfg, storage, logger); err != nil {
+		return fmt.Errorf("failed to extract entities: %w", err)
+	}
+
+	return nil
+}
 
+// sourceID returns the ID of the chunk source of the inserted document, or the source's own ID
+// if the sources were stored beforehand.
+func (c extractionConfig) sourceID(source Source) string {
+	if c.docID == "" {
+		return source.ID
+	}
+	return source.genID(c.docID)
+}
+
+// newWorkers returns the semaphore bounding the concurrent LLM calls of an insertion to the
+// ConcurrencyCount of the handler.
+func newWorkers(handler DocumentHandler) chan struct{} {
 	llmConcurrencyCount := handler.ConcurrencyCount()
 	if llmConcurrencyCount == 0 {
 		llmConcurrencyCount = 1
 	}
+	return make(chan struct{}, llmConcurrencyCount)
+}
 
-	if err := extractEntities(doc.ID, chunks, llm,
-		handler.EntityExtractionPromptData(), handler.MaxRetries(), llmConcurrencyCount, handler.GleanCount(),
-		handler.MaxSummariesTokenLength(), handler.BackoffDuration(), storage, logger); err != nil {
-		return fmt.Errorf("failed to extract entities: %w", err)
+// updateGraphAnalytics updates the centrality and the communities of the graph after an
+// insertion, if the options ask for them. Only the communities of the merged entities are
+// updated.
+func updateGraphAnalytics(storage Storage, o options, llms stageLLMs, merged []string, logger *slog.Logger) error {
+	if o.centrality != nil {
+		if err := updateCentrality(storage, *o.centrality, o.scope, logger); err != nil {
+			return fmt.Errorf("failed to update centrality: %w", err)
+		}
+	}
+	if o.communities != nil {
+		if err := updateCommunities(storage, llms.communityReport, *o.communities, merged, logger); err != nil {
+			return fmt.Errorf("failed to update communities: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func extractEntities(
+// extractionConfig configures the extraction of the entities and relationships of a document.
+type extractionConfig struct {
+	// docID is the ID of the inserted document, or empty if the sources were stored beforehand,
+	// possibly from several documents.
+	docID      string
+	llms       stageLLMs
+	policy     ExtractionPolicy
+	stats      *ExtractionStats
+	resolution *EntityResolution
+	claims     *ClaimExtraction
+	changeLog  bool
+	promptData EntityExtractionPromptData
+
+	maxRetries        int
+	gleanCount        int
+	summariesMaxToken int
+	backoff           time.Duration
+	tokenizer         llmod.Tokenizer
+
+	// workers is the semaphore limiting concurrent LLM calls, possibly shared with other
+	// insertions.
+	workers chan struct{}
+	// chunkDone is called once the extraction of each chunk is done, whether it failed or not.
+	// It may be nil.
+	chunkDone func()
+	// merged collects the names of the merged entities and relationship endpoints. It may be
+	// nil.
+	merged *mergedEntities
+	hooks  Hooks
+	scope  mergeScope
+}
+
+// newExtractionConfig configures the extraction of the document docID, or of stored sources if
+// docID is empty, with the settings of the handler and the options of the insertion.
+func newExtractionConfig(
 	docID string,
-	sources []Source,
-	llm LLM,
-	extractPromptData EntityExtractionPromptData,
-	llmMaxRetries, llmConcurrencyCount, llmMaxGleanCount, summariesMaxToken int,
-	backoffDuration time.Duration,
-	storage Storage,
-	logger *slog.Logger,
-) error {
+	handler DocumentHandler,
+	llms stageLLMs,
+	o options,
+	workers chan struct{},
+	progress insertProgress,
+) extractionConfig {
+	return extractionConfig{
+		docID:             docID,
+		llms:              llms,
+		policy:            extractionPolicy(handler),
+		stats:             o.extractionStats,
+		resolution:        o.resolution,
+		claims:            o.claims,
+		changeLog:         o.changeLog,
+		promptData:        handler.EntityExtractionPromptData(),
+		maxRetries:        handler.MaxRetries(),
+		gleanCount:        handler.GleanCount(),
+		summariesMaxToken: handler.MaxSummariesTokenLength(),
+		backoff:           handler.BackoffDuration(),
+		tokenizer:         handlerTokenizer(handler),
+		workers:           workers,
+		chunkDone:         progress.chunkDone,
+		merged:            progress.merged,
+		hooks:             o.hooks,
+		scope:             o.scope,
+	}
+}
+
+func extractEntities(sources []Source, cfg extractionConfig, storage Storage, logger *slog.Logger) error {
 	// Sort sources by order index to maintain document flow
 	orderedSources := make([]Source, len(sources))
 	copy(orderedSources, sources)
@@ -240,19 +447,45 @@ func extractEntities(
 
 	logger.Info("Extracting entities", "count", len(orderedSources))
 
+	var claimStorage ClaimStorage
+	if cfg.claims != nil {
+		var ok bool
+		if claimStorage, ok = storage.(ClaimStorage); !ok {
+			return errors.New("claim extraction requires a storage implementing ClaimStorage")
+		}
+	}
+
+	var changeLogStorage ChangeLogStorage
+	if cfg.changeLog {
+		var ok bool
+		if changeLogStorage, ok = storage.(ChangeLogStorage); !ok {
+			return errors.New("change log requires a storage implementing ChangeLogStorage")
+		}
+	}
+
+	var resolver *entityResolver
+	if cfg.resolution != nil {
+		r := newEntityResolver(*cfg.resolution, storage, cfg.llms.entityResolution, logger)
+		resolver = &r
+	}
+
 	eg := new(errgroup.Group)
-	// Semaphore to limit concurrent LLM calls
-	sem := make(chan struct{}, llmConcurrencyCount)
 
+	// The chunks are extracted concurrently, then their extractions are merged once per entity
+	// and relationship, so the chunks mentioning the same entity don't overwrite each other.
+	extractions := make([]chunkExtraction, len(orderedSources))
 	for i, source := range orderedSources {
 		eg.Go(func() error {
 			// Acquire semaphore before making LLM call
-			sem <- struct{}{}
-			defer func() { <-sem }()
+			cfg.workers <- struct{}{}
+			defer func() { <-cfg.workers }()
+			if cfg.chunkDone != nil {
+				defer cfg.chunkDone()
+			}
 
 			// Extract entities and relationships for this source chunk
 			entities, relationships, err := llmExtractEntities(source,
-				extractPromptData, llmMaxRetries, llmMaxGleanCount, backoffDuration, llm, logger)
+				cfg.promptData, cfg.maxRetries, cfg.gleanCount, cfg.backoff, cfg.llms, cfg.policy, cfg.stats, logger)
 			if err != nil {
 				return fmt.Errorf("failed to extract entities with LLM: %w", err)
 			} else if len(entities) == 0 && len(relationships) == 0 {
@@ -261,19 +494,30 @@ func extractEntities(
 
 			logger.Info("Done call LLM", "entities", len(entities), "relationships", len(relationships))
 
-			// Process each entity group by name
-			for name, unmergedEntities := range entities {
-				if err := mergeGraphEntities(name, source.genID(docID), extractPromptData.Language,
-					unmergedEntities, summariesMaxToken, storage, llm, logger); err != nil {
-					return fmt.Errorf("failed to process graph entity: %w", err)
+			if resolver != nil {
+				entities, relationships, err = resolver.resolveAll(entities, relationships)
+				if err != nil {
+					return fmt.Errorf("failed to resolve entities: %w", err)
 				}
 			}
 
-			// Process each relationship group by source-target pair
-			for key, unmergedRelationships := range relationships {
-				if err := mergeGraphRelationships(key, source.genID(docID), extractPromptData.Language,
-					unmergedRelationships, summariesMaxToken, storage, llm, logger); err != nil {
-					return fmt.Errorf("failed to process graph relationship: %w", err)
+			extractedSource := source
+			extractedSource.ID = cfg.sourceID(source)
+			extraction := ExtractionEvent{Source: extractedSource, Entities: entities, Relationships: relationships}
+			if err := callHook("after extraction", cfg.hooks.AfterExtraction, &extraction); err != nil {
+				return err
+			}
+			entities, relationships = extraction.Entities, extraction.Relationships
+			extractions[i] = chunkExtraction{entities: entities, relationships: relationships}
+
+			// The claims are stored once the entities are merged, so a failed insertion doesn't
+			// leave claims about entities that were never stored.
+			if cfg.claims != nil {
+				extractions[i].claims, err = llmExtractClaims(extractedSource, *cfg.claims,
+					slices.Collect(maps.Keys(entities)), cfg.promptData.Language, cfg.policy, cfg.maxRetries, cfg.backoff,
+					cfg.llms.claimExtraction, logger)
+				if err != nil {
+					return fmt.Errorf("failed to extract claims with LLM: %w", err)
 				}
 			}
 
@@ -287,6 +531,281 @@ func extractEntities(
 		return err
 	}
 
+	// Group the extractions by entity name and relationship key, in the order of the chunks
+	entities := make(map[string]*extractedMentions[GraphEntity])
+	relationships := make(map[string]*extractedRelationship)
+	for i, extraction := range extractions {
+		sourceID := cfg.sourceID(orderedSources[i])
+		metadata := orderedSources[i].Metadata
+		for name, unmergedEntities := range extraction.entities {
+			if entities[name] == nil {
+				entities[name] = &extractedMentions[GraphEntity]{}
+			}
+			entities[name].add(unmergedEntities, sourceID, metadata)
+		}
+		// The relationships are keyed by their change log key rather than by the "SOURCE-TARGET"
+		// keys of the extraction, since entity names may contain hyphens, and both directions of a
+		// relationship are merged together, between the endpoints of the first mention in the order
+		// of the chunks and of the extraction keys.
+		for _, extractionKey := range slices.Sorted(maps.Keys(extraction.relationships)) {
+			for _, relationship := range extraction.relationships[extractionKey] {
+				key := relationshipChangeKey(relationship.SourceEntity, relationship.TargetEntity)
+				if relationships[key] == nil {
+					relationships[key] = &extractedRelationship{
+						sourceEntity: relationship.SourceEntity,
+						targetEntity: relationship.TargetEntity,
+					}
+				}
+				relationships[key].add([]GraphRelationship{relationship}, sourceID, metadata)
+			}
+		}
+	}
+
+	logger.Info("Merging extractions", "entities", len(entities), "relationships", len(relationships))
+
+	// Entities are merged before relationships, so only the endpoints that weren't extracted
+	// get a placeholder entity.
+	eg = new(errgroup.Group)
+	for name, mentions := range entities {
+		eg.Go(func() error {
+			cfg.workers <- struct{}{}
+			defer func() { <-cfg.workers }()
+
+			unlock := cfg.scope.lock(entityChangeKey(name))
+			defer unlock()
+
+			if err := mergeGraphEntities(name, cfg.promptData.Language, *mentions, cfg.summariesMaxToken, cfg.tokenizer,
+				storage, changeLogStorage, cfg.llms.summarization, cfg.hooks, logger); err != nil {
+				return fmt.Errorf("failed to process graph entity: %w", err)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	eg = new(errgroup.Group)
+	for key, relationship := range relationships {
+		eg.Go(func() error {
+			cfg.workers <- struct{}{}
+			defer func() { <-cfg.workers }()
+
+			unlock := cfg.scope.lock(key)
+			defer unlock()
+
+			if err := mergeGraphRelationships(relationship.sourceEntity, relationship.targetEntity,
+				cfg.promptData.Language, relationship.extractedMentions, cfg.summariesMaxToken, cfg.tokenizer, storage,
+				changeLogStorage, cfg.llms.summarization, cfg.hooks, cfg.scope, logger); err != nil {
+				return fmt.Errorf("failed to process graph relationship: %w", err)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	if claimStorage != nil {
+		if err := upsertClaims(extractions, storage, claimStorage, logger); err != nil {
+			return err
+		}
+	}
+
+	if cfg.merged != nil {
+		cfg.merged.add(slices.Collect(maps.Keys(entities))...)
+		for _, relationship := range relationships {
+			cfg.merged.add(relationship.sourceEntity, relationship.targetEntity)
+		}
+	}
+	return nil
+}
+
+// chunkExtraction is what the LLM extracted from a chunk, with entities grouped by name and
+// relationships by source-target pair.
+type chunkExtraction struct {
+	entities      map[string][]GraphEntity
+	relationships map[string][]GraphRelationship
+	claims        []Claim
+}
+
+// upsertClaims stores the claims of the extractions whose subject is stored, leaving out the
+// claims about the entities the hooks skipped.
+func upsertClaims(
+	extractions []chunkExtraction,
+	storage Storage,
+	claimStorage ClaimStorage,
+	logger *slog.Logger,
+) error {
+	subjects := make([]string, 0)
+	for _, extraction := range extractions {
+		for _, claim := range extraction.claims {
+			subjects = appendIfUnique(subjects, claim.Subject)
+		}
+	}
+	if len(subjects) == 0 {
+		return nil
+	}
+	stored, err := storage.GraphEntities(subjects)
+	if err != nil {
+		return fmt.Errorf("failed to batch get claim subjects: %w", err)
+	}
+
+	claims := make([]Claim, 0)
+	for _, extraction := range extractions {
+		for _, claim := range extraction.claims {
+			if _, ok := stored[claim.Subject]; ok {
+				claims = append(claims, claim)
+			}
+		}
+	}
+	if len(claims) == 0 {
+		return nil
+	}
+
+	logger.Info("Upserting claims", "count", len(claims))
+
+	if err := claimStorage.KVUpsertClaims(claims); err != nil {
+		return fmt.Errorf("failed to upsert claims: %w", err)
+	}
+	return nil
+}
+
+// extractedMentions collects the extractions of an entity or relationship from all the chunks
+// mentioning it, with the IDs and metadata of these chunks.
+type extractedMentions[T any] struct {
+	items     []T
+	sourceIDs []string
+	metadata  map[string][]string
+}
+
+func (m *extractedMentions[T]) add(items []T, sourceID string, metadata map[string]string) {
+	m.items = append(m.items, items...)
+	m.sourceIDs = appendIfUnique(m.sourceIDs, sourceID)
+	values := make(map[string][]string, len(metadata))
+	for key, value := range metadata {
+		values[key] = []string{value}
+	}
+	m.metadata = mergeMetadata(m.metadata, values)
+}
+
+// extractedRelationship collects the mentions of a relationship in either direction, merged
+// between the endpoints of its first mention.
+type extractedRelationship struct {
+	extractedMentions[GraphRelationship]
+
+	sourceEntity string
+	targetEntity string
+}
+
+// mergeLocks serializes the merges of the same entity or relationship in the same storage and
+// workspace, including the merges of concurrent insertions.
+var mergeLocks keyLocks
+
+// mergeScope identifies the storage and workspace whose merges are serialized together. The
+// storage is the one passed to the public function, before it's scoped to the workspace, and
+// is left nil if it isn't comparable, so such storages share a scope per workspace.
+type mergeScope struct {
+	storage   any
+	workspace string
+}
+
+func newMergeScope(storage Storage, workspace string) mergeScope {
+	scope := mergeScope{workspace: workspace}
+	if storage != nil && reflect.ValueOf(storage).Comparable() {
+		scope.storage = storage
+	}
+	return scope
+}
+
+// lock locks the merges of the entity or relationship with the change log key in the scope,
+// and returns the function unlocking them.
+func (s mergeScope) lock(key string) func() {
+	return mergeLocks.lock(mergeLockKey{scope: s, key: key})
+}
+
+type mergeLockKey struct {
+	scope mergeScope
+	key   string
+}
+
+// keyLocks is a set of mutexes created on demand for each key, and dropped once unlocked by all
+// their holders.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[mergeLockKey]*keyLock
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks the mutex of key, and returns the function unlocking it.
+func (l *keyLocks) lock(key mergeLockKey) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[mergeLockKey]*keyLock)
+	}
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	kl.mu.Lock()
+	return func() {
+		kl.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		kl.refs--
+		if kl.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// mergedEntities collects the names of the entities merged by concurrent extractions.
+type mergedEntities struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+func (m *mergedEntities) add(names ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.names == nil {
+		m.names = make(map[string]struct{})
+	}
+	for _, name := range names {
+		m.names[name] = struct{}{}
+	}
+}
+
+// list returns the collected names, which is never nil.
+func (m *mergedEntities) list() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.AppendSeq(make([]string, 0, len(m.names)), maps.Keys(m.names))
+}
+
+// extractionPolicy returns the ExtractionPolicy of handler, if it provides one.
+func extractionPolicy(handler DocumentHandler) ExtractionPolicy {
+	if provider, ok := handler.(ExtractionPolicyProvider); ok {
+		return provider.ExtractionPolicy()
+	}
+	return ExtractionPolicy{}
+}
+
+// handlerTokenizer returns the tokenizer of handler, if it provides one, or nil for the default
+// tokenizer.
+func handlerTokenizer(handler DocumentHandler) llmod.Tokenizer {
+	if provider, ok := handler.(TokenizerProvider); ok {
+		return provider.Tokenizer()
+	}
 	return nil
 }
 
@@ -300,81 +819,77 @@ func llmExtractEntities(
 	data EntityExtractionPromptData,
 	maxRetries, maxGleanCount int,
 	backoffDuration time.Duration,
-	llm LLM,
+	llms stageLLMs,
+	policy ExtractionPolicy,
+	stats *ExtractionStats,
 	logger *slog.Logger,
 ) (map[string][]GraphEntity, map[string][]GraphRelationship, error) {
 	data.Input = source.Content
-	extractPrompt, err := promptTemplate("extract-entities", extractEntitiesPrompt, data)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate extract entities prompt: %w", err)
+	if len(data.EntityTypes) == 0 {
+		data.EntityTypes = data.Ontology.typeNames()
 	}
-	gleanPrompt, err := promptTemplate("glean-entities", gleanEntitiesPrompt, data)
+	codec, err := newExtractionCodec(data)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate glean entities prompt: %w", err)
+		return nil, nil, err
 	}
+	extractPrompt, gleanPrompt := codec.extractPrompt, codec.gleanPrompt
 
 	logger.Debug("Use LLM to extract entities from source",
 		"extractPrompt", extractPrompt, "gleanPrompt", gleanPrompt, "source", source)
 
-	type llmResult struct {
-		Entities      []GraphEntity       `json:"entities"`
-		Relationships []GraphRelationship `json:"relationships"`
-	}
-
-	var results llmResult
+	// Every failed attempt moves to the next extraction tier, so each tier but the last one gets
+	// a single attempt, and the last tier gets up to maxRetries attempts, at least one.
+	lastTier := len(llms.extractionTiers) - 1
+	maxAttempts := lastTier + max(maxRetries, 1)
 
 	retry := 0
 
+attempts:
 	for {
-		// If this is not a first retry, add backoff delay.
-		if retry > 0 {
-			time.Sleep(backoffDuration)
-		}
 		// LLM sometimes returns incorrect format, retry up to maxRetries() times.
-		if retry >= maxRetries {
-			return nil, nil, fmt.Errorf("failed to extract entities after %d retries", maxRetries)
+		if retry >= maxAttempts {
+			return nil, nil, fmt.Errorf("failed to extract entities after %d retries", retry)
 		}
+		tier := min(retry, lastTier)
+		// If this is a retry on the same tier, add backoff delay.
+		if retry > 0 && tier == min(retry-1, lastTier) {
+			time.Sleep(backoffDuration)
+		}
+		extractionLLM := llms.extractionTiers[tier]
+		gleanLLM := llms.gleanTiers[min(tier, len(llms.gleanTiers)-1)]
+
+		logger.Debug("Use LLM to extract entities from source", "extractPrompt", extractPrompt, "tier", tier)
 
-		logger.Debug("Use LLM to extract entities from source", "extractPrompt", extractPrompt)
+		var results extractionResult
 
 		// Initial extraction conversation
 		histories := []string{extractPrompt}
 
-		sourceResult, err := llm.Chat(histories)
+		sourceResult, err := codec.chat(extractionLLM, histories)
 		if err != nil {
 			nErr := fmt.Errorf("failed to call LLM: %w", err)
+			// Retrying won't help when the prompt is too long or the credentials are rejected,
+			// unless a stronger tier is left to escalate to.
+			if !llmod.IsRetryable(err) && tier == lastTier {
+				return nil, nil, nErr
+			}
 			retry++
-			logger.Warn("Retry extract", "retry", retry, "error", nErr)
+			logger.Warn("Retry extract", "retry", retry, "tier", tier, "error", nErr)
 			continue
 		}
 
-		sResult := llmod.RemoveMarkdownBackticks(sourceResult)
-		sResult = llmod.RemoveThinkTags(sResult)
-		// Parse initial extraction results
-		var sourceParsed llmResult
-
-		sResult, _ = jsonrepair.JSONRepair(sResult)
-		err = json.Unmarshal([]byte(sResult), &sourceParsed)
-
-		if err != nil {
-			if maxRetries < retry {
-				logger.Info("LLM failed to call source: %s, content: %s", source.ID, source.Content)
-				return map[string][]GraphEntity{}, map[string][]GraphRelationship{}, nil
-			}
-			nErr := fmt.Errorf("failed to parse llm result: %w prompt %s", err, sResult)
+		sResult := cleanExtractionResponse(sourceResult)
+		if strings.TrimSpace(sResult) == "" {
 			retry++
-			logger.Warn("Retry parse result", "retry", retry, "error", nErr)
+			logger.Warn("Retry empty result", "retry", retry, "tier", tier)
 			continue
 		}
-
+		// Parse initial extraction results
+		sourceParsed, sResult, err := codec.parse(sResult)
 		if err != nil {
-			if maxRetries < retry {
-				logger.Info("LLM failed to call source: %s, content: %s", source.ID, source.Content)
-				return map[string][]GraphEntity{}, map[string][]GraphRelationship{}, nil
-			}
-			nErr := fmt.Errorf("failed to parse llm result: %w prompt %s", err, sResult)
+			nErr := fmt.Errorf("%w prompt %s", err, sResult)
 			retry++
-			logger.Warn("Retry parse result", "retry", retry, "error", nErr)
+			logger.Warn("Retry parse result", "retry", retry, "tier", tier, "error", nErr)
 			continue
 		}
 		results.Entities = append(results.Entities, sourceParsed.Entities...)
@@ -382,37 +897,32 @@ func llmExtractEntities(
 
 		histories = append(histories, sResult)
 
-		// "Gleaning" process: attempt to extract additional entities that might have been missed
+		// "Gleaning" process: attempt to extract additional entities that might have been missed.
+		// A failed glean or decision fails the attempt, like a failed extraction.
 		gleanCount := 0
 		for {
 			logger.Debug("Use LLM to glean entities from source", "gleanPrompt", gleanPrompt)
 			histories = append(histories, gleanPrompt)
-			gleanResult, err := llm.Chat(histories)
+			gleanResult, err := codec.chat(gleanLLM, histories)
 			if err != nil {
 				nErr := fmt.Errorf("failed to call LLM on glean: %w", err)
+				if !llmod.IsRetryable(err) && tier == lastTier {
+					return nil, nil, nErr
+				}
 				retry++
-				logger.Warn("Retry glean", "retry", retry, "error", nErr)
-				continue
+				logger.Warn("Retry glean", "retry", retry, "tier", tier, "error", nErr)
+				continue attempts
 			}
 
-			gResult := llmod.RemoveMarkdownBackticks(gleanResult)
-			gResult = llmod.RemoveThinkTags(gResult)
-			gResult, _ = jsonrepair.JSONRepair(gResult)
-
 			// Parse glean results
+			gleanParsed, gResult, err := codec.parse(cleanExtractionResponse(gleanResult))
 			histories = append(histories, gResult)
-
-			var gleanParsed llmResult
-			err = json.Unmarshal([]byte(gResult), &gleanParsed)
 			if err != nil {
-				if maxRetries < retry {
-					logger.Info("LLM failed to call source: %s, content: %s", source.ID, source.Content)
-					return map[string][]GraphEntity{}, map[string][]GraphRelationship{}, nil
-				}
-				nErr := fmt.Errorf("failed to parse llm result: %w \nprompt: %s\nresponse: %s", err, gleanPrompt, gResult)
+				nErr := fmt.Errorf("%w \nprompt: %s\nresponse: %s", err, gleanPrompt, gResult)
 				retry++
-				logger.Warn("Retry parse result", "retry", retry, "error", nErr)
-				continue
+				logger.Warn("Retry parse glean result", "retry", retry, "tier", tier, "source", source.ID,
+					"error", nErr)
+				continue attempts
 			}
 			results.Entities = append(results.Entities, gleanParsed.Entities...)
 			results.Relationships = append(results.Relationships, gleanParsed.Relationships...)
@@ -427,12 +937,15 @@ func llmExtractEntities(
 			decideMessages = append(decideMessages, histories...)
 			decideMessages = append(decideMessages, gleanDecideContinuePrompt)
 
-			decideResult, err := llm.Chat(decideMessages)
+			decideResult, err := llms.gleanDecision.Chat(decideMessages)
 			if err != nil {
 				nErr := fmt.Errorf("failed to call LLM on decide: %w", err)
+				if !llmod.IsRetryable(err) && tier == lastTier {
+					return nil, nil, nErr
+				}
 				retry++
-				logger.Warn("Retry decide", "retry", retry, "error", nErr)
-				continue
+				logger.Warn("Retry decide", "retry", retry, "tier", tier, "error", nErr)
+				continue attempts
 			}
 
 			decideResult = strings.ToLower(strings.TrimSpace(strings.Trim(strings.Trim(decideResult, `"`), `'`)))
@@ -445,69 +958,41 @@ func llmExtractEntities(
 			}
 		}
 
-		// Organize entities by name and relationships by source-target pair
-		entities, relationships := dedupeLLMResult(results.Entities, results.Relationships, data.EntityTypes)
-		return entities, relationships, nil
-	}
-}
+		logger.Info("Extracted entities from source", "source", source.ID, "tier", tier,
+			"entities", len(results.Entities), "relationships", len(results.Relationships))
 
-func dedupeLLMResult(
-	entities []GraphEntity,
-	relationships []GraphRelationship,
-	entityTypes []string,
-) (map[string][]GraphEntity, map[string][]GraphRelationship) {
-	// Group entities by their names and relationships by their source-target pair
-	ents := make(map[string][]GraphEntity, 0)
-	rels := make(map[string][]GraphRelationship, 0)
-
-	// Convert entity types to uppercase for case-insensitive matching
-	expectedEntityTypes := make([]string, 0)
-	for _, et := range entityTypes {
-		expectedEntityTypes = append(expectedEntityTypes, strings.ToUpper(et))
-	}
-	expectedEntityTypes = append(expectedEntityTypes, "UNKNOWN")
-
-	// Process and group entities by name
-	for _, entity := range entities {
-		entity.Type = strings.ToUpper(entity.Type)
-		// Enforce valid entity types; use "UNKNOWN" if invalid
-		if !slices.Contains(expectedEntityTypes, entity.Type) {
-			entity.Type = "UNKNOWN"
-		}
-
-		entity.Name = strings.ToUpper(entity.Name)
-		if _, ok := ents[entity.Name]; !ok {
-			ents[entity.Name] = make([]GraphEntity, 0)
+		// Normalize and validate the results, and organize entities by name and relationships
+		// by source-target pair
+		entities, relationships, report := applyExtractionPolicy(results, data.EntityTypes, data.Ontology, policy)
+		logger.Debug("Applied extraction policy", "source", source.ID,
+			"entities", report.Entities, "relationships", report.Relationships, "rules", report.Rules)
+		if stats != nil {
+			stats.add(report)
 		}
-		ents[entity.Name] = append(ents[entity.Name], entity)
-	}
-
-	// Process and group relationships by composite key: sourceEntity-targetEntity
-	for _, relationship := range relationships {
-		relationship.SourceEntity = strings.ToUpper(relationship.SourceEntity)
-		relationship.TargetEntity = strings.ToUpper(relationship.TargetEntity)
-		relationKey := fmt.Sprintf("%s-%s", relationship.SourceEntity, relationship.TargetEntity)
-		if _, ok := rels[relationKey]; !ok {
-			rels[relationKey] = make([]GraphRelationship, 0)
-		}
-		rels[relationKey] = append(rels[relationKey], relationship)
+		return entities, relationships, nil
 	}
-
-	return ents, rels
 }
 
+// mergeGraphEntities merges the extractions of the entity from the chunks of a document into
+// the stored entity, summarizing its descriptions once. The caller holds the lock of the entity.
 func mergeGraphEntities(
-	name, sourceID, language string,
-	entities []GraphEntity,
+	name, language string,
+	mentions extractedMentions[GraphEntity],
 	summariesMaxToken int,
+	tokenizer llmod.Tokenizer,
 	storage Storage,
+	changeLog ChangeLogStorage,
 	llm LLM,
+	hooks Hooks,
 	logger *slog.Logger,
 ) error {
 	// Collect data from existing entity (if found) to merge with new data
 	existingTypes := make([]string, 0)
 	existingSourceIDs := make([]string, 0)
 	existingDescriptions := make([]string, 0)
+	existingAliases := make([]string, 0)
+	var existingProperties map[string]any
+	existingFound := false
 
 	existingEntity, err := storage.GraphEntity(name)
 	if err != nil {
@@ -516,6 +1001,7 @@ func mergeGraphEntities(
 		}
 		// If entity not found, continue with empty existing data
 	} else {
+		existingFound = true
 		// Extract and parse data from existing entity
 		existingTypes = append(existingTypes, existingEntity.Type)
 
@@ -524,32 +1010,77 @@ func mergeGraphEntities(
 
 		arrSourceIDs := strings.Split(existingEntity.SourceIDs, GraphFieldSeparator)
 		existingSourceIDs = append(existingSourceIDs, arrSourceIDs...)
+
+		existingAliases = append(existingAliases, existingEntity.Aliases...)
+		existingProperties = existingEntity.Properties
+	}
+
+	merge := EntityMergeEvent{Name: name, Extracted: mentions.items, SourceIDs: mentions.sourceIDs}
+	if existingFound {
+		existing := existingEntity
+		merge.Existing = &existing
+	}
+	if err := callHook("before entity merge", hooks.BeforeEntityMerge, &merge); err != nil {
+		return err
+	}
+	if merge.Skip {
+		return nil
 	}
+	mentions.items, mentions.sourceIDs = merge.Extracted, merge.SourceIDs
 
-	// Merge data from new entities
-	for _, entity := range entities {
+	// Merge data from new entities, recording the names of the ones resolved to this entity
+	for _, entity := range mentions.items {
 		existingTypes = append(existingTypes, entity.Type)
 		existingDescriptions = appendIfUnique(existingDescriptions, entity.Descriptions)
+		if entity.Name != name {
+			existingAliases = appendIfUnique(existingAliases, entity.Name)
+		}
+		existingProperties = mergeProperties(existingProperties, entity.Properties)
+	}
+	for _, sourceID := range mentions.sourceIDs {
+		existingSourceIDs = appendIfUnique(existingSourceIDs, sourceID)
 	}
-	existingSourceIDs = appendIfUnique(existingSourceIDs, sourceID)
 
 	// Choose the most frequent entity type from all type mentions
 	entityType := mostFrequentItem(existingTypes)
 	sourceIDs := strings.Join(existingSourceIDs, GraphFieldSeparator)
 
 	// Summarize descriptions if they exceed token limit
-	description, err := descriptionsSummary(name, language, summariesMaxToken, existingDescriptions, llm)
+	description, err := descriptionsSummary(name, language, summariesMaxToken, existingDescriptions, tokenizer, llm,
+		hooks)
 	if err != nil {
 		return fmt.Errorf("failed to summarize descriptions: %w", err)
 	}
 
+	now := time.Now()
 	ent := GraphEntity{
 		Name:         name,
 		Type:         entityType,
 		Descriptions: description,
 		SourceIDs:    sourceIDs,
-		CreatedAt:    time.Now(),
+		Properties:   existingProperties,
+		PageRank:     existingEntity.PageRank,
+		Betweenness:  existingEntity.Betweenness,
+		Metadata:     mergeMetadata(existingEntity.Metadata, mentions.metadata),
+		CreatedAt:    now,
+		UpdatedAt:    now,
 	}
+	if len(existingAliases) > 0 {
+		ent.Aliases = existingAliases
+	}
+	if existingFound {
+		ent.CreatedAt = existingEntity.CreatedAt
+		ent.UpdateCount = existingEntity.UpdateCount + 1
+	}
+
+	merge.Merged = ent
+	if err := callHook("after entity merge", hooks.AfterEntityMerge, &merge); err != nil {
+		return err
+	}
+	if merge.Skip {
+		return nil
+	}
+	ent = merge.Merged
 
 	logger.Debug("Upserting graph entity", "entity", ent)
 
@@ -558,19 +1089,38 @@ func mergeGraphEntities(
 		return fmt.Errorf("failed to upsert graph entity in graph storage: %w", err)
 	}
 
-	if err := storage.VectorUpsertEntity(ent.Name, ent.Name+ent.Descriptions); err != nil {
+	if err := upsertEntityVector(storage, ent); err != nil {
 		return fmt.Errorf("failed to upsert entity in vector storage: %w", err)
 	}
 
+	if ent.Descriptions != existingEntity.Descriptions {
+		if err := appendChange(changeLog, descriptionChange(Change{
+			Key:         entityChangeKey(name),
+			Entity:      name,
+			SourceID:    strings.Join(mentions.sourceIDs, GraphFieldSeparator),
+			UpdateCount: ent.UpdateCount,
+			Time:        now,
+		}, existingEntity.Descriptions, ent.Descriptions)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// mergeGraphRelationships merges the extractions of the relationship from the chunks of a
+// document into the stored relationship, summarizing its descriptions once. The caller holds the
+// lock of the relationship.
 func mergeGraphRelationships(
-	key, sourceID, language string,
-	relationships []GraphRelationship,
+	sourceEntity, targetEntity, language string,
+	mentions extractedMentions[GraphRelationship],
 	summariesMaxToken int,
+	tokenizer llmod.Tokenizer,
 	storage Storage,
+	changeLog ChangeLogStorage,
 	llm LLM,
+	hooks Hooks,
+	scope mergeScope,
 	logger *slog.Logger,
 ) error {
 	// Track existing relationship properties to merge with new data
@@ -578,11 +1128,8 @@ func mergeGraphRelationships(
 	existingDescriptions := make([]string, 0)
 	existingKeywords := make([]string, 0)
 	existingSourceIDs := make([]string, 0)
-
-	// Parse composite key format "SOURCE-TARGET" into separate entity names
-	arrKey := strings.Split(key, "-")
-	sourceEntity := arrKey[0]
-	targetEntity := arrKey[1]
+	var existingProperties map[string]any
+	existingFound := false
 
 	// Retrieve existing relationship data from storage if it exists
 	existingRelationship, err := storage.GraphRelationship(sourceEntity, targetEntity)
@@ -592,6 +1139,7 @@ func mergeGraphRelationships(
 		}
 		// If relationship not found, continue with empty existing data
 	} else {
+		existingFound = true
 		// Accumulate existing weight (weights are additive for relationship strength)
 		existingWeight += existingRelationship.Weight
 
@@ -603,66 +1151,51 @@ func mergeGraphRelationships(
 
 		arrSourceIDs := strings.Split(existingRelationship.SourceIDs, GraphFieldSeparator)
 		existingSourceIDs = append(existingSourceIDs, arrSourceIDs...)
+
+		existingProperties = existingRelationship.Properties
 	}
 
+	merge := RelationshipMergeEvent{
+		SourceEntity: sourceEntity,
+		TargetEntity: targetEntity,
+		Extracted:    mentions.items,
+		SourceIDs:    mentions.sourceIDs,
+	}
+	if existingFound {
+		existing := existingRelationship
+		merge.Existing = &existing
+	}
+	if err := callHook("before relationship merge", hooks.BeforeRelationshipMerge, &merge); err != nil {
+		return err
+	}
+	if merge.Skip {
+		return nil
+	}
+	mentions.items, mentions.sourceIDs = merge.Extracted, merge.SourceIDs
+
 	// Merge new relationship data with existing data
-	for _, relationship := range relationships {
+	for _, relationship := range mentions.items {
 		existingWeight += relationship.Weight
 		existingDescriptions = appendIfUnique(existingDescriptions, relationship.Descriptions)
 		for _, keyword := range relationship.Keywords {
 			existingKeywords = appendIfUnique(existingKeywords, keyword)
 		}
+		existingProperties = mergeProperties(existingProperties, relationship.Properties)
+	}
+	for _, sourceID := range mentions.sourceIDs {
+		existingSourceIDs = appendIfUnique(existingSourceIDs, sourceID)
 	}
-	existingSourceIDs = appendIfUnique(existingSourceIDs, sourceID)
 
 	// Summarize all descriptions if they exceed token limit
-	description, err := descriptionsSummary(key, language, summariesMaxToken, existingDescriptions, llm)
+	description, err := descriptionsSummary(sourceEntity+"-"+targetEntity, language, summariesMaxToken,
+		existingDescriptions, tokenizer, llm, hooks)
 	if err != nil {
 		return fmt.Errorf("failed to summarize descriptions: %w", err)
 	}
 	sourceIDs := strings.Join(existingSourceIDs, GraphFieldSeparator)
 
-	// Create source entity if it doesn't exist
-	// This ensures relationship integrity by avoiding dangling references
-	_, err = storage.GraphEntity(sourceEntity)
-	if err != nil {
-		if !errors.Is(err, ErrEntityNotFound) {
-			return fmt.Errorf("failed to get source entity with name %s: %w", sourceEntity, err)
-		}
-		logger.Debug("Entity not found, upserting", "entity", sourceEntity)
-
-		// Create a minimal placeholder entity with UNKNOWN type
-		if err := storage.GraphUpsertEntity(GraphEntity{
-			Name:         sourceEntity,
-			Type:         "UNKNOWN",
-			Descriptions: description,
-			SourceIDs:    sourceID,
-			CreatedAt:    time.Now(),
-		}); err != nil {
-			return fmt.Errorf("failed to upsert source node with name %s: %w", sourceEntity, err)
-		}
-	}
-
-	// Create target entity if it doesn't exist
-	// Similar to source entity creation for relationship integrity
-	_, err = storage.GraphEntity(targetEntity)
-	if err != nil {
-		if !errors.Is(err, ErrEntityNotFound) {
-			return fmt.Errorf("failed to get target entity with name %s: %w", targetEntity, err)
-		}
-		logger.Debug("Entity not found, upserting", "entity", targetEntity)
-		if err := storage.GraphUpsertEntity(GraphEntity{
-			Name:         targetEntity,
-			Type:         "UNKNOWN",
-			Descriptions: description,
-			SourceIDs:    sourceID,
-			CreatedAt:    time.Now(),
-		}); err != nil {
-			return fmt.Errorf("failed to upsert target node with name %s: %w", targetEntity, err)
-		}
-	}
-
 	// Create final relationship with merged data
+	now := time.Now()
 	rel := GraphRelationship{
 		SourceEntity: sourceEntity,
 		TargetEntity: targetEntity,
@@ -670,7 +1203,35 @@ func mergeGraphRelationships(
 		Descriptions: description,
 		Keywords:     existingKeywords,
 		SourceIDs:    sourceIDs,
-		CreatedAt:    time.Now(),
+		Properties:   existingProperties,
+		Metadata:     mergeMetadata(existingRelationship.Metadata, mentions.metadata),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	rel.ValidFrom, rel.ValidTo = mergeValidity(existingRelationship.ValidFrom, existingRelationship.ValidTo,
+		mentions.items)
+	// The previous description and validity are kept as a version rather than overwritten.
+	if existingFound {
+		rel.CreatedAt = existingRelationship.CreatedAt
+		rel.UpdateCount = existingRelationship.UpdateCount + 1
+		rel.History = relationshipHistory(existingRelationship, rel)
+	}
+
+	merge.Merged = rel
+	if err := callHook("after relationship merge", hooks.AfterRelationshipMerge, &merge); err != nil {
+		return err
+	}
+	if merge.Skip {
+		return nil
+	}
+	rel = merge.Merged
+
+	// Create the entities of the relationship if they don't exist
+	// This ensures relationship integrity by avoiding dangling references
+	for _, entity := range []string{rel.SourceEntity, rel.TargetEntity} {
+		if err := ensureEntity(entity, rel.Descriptions, mentions, storage, changeLog, scope, logger); err != nil {
+			return fmt.Errorf("failed to upsert node with name %s: %w", entity, err)
+		}
 	}
 
 	// Update both graph and vector storage for the relationship
@@ -682,25 +1243,87 @@ func mergeGraphRelationships(
 	// This enables semantic search over relationships
 	keywords := strings.Join(rel.Keywords, GraphFieldSeparator)
 	content := keywords + rel.SourceEntity + rel.TargetEntity + rel.Descriptions
-	if err := storage.VectorUpsertRelationship(rel.SourceEntity, rel.TargetEntity, content); err != nil {
+	if err := upsertRelationshipVector(storage, rel, content); err != nil {
 		return fmt.Errorf("failed to upsert relationship vector: %w", err)
 	}
 
+	if rel.Descriptions != existingRelationship.Descriptions {
+		if err := appendChange(changeLog, descriptionChange(Change{
+			Key:          relationshipChangeKey(sourceEntity, targetEntity),
+			SourceEntity: sourceEntity,
+			TargetEntity: targetEntity,
+			SourceID:     strings.Join(mentions.sourceIDs, GraphFieldSeparator),
+			UpdateCount:  rel.UpdateCount,
+			Time:         now,
+		}, existingRelationship.Descriptions, rel.Descriptions)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func descriptionsSummary(name, language string, maxToken int, descriptions []string, llm LLM) (string, error) {
+// ensureEntity creates the entity of a relationship if it doesn't exist, with an unknown type and
+// the description of the relationship.
+func ensureEntity(
+	name, description string,
+	mentions extractedMentions[GraphRelationship],
+	storage Storage,
+	changeLog ChangeLogStorage,
+	scope mergeScope,
+	logger *slog.Logger,
+) error {
+	unlock := scope.lock(entityChangeKey(name))
+	defer unlock()
+
+	if _, err := storage.GraphEntity(name); err == nil {
+		return nil
+	} else if !errors.Is(err, ErrEntityNotFound) {
+		return fmt.Errorf("failed to get entity: %w", err)
+	}
+	logger.Debug("Entity not found, upserting", "entity", name)
+
+	now := time.Now()
+	sourceIDs := strings.Join(mentions.sourceIDs, GraphFieldSeparator)
+	if err := storage.GraphUpsertEntity(GraphEntity{
+		Name:         name,
+		Type:         UnknownEntityType,
+		Descriptions: description,
+		SourceIDs:    sourceIDs,
+		Metadata:     mergeMetadata(nil, mentions.metadata),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}); err != nil {
+		return err
+	}
+	return appendChange(changeLog, Change{
+		Key:         entityChangeKey(name),
+		Entity:      name,
+		SourceID:    sourceIDs,
+		Description: description,
+		Time:        now,
+	})
+}
+
+func descriptionsSummary(
+	name, language string,
+	maxToken int,
+	descriptions []string,
+	tokenizer llmod.Tokenizer,
+	llm LLM,
+	hooks Hooks,
+) (string, error) {
 	// Join all descriptions with separator
 	joinedDescriptions := strings.Join(descriptions, GraphFieldSeparator)
 
 	// Check if the joined descriptions exceed the token limit
-	tokens, err := internal.EncodeStringByTiktoken(joinedDescriptions)
+	tokenCount, err := llmod.CountTokens(tokenizer, joinedDescriptions)
 	if err != nil {
-		return "", fmt.Errorf("failed to encode string: %w", err)
+		return "", fmt.Errorf("failed to count tokens: %w", err)
 	}
 
 	// If descriptions are under token limit, no need to summarize
-	if len(tokens) < maxToken {
+	if tokenCount < maxToken {
 		return joinedDescriptions, nil
 	}
 
@@ -719,5 +1342,14 @@ func descriptionsSummary(name, language string, maxToken int, descriptions []str
 		return "", fmt.Errorf("failed to generate summarize descriptions prompt: %w", err)
 	}
 
-	return llm.Chat([]string{summarizePrompt})
+	summary, err := llm.Chat([]string{summarizePrompt})
+	if err != nil {
+		return "", err
+	}
+
+	summarization := SummarizationEvent{Name: name, Descriptions: descriptions, Summary: summary}
+	if err := callHook("after summarization", hooks.AfterSummarization, &summarization); err != nil {
+		return "", err
+	}
+	return summarization.Summary, nil
 }
@@ -1,14 +1,22 @@
 package golightrag_test
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/llm"
 )
 
 //nolint:gocognit,gocyclo // Ignore complexity for this test
@@ -324,4 +332,2032 @@ func TestInsert(t *testing.T) {
 			t.Error("Expected error, got nil")
 		}
 	})
+
+	t.Run("Non-retryable LLM error", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-6",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatErr:   fmt.Errorf("wrapped: %w", llm.ErrContextTooLong),
+			chatCalls: make([][]string, 0),
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			gleanCount:  2,
+			maxTokenLen: 1000,
+		}
+
+		storage := &MockStorage{}
+
+		err := golightrag.Insert(doc, handler, storage, mockLLM, logger)
+		if !errors.Is(err, llm.ErrContextTooLong) {
+			t.Errorf("Expected ErrContextTooLong, got %v", err)
+		}
+		if len(mockLLM.chatCalls) != 1 {
+			t.Errorf("Expected a single LLM call without retries, got %d", len(mockLLM.chatCalls))
+		}
+	})
+
+	t.Run("Structured output", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-7",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockStructuredLLM{
+			MockLLM: &MockLLM{
+				chatResponse: `{"entities": [], "relationships": []}`,
+				chatCalls:    make([][]string, 0),
+			},
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			gleanCount:  1,
+			maxTokenLen: 1000,
+		}
+
+		storage := &MockStorage{}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// The extraction and glean calls request the schema, the glean decision doesn't.
+		if len(mockLLM.schemas) != 2 || len(mockLLM.chatCalls) != 3 {
+			t.Fatalf("Expected 2 structured calls out of 3, got %d out of %d", len(mockLLM.schemas), len(mockLLM.chatCalls))
+		}
+		var schema struct {
+			Properties struct {
+				Entities struct {
+					Items struct {
+						Properties struct {
+							EntityType struct {
+								Enum []string `json:"enum"`
+							} `json:"entity_type"`
+						} `json:"properties"`
+					} `json:"items"`
+				} `json:"entities"`
+			} `json:"properties"`
+		}
+		if err := json.Unmarshal(mockLLM.schemas[0].Schema, &schema); err != nil {
+			t.Fatalf("Expected a valid JSON schema, got %v", err)
+		}
+		enum := schema.Properties.Entities.Items.Properties.EntityType.Enum
+		if len(enum) != 2 || enum[0] != "PERSON" || enum[1] != "ORGANIZATION" {
+			t.Errorf("Expected entity types to be restricted to the prompt's, got %v", enum)
+		}
+	})
+
+	t.Run("Replays a recorded insertion", func(t *testing.T) {
+		// The cassette holds the LLM calls of this insertion. Set UPDATE_CASSETTES and
+		// OPENAI_API_KEY to record it again from OpenAI when the extraction prompts change.
+		cassette := filepath.Join("testdata", "insert.json")
+
+		doc := golightrag.Document{
+			ID:      "test-doc-8",
+			Content: "Alice is a software engineer at Acme Corp. Acme Corp builds industrial robots.",
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Alice is a software engineer at Acme Corp.",
+					TokenSize:  9,
+					OrderIndex: 0,
+				},
+				{
+					Content:    "Acme Corp builds industrial robots.",
+					TokenSize:  6,
+					OrderIndex: 1,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION", "PRODUCT"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		var l golightrag.LLM
+		var recorder *llm.Recorder
+		if os.Getenv("UPDATE_CASSETTES") != "" {
+			recorder = llm.NewRecorder(llm.NewOpenAI(os.Getenv("OPENAI_API_KEY"), "gpt-4o-mini", llm.Parameters{}, logger))
+			l = recorder
+		} else {
+			replayer, err := llm.NewReplayer(cassette, llm.CassetteConfig{})
+			if err != nil {
+				t.Fatalf("Failed to load cassette: %v", err)
+			}
+			l = replayer
+		}
+
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+		if err := golightrag.Insert(doc, handler, storage, l, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if recorder != nil {
+			if err := recorder.Save(cassette); err != nil {
+				t.Fatalf("Failed to save cassette: %v", err)
+			}
+		}
+
+		for _, name := range []string{"ALICE", "ACME CORP"} {
+			if _, ok := storage.entities[name]; !ok {
+				t.Errorf("Expected the replayed extraction of %s to be stored, got %v", name, storage.entities)
+			}
+		}
+		if _, ok := storage.relationships["ALICE:ACME CORP"]; !ok {
+			t.Errorf("Expected the replayed relationship to be stored, got %v", storage.relationships)
+		}
+	})
+
+	t.Run("Tuple extraction format", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-9",
+			Content: "Test content",
+		}
+
+		// The records are split by newlines as well as the record delimiter, and the response is
+		// cut off in the middle of the last record.
+		mockLLM := &MockStructuredLLM{
+			MockLLM: &MockLLM{
+				chatResponse: "```\n(\"entity\"<|>Alice<|>PERSON<|>Alice is an engineer.)##\n" +
+					"(\"entity\"<|>\"Acme\"<|>ORGANIZATION<|>Acme is a company.)\n" +
+					"(\"relationship\"<|>Alice<|>Acme<|>Alice works at Acme.<|>employment, work<|>8)##\n" +
+					"(\"entity\"<|>Bob<|>PERS",
+				chatCalls: make([][]string, 0),
+			},
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+				Format:      golightrag.ExtractionFormatTuple,
+			},
+			maxRetries:  3,
+			gleanCount:  1,
+			maxTokenLen: 1000,
+		}
+
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(mockLLM.schemas) != 0 {
+			t.Errorf("Expected no JSON schema to be requested, got %d", len(mockLLM.schemas))
+		}
+		if !strings.Contains(mockLLM.chatCalls[0][0], `("entity"<|><entity_name><|><entity_type><|><entity_description>)`) {
+			t.Errorf("Expected the tuple extraction prompt, got %s", mockLLM.chatCalls[0][0])
+		}
+		// The glean is sent with the extraction response in the history.
+		if len(mockLLM.chatCalls[1]) != 3 || !strings.Contains(mockLLM.chatCalls[1][2], "same format") {
+			t.Errorf("Expected the tuple glean prompt after the extraction, got %q", mockLLM.chatCalls[1])
+		}
+
+		if len(storage.entities) != 2 {
+			t.Fatalf("Expected ALICE and ACME, got %v", storage.entities)
+		}
+		if storage.entities["ACME"].Type != "ORGANIZATION" {
+			t.Errorf("Expected ACME to be an ORGANIZATION, got %s", storage.entities["ACME"].Type)
+		}
+		rel, ok := storage.relationships["ALICE:ACME"]
+		if !ok {
+			t.Fatalf("Expected the ALICE-ACME relationship, got %v", storage.relationships)
+		}
+		if len(rel.Keywords) != 2 || rel.Keywords[0] != "employment" || rel.Keywords[1] != "work" {
+			t.Errorf("Expected keywords [employment work], got %v", rel.Keywords)
+		}
+		if rel.Weight != 16 {
+			t.Errorf("Expected the weights of the extraction and glean to be summed to 16, got %v", rel.Weight)
+		}
+	})
+
+	t.Run("Tuple extraction validity", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-9b",
+			Content: "Test content",
+		}
+
+		// The validity dates come after the strength, may be empty, and are followed by the
+		// predicate when the ontology has any. A record may also end with the predicate alone.
+		mockLLM := &MockLLM{
+			chatResponse: "(\"entity\"<|>Alice<|>PERSON<|>Alice is an engineer.)##\n" +
+				"(\"entity\"<|>Acme<|>ORGANIZATION<|>Acme is a company.)##\n" +
+				"(\"entity\"<|>Initech<|>ORGANIZATION<|>Initech is a company.)##\n" +
+				"(\"entity\"<|>Bob<|>PERSON<|>Bob is a manager.)##\n" +
+				"(\"relationship\"<|>Alice<|>Acme<|>Alice worked at Acme.<|>employment<|>8<|>2015<|>2019-06)##\n" +
+				"(\"relationship\"<|>Alice<|>Initech<|>Alice works at Initech.<|>employment<|>8<|>2019-07-01<|><|>WORKS_AT)##\n" +
+				"(\"relationship\"<|>Bob<|>Initech<|>Bob works at Initech.<|>employment<|>6<|>WORKS_AT)##\n" +
+				"<|COMPLETE|>",
+			chatCalls: make([][]string, 0),
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{{Content: "Test content", TokenSize: 2}},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+				Format:      golightrag.ExtractionFormatTuple,
+				Ontology:    golightrag.Ontology{Predicates: []string{"WORKS_AT"}},
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if !strings.Contains(mockLLM.chatCalls[0][0], "<|><valid_from><|><valid_to><|><relationship_predicate>)") {
+			t.Errorf("Expected the tuple prompt to ask for the validity dates, got %s", mockLLM.chatCalls[0][0])
+		}
+
+		past := storage.relationships["ALICE:ACME"]
+		wantFrom := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+		wantTo := time.Date(2019, 6, 30, 0, 0, 0, 0, time.UTC)
+		if !past.ValidFrom.Equal(wantFrom) || !past.ValidTo.Equal(wantTo) {
+			t.Errorf("Expected ALICE-ACME to be valid from %s to %s, got %s to %s",
+				wantFrom, wantTo, past.ValidFrom, past.ValidTo)
+		}
+		if past.Properties[golightrag.PredicateProperty] != nil {
+			t.Errorf("Expected no predicate for ALICE-ACME, got %v", past.Properties)
+		}
+
+		current := storage.relationships["ALICE:INITECH"]
+		if want := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC); !current.ValidFrom.Equal(want) ||
+			!current.ValidTo.IsZero() {
+			t.Errorf("Expected ALICE-INITECH to be valid from %s on, got %s to %s",
+				want, current.ValidFrom, current.ValidTo)
+		}
+		if current.Properties[golightrag.PredicateProperty] != "WORKS_AT" {
+			t.Errorf("Expected the predicate WORKS_AT for ALICE-INITECH, got %v", current.Properties)
+		}
+
+		legacy := storage.relationships["BOB:INITECH"]
+		if !legacy.ValidFrom.IsZero() || !legacy.ValidTo.IsZero() {
+			t.Errorf("Expected no validity for BOB-INITECH, got %s to %s", legacy.ValidFrom, legacy.ValidTo)
+		}
+		if legacy.Properties[golightrag.PredicateProperty] != "WORKS_AT" {
+			t.Errorf("Expected the predicate WORKS_AT for BOB-INITECH, got %v", legacy.Properties)
+		}
+	})
+
+	t.Run("Extraction policy", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-10",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: "(\"entity\"<|>Straße   Corp.<|>ORGANIZATION<|>A company in Berlin.)##\n" +
+				"(\"entity\"<|>Widget<|>GADGET<|>A product made by Straße Corp, sold all over the world.)##\n" +
+				"(\"entity\"<|>Tiny<|>PERSON<|>x)##\n" +
+				"(\"relationship\"<|>Straße Corp<|>Widget<|>Straße Corp makes Widget.<|>product<|>2)##\n" +
+				"(\"relationship\"<|>Widget<|>Widget<|>Widget is a Widget.<|>identity<|>1)##\n" +
+				"(\"relationship\"<|>Widget<|>Ghost<|>Ghost uses Widget.<|>usage<|>1)\n" +
+				"<|COMPLETE|>",
+			chatCalls: make([][]string, 0),
+		}
+
+		handler := &policyDocumentHandler{
+			MockDocumentHandler: &MockDocumentHandler{
+				sources: []golightrag.Source{
+					{
+						Content:    "Test content",
+						TokenSize:  2,
+						OrderIndex: 0,
+					},
+				},
+				entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+					Goal:        "Extract entities",
+					EntityTypes: []string{"PERSON", "ORGANIZATION"},
+					Language:    "English",
+					Format:      golightrag.ExtractionFormatTuple,
+				},
+				maxRetries:  3,
+				maxTokenLen: 1000,
+			},
+			policy: golightrag.ExtractionPolicy{
+				NameCase:                  golightrag.NameCaseFold,
+				CanonicalizeNames:         true,
+				KeepUnknownTypes:          true,
+				DropDanglingRelationships: true,
+				DropSelfLoops:             true,
+				MinDescriptionLength:      5,
+				MaxDescriptionLength:      40,
+			},
+		}
+
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		stats := &golightrag.ExtractionStats{}
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger,
+			golightrag.WithExtractionStats(stats)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(storage.entities) != 2 {
+			t.Fatalf("Expected only the entities of the accepted relationship, got %v", storage.entities)
+		}
+		if _, ok := storage.entities["strasse corp"]; !ok {
+			t.Errorf("Expected the folded and canonicalized name \"strasse corp\", got %v", storage.entities)
+		}
+		widget := storage.entities["widget"]
+		if widget.Type != "GADGET" {
+			t.Errorf("Expected the unknown type to be kept, got %s", widget.Type)
+		}
+		if len([]rune(widget.Descriptions)) != 40 {
+			t.Errorf("Expected the description to be truncated to 40 characters, got %q", widget.Descriptions)
+		}
+		if _, ok := storage.relationships["strasse corp:widget"]; !ok || len(storage.relationships) != 1 {
+			t.Errorf("Expected only the strasse corp-widget relationship, got %v", storage.relationships)
+		}
+
+		// Every rule is applied to both the extraction and the glean.
+		report := stats.Report()
+		if report.Entities != 4 || report.Relationships != 2 {
+			t.Errorf("Expected 4 entities and 2 relationships accepted, got %d and %d",
+				report.Entities, report.Relationships)
+		}
+		expectedRules := map[golightrag.ExtractionRule]int{
+			golightrag.RuleCanonicalizedName:    2,
+			golightrag.RuleUnknownType:          2,
+			golightrag.RuleShortDescription:     2,
+			golightrag.RuleLongDescription:      2,
+			golightrag.RuleSelfLoop:             2,
+			golightrag.RuleDanglingRelationship: 2,
+		}
+		for rule, count := range expectedRules {
+			if report.Rules[rule] != count {
+				t.Errorf("Expected rule %s to be applied %d times, got %d", rule, count, report.Rules[rule])
+			}
+		}
+	})
+
+	t.Run("Entity resolution", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-11",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Microsoft Corporation", "entity_type": "ORGANIZATION",
+						"entity_description": "Microsoft Corporation is a software company."},
+					{"entity_name": "Satya Nadella", "entity_type": "PERSON",
+						"entity_description": "Satya Nadella is a CEO."}
+				],
+				"relationships": [
+					{"source_entity": "Satya Nadella", "target_entity": "Microsoft Corporation",
+						"relationship_description": "Satya Nadella leads Microsoft Corporation.",
+						"relationship_keywords": ["leadership"], "relationship_strength": 9}
+				]
+			}`,
+			chatCalls: make([][]string, 0),
+		}
+		confirmLLM := &MockLLM{
+			chatResponse: "YES",
+			chatCalls:    make([][]string, 0),
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		// The vector search returns MICROSOFT for every name, but SATYA NADELLA isn't resolved
+		// to it as their types differ.
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"MICROSOFT": {
+					Name:         "MICROSOFT",
+					Type:         "ORGANIZATION",
+					Descriptions: "Microsoft is a software company.",
+					SourceIDs:    "doc-0-chunk-0",
+				},
+			},
+			relationships:            make(map[string]golightrag.GraphRelationship),
+			vectorQueryEntityResults: []string{"MICROSOFT"},
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger,
+			golightrag.WithEntityResolution(golightrag.EntityResolution{Confirm: true}),
+			golightrag.WithRouting(golightrag.Routing{EntityResolution: confirmLLM})); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(confirmLLM.chatCalls) != 1 || !strings.Contains(confirmLLM.chatCalls[0][0], "MICROSOFT CORPORATION") {
+			t.Errorf("Expected a single confirmation of MICROSOFT CORPORATION, got %v", confirmLLM.chatCalls)
+		}
+		if _, ok := storage.entities["MICROSOFT CORPORATION"]; ok {
+			t.Error("Expected MICROSOFT CORPORATION to be merged into MICROSOFT")
+		}
+		microsoft := storage.entities["MICROSOFT"]
+		if !slices.Equal(microsoft.Aliases, []string{"MICROSOFT CORPORATION"}) {
+			t.Errorf("Expected MICROSOFT CORPORATION as alias, got %v", microsoft.Aliases)
+		}
+		if !strings.Contains(microsoft.Descriptions, "Microsoft Corporation is a software company.") {
+			t.Errorf("Expected the descriptions to be merged, got %s", microsoft.Descriptions)
+		}
+		if _, ok := storage.entities["SATYA NADELLA"]; !ok {
+			t.Error("Expected SATYA NADELLA to be stored")
+		}
+		if _, ok := storage.relationships["SATYA NADELLA:MICROSOFT"]; !ok {
+			t.Errorf("Expected the relationship to be resolved to MICROSOFT, got %v", storage.relationships)
+		}
+
+		// An extraction of the alias resolves to MICROSOFT without a vector search or confirmation.
+		storage.vectorQueryEntityResults = nil
+		if err := golightrag.Insert(golightrag.Document{ID: "test-doc-12", Content: "Test content"},
+			handler, storage, mockLLM, logger,
+			golightrag.WithEntityResolution(golightrag.EntityResolution{Confirm: true}),
+			golightrag.WithRouting(golightrag.Routing{EntityResolution: confirmLLM})); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, ok := storage.entities["MICROSOFT CORPORATION"]; ok || len(confirmLLM.chatCalls) != 1 {
+			t.Errorf("Expected the alias to be resolved without confirmation, got %d confirmations",
+				len(confirmLLM.chatCalls))
+		}
+	})
+	t.Run("Entity resolution without confirmation", func(t *testing.T) {
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Apple Music", "entity_type": "ORGANIZATION",
+						"entity_description": "Apple Music is a streaming service."},
+					{"entity_name": "Apple, Inc", "entity_type": "ORGANIZATION",
+						"entity_description": "Apple, Inc makes phones."},
+					{"entity_name": "Bank", "entity_type": "ORGANIZATION",
+						"entity_description": "Bank is a bank."}
+				],
+				"relationships": [
+					{"source_entity": "Apple Music", "target_entity": "Bank of America",
+						"relationship_description": "Apple Music banks with Bank of America.",
+						"relationship_keywords": ["banking"], "relationship_strength": 5}
+				]
+			}`,
+			chatCalls: make([][]string, 0),
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		// Without confirmation, only names differing in case, punctuation and spacing resolve:
+		// APPLE MUSIC contains APPLE and BANK is contained in BANK OF AMERICA, but they're
+		// distinct entities. BANK OF AMERICA is only an endpoint, so it has no type to check.
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"APPLE INC": {
+					Name:         "APPLE INC",
+					Type:         "ORGANIZATION",
+					Descriptions: "Apple Inc is a technology company.",
+					SourceIDs:    "doc-0-chunk-0",
+				},
+				"BANK OF AMERICA": {
+					Name:         "BANK OF AMERICA",
+					Type:         "ORGANIZATION",
+					Descriptions: "Bank of America is a bank.",
+					SourceIDs:    "doc-0-chunk-0",
+				},
+			},
+			relationships:            make(map[string]golightrag.GraphRelationship),
+			vectorQueryEntityResults: []string{"APPLE INC", "BANK OF AMERICA"},
+		}
+
+		if err := golightrag.Insert(golightrag.Document{ID: "test-doc-resolution", Content: "Test content"},
+			handler, storage, mockLLM, logger,
+			golightrag.WithEntityResolution(golightrag.EntityResolution{})); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		for _, name := range []string{"APPLE MUSIC", "BANK"} {
+			if _, ok := storage.entities[name]; !ok {
+				t.Errorf("Expected %s to be stored apart, got %v", name, slices.Collect(maps.Keys(storage.entities)))
+			}
+		}
+		if _, ok := storage.entities["APPLE, INC"]; ok {
+			t.Error("Expected APPLE, INC to be merged into APPLE INC")
+		}
+		if aliases := storage.entities["APPLE INC"].Aliases; !slices.Equal(aliases, []string{"APPLE, INC"}) {
+			t.Errorf("Expected APPLE, INC as alias, got %v", aliases)
+		}
+		if _, ok := storage.relationships["APPLE MUSIC:BANK OF AMERICA"]; !ok {
+			t.Errorf("Expected the relationship between the extracted names, got %v", storage.relationships)
+		}
+	})
+	t.Run("Ontology attributes", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-13",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice is the CEO of Acme.",
+						"entity_attributes": {"role": "CEO", "age": "42", "remote": "maybe", "height": 1.7}},
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company.",
+						"entity_attributes": {"founded": null}}
+				],
+				"relationships": [
+					{"source_entity": "Alice", "target_entity": "Acme",
+						"relationship_description": "Alice leads Acme.", "relationship_keywords": ["leadership"],
+						"relationship_strength": 9, "relationship_predicate": "leads"},
+					{"source_entity": "Acme", "target_entity": "Alice",
+						"relationship_description": "Acme pays Alice.", "relationship_keywords": ["salary"],
+						"relationship_strength": 5, "relationship_predicate": "PAYS"}
+				]
+			}`,
+			chatCalls: make([][]string, 0),
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:     "Extract entities",
+				Language: "English",
+				Ontology: golightrag.Ontology{
+					EntityTypes: []golightrag.EntityTypeSchema{
+						{
+							Name: "PERSON",
+							Attributes: []golightrag.AttributeSchema{
+								{Name: "role", Type: golightrag.AttributeTypeString},
+								{Name: "age", Type: golightrag.AttributeTypeNumber},
+								{Name: "remote", Type: golightrag.AttributeTypeBoolean},
+							},
+						},
+						{
+							Name: "ORGANIZATION",
+							Attributes: []golightrag.AttributeSchema{
+								{Name: "founded", Type: golightrag.AttributeTypeNumber},
+							},
+						},
+					},
+					Predicates: []string{"LEADS", "WORKS_AT"},
+				},
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		// The attributes of an existing entity that aren't extracted again are kept.
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"ALICE": {
+					Name:         "ALICE",
+					Type:         "PERSON",
+					Descriptions: "Alice is an engineer.",
+					SourceIDs:    "doc-0-chunk-0",
+					Properties:   map[string]any{"role": "engineer", "remote": true},
+				},
+			},
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		stats := &golightrag.ExtractionStats{}
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger,
+			golightrag.WithExtractionStats(stats)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		prompt := mockLLM.chatCalls[0][0]
+		if !strings.Contains(prompt, "---Ontology---") || !strings.Contains(prompt, "- age (number)") {
+			t.Errorf("Expected the ontology in the extraction prompt, got %s", prompt)
+		}
+		if !strings.Contains(prompt, "[PERSON, ORGANIZATION]") {
+			t.Errorf("Expected the entity types of the ontology in the extraction prompt, got %s", prompt)
+		}
+
+		alice := storage.entities["ALICE"]
+		want := map[string]any{"role": "CEO", "age": 42.0, "remote": true}
+		if !maps.Equal(alice.Properties, want) {
+			t.Errorf("Expected properties %v, got %v", want, alice.Properties)
+		}
+		if acme := storage.entities["ACME"]; acme.Properties != nil {
+			t.Errorf("Expected no properties for the null attribute, got %v", acme.Properties)
+		}
+
+		// Both directions are merged into one relationship, with the declared predicate LEADS and
+		// without the undeclared one.
+		if len(storage.relationships) != 1 {
+			t.Fatalf("Expected a single relationship between ALICE and ACME, got %v", storage.relationships)
+		}
+		for _, relationship := range storage.relationships {
+			if relationship.Properties[golightrag.PredicateProperty] != "LEADS" {
+				t.Errorf("Expected the declared predicate LEADS, got %v", relationship.Properties)
+			}
+		}
+
+		// The rules are applied to both the extraction and the glean.
+		rules := stats.Report().Rules
+		if rules[golightrag.RuleUnknownAttribute] != 2 || rules[golightrag.RuleInvalidAttribute] != 2 ||
+			rules[golightrag.RuleUnknownPredicate] != 2 {
+			t.Errorf("Expected an unknown attribute, invalid attribute and unknown predicate per extraction, got %v",
+				rules)
+		}
+	})
+	t.Run("Claim extraction", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-14",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."},
+					{"entity_name": "Widgets Inc", "entity_type": "ORGANIZATION",
+						"entity_description": "Widgets Inc makes widgets."}
+				],
+				"relationships": []
+			}`,
+			chatCalls: make([][]string, 0),
+		}
+		claimLLM := &MockLLM{
+			chatResponse: `{
+				"claims": [
+					{"subject": "Acme", "object": "Widgets Inc", "claim_type": "acquisition", "claim_status": "TRUE",
+						"claim_description": "Acme acquired Widgets Inc.", "start_date": "2021-03-01",
+						"end_date": "2021-03-01", "source_text": "Acme acquired Widgets Inc on March 1, 2021."},
+					{"subject": "Acme", "object": null, "claim_type": "REGULATORY_FINE", "claim_status": "unclear",
+						"claim_description": "Acme may be fined.", "start_date": "sometime", "end_date": null,
+						"source_text": "Acme may be fined."},
+					{"subject": "Globex", "object": "NONE", "claim_type": "LAWSUIT", "claim_status": "TRUE",
+						"claim_description": "Globex was sued.", "start_date": null, "end_date": null,
+						"source_text": "Globex was sued."}
+				]
+			}`,
+			chatCalls: make([][]string, 0),
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger,
+			golightrag.WithClaimExtraction(golightrag.ClaimExtraction{Description: "Acquisitions and fines."}),
+			golightrag.WithRouting(golightrag.Routing{ClaimExtraction: claimLLM})); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(claimLLM.chatCalls) != 1 {
+			t.Fatalf("Expected a single claim extraction, got %d", len(claimLLM.chatCalls))
+		}
+		prompt := claimLLM.chatCalls[0][0]
+		if !strings.Contains(prompt, "Acquisitions and fines.") || !strings.Contains(prompt, "[ACME, WIDGETS INC]") {
+			t.Errorf("Expected the claim description and the entities in the prompt, got %s", prompt)
+		}
+
+		// The claim about an entity that wasn't extracted from the chunk is dropped.
+		if len(storage.claims) != 2 {
+			t.Fatalf("Expected 2 claims, got %v", storage.claims)
+		}
+		claimsByType := make(map[string]golightrag.Claim)
+		for id, claim := range storage.claims {
+			if !strings.HasPrefix(id, "test-doc-14-chunk-0-claim-") {
+				t.Errorf("Expected the claim ID to start with its source ID, got %s", id)
+			}
+			claimsByType[claim.Type] = claim
+		}
+		acquisition := claimsByType["ACQUISITION"]
+		if acquisition.Subject != "ACME" || acquisition.Object != "WIDGETS INC" || acquisition.Type != "ACQUISITION" ||
+			acquisition.Status != golightrag.ClaimStatusTrue || acquisition.SourceID != "test-doc-14-chunk-0" {
+			t.Errorf("Unexpected acquisition claim %+v", acquisition)
+		}
+		if !acquisition.StartDate.Equal(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected the acquisition to start on 2021-03-01, got %v", acquisition.StartDate)
+		}
+		fine := claimsByType["REGULATORY_FINE"]
+		if fine.Object != "" || fine.Status != golightrag.ClaimStatusSuspected || !fine.StartDate.IsZero() {
+			t.Errorf("Expected a suspected fine without object and dates, got %+v", fine)
+		}
+
+		// Extracting the same claims again updates them instead of adding new ones.
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger,
+			golightrag.WithClaimExtraction(golightrag.ClaimExtraction{Description: "Acquisitions and fines."}),
+			golightrag.WithRouting(golightrag.Routing{ClaimExtraction: claimLLM})); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(storage.claims) != 2 {
+			t.Errorf("Expected the claims to be updated, got %v", storage.claims)
+		}
+
+		// The claims are only stored once their subject is, so they're left out when the merge
+		// of the subject is skipped, or when the insertion fails.
+		storage = &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger,
+			golightrag.WithClaimExtraction(golightrag.ClaimExtraction{}),
+			golightrag.WithRouting(golightrag.Routing{ClaimExtraction: claimLLM}),
+			golightrag.WithHooks(golightrag.Hooks{
+				BeforeEntityMerge: func(e *golightrag.EntityMergeEvent) error {
+					e.Skip = e.Name == "ACME"
+					return nil
+				},
+			})); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(storage.claims) != 0 {
+			t.Errorf("Expected no claims about the skipped entity, got %v", storage.claims)
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger,
+			golightrag.WithClaimExtraction(golightrag.ClaimExtraction{}),
+			golightrag.WithRouting(golightrag.Routing{ClaimExtraction: claimLLM}),
+			golightrag.WithHooks(golightrag.Hooks{
+				BeforeEntityMerge: func(e *golightrag.EntityMergeEvent) error {
+					if e.Name == "WIDGETS INC" {
+						return errors.New("merge failed")
+					}
+					return nil
+				},
+			})); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if len(storage.claims) != 0 {
+			t.Errorf("Expected no claims after a failed insertion, got %v", storage.claims)
+		}
+	})
+
+	t.Run("Relationship validity and history", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-15",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice is an engineer."},
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+				],
+				"relationships": [
+					{"source_entity": "Alice", "target_entity": "Acme",
+						"relationship_description": "Alice rejoined Acme.", "relationship_keywords": ["employment"],
+						"relationship_strength": 8, "valid_from": "2020-03", "valid_to": null},
+					{"source_entity": "Bob", "target_entity": "Acme",
+						"relationship_description": "Bob left Acme.", "relationship_keywords": ["employment"],
+						"relationship_strength": 5, "valid_from": null, "valid_to": "2019"}
+				]
+			}`,
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		previous := golightrag.GraphRelationship{
+			SourceEntity: "ALICE",
+			TargetEntity: "ACME",
+			Weight:       5,
+			Descriptions: "Alice worked at Acme.",
+			SourceIDs:    "doc-0-chunk-0",
+			ValidFrom:    time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC),
+			ValidTo:      time.Date(2015, 12, 31, 0, 0, 0, 0, time.UTC),
+			CreatedAt:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt:    time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		}
+		storage := &MockStorage{
+			entities: make(map[string]golightrag.GraphEntity),
+			relationships: map[string]golightrag.GraphRelationship{
+				"ALICE:ACME": previous,
+				// Stored before UpdatedAt was tracked.
+				"BOB:ACME": {
+					SourceEntity: "BOB",
+					TargetEntity: "ACME",
+					Descriptions: "Bob works at Acme.",
+					CreatedAt:    time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		relationship := storage.relationships["ALICE:ACME"]
+		if !relationship.ValidFrom.Equal(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)) || !relationship.ValidTo.IsZero() {
+			t.Errorf("Expected the relationship to be valid since 2020-03-01, got %v to %v",
+				relationship.ValidFrom, relationship.ValidTo)
+		}
+		if len(relationship.History) != 1 {
+			t.Fatalf("Expected the previous version in the history, got %+v", relationship.History)
+		}
+		version := relationship.History[0]
+		if version.Descriptions != previous.Descriptions || version.SourceIDs != previous.SourceIDs ||
+			!version.ValidFrom.Equal(previous.ValidFrom) || !version.ValidTo.Equal(previous.ValidTo) ||
+			!version.CreatedAt.Equal(previous.UpdatedAt) {
+			t.Errorf("Expected the previous version %+v, got %+v", previous, version)
+		}
+		if history := storage.relationships["BOB:ACME"].History; len(history) != 1 ||
+			!history[0].CreatedAt.Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected a version recorded at the creation of BOB-ACME, got %+v", history)
+		}
+
+		// A merge that only adds to the description keeps the period and records no version.
+		mockLLM.chatResponse = `{
+			"entities": [],
+			"relationships": [
+				{"source_entity": "Alice", "target_entity": "Acme",
+					"relationship_description": "Alice leads a team at Acme.", "relationship_keywords": ["employment"],
+					"relationship_strength": 8, "valid_from": null, "valid_to": null}
+			]
+		}`
+		doc.ID = "test-doc-15b"
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		relationship = storage.relationships["ALICE:ACME"]
+		if !strings.Contains(relationship.Descriptions, "Alice leads a team at Acme.") {
+			t.Errorf("Expected the new description to be merged, got %q", relationship.Descriptions)
+		}
+		if len(relationship.History) != 1 {
+			t.Errorf("Expected no new version for a description change, got %+v", relationship.History)
+		}
+	})
+
+	t.Run("Timestamps and change log", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-16",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice leads the Acme team."},
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+				],
+				"relationships": [
+					{"source_entity": "Alice", "target_entity": "Acme",
+						"relationship_description": "Alice works at Acme.", "relationship_keywords": ["employment"],
+						"relationship_strength": 8}
+				]
+			}`,
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		newStorage := func() *MockStorage {
+			return &MockStorage{
+				entities: map[string]golightrag.GraphEntity{
+					"ALICE": {
+						Name:         "ALICE",
+						Type:         "PERSON",
+						Descriptions: "Alice is an engineer.",
+						SourceIDs:    "doc-0-chunk-0",
+						CreatedAt:    createdAt,
+						UpdatedAt:    createdAt,
+						UpdateCount:  2,
+					},
+				},
+				relationships: make(map[string]golightrag.GraphRelationship),
+			}
+		}
+
+		storage := newStorage()
+		start := time.Now()
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger, golightrag.WithChangeLog()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		alice := storage.entities["ALICE"]
+		if !alice.CreatedAt.Equal(createdAt) || alice.UpdatedAt.Before(start) || alice.UpdateCount != 3 {
+			t.Errorf("Expected ALICE to keep its creation time and count the update, got %v, %v and %d",
+				alice.CreatedAt, alice.UpdatedAt, alice.UpdateCount)
+		}
+		acme := storage.entities["ACME"]
+		if acme.CreatedAt.Before(start) || !acme.UpdatedAt.Equal(acme.CreatedAt) || acme.UpdateCount != 0 {
+			t.Errorf("Expected ACME to be created now, got %v, %v and %d",
+				acme.CreatedAt, acme.UpdatedAt, acme.UpdateCount)
+		}
+
+		changes, err := golightrag.EntityChanges(storage, "ALICE")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(changes) != 1 {
+			t.Fatalf("Expected a change of ALICE, got %+v", changes)
+		}
+		change := changes[0]
+		if change.SourceID != "test-doc-16-chunk-0" || change.UpdateCount != 3 {
+			t.Errorf("Expected the change to record the merge of the chunk, got %+v", change)
+		}
+		// The merge appended to the description, so only the appended description is logged.
+		if !change.Appended || change.PreviousDescription != "" ||
+			strings.Contains(change.Description, "Alice is an engineer.") ||
+			change.DescriptionAfter("Alice is an engineer.") != alice.Descriptions {
+			t.Errorf("Expected the change to only hold the appended description, got %+v", change)
+		}
+
+		// Relationships are undirected, so their change log is found either way round.
+		changes, err = golightrag.RelationshipChanges(storage, "ACME", "ALICE")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(changes) != 1 || changes[0].PreviousDescription != "" || changes[0].Appended ||
+			changes[0].Description != "Alice works at Acme." {
+			t.Errorf("Expected the creation of the relationship, got %+v", changes)
+		}
+
+		// The change log requires a ChangeLogStorage.
+		err = golightrag.Insert(doc, handler, struct{ golightrag.Storage }{newStorage()}, mockLLM, logger,
+			golightrag.WithChangeLog())
+		if err == nil {
+			t.Errorf("Expected an error for a storage without change log support")
+		}
+	})
+
+	t.Run("Document metadata", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:       "test-doc-17",
+			Content:  "Test content",
+			Metadata: map[string]string{"tenant": "acme", "year": "2024"},
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice leads the Acme team."},
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+				],
+				"relationships": [
+					{"source_entity": "Alice", "target_entity": "Acme",
+						"relationship_description": "Alice works at Acme.", "relationship_keywords": ["employment"],
+						"relationship_strength": 8}
+				]
+			}`,
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"ALICE": {
+					Name:         "ALICE",
+					Type:         "PERSON",
+					Descriptions: "Alice is an engineer.",
+					SourceIDs:    "doc-0-chunk-0",
+					Metadata:     map[string][]string{"year": {"2023"}},
+				},
+			},
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// The metadata of the merged entity is the union of the metadata of its sources.
+		want := map[string][]string{"tenant": {"acme"}, "year": {"2023", "2024"}}
+		if got := storage.entities["ALICE"].Metadata; !maps.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("Expected ALICE metadata %v, got %v", want, got)
+		}
+		want = map[string][]string{"tenant": {"acme"}, "year": {"2024"}}
+		if got := storage.entities["ACME"].Metadata; !maps.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("Expected ACME metadata %v, got %v", want, got)
+		}
+		if got := storage.relationships["ALICE:ACME"].Metadata; !maps.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("Expected relationship metadata %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Workspaces", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-18",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+				],
+				"relationships": []
+			}`,
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		storage := &workspaceStorage{
+			MockStorage: &MockStorage{
+				entities:      make(map[string]golightrag.GraphEntity),
+				relationships: make(map[string]golightrag.GraphRelationship),
+			},
+		}
+
+		err := golightrag.Insert(doc, handler, storage, mockLLM, logger, golightrag.WithWorkspace("acme"))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		acme := storage.workspaces["acme"]
+		if acme == nil {
+			t.Fatal("Expected the acme workspace to be opened")
+		}
+		if _, ok := acme.entities["ACME"]; !ok {
+			t.Error("Expected ACME to be inserted in the acme workspace")
+		}
+		if !acme.kvUpsertSourcesCalled {
+			t.Error("Expected the chunks to be stored in the acme workspace")
+		}
+		if len(storage.entities) != 0 || storage.kvUpsertSourcesCalled {
+			t.Error("Expected the default workspace to stay untouched")
+		}
+
+		// A storage without workspaces can't honour one.
+		err = golightrag.Insert(doc, handler, storage.MockStorage, mockLLM, logger, golightrag.WithWorkspace("acme"))
+		if err == nil {
+			t.Error("Expected an error for a storage without workspaces")
+		}
+	})
+
+	t.Run("Concurrent chunks mentioning the same entity", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-19",
+			Content: "Test content",
+		}
+
+		mockLLM := &chunkLLM{
+			responses: map[string]string{
+				"Alice founded Acme.": `{
+					"entities": [
+						{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice founded Acme."},
+						{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+					],
+					"relationships": [
+						{"source_entity": "Alice", "target_entity": "Acme",
+							"relationship_description": "Alice founded Acme.", "relationship_keywords": ["founder"],
+							"relationship_strength": 9}
+					]
+				}`,
+				"Alice runs Acme.": `{
+					"entities": [
+						{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice is the CEO of Acme."}
+					],
+					"relationships": [
+						{"source_entity": "Alice", "target_entity": "Acme",
+							"relationship_description": "Alice runs Acme.", "relationship_keywords": ["leadership"],
+							"relationship_strength": 7}
+					]
+				}`,
+			},
+			summary: "Summarized description.",
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{Content: "Alice founded Acme.", TokenSize: 3, OrderIndex: 0},
+				{Content: "Alice runs Acme.", TokenSize: 3, OrderIndex: 1},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:       3,
+			concurrencyCount: 2,
+			// Every merged description is summarized.
+			maxTokenLen: 1,
+		}
+
+		storage := &lockedStorage{
+			MockStorage: &MockStorage{
+				entities:      make(map[string]golightrag.GraphEntity),
+				relationships: make(map[string]golightrag.GraphRelationship),
+			},
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Both chunks are merged into each entity and relationship, which are summarized once.
+		wantSourceIDs := "test-doc-19-chunk-0" + golightrag.GraphFieldSeparator + "test-doc-19-chunk-1"
+		if got := storage.entities["ALICE"].SourceIDs; got != wantSourceIDs {
+			t.Errorf("Expected ALICE source IDs %q, got %q", wantSourceIDs, got)
+		}
+		if got := storage.relationships["ALICE:ACME"].SourceIDs; got != wantSourceIDs {
+			t.Errorf("Expected relationship source IDs %q, got %q", wantSourceIDs, got)
+		}
+		if got := storage.relationships["ALICE:ACME"].Weight; got != 16 {
+			t.Errorf("Expected relationship weight 16, got %v", got)
+		}
+		if got := storage.entities["ALICE"].Descriptions; got != mockLLM.summary {
+			t.Errorf("Expected ALICE description %q, got %q", mockLLM.summary, got)
+		}
+
+		summaries := mockLLM.summaryPrompts()
+		if len(summaries) != 3 {
+			t.Fatalf("Expected 3 summarizations, one for ALICE, ACME and their relationship, got %d",
+				len(summaries))
+		}
+		found := false
+		for _, prompt := range summaries {
+			if strings.Contains(prompt, "Alice founded Acme.") && strings.Contains(prompt, "Alice is the CEO of Acme.") {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("Expected the descriptions of ALICE from both chunks to be summarized together")
+		}
+	})
+
+	t.Run("Both directions of a relationship", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-19b",
+			Content: "Test content",
+		}
+
+		mockLLM := &chunkLLM{
+			responses: map[string]string{
+				"Alice founded Acme.": `{
+					"entities": [
+						{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice founded Acme."},
+						{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+					],
+					"relationships": [
+						{"source_entity": "Alice", "target_entity": "Acme",
+							"relationship_description": "Alice founded Acme.", "relationship_keywords": ["founder"],
+							"relationship_strength": 9}
+					]
+				}`,
+				"Acme employs Alice.": `{
+					"entities": [
+						{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme employs Alice."}
+					],
+					"relationships": [
+						{"source_entity": "Acme", "target_entity": "Alice",
+							"relationship_description": "Acme employs Alice.", "relationship_keywords": ["employment"],
+							"relationship_strength": 7}
+					]
+				}`,
+			},
+			summary: "Summarized description.",
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{Content: "Alice founded Acme.", TokenSize: 3, OrderIndex: 0},
+				{Content: "Acme employs Alice.", TokenSize: 3, OrderIndex: 1},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:       3,
+			concurrencyCount: 2,
+			maxTokenLen:      1000,
+		}
+
+		storage := &lockedStorage{
+			MockStorage: &MockStorage{
+				entities:      make(map[string]golightrag.GraphEntity),
+				relationships: make(map[string]golightrag.GraphRelationship),
+			},
+		}
+
+		var merges []golightrag.RelationshipMergeEvent
+		hooks := golightrag.Hooks{
+			BeforeRelationshipMerge: func(e *golightrag.RelationshipMergeEvent) error {
+				merges = append(merges, *e)
+				return nil
+			},
+		}
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger, golightrag.WithHooks(hooks)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		// Both directions are merged once, between the endpoints of the first mention.
+		if len(merges) != 1 {
+			t.Fatalf("Expected a single relationship merge, got %d", len(merges))
+		}
+		if merges[0].SourceEntity != "ALICE" || merges[0].TargetEntity != "ACME" || len(merges[0].Extracted) != 2 {
+			t.Errorf("Expected both mentions merged into ALICE:ACME, got %s:%s with %d mentions",
+				merges[0].SourceEntity, merges[0].TargetEntity, len(merges[0].Extracted))
+		}
+		if _, ok := storage.relationships["ACME:ALICE"]; ok {
+			t.Error("Expected no relationship stored from ACME to ALICE")
+		}
+		if got := storage.relationships["ALICE:ACME"].Weight; got != 16 {
+			t.Errorf("Expected relationship weight 16, got %v", got)
+		}
+	})
+
+	t.Run("Merges in separate storages", func(t *testing.T) {
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{Content: "Test content", TokenSize: 2, OrderIndex: 0},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+		newLLM := func() *MockLLM {
+			return &MockLLM{
+				chatResponse: `{
+					"entities": [
+						{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+					],
+					"relationships": []
+				}`,
+			}
+		}
+		newStorage := func() *MockStorage {
+			return &MockStorage{
+				entities:      make(map[string]golightrag.GraphEntity),
+				relationships: make(map[string]golightrag.GraphRelationship),
+			}
+		}
+
+		shared := &workspaceStorage{MockStorage: newStorage()}
+		// Open the workspaces beforehand, as workspaceStorage can't open them concurrently.
+		for _, workspace := range []string{"first", "second"} {
+			if _, err := shared.InWorkspace(workspace); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+
+		tests := []struct {
+			name                        string
+			firstStorage, secondStorage golightrag.Storage
+			firstOpts, secondOpts       []golightrag.Option
+		}{
+			{
+				name:          "Storages",
+				firstStorage:  newStorage(),
+				secondStorage: newStorage(),
+			},
+			{
+				name:          "Workspaces",
+				firstStorage:  shared,
+				secondStorage: shared,
+				firstOpts:     []golightrag.Option{golightrag.WithWorkspace("first")},
+				secondOpts:    []golightrag.Option{golightrag.WithWorkspace("second")},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				// The first insertion holds the merge of ACME until the second one merges ACME too,
+				// which it can only do if the merges don't share a lock.
+				started := make(chan struct{})
+				secondMerged := make(chan struct{})
+				firstHooks := golightrag.Hooks{
+					BeforeEntityMerge: func(*golightrag.EntityMergeEvent) error {
+						close(started)
+						select {
+						case <-secondMerged:
+							return nil
+						case <-time.After(5 * time.Second):
+							return errors.New("the second merge of ACME waited for the first one")
+						}
+					},
+				}
+				secondHooks := golightrag.Hooks{
+					BeforeEntityMerge: func(*golightrag.EntityMergeEvent) error {
+						close(secondMerged)
+						return nil
+					},
+				}
+
+				firstDoc := golightrag.Document{ID: "test-doc-19c", Content: "Test content"}
+				firstErr := make(chan error, 1)
+				go func() {
+					opts := append(slices.Clone(tt.firstOpts), golightrag.WithHooks(firstHooks))
+					firstErr <- golightrag.Insert(firstDoc, handler, tt.firstStorage, newLLM(), logger, opts...)
+				}()
+				<-started
+
+				secondDoc := golightrag.Document{ID: "test-doc-19d", Content: "Test content"}
+				opts := append(slices.Clone(tt.secondOpts), golightrag.WithHooks(secondHooks))
+				if err := golightrag.Insert(secondDoc, handler, tt.secondStorage, newLLM(), logger, opts...); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if err := <-firstErr; err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+			})
+		}
+	})
+
+	t.Run("Hyphenated entity names", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-20",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "JEAN-PAUL", "entity_type": "PERSON", "entity_description": "A researcher."},
+					{"entity_name": "COVID-19", "entity_type": "DISEASE", "entity_description": "A disease."}
+				],
+				"relationships": [
+					{"source_entity": "JEAN-PAUL", "target_entity": "COVID-19",
+						"relationship_description": "Jean-Paul studies COVID-19.", "relationship_keywords": ["research"],
+						"relationship_strength": 5}
+				]
+			}`,
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{{Content: "Jean-Paul studies COVID-19.", TokenSize: 5}},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "DISEASE"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		rel, ok := storage.relationships["JEAN-PAUL:COVID-19"]
+		if !ok {
+			t.Fatalf("Expected the relationship between JEAN-PAUL and COVID-19, got %v",
+				slices.Collect(maps.Keys(storage.relationships)))
+		}
+		if rel.SourceEntity != "JEAN-PAUL" || rel.TargetEntity != "COVID-19" {
+			t.Errorf("Expected endpoints JEAN-PAUL and COVID-19, got %s and %s", rel.SourceEntity, rel.TargetEntity)
+		}
+		if len(storage.entities) != 2 {
+			t.Errorf("Expected only the 2 extracted entities, got %v", slices.Collect(maps.Keys(storage.entities)))
+		}
+	})
+
+	t.Run("Handler tokenizer", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-tokenizer",
+			Content: "Test content",
+		}
+
+		for _, tt := range []struct {
+			name      string
+			tokenizer llm.Tokenizer
+			plain     bool
+			summaries int
+		}{
+			// Every description exceeds MaxSummariesTokenLength with the handler's tokenizer.
+			{name: "Provided", tokenizer: fixedTokenizer(2000), summaries: 3},
+			// Handlers without a TokenizerProvider count with the default tokenizer.
+			{name: "Default", tokenizer: fixedTokenizer(2000), plain: true, summaries: 0},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				mockLLM := &chunkLLM{
+					responses: map[string]string{
+						"Alice founded Acme.": `{
+							"entities": [
+								{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice founded Acme."},
+								{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+							],
+							"relationships": [
+								{"source_entity": "Alice", "target_entity": "Acme",
+									"relationship_description": "Alice founded Acme.", "relationship_keywords": ["founder"],
+									"relationship_strength": 9}
+							]
+						}`,
+					},
+					summary: "Summarized description.",
+				}
+
+				var handler golightrag.DocumentHandler = &MockDocumentHandler{
+					sources: []golightrag.Source{{Content: "Alice founded Acme.", TokenSize: 3}},
+					entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+						Goal:        "Extract entities",
+						EntityTypes: []string{"PERSON", "ORGANIZATION"},
+						Language:    "English",
+					},
+					maxRetries:  3,
+					maxTokenLen: 1000,
+					tokenizer:   tt.tokenizer,
+				}
+				if tt.plain {
+					handler = plainDocumentHandler{handler}
+				}
+
+				storage := &MockStorage{
+					entities:      make(map[string]golightrag.GraphEntity),
+					relationships: make(map[string]golightrag.GraphRelationship),
+				}
+
+				if err := golightrag.Insert(doc, handler, storage, mockLLM, logger); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if got := len(mockLLM.summaryPrompts()); got != tt.summaries {
+					t.Errorf("Expected %d summarizations, got %d", tt.summaries, got)
+				}
+			})
+		}
+	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		doc := golightrag.Document{
+			ID:      "test-doc-20",
+			Content: "Test content",
+		}
+
+		mockLLM := &MockLLM{
+			chatResponse: `{
+				"entities": [
+					{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice works at Acme."},
+					{"entity_name": "Bob", "entity_type": "PERSON", "entity_description": "Bob is a spammer."},
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme sells anvils."}
+				],
+				"relationships": [
+					{"source_entity": "Alice", "target_entity": "Acme",
+						"relationship_description": "Alice works at Acme.", "relationship_keywords": ["employment"],
+						"relationship_strength": 8},
+					{"source_entity": "Bob", "target_entity": "Acme",
+						"relationship_description": "Bob spams Acme.", "relationship_keywords": ["spam"],
+						"relationship_strength": 2}
+				]
+			}`,
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{
+					Content:    "Test content",
+					TokenSize:  2,
+					OrderIndex: 0,
+				},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+
+		acme := golightrag.GraphEntity{
+			Name:         "ACME",
+			Type:         "ORGANIZATION",
+			Descriptions: "Acme is a curated company.",
+			SourceIDs:    "doc-0-chunk-0",
+		}
+		storage := &MockStorage{
+			entities:      map[string]golightrag.GraphEntity{"ACME": acme},
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		var chunks []golightrag.Source
+		var existing []string
+		hooks := golightrag.Hooks{
+			AfterChunking: func(e *golightrag.ChunkingEvent) error {
+				chunks = e.Chunks
+				return nil
+			},
+			AfterExtraction: func(e *golightrag.ExtractionEvent) error {
+				delete(e.Entities, "BOB")
+				delete(e.Relationships, "BOB-ACME")
+				return nil
+			},
+			BeforeEntityMerge: func(e *golightrag.EntityMergeEvent) error {
+				if e.Existing != nil {
+					// Curated entities are kept as they are.
+					existing = append(existing, e.Name)
+					e.Skip = true
+				}
+				return nil
+			},
+			AfterEntityMerge: func(e *golightrag.EntityMergeEvent) error {
+				e.Merged.Descriptions = strings.ToUpper(e.Merged.Descriptions)
+				return nil
+			},
+			AfterRelationshipMerge: func(e *golightrag.RelationshipMergeEvent) error {
+				e.Merged.Weight = 1
+				return nil
+			},
+		}
+
+		err := golightrag.Insert(doc, handler, storage, mockLLM, logger, golightrag.WithHooks(hooks))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(chunks) != 1 || chunks[0].ID != "test-doc-20-chunk-0" {
+			t.Errorf("Expected the chunk of the document, got %+v", chunks)
+		}
+		if _, ok := storage.entities["BOB"]; ok {
+			t.Error("Expected BOB to be removed by the extraction hook")
+		}
+		if _, ok := storage.relationships["BOB:ACME"]; ok {
+			t.Error("Expected the BOB-ACME relationship to be removed by the extraction hook")
+		}
+		if !slices.Equal(existing, []string{"ACME"}) {
+			t.Errorf("Expected only ACME to exist before its merge, got %v", existing)
+		}
+		if got := storage.entities["ACME"].Descriptions; got != acme.Descriptions {
+			t.Errorf("Expected the skipped ACME to keep its description, got %q", got)
+		}
+		if got := storage.entities["ALICE"].Descriptions; got != "ALICE WORKS AT ACME." {
+			t.Errorf("Expected the ALICE description modified by the hook, got %q", got)
+		}
+		if got := storage.relationships["ALICE:ACME"].Weight; got != 1 {
+			t.Errorf("Expected the relationship weight modified by the hook, got %v", got)
+		}
+
+		// A hook returning an error fails the insertion.
+		errHook := errors.New("hook error")
+		hooks.AfterChunking = func(*golightrag.ChunkingEvent) error { return errHook }
+		err = golightrag.Insert(doc, handler, storage, mockLLM, logger, golightrag.WithHooks(hooks))
+		if !errors.Is(err, errHook) {
+			t.Errorf("Expected the hook error, got %v", err)
+		}
+	})
+}
+
+func TestProcessUnprocessedChunk(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	mockLLM := &chunkLLM{
+		responses: map[string]string{
+			"Alice founded Acme.": `{
+				"entities": [
+					{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice founded Acme."},
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+				],
+				"relationships": []
+			}`,
+			"Bob joined Acme.": `{
+				"entities": [
+					{"entity_name": "Bob", "entity_type": "PERSON", "entity_description": "Bob joined Acme."},
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme hires."}
+				],
+				"relationships": []
+			}`,
+		},
+	}
+
+	handler := &MockDocumentHandler{
+		entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+			Goal:        "Extract entities",
+			EntityTypes: []string{"PERSON", "ORGANIZATION"},
+			Language:    "English",
+		},
+		maxRetries:  3,
+		maxTokenLen: 1000,
+	}
+
+	storage := &MockStorage{
+		entities:      make(map[string]golightrag.GraphEntity),
+		relationships: make(map[string]golightrag.GraphRelationship),
+	}
+
+	// The unprocessed chunks come from two documents.
+	sources := []golightrag.Source{
+		{ID: "doc-a-chunk-0", Content: "Alice founded Acme.", TokenSize: 3, DocumentID: "doc-a"},
+		{ID: "doc-b-chunk-0", Content: "Bob joined Acme.", TokenSize: 3, DocumentID: "doc-b"},
+	}
+	if err := golightrag.ProcessUnprocessedChunk(sources, handler, storage, mockLLM, logger); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Each extraction is attributed to its own source.
+	wantSourceIDs := map[string]string{
+		"ALICE": "doc-a-chunk-0",
+		"BOB":   "doc-b-chunk-0",
+		"ACME":  "doc-a-chunk-0" + golightrag.GraphFieldSeparator + "doc-b-chunk-0",
+	}
+	for name, want := range wantSourceIDs {
+		if got := storage.entities[name].SourceIDs; got != want {
+			t.Errorf("Expected %s source IDs %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestInsertBatch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	mockLLM := &chunkLLM{
+		responses: map[string]string{
+			"Alice founded Acme.": `{
+				"entities": [
+					{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice founded Acme."},
+					{"entity_name": "Acme", "entity_type": "ORGANIZATION", "entity_description": "Acme is a company."}
+				],
+				"relationships": []
+			}`,
+			"Alice runs Acme.": `{
+				"entities": [
+					{"entity_name": "Alice", "entity_type": "PERSON", "entity_description": "Alice is the CEO of Acme."}
+				],
+				"relationships": []
+			}`,
+		},
+	}
+
+	handler := &contentDocumentHandler{
+		MockDocumentHandler: &MockDocumentHandler{
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON", "ORGANIZATION"},
+				Language:    "English",
+			},
+			maxRetries:       3,
+			concurrencyCount: 2,
+			maxTokenLen:      1000,
+		},
+		chunks: map[string][]string{
+			"Alice founded Acme.": {"Alice founded Acme."},
+			"Alice runs Acme.":    {"Alice runs Acme.", "Acme was founded in 2020."},
+		},
+	}
+
+	storage := &lockedStorage{
+		MockStorage: &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		},
+	}
+
+	docs := []golightrag.Document{
+		{ID: "doc-a", Content: "Alice founded Acme."},
+		{ID: "doc-b", Content: "Alice runs Acme."},
+		{ID: "doc-c", Content: "Unchunkable content."},
+	}
+
+	var reports []golightrag.BatchProgress
+	results, err := golightrag.InsertBatch(slices.Values(docs), handler, storage, mockLLM, logger,
+		golightrag.WithProgress(func(p golightrag.BatchProgress) {
+			reports = append(reports, p)
+		}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The results are in the order of the documents, a failed document not stopping the others.
+	if len(results) != len(docs) {
+		t.Fatalf("Expected %d results, got %d", len(docs), len(results))
+	}
+	wantChunks := []int{1, 2, 0}
+	for i, result := range results {
+		if result.DocumentID != docs[i].ID {
+			t.Errorf("Expected result %d for %s, got %s", i, docs[i].ID, result.DocumentID)
+		}
+		if result.Chunks != wantChunks[i] {
+			t.Errorf("Expected %d chunks for %s, got %d", wantChunks[i], result.DocumentID, result.Chunks)
+		}
+		if failed := result.Err != nil; failed != (i == 2) {
+			t.Errorf("Unexpected error for %s: %v", result.DocumentID, result.Err)
+		}
+	}
+
+	alice := storage.entities["ALICE"]
+	for _, sourceID := range []string{"doc-a-chunk-0", "doc-b-chunk-0"} {
+		if !strings.Contains(alice.SourceIDs, sourceID) {
+			t.Errorf("Expected source ID %s in ALICE SourceIDs: %s", sourceID, alice.SourceIDs)
+		}
+	}
+
+	if len(reports) == 0 {
+		t.Fatal("Expected progress reports")
+	}
+	final := reports[len(reports)-1]
+	if final.Documents != 3 || final.DocumentsDone != 2 || final.DocumentsFailed != 1 {
+		t.Errorf("Expected 3 documents, 2 done and 1 failed, got %d, %d and %d",
+			final.Documents, final.DocumentsDone, final.DocumentsFailed)
+	}
+	if final.Chunks != 3 || final.ChunksDone != 3 {
+		t.Errorf("Expected 3 chunks done, got %d of %d", final.ChunksDone, final.Chunks)
+	}
+	if final.ETA != 0 {
+		t.Errorf("Expected no time left once the batch is done, got %v", final.ETA)
+	}
+	finished := 0
+	for _, report := range reports {
+		if report.Result != nil {
+			finished++
+		}
+	}
+	if finished != len(docs) {
+		t.Errorf("Expected a report for each finished document, got %d", finished)
+	}
+}
+
+// contentDocumentHandler chunks a document with the chunks of its content, failing for unknown
+// contents.
+type contentDocumentHandler struct {
+	*MockDocumentHandler
+	chunks map[string][]string
+}
+
+func (h *contentDocumentHandler) ChunksDocument(content string) ([]golightrag.Source, error) {
+	chunks, ok := h.chunks[content]
+	if !ok {
+		return nil, errors.New("unknown content")
+	}
+	sources := make([]golightrag.Source, len(chunks))
+	for i, chunk := range chunks {
+		sources[i] = golightrag.Source{Content: chunk, TokenSize: len(chunk), OrderIndex: i}
+	}
+	return sources, nil
+}
+
+// workspaceStorage keeps a separate MockStorage for every workspace.
+type workspaceStorage struct {
+	*MockStorage
+	workspaces map[string]*MockStorage
+}
+
+func (s *workspaceStorage) InWorkspace(workspace string) (golightrag.Storage, error) {
+	if s.workspaces == nil {
+		s.workspaces = make(map[string]*MockStorage)
+	}
+	if _, ok := s.workspaces[workspace]; !ok {
+		s.workspaces[workspace] = &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+	}
+	return s.workspaces[workspace], nil
+}
+
+// chunkLLM answers the extraction of a chunk with the response for the chunk content, and the
+// summarization of descriptions with summary, recording the summarization prompts.
+type chunkLLM struct {
+	responses map[string]string
+	summary   string
+
+	mu        sync.Mutex
+	summaries []string
+}
+
+func (l *chunkLLM) Chat(messages []string) (string, error) {
+	prompt := messages[len(messages)-1]
+	if strings.Contains(prompt, "comprehensive summary") {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.summaries = append(l.summaries, prompt)
+		return l.summary, nil
+	}
+	for content, response := range l.responses {
+		if strings.Contains(prompt, content) {
+			return response, nil
+		}
+	}
+	return `{"entities": [], "relationships": []}`, nil
+}
+
+func (l *chunkLLM) summaryPrompts() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.summaries)
+}
+
+// lockedStorage serializes the source, graph and vector upserts of a MockStorage, so it can be
+// inserted into concurrently.
+type lockedStorage struct {
+	*MockStorage
+	mu sync.Mutex
+}
+
+func (s *lockedStorage) KVUpsertSources(sources []golightrag.Source) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MockStorage.KVUpsertSources(sources)
+}
+
+func (s *lockedStorage) GraphEntity(name string) (golightrag.GraphEntity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MockStorage.GraphEntity(name)
+}
+
+func (s *lockedStorage) GraphRelationship(sourceEntity, targetEntity string) (golightrag.GraphRelationship, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MockStorage.GraphRelationship(sourceEntity, targetEntity)
+}
+
+func (s *lockedStorage) GraphUpsertEntity(entity golightrag.GraphEntity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MockStorage.GraphUpsertEntity(entity)
+}
+
+func (s *lockedStorage) GraphUpsertRelationship(relationship golightrag.GraphRelationship) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MockStorage.GraphUpsertRelationship(relationship)
+}
+
+func (s *lockedStorage) VectorUpsertEntityWithMetadata(name, content string, metadata map[string][]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MockStorage.VectorUpsertEntityWithMetadata(name, content, metadata)
+}
+
+func (s *lockedStorage) VectorUpsertRelationshipWithMetadata(
+	source, target, content string, metadata map[string][]string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MockStorage.VectorUpsertRelationshipWithMetadata(source, target, content, metadata)
+}
+
+type policyDocumentHandler struct {
+	*MockDocumentHandler
+	policy golightrag.ExtractionPolicy
+}
+
+func (h *policyDocumentHandler) ExtractionPolicy() golightrag.ExtractionPolicy {
+	return h.policy
+}
+
+// fixedTokenizer counts every text as the same number of tokens.
+type fixedTokenizer int
+
+func (f fixedTokenizer) Encode(string) ([]int, error) {
+	return make([]int, f), nil
+}
+
+// plainDocumentHandler hides the optional interfaces of the handler it wraps, such as
+// TokenizerProvider.
+type plainDocumentHandler struct {
+	golightrag.DocumentHandler
 }
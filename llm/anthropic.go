@@ -8,8 +8,6 @@ import (
 	"io"
 	"net/http"
 	"time"
-
-	goopenai "github.com/sashabaranov/go-openai"
 )
 
 // Anthropic provides an interface to the Anthropic API for large language model interactions. It implements
@@ -30,12 +28,25 @@ type anthropicMessage struct {
 }
 
 type anthropicMessageContent struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+type anthropicResponse struct {
+	Model   string                    `json:"model"`
+	Content []anthropicMessageContent `json:"content"`
+	Usage   anthropicUsage            `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
 
 type anthropicChatRequest struct {
 	Model     string             `json:"model"`
+	System    string             `json:"system,omitempty"`
 	Messages  []anthropicMessage `json:"messages"`
 	MaxTokens int                `json:"max_tokens"`
 
@@ -43,6 +54,20 @@ type anthropicChatRequest struct {
 	Temperature   *float32 `json:"temperature,omitempty"`
 	TopK          *int     `json:"top_k,omitempty"`
 	TopP          *float32 `json:"top_p,omitempty"`
+
+	Tools      []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice *anthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
 }
 
 const (
@@ -64,54 +89,108 @@ func NewAnthropic(apiKey, model string, maxTokens int, params Parameters) Anthro
 
 // Chat sends a chat message to the Anthropic API.
 func (a Anthropic) Chat(messages []string) (string, error) {
+	res, _, err := a.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage sends a chat message to the Anthropic API and returns the token usage of the call.
+func (a Anthropic) ChatWithUsage(messages []string) (string, Usage, error) {
+	res, err := a.Complete(NewChatRequest(messages))
+	return res.Content, res.Usage, err
+}
+
+// SupportsStructuredOutput reports true, see ChatStructured.
+func (a Anthropic) SupportsStructuredOutput() bool {
+	return true
+}
+
+// ChatStructured sends a chat message to the Anthropic API and returns a response conforming to
+// schema. The API has no JSON mode, so the schema is declared as the input schema of a tool the
+// model is forced to call, and the input of that call is returned.
+func (a Anthropic) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	req := NewChatRequest(messages)
+	req.Schema = &schema
+	res, err := a.Complete(req)
+	return res.Content, res.Usage, err
+}
+
+// Complete sends a chat request to the Anthropic API. System messages are merged into the
+// system prompt, and Parameters.MaxTokens overrides the maximum tokens given to NewAnthropic.
+func (a Anthropic) Complete(req ChatRequest) (ChatResponse, error) {
+	system, messages := req.systemPrompt()
 	msgs := make([]anthropicMessage, len(messages))
 	for i, msg := range messages {
-		role := goopenai.ChatMessageRoleUser
-		if i%2 == 1 {
-			role = goopenai.ChatMessageRoleAssistant
-		}
 		msgs[i] = anthropicMessage{
-			Role:    role,
-			Content: []anthropicMessageContent{{Type: "text", Text: msg}},
+			Role:    string(msg.Role),
+			Content: []anthropicMessageContent{{Type: "text", Text: msg.Content}},
 		}
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
-	resp, err := a.doRequest(ctx, msgs)
+	resp, err := a.doRequest(ctx, system, msgs, a.params.override(req.Parameters), req.Schema)
 	if err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
+		return ChatResponse{}, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
-	}
-
-	var msg anthropicMessage
+	var msg anthropicResponse
 	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
-		return "", fmt.Errorf("error decoding response: %w", err)
+		return ChatResponse{}, fmt.Errorf("error decoding response: %w", err)
 	}
 
 	if len(msg.Content) == 0 {
-		return "", fmt.Errorf("empty response content")
+		return ChatResponse{}, fmt.Errorf("empty response content")
+	}
+
+	usage := Usage{
+		Model:            msg.Model,
+		PromptTokens:     msg.Usage.InputTokens,
+		CompletionTokens: msg.Usage.OutputTokens,
+	}
+
+	if req.Schema != nil {
+		for _, content := range msg.Content {
+			if content.Type == "tool_use" {
+				return ChatResponse{Content: string(content.Input), Usage: usage}, nil
+			}
+		}
+		return ChatResponse{}, fmt.Errorf("no tool use in response content")
 	}
 
-	return msg.Content[0].Text, nil
+	return ChatResponse{Content: msg.Content[0].Text, Usage: usage}, nil
 }
 
-func (a Anthropic) doRequest(ctx context.Context, messages []anthropicMessage) (*http.Response, error) {
+func (a Anthropic) doRequest(
+	ctx context.Context,
+	system string,
+	messages []anthropicMessage,
+	params Parameters,
+	schema *Schema,
+) (*http.Response, error) {
+	maxTokens := a.maxTokens
+	if params.MaxTokens != nil {
+		maxTokens = *params.MaxTokens
+	}
 	reqBody := anthropicChatRequest{
 		Model:     a.model,
+		System:    system,
 		Messages:  messages,
-		MaxTokens: a.maxTokens,
+		MaxTokens: maxTokens,
 
-		StopSequences: a.params.Stop,
-		Temperature:   a.params.Temperature,
-		TopK:          a.params.TopK,
-		TopP:          a.params.TopP,
+		StopSequences: params.Stop,
+		Temperature:   params.Temperature,
+		TopK:          params.TopK,
+		TopP:          params.TopP,
+	}
+	if schema != nil {
+		reqBody.Tools = []anthropicTool{{
+			Name:        schema.Name,
+			Description: schema.Description,
+			InputSchema: schema.Schema,
+		}}
+		reqBody.ToolChoice = &anthropicToolChoice{Type: "tool", Name: schema.Name}
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -134,8 +213,9 @@ func (a Anthropic) doRequest(ctx context.Context, messages []anthropicMessage) (
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s, request: %s", resp.StatusCode, string(body), jsonBody)
+		return nil, newProviderError("anthropic", resp.StatusCode, errorMessage(body), resp.Header)
 	}
 
 	return resp, nil
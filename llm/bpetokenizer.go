@@ -3,12 +3,12 @@ package llm
 import (
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"sort"
 	"strings"
-
-	"github.com/dlclark/regexp2"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Pair represents a pair of tokens to be merged.
@@ -17,24 +17,71 @@ type Pair struct {
 	Right string
 }
 
-// Tokenizer is an interface for tokenizing text.
+// BpeTokenizer is a byte pair encoding tokenizer compatible with the Hugging Face tokenizers
+// library. It can be loaded from a tokenizer.json file, which describes the full pipeline
+// (normalizer, pre-tokenizer, BPE model, added tokens and decoder), or from the legacy
+// vocab.json and merges.txt pair of a GPT-2 style byte-level model.
+type BpeTokenizer struct {
+	vocab        map[string]int
+	reverseVocab map[int]string
+	merges       map[Pair]int
+
+	unkToken                string
+	continuingSubwordPrefix string
+	endOfWordSuffix         string
+	fuseUnk                 bool
+	byteFallback            bool
+	ignoreMerges            bool
+
+	addedTokens       map[int]addedToken
+	addedTokensByByte map[byte][]addedToken
+
+	normalizer   tokenNormalizer
+	preTokenizer tokenPreTokenizer
+	decoder      tokenDecoder
+
+	cacheMu sync.RWMutex
+	cache   map[string][]int
+}
 
-type Tokenizer interface {
-	Encode(text string) ([]int, error)
+// addedToken is a token that is matched verbatim in the input before the BPE model runs,
+// such as the special tokens of chat templates.
+type addedToken struct {
+	ID         int    `json:"id"`
+	Content    string `json:"content"`
+	SingleWord bool   `json:"single_word"`
+	LStrip     bool   `json:"lstrip"`
+	RStrip     bool   `json:"rstrip"`
+	Special    bool   `json:"special"`
 }
 
-// BpeTokenizer holds the vocabulary, merge rules, and special tokens.
+type hfTokenizerFile struct {
+	AddedTokens  []addedToken    `json:"added_tokens"`
+	Normalizer   json.RawMessage `json:"normalizer"`
+	PreTokenizer json.RawMessage `json:"pre_tokenizer"`
+	Decoder      json.RawMessage `json:"decoder"`
+	Model        hfBpeModel      `json:"model"`
+}
 
-type BpeTokenizer struct {
-	vocab         map[string]int
-	merges        map[Pair]int
-	specialTokens map[string]int
-	preTokenizeRe *regexp2.Regexp
+type hfBpeModel struct {
+	Type                    string            `json:"type"`
+	Vocab                   map[string]int    `json:"vocab"`
+	Merges                  []json.RawMessage `json:"merges"`
+	UnkToken                *string           `json:"unk_token"`
+	ContinuingSubwordPrefix *string           `json:"continuing_subword_prefix"`
+	EndOfWordSuffix         *string           `json:"end_of_word_suffix"`
+	FuseUnk                 bool              `json:"fuse_unk"`
+	ByteFallback            bool              `json:"byte_fallback"`
+	IgnoreMerges            bool              `json:"ignore_merges"`
 }
 
-// NewTokenizer creates and initializes a new tokenizer from vocab and merges files.
+// maxBpeCacheSize bounds the number of pre-tokenized words whose encoding is memoized.
+const maxBpeCacheSize = 50000
+
+// NewBpeTokenizer creates a byte-level BPE tokenizer from the vocab.json and merges.txt files
+// of a GPT-2 style model. Text is split with the GPT-2 pre-tokenization regex and every byte
+// is mapped to its printable unicode representation before merging.
 func NewBpeTokenizer(vocabPath, mergesPath string) (*BpeTokenizer, error) {
-	// 1. Load Vocabulary
 	vocabFile, err := os.ReadFile(vocabPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read vocab file: %w", err)
@@ -44,48 +91,343 @@ func NewBpeTokenizer(vocabPath, mergesPath string) (*BpeTokenizer, error) {
 		return nil, fmt.Errorf("failed to parse vocab JSON: %w", err)
 	}
 
-	// 2. Load Merges
 	mergesFile, err := os.ReadFile(mergesPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read merges file: %w", err)
 	}
-	mergesLines := strings.Split(string(mergesFile), "\n")
-	merges := make(map[Pair]int)
-	// Skip header and empty lines
-	for i, line := range mergesLines[1:] {
-		if line == "" {
+	var merges []Pair
+	for _, line := range strings.Split(string(mergesFile), "\n") {
+		line = strings.TrimRight(line, "\r")
+		// Skip the version header and empty lines
+		if line == "" || strings.HasPrefix(line, "#version") {
 			continue
 		}
 		parts := strings.Fields(line)
 		if len(parts) != 2 {
-			continue
+			return nil, fmt.Errorf("invalid merge rule %q", line)
 		}
-		merges[Pair{Left: parts[0], Right: parts[1]}] = i
+		merges = append(merges, Pair{Left: parts[0], Right: parts[1]})
 	}
 
-	// 3. Define Special Tokens (example from Qwen family)
-	specialTokens := map[string]int{
-		"<|endoftext|>": 151643,
-		"<|im_start|>":  151644,
-		"<|im_end|>":    151645,
+	t := &BpeTokenizer{
+		preTokenizer: byteLevelPreTokenizer{useRegex: true},
+		decoder:      decoderFunc(byteLevelDecode),
+	}
+	if err := t.init(vocab, merges, nil); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewBpeTokenizerFromFile creates a tokenizer from a Hugging Face tokenizer.json file.
+func NewBpeTokenizerFromFile(path string) (*BpeTokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokenizer file: %w", err)
+	}
+	return NewBpeTokenizerFromJSON(data)
+}
+
+// NewBpeTokenizerFromJSON creates a tokenizer from the content of a Hugging Face
+// tokenizer.json file. Only BPE models are supported. Post-processors are ignored, so encoded
+// text never includes template tokens such as a leading BOS, which is what token counting and
+// chunking need.
+func NewBpeTokenizerFromJSON(data []byte) (*BpeTokenizer, error) {
+	var file hfTokenizerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tokenizer JSON: %w", err)
+	}
+	if file.Model.Type != "" && file.Model.Type != "BPE" {
+		return nil, fmt.Errorf("unsupported tokenizer model type %q", file.Model.Type)
+	}
+
+	t := &BpeTokenizer{
+		fuseUnk:      file.Model.FuseUnk,
+		byteFallback: file.Model.ByteFallback,
+		ignoreMerges: file.Model.IgnoreMerges,
+	}
+	if file.Model.UnkToken != nil {
+		t.unkToken = *file.Model.UnkToken
+	}
+	if file.Model.ContinuingSubwordPrefix != nil {
+		t.continuingSubwordPrefix = *file.Model.ContinuingSubwordPrefix
+	}
+	if file.Model.EndOfWordSuffix != nil {
+		t.endOfWordSuffix = *file.Model.EndOfWordSuffix
 	}
 
-	// 4. Define Pre-tokenization Regex (similar to Qwen3's)
-	// This regex splits by categories: letters, numbers, punctuation, and whitespace.
-	// It also captures special tokens as a whole.
-	specialTokenPattern := `<\|endoftext\|>|<\|im_start\|>|<\|im_end\|>`
-	pattern := fmt.Sprintf(`(?i)(%s)|'s|'t|'re|'ve|'m|'ll|'d|[\p{L}]+|[\p{N}]+|[^\s\p{L}\p{N}]+`, specialTokenPattern)
-	re, err := regexp2.Compile(pattern, 0)
+	normalizer, err := parseHFComponent(file.Normalizer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse normalizer: %w", err)
+	}
+	if t.normalizer, err = newNormalizer(normalizer); err != nil {
+		return nil, err
+	}
+	preTokenizer, err := parseHFComponent(file.PreTokenizer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to compile pre-tokenization regex: %w", err)
+		return nil, fmt.Errorf("failed to parse pre-tokenizer: %w", err)
+	}
+	if t.preTokenizer, err = newPreTokenizer(preTokenizer); err != nil {
+		return nil, err
+	}
+	decoder, err := parseHFComponent(file.Decoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse decoder: %w", err)
+	}
+	if t.decoder, err = newDecoder(decoder); err != nil {
+		return nil, err
+	}
+
+	merges := make([]Pair, 0, len(file.Model.Merges))
+	for _, raw := range file.Model.Merges {
+		pair, err := parseMerge(raw)
+		if err != nil {
+			return nil, err
+		}
+		merges = append(merges, pair)
+	}
+
+	if err := t.init(file.Model.Vocab, merges, file.AddedTokens); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseMerge accepts both merge encodings found in tokenizer.json files:
+// "left right" strings and ["left", "right"] arrays.
+func parseMerge(raw json.RawMessage) (Pair, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		left, right, ok := strings.Cut(s, " ")
+		if !ok {
+			return Pair{}, fmt.Errorf("invalid merge rule %q", s)
+		}
+		return Pair{Left: left, Right: right}, nil
+	}
+	var parts []string
+	if err := json.Unmarshal(raw, &parts); err != nil || len(parts) != 2 {
+		return Pair{}, fmt.Errorf("invalid merge rule %s", raw)
+	}
+	return Pair{Left: parts[0], Right: parts[1]}, nil
+}
+
+func (t *BpeTokenizer) init(vocab map[string]int, merges []Pair, added []addedToken) error {
+	t.vocab = vocab
+	t.reverseVocab = make(map[int]string, len(vocab))
+	for token, id := range vocab {
+		t.reverseVocab[id] = token
+	}
+
+	t.merges = make(map[Pair]int, len(merges))
+	for rank, pair := range merges {
+		for _, token := range []string{pair.Left, pair.Right, t.mergeResult(pair)} {
+			if _, ok := vocab[token]; !ok {
+				return fmt.Errorf("merge rule %q %q references token %q missing from the vocabulary",
+					pair.Left, pair.Right, token)
+			}
+		}
+		if _, ok := t.merges[pair]; !ok {
+			t.merges[pair] = rank
+		}
+	}
+
+	t.addedTokens = make(map[int]addedToken, len(added))
+	t.addedTokensByByte = make(map[byte][]addedToken)
+	for _, tok := range added {
+		if tok.Content == "" {
+			continue
+		}
+		t.addedTokens[tok.ID] = tok
+		t.addedTokensByByte[tok.Content[0]] = append(t.addedTokensByByte[tok.Content[0]], tok)
+	}
+	// Longest tokens first, so matching is leftmost-longest.
+	for _, toks := range t.addedTokensByByte {
+		sort.Slice(toks, func(i, j int) bool { return len(toks[i].Content) > len(toks[j].Content) })
+	}
+
+	t.cache = make(map[string][]int)
+	return nil
+}
+
+func (t *BpeTokenizer) mergeResult(pair Pair) string {
+	return pair.Left + strings.TrimPrefix(pair.Right, t.continuingSubwordPrefix)
+}
+
+// textSegment is a part of the input that is either an added token or regular text.
+type textSegment struct {
+	text  string
+	start int
+	added *addedToken
+}
+
+// splitAddedTokens cuts text around the added tokens it contains.
+func (t *BpeTokenizer) splitAddedTokens(text string) []textSegment {
+	if len(t.addedTokensByByte) == 0 {
+		return []textSegment{{text: text}}
+	}
+
+	var segs []textSegment
+	segStart := 0
+	for i := 0; i < len(text); {
+		tok := t.matchAddedToken(text, i)
+		if tok == nil {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+			continue
+		}
+
+		left := text[segStart:i]
+		if tok.LStrip {
+			left = strings.TrimRightFunc(left, unicode.IsSpace)
+		}
+		if left != "" {
+			segs = append(segs, textSegment{text: left, start: segStart})
+		}
+		segs = append(segs, textSegment{text: tok.Content, start: i, added: tok})
+
+		i += len(tok.Content)
+		if tok.RStrip {
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				i += size
+			}
+		}
+		segStart = i
+	}
+	if segStart < len(text) {
+		segs = append(segs, textSegment{text: text[segStart:], start: segStart})
+	}
+	return segs
+}
+
+func (t *BpeTokenizer) matchAddedToken(text string, i int) *addedToken {
+	for _, tok := range t.addedTokensByByte[text[i]] {
+		if !strings.HasPrefix(text[i:], tok.Content) {
+			continue
+		}
+		if tok.SingleWord {
+			before, _ := utf8.DecodeLastRuneInString(text[:i])
+			after, _ := utf8.DecodeRuneInString(text[i+len(tok.Content):])
+			if (i > 0 && isWordRune(before)) || (i+len(tok.Content) < len(text) && isWordRune(after)) {
+				continue
+			}
+		}
+		return &tok
+	}
+	return nil
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Encode converts a string into a slice of token IDs.
+func (t *BpeTokenizer) Encode(text string) ([]int, error) {
+	var ids []int
+	for _, seg := range t.splitAddedTokens(text) {
+		if seg.added != nil {
+			ids = append(ids, seg.added.ID)
+			continue
+		}
+
+		normalized := seg.text
+		if t.normalizer != nil {
+			normalized = t.normalizer.normalize(normalized)
+		}
+		words := []string{normalized}
+		if t.preTokenizer != nil {
+			words = t.preTokenizer.preTokenize(words, seg.start == 0)
+		}
+		for _, word := range words {
+			if word == "" {
+				continue
+			}
+			ids = append(ids, t.encodeWord(word)...)
+		}
+	}
+	return ids, nil
+}
+
+// encodeWord runs the BPE model on a single pre-tokenized word.
+func (t *BpeTokenizer) encodeWord(word string) []int {
+	t.cacheMu.RLock()
+	ids, ok := t.cache[word]
+	t.cacheMu.RUnlock()
+	if ok {
+		return ids
+	}
+
+	if id, ok := t.vocab[word]; ok && t.ignoreMerges {
+		ids = []int{id}
+	} else {
+		for _, token := range t.bpe(t.initialSymbols(word)) {
+			if id, ok := t.vocab[token]; ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	t.cacheMu.Lock()
+	if len(t.cache) >= maxBpeCacheSize {
+		t.cache = make(map[string][]int)
+	}
+	t.cache[word] = ids
+	t.cacheMu.Unlock()
+
+	return ids
+}
+
+// initialSymbols splits a word into the characters the BPE merges start from. Characters that
+// are not in the vocabulary become byte tokens when byte fallback is enabled, the unknown token
+// otherwise, and are dropped if the model has no unknown token.
+func (t *BpeTokenizer) initialSymbols(word string) []string {
+	var symbols []string
+	lastUnk := false
+	for i, r := range word {
+		symbol := string(r)
+		if i > 0 {
+			symbol = t.continuingSubwordPrefix + symbol
+		}
+		if i+utf8.RuneLen(r) == len(word) {
+			symbol += t.endOfWordSuffix
+		}
+
+		if _, ok := t.vocab[symbol]; ok {
+			symbols = append(symbols, symbol)
+			lastUnk = false
+			continue
+		}
+		if t.byteFallback {
+			if bytes, ok := t.byteFallbackSymbols(string(r)); ok {
+				symbols = append(symbols, bytes...)
+				lastUnk = false
+				continue
+			}
+		}
+		if t.unkToken == "" {
+			continue
+		}
+		if !(t.fuseUnk && lastUnk) {
+			symbols = append(symbols, t.unkToken)
+		}
+		lastUnk = true
 	}
+	return symbols
+}
 
-	return &BpeTokenizer{
-		vocab:         vocab,
-		merges:        merges,
-		specialTokens: specialTokens,
-		preTokenizeRe: re,
-	}, nil
+func (t *BpeTokenizer) byteFallbackSymbols(char string) ([]string, bool) {
+	symbols := make([]string, 0, len(char))
+	for i := 0; i < len(char); i++ {
+		symbol := fmt.Sprintf("<0x%02X>", char[i])
+		if _, ok := t.vocab[symbol]; !ok {
+			return nil, false
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols, true
 }
 
 // getPairs finds all adjacent pairs in a sequence of tokens.
@@ -128,11 +470,12 @@ func (t *BpeTokenizer) bpe(tokens []string) []string {
 		}
 
 		// Merge the best pair
+		merged := t.mergeResult(bestPair)
 		var newTokens []string
 		i := 0
 		for i < len(tokens) {
 			if i < len(tokens)-1 && tokens[i] == bestPair.Left && tokens[i+1] == bestPair.Right {
-				newTokens = append(newTokens, bestPair.Left+bestPair.Right)
+				newTokens = append(newTokens, merged)
 				i += 2
 			} else {
 				newTokens = append(newTokens, tokens[i])
@@ -144,95 +487,31 @@ func (t *BpeTokenizer) bpe(tokens []string) []string {
 	return tokens
 }
 
-// preTokenize splits the input text into initial chunks.
-func (t *BpeTokenizer) preTokenize(text string) []string {
-	var parts []string
-	match, err := t.preTokenizeRe.FindStringMatch(text)
-	for match != nil && err == nil {
-		parts = append(parts, match.String())
-		match, err = t.preTokenizeRe.FindNextMatch(match)
-	}
-	return parts
-}
-
-// Encode converts a string into a slice of token IDs.
-func (t *BpeTokenizer) Encode(text string) ([]int, error) {
-	var finalTokenIDs []int
-
-	// Pre-tokenize the input text into chunks
-	chunks := t.preTokenize(text)
-
-	for _, chunk := range chunks {
-		// If the chunk is a special token, handle it directly.
-		if id, isSpecial := t.specialTokens[chunk]; isSpecial {
-			finalTokenIDs = append(finalTokenIDs, id)
+// Decode converts a slice of token IDs back into a string, undoing the byte-level mapping,
+// byte fallback and whitespace replacement applied while encoding.
+func (t *BpeTokenizer) Decode(ids []int) (string, error) {
+	tokens := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if tok, ok := t.addedTokens[id]; ok {
+			tokens = append(tokens, tok.Content)
 			continue
 		}
-
-		// Convert the chunk to its byte representation, then to a list of initial string tokens.
-		// This is the "Byte" part of BPE.
-		var initialTokens []string
-		for _, b := range []byte(chunk) {
-			initialTokens = append(initialTokens, string(rune(b)))
+		token, ok := t.reverseVocab[id]
+		if !ok {
+			return "", fmt.Errorf("token ID %d not found in vocabulary", id)
 		}
-
-		// Perform BPE merges
-		mergedTokens := t.bpe(initialTokens)
-
-		// Convert merged tokens to IDs from the vocabulary
-		for _, token := range mergedTokens {
-			id, ok := t.vocab[token]
-			if !ok {
-				// Handle unknown tokens. For simplicity, we return an error.
-				// A real implementation might use an <unk> token.
-				return nil, fmt.Errorf("token not found in vocabulary: %s", token)
-			}
-			finalTokenIDs = append(finalTokenIDs, id)
-		}
-	}
-
-	return finalTokenIDs, nil
-}
-
-// DownloadTokenizer downloads the tokenizer files from Hugging Face and returns a new Tokenizer.
-func DownloadTokenizer(modelName string) (Tokenizer, error) {
-	vocabURL := fmt.Sprintf("https://huggingface.co/%s/resolve/main/vocab.json", modelName)
-	mergesURL := fmt.Sprintf("https://huggingface.co/%s/resolve/main/merges.txt", modelName)
-
-	vocabPath, err := downloadFile(vocabURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download vocab.json: %w", err)
+		tokens = append(tokens, token)
 	}
 
-	mergesPath, err := downloadFile(mergesURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download merges.txt: %w", err)
+	if t.decoder == nil {
+		// Without a decoder, tokens are separated by spaces like the reference implementation does.
+		return strings.Join(tokens, " "), nil
 	}
-
-	return NewBpeTokenizer(vocabPath, mergesPath)
+	return strings.Join(t.decoder.decode(tokens), ""), nil
 }
 
-func downloadFile(url string) (string, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download file: %s", resp.Status)
-	}
-
-	tempFile, err := os.CreateTemp("", "tokenizer-*")
-	if err != nil {
-		return "", err
-	}
-	defer tempFile.Close()
-
-	_, err = io.Copy(tempFile, resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return tempFile.Name(), nil
+// DownloadTokenizer returns the tokenizer of a Hugging Face model through DefaultTokenizerRegistry.
+// The tokenizer files are downloaded into the registry's cache directory on first use only.
+func DownloadTokenizer(modelName string) (Tokenizer, error) {
+	return LoadTokenizer(modelName)
 }
@@ -1,6 +1,10 @@
 package llm
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -22,3 +26,125 @@ func TestTokenizer_Encode_With_Download(t *testing.T) {
 
 	t.Logf("Encoded token IDs: %v", ids)
 }
+
+// The gpt2 and cl100k fixtures are subsets of the r50k_base and cl100k_base vocabularies,
+// converted to the tokenizer.json format of their Hugging Face counterparts. They contain every
+// token and merge needed by the texts in encodings.json, whose IDs were produced by tiktoken
+// with the full vocabularies.
+func TestBpeTokenizer_ReferenceEncodings(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "tokenizers", "encodings.json"))
+	if err != nil {
+		t.Fatalf("Failed to read encodings: %v", err)
+	}
+	var samples []struct {
+		Tokenizer string `json:"tokenizer"`
+		Text      string `json:"text"`
+		IDs       []int  `json:"ids"`
+	}
+	if err := json.Unmarshal(data, &samples); err != nil {
+		t.Fatalf("Failed to parse encodings: %v", err)
+	}
+
+	tokenizers := make(map[string]*BpeTokenizer)
+	for _, sample := range samples {
+		tk, ok := tokenizers[sample.Tokenizer]
+		if !ok {
+			tk, err = NewBpeTokenizerFromFile(filepath.Join("testdata", "tokenizers", sample.Tokenizer, "tokenizer.json"))
+			if err != nil {
+				t.Fatalf("Failed to load tokenizer %s: %v", sample.Tokenizer, err)
+			}
+			tokenizers[sample.Tokenizer] = tk
+		}
+
+		t.Run(sample.Tokenizer+"/"+sample.Text, func(t *testing.T) {
+			ids, err := tk.Encode(sample.Text)
+			if err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+			if !slices.Equal(ids, sample.IDs) {
+				t.Errorf("Expected %v, got %v", sample.IDs, ids)
+			}
+
+			decoded, err := tk.Decode(ids)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			if decoded != sample.Text {
+				t.Errorf("Expected decoded text %q, got %q", sample.Text, decoded)
+			}
+		})
+	}
+}
+
+func TestBpeTokenizer_SentencePiece(t *testing.T) {
+	tk, err := NewBpeTokenizerFromFile(filepath.Join("testdata", "tokenizers", "sentencepiece", "tokenizer.json"))
+	if err != nil {
+		t.Fatalf("Failed to load tokenizer: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		ids     []int
+		decoded string
+	}{
+		{name: "Merges", text: "hello world", ids: []int{17, 22}, decoded: "hello world"},
+		{name: "Byte fallback", text: "hello é", ids: []int{17, 5, 3, 4}, decoded: "hello é"},
+		{name: "Special token", text: "<s>hello</s>", ids: []int{1, 17, 2}, decoded: "<s> hello</s>"},
+		{name: "Fused unknown", text: "hello xx", ids: []int{17, 5, 0}, decoded: "hello <unk>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := tk.Encode(tt.text)
+			if err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+			if !slices.Equal(ids, tt.ids) {
+				t.Errorf("Expected %v, got %v", tt.ids, ids)
+			}
+
+			decoded, err := tk.Decode(ids)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			if decoded != tt.decoded {
+				t.Errorf("Expected decoded text %q, got %q", tt.decoded, decoded)
+			}
+		})
+	}
+}
+
+func TestNewBpeTokenizer_ByteLevel(t *testing.T) {
+	dir := t.TempDir()
+	// "Ġ" is the byte-level representation of a space.
+	vocab := `{"a": 0, "b": 1, "Ġ": 2, "Ġa": 3, "Ġab": 4}`
+	merges := "#version: 0.2\nĠ a\nĠa b\n"
+	if err := os.WriteFile(filepath.Join(dir, "vocab.json"), []byte(vocab), 0o600); err != nil {
+		t.Fatalf("Failed to write vocab: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "merges.txt"), []byte(merges), 0o600); err != nil {
+		t.Fatalf("Failed to write merges: %v", err)
+	}
+
+	tk, err := NewBpeTokenizer(filepath.Join(dir, "vocab.json"), filepath.Join(dir, "merges.txt"))
+	if err != nil {
+		t.Fatalf("Failed to create tokenizer: %v", err)
+	}
+
+	ids, err := tk.Encode("a ab")
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !slices.Equal(ids, []int{0, 4}) {
+		t.Errorf("Expected [0 4], got %v", ids)
+	}
+
+	decoded, err := tk.Decode(ids)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded != "a ab" {
+		t.Errorf("Expected %q, got %q", "a ab", decoded)
+	}
+}
@@ -0,0 +1,297 @@
+package llm
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CassetteConfig configures how a Replayer matches requests to recorded responses. Recordings
+// keep the requests verbatim, so the same cassette can be replayed with any configuration.
+type CassetteConfig struct {
+	// Normalize rewrites the system prompt and every message before they are compared.
+	// Defaults to NormalizePrompt.
+	Normalize func(string) string
+	// Match selects the parts of a request that must match. Defaults to MatchRequest.
+	Match MatchMode
+}
+
+// MatchMode selects the parts of a request compared by a Replayer.
+type MatchMode int
+
+const (
+	// MatchRequest matches the system prompt, the role and content of every message and the
+	// schema name.
+	MatchRequest MatchMode = iota
+	// MatchLastMessage only matches the content of the last message, so changes to earlier turns,
+	// such as a reworded system prompt or conversation history, don't require a new recording.
+	MatchLastMessage
+)
+
+// Recorder forwards calls to the wrapped Chatter and records every successful call, so that they
+// can be saved to a cassette file and served by a Replayer.
+type Recorder struct {
+	next Chatter
+
+	mu           sync.Mutex
+	interactions []interaction
+	calls        int
+}
+
+// Replayer serves the responses of a cassette file recorded by a Recorder without calling any
+// provider. Calls are matched by the key built from the normalized request. When the same key
+// was recorded several times, its responses are served in the order the requests were sent
+// while recording, and the last one is repeated. A call without a recorded response fails with
+// ErrNoRecording.
+//
+// Calls with the same key take the responses in the order they reach the Replayer, so when the
+// recorded responses differ, such as for chunks with the same content, those calls must be made
+// serially to be replayed deterministically, such as with a ConcurrencyCount of 1.
+type Replayer struct {
+	cfg        CassetteConfig
+	structured bool
+
+	mu        sync.Mutex
+	responses map[string][]interaction
+	served    map[string]int
+}
+
+// ErrNoRecording is returned by Replayer for a request that isn't in its cassette.
+var ErrNoRecording = errors.New("no recorded response")
+
+// cassette is the file format of the recordings.
+type cassette struct {
+	// StructuredOutput is the SupportsStructuredOutput of the recorded Chatter, so the pipeline
+	// sends the same requests while replaying.
+	StructuredOutput bool          `json:"structured_output"`
+	Interactions     []interaction `json:"interactions"`
+}
+
+type interaction struct {
+	// Sequence is the order the request was sent in, which may differ from the order the
+	// responses were recorded in when the calls are concurrent.
+	Sequence int             `json:"sequence"`
+	System   string          `json:"system,omitempty"`
+	Messages []cassetteEntry `json:"messages"`
+	Schema   string          `json:"schema,omitempty"`
+	Response string          `json:"response"`
+	Usage    cassetteUsage   `json:"usage"`
+}
+
+type cassetteEntry struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+type cassetteUsage struct {
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int    `json:"completion_tokens,omitempty"`
+}
+
+// NewRecorder wraps next with a Recorder.
+func NewRecorder(next Chatter) *Recorder {
+	return &Recorder{next: next}
+}
+
+// Chat implements Chatter.
+func (r *Recorder) Chat(messages []string) (string, error) {
+	res, _, err := r.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage implements UsageReporter.
+func (r *Recorder) ChatWithUsage(messages []string) (string, Usage, error) {
+	return completeParts(r, NewChatRequest(messages))
+}
+
+// SupportsStructuredOutput implements StructuredChatter, reporting the wrapped Chatter's support.
+func (r *Recorder) SupportsStructuredOutput() bool {
+	return SupportsStructuredOutput(r.next)
+}
+
+// ChatStructured implements StructuredChatter.
+func (r *Recorder) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	req := NewChatRequest(messages)
+	req.Schema = &schema
+	return completeParts(r, req)
+}
+
+// Complete implements Completer.
+func (r *Recorder) Complete(req ChatRequest) (ChatResponse, error) {
+	r.mu.Lock()
+	sequence := r.calls
+	r.calls++
+	r.mu.Unlock()
+
+	res, err := Complete(r.next, req)
+	if err != nil {
+		return res, err
+	}
+
+	in := newInteraction(req, res)
+	in.Sequence = sequence
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, in)
+	return res, nil
+}
+
+// Save writes the recorded calls to the cassette file at path, replacing it.
+func (r *Recorder) Save(path string) error {
+	r.mu.Lock()
+	c := cassette{
+		StructuredOutput: SupportsStructuredOutput(r.next),
+		Interactions:     append([]interaction{}, r.interactions...),
+	}
+	r.mu.Unlock()
+	slices.SortFunc(c.Interactions, func(a, b interaction) int { return cmp.Compare(a.Sequence, b.Sequence) })
+
+	bs, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+	if err := os.WriteFile(path, bs, 0o600); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// NewReplayer loads the cassette file at path.
+func NewReplayer(path string, cfg CassetteConfig) (*Replayer, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var c cassette
+	if err := json.Unmarshal(bs, &c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cassette: %w", err)
+	}
+
+	cfg = cfg.withDefaults()
+	slices.SortStableFunc(c.Interactions, func(a, b interaction) int { return cmp.Compare(a.Sequence, b.Sequence) })
+	responses := make(map[string][]interaction)
+	for _, in := range c.Interactions {
+		key := cfg.key(in)
+		responses[key] = append(responses[key], in)
+	}
+	return &Replayer{
+		cfg:        cfg,
+		structured: c.StructuredOutput,
+		responses:  responses,
+		served:     make(map[string]int),
+	}, nil
+}
+
+// Chat implements Chatter.
+func (r *Replayer) Chat(messages []string) (string, error) {
+	res, _, err := r.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage implements UsageReporter, returning the recorded usage.
+func (r *Replayer) ChatWithUsage(messages []string) (string, Usage, error) {
+	return completeParts(r, NewChatRequest(messages))
+}
+
+// SupportsStructuredOutput implements StructuredChatter, reporting the support of the recorded
+// Chatter.
+func (r *Replayer) SupportsStructuredOutput() bool {
+	return r.structured
+}
+
+// ChatStructured implements StructuredChatter.
+func (r *Replayer) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	req := NewChatRequest(messages)
+	req.Schema = &schema
+	return completeParts(r, req)
+}
+
+// Complete implements Completer.
+func (r *Replayer) Complete(req ChatRequest) (ChatResponse, error) {
+	key := r.cfg.key(newInteraction(req, ChatResponse{}))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recorded := r.responses[key]
+	if len(recorded) == 0 {
+		return ChatResponse{}, fmt.Errorf("%w for %q", ErrNoRecording, truncate(key, 200))
+	}
+	i := min(r.served[key], len(recorded)-1)
+	r.served[key]++
+
+	in := recorded[i]
+	return ChatResponse{
+		Content: in.Response,
+		Usage: Usage{
+			Model:            in.Usage.Model,
+			PromptTokens:     in.Usage.PromptTokens,
+			CompletionTokens: in.Usage.CompletionTokens,
+		},
+	}, nil
+}
+
+// NormalizePrompt trims a prompt and collapses its runs of whitespace into single spaces, so
+// recordings keep matching across formatting changes of the prompt templates.
+func NormalizePrompt(prompt string) string {
+	return strings.Join(strings.Fields(prompt), " ")
+}
+
+func newInteraction(req ChatRequest, res ChatResponse) interaction {
+	in := interaction{
+		System:   req.System,
+		Messages: make([]cassetteEntry, len(req.Messages)),
+		Response: res.Content,
+		Usage: cassetteUsage{
+			Model:            res.Usage.Model,
+			PromptTokens:     res.Usage.PromptTokens,
+			CompletionTokens: res.Usage.CompletionTokens,
+		},
+	}
+	for i, msg := range req.Messages {
+		in.Messages[i] = cassetteEntry{Role: msg.Role, Content: msg.Content}
+	}
+	if req.Schema != nil {
+		in.Schema = req.Schema.Name
+	}
+	return in
+}
+
+func (c CassetteConfig) withDefaults() CassetteConfig {
+	if c.Normalize == nil {
+		c.Normalize = NormalizePrompt
+	}
+	return c
+}
+
+// key returns the normalized parts of in selected by the match mode.
+func (c CassetteConfig) key(in interaction) string {
+	if c.Match == MatchLastMessage {
+		if len(in.Messages) == 0 {
+			return ""
+		}
+		return c.Normalize(in.Messages[len(in.Messages)-1].Content)
+	}
+
+	parts := make([]string, 0, len(in.Messages)+2)
+	parts = append(parts, "system: "+c.Normalize(in.System))
+	for _, msg := range in.Messages {
+		parts = append(parts, string(msg.Role)+": "+c.Normalize(msg.Content))
+	}
+	parts = append(parts, "schema: "+in.Schema)
+	return strings.Join(parts, "\n")
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
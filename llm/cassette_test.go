@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCassette_RecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	calls := 0
+	provider := chatterFunc(func(messages []string) (string, error) {
+		calls++
+		if messages[len(messages)-1] == "fail" {
+			return "", ErrOverloaded
+		}
+		return "answer to " + messages[len(messages)-1], nil
+	})
+
+	rec := NewRecorder(provider)
+	for _, prompt := range []string{"first  question", "second question", "fail"} {
+		_, _ = rec.Chat([]string{prompt})
+	}
+	if _, err := rec.Chat([]string{"history", "reply", "second question"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := rec.Save(path); err != nil {
+		t.Fatalf("Failed to save cassette: %v", err)
+	}
+
+	t.Run("Replays normalized prompts", func(t *testing.T) {
+		r, err := NewReplayer(path, CassetteConfig{})
+		if err != nil {
+			t.Fatalf("Failed to load cassette: %v", err)
+		}
+		res, err := r.Chat([]string{"  first question\n"})
+		if err != nil || res != "answer to first  question" {
+			t.Errorf("Expected the recorded response, got %q, %v", res, err)
+		}
+		if calls != 4 {
+			t.Errorf("Expected no provider call while replaying, got %d calls", calls)
+		}
+	})
+
+	t.Run("Fails on requests without a recording", func(t *testing.T) {
+		r, err := NewReplayer(path, CassetteConfig{})
+		if err != nil {
+			t.Fatalf("Failed to load cassette: %v", err)
+		}
+		// Failed calls aren't recorded, and the whole conversation has to match by default.
+		for _, messages := range [][]string{{"fail"}, {"other history", "reply", "second question"}} {
+			if _, err := r.Chat(messages); !errors.Is(err, ErrNoRecording) {
+				t.Errorf("Expected ErrNoRecording for %q, got %v", messages, err)
+			}
+		}
+	})
+
+	t.Run("Matches the last message", func(t *testing.T) {
+		r, err := NewReplayer(path, CassetteConfig{Match: MatchLastMessage})
+		if err != nil {
+			t.Fatalf("Failed to load cassette: %v", err)
+		}
+		// Both recordings of the same last message are served in order, then the last one repeats.
+		for range 3 {
+			res, err := r.Chat([]string{"other history", "reply", "second question"})
+			if err != nil || res != "answer to second question" {
+				t.Errorf("Expected the recorded response, got %q, %v", res, err)
+			}
+		}
+	})
+
+	t.Run("Custom normalization", func(t *testing.T) {
+		r, err := NewReplayer(path, CassetteConfig{Normalize: func(s string) string { return s }})
+		if err != nil {
+			t.Fatalf("Failed to load cassette: %v", err)
+		}
+		if _, err := r.Chat([]string{"first question"}); !errors.Is(err, ErrNoRecording) {
+			t.Errorf("Expected ErrNoRecording without whitespace normalization, got %v", err)
+		}
+	})
+}
+
+func TestCassette_ConcurrentRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	// The first call answers after the second one, so the responses are recorded out of order.
+	started, second := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	provider := chatterFunc(func(_ []string) (string, error) {
+		mu.Lock()
+		calls++
+		call := calls
+		mu.Unlock()
+		if call == 1 {
+			close(started)
+			<-second
+			return "first answer", nil
+		}
+		defer close(second)
+		return "second answer", nil
+	})
+
+	rec := NewRecorder(provider)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = rec.Chat([]string{"question"})
+	}()
+	<-started
+	if _, err := rec.Chat([]string{"question"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wg.Wait()
+	if err := rec.Save(path); err != nil {
+		t.Fatalf("Failed to save cassette: %v", err)
+	}
+
+	r, err := NewReplayer(path, CassetteConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load cassette: %v", err)
+	}
+	for _, want := range []string{"first answer", "second answer"} {
+		if res, err := r.Chat([]string{"question"}); err != nil || res != want {
+			t.Errorf("Expected %q in the order the requests were sent, got %q, %v", want, res, err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+// Sentinel errors classifying provider failures. Errors returned by the providers in this
+// package match them with errors.Is.
+var (
+	// ErrRateLimited means the provider rejected the request because a rate limit or quota
+	// was exceeded.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrOverloaded means the provider is temporarily unable to serve the request.
+	ErrOverloaded = errors.New("provider overloaded")
+	// ErrContextTooLong means the prompt exceeds the context window of the model.
+	ErrContextTooLong = errors.New("context too long")
+	// ErrAuth means the credentials were missing, invalid or lack the required permissions.
+	ErrAuth = errors.New("authentication failed")
+)
+
+// ProviderError is returned by the providers when the API responds with an error.
+type ProviderError struct {
+	// Provider is the name of the provider that returned the error, for example "openai".
+	Provider string
+	// StatusCode is the HTTP status code of the response, or zero if unknown.
+	StatusCode int
+	// Kind is one of ErrRateLimited, ErrOverloaded, ErrContextTooLong or ErrAuth,
+	// or nil if the error doesn't fall into any of these classes.
+	Kind error
+	// RetryAfter is the delay requested by the provider before retrying, or zero if none was given.
+	RetryAfter time.Duration
+	// Message is the error message returned by the provider.
+	Message string
+}
+
+// Error implements the error interface.
+func (e *ProviderError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Provider)
+	if e.Kind != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.Kind.Error())
+	}
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&sb, " (status %d)", e.StatusCode)
+	}
+	if e.Message != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Message)
+	}
+	return sb.String()
+}
+
+// Unwrap returns the error class, so errors.Is matches the sentinel errors.
+func (e *ProviderError) Unwrap() error {
+	return e.Kind
+}
+
+// IsRetryable reports whether a failed call may succeed if it is repeated.
+// Rate limits, overloads, server errors, timeouts and network failures are retryable, while
+// prompts that are too long, authentication failures and other client errors are not.
+// Errors that can't be classified, such as malformed responses or errors returned by custom
+// Chatter implementations, are not retried either, since repeating them would most likely
+// fail the same way. Wrap them with ErrOverloaded to make them retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrContextTooLong) || errors.Is(err, ErrAuth) || errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrOverloaded) {
+		return true
+	}
+
+	var pErr *ProviderError
+	if errors.As(err, &pErr) {
+		return pErr.StatusCode >= http.StatusInternalServerError ||
+			pErr.StatusCode == http.StatusRequestTimeout || pErr.StatusCode == http.StatusConflict
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// RetryAfter returns the delay requested by the provider in err, or zero if there is none.
+func RetryAfter(err error) time.Duration {
+	var pErr *ProviderError
+	if errors.As(err, &pErr) {
+		return pErr.RetryAfter
+	}
+	return 0
+}
+
+// newProviderError classifies an HTTP error response.
+func newProviderError(provider string, statusCode int, message string, header http.Header) *ProviderError {
+	return &ProviderError{
+		Provider:   provider,
+		StatusCode: statusCode,
+		Kind:       classifyError(statusCode, message),
+		RetryAfter: parseRetryAfter(header),
+		Message:    message,
+	}
+}
+
+var contextTooLongMarkers = []string{
+	"context_length_exceeded",
+	"context length",
+	"context window",
+	"maximum context",
+	"prompt is too long",
+	"too many tokens",
+	"input is too long",
+}
+
+func classifyError(statusCode int, message string) error {
+	if statusCode == http.StatusTooManyRequests {
+		return ErrRateLimited
+	}
+	lower := strings.ToLower(message)
+	for _, marker := range contextTooLongMarkers {
+		if strings.Contains(lower, marker) {
+			return ErrContextTooLong
+		}
+	}
+
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrAuth
+	case http.StatusRequestEntityTooLarge:
+		return ErrContextTooLong
+	case http.StatusServiceUnavailable, 529: // 529 is used by Anthropic when the API is overloaded
+		return ErrOverloaded
+	}
+	if strings.Contains(lower, "overloaded") {
+		return ErrOverloaded
+	}
+	return nil
+}
+
+func parseRetryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms := header.Get("retry-after-ms"); ms != "" {
+		if v, err := strconv.ParseFloat(ms, 64); err == nil && v > 0 {
+			return time.Duration(v * float64(time.Millisecond))
+		}
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// errorMessage extracts the message of a JSON error response body, as returned by the
+// Anthropic and OpenAI-style APIs, falling back to the raw body.
+func errorMessage(body []byte) string {
+	var res struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err == nil && len(res.Error) > 0 {
+		var detail struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(res.Error, &detail); err == nil && detail.Message != "" {
+			return detail.Message
+		}
+		var message string
+		if err := json.Unmarshal(res.Error, &message); err == nil && message != "" {
+			return message
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// errorResponse holds the status code and header of an error response, which the go-openai and
+// Ollama clients don't expose in their errors, recorded by errorResponseTransport.
+type errorResponse struct {
+	statusCode int
+	header     http.Header
+}
+
+type errorResponseKey struct{}
+
+// withErrorResponse returns a context whose requests sent through errorResponseTransport record
+// their error response in the returned errorResponse.
+func withErrorResponse(ctx context.Context) (context.Context, *errorResponse) {
+	res := &errorResponse{}
+	return context.WithValue(ctx, errorResponseKey{}, res), res
+}
+
+// errorResponseTransport records the error responses of the requests whose context was made by
+// withErrorResponse. A nil base uses http.DefaultTransport.
+type errorResponseTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t errorResponseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+	if res, ok := req.Context().Value(errorResponseKey{}).(*errorResponse); ok {
+		res.statusCode = resp.StatusCode
+		res.header = resp.Header.Clone()
+	}
+	return resp, nil
+}
+
+// newOpenAIClient creates a go-openai client recording the error responses, so their
+// Retry-After header reaches the ProviderError.
+func newOpenAIClient(config goopenai.ClientConfig) *goopenai.Client {
+	config.HTTPClient = &http.Client{Transport: errorResponseTransport{}}
+	return goopenai.NewClientWithConfig(config)
+}
+
+// fromOpenAIError converts the errors of the go-openai client into a ProviderError, with the
+// header of the error response res.
+// Errors that don't come from the API, such as transport failures, are returned as is.
+func fromOpenAIError(provider string, err error, res *errorResponse) error {
+	var apiErr *goopenai.APIError
+	if errors.As(err, &apiErr) {
+		pErr := newProviderError(provider, apiErr.HTTPStatusCode, apiErr.Message, res.header)
+		if code, ok := apiErr.Code.(string); ok && code == "context_length_exceeded" {
+			pErr.Kind = ErrContextTooLong
+		}
+		return pErr
+	}
+	var reqErr *goopenai.RequestError
+	if errors.As(err, &reqErr) {
+		message := string(reqErr.Body)
+		if message == "" && reqErr.Err != nil {
+			message = reqErr.Err.Error()
+		}
+		return newProviderError(provider, reqErr.HTTPStatusCode, message, res.header)
+	}
+	return err
+}
+
+// fromOllamaError converts the errors of the Ollama client into a ProviderError, with the status
+// code and header of the error response res. The client returns the message of an error
+// response with a JSON body as a plain error, which res identifies as a response.
+func fromOllamaError(err error, res *errorResponse) error {
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		return newProviderError("ollama", statusErr.StatusCode, statusErr.ErrorMessage, res.header)
+	}
+	if res.statusCode != 0 {
+		return newProviderError("ollama", res.statusCode, err.Error(), res.header)
+	}
+	return err
+}
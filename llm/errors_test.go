@@ -0,0 +1,208 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+func TestNewProviderError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		kind       error
+		retryable  bool
+	}{
+		{
+			name:       "Rate limited",
+			statusCode: http.StatusTooManyRequests,
+			body:       `{"error":{"type":"rate_limit_error","message":"Number of requests exceeded"}}`,
+			kind:       ErrRateLimited,
+			retryable:  true,
+		},
+		{
+			name:       "Anthropic overloaded",
+			statusCode: 529,
+			body:       `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			kind:       ErrOverloaded,
+			retryable:  true,
+		},
+		{
+			name:       "Context too long",
+			statusCode: http.StatusBadRequest,
+			body:       `{"error":{"message":"prompt is too long: 210000 tokens > 200000 maximum"}}`,
+			kind:       ErrContextTooLong,
+			retryable:  false,
+		},
+		{
+			name:       "Unauthorized",
+			statusCode: http.StatusUnauthorized,
+			body:       `{"error":{"message":"invalid x-api-key"}}`,
+			kind:       ErrAuth,
+			retryable:  false,
+		},
+		{
+			name:       "Server error",
+			statusCode: http.StatusInternalServerError,
+			body:       `internal error`,
+			kind:       nil,
+			retryable:  true,
+		},
+		{
+			name:       "Bad request",
+			statusCode: http.StatusBadRequest,
+			body:       `{"error":"invalid model"}`,
+			kind:       nil,
+			retryable:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newProviderError("test", tt.statusCode, errorMessage([]byte(tt.body)), nil)
+			if err.Kind != tt.kind {
+				t.Errorf("Expected kind %v, got %v", tt.kind, err.Kind)
+			}
+			if tt.kind != nil && !errors.Is(fmt.Errorf("wrapped: %w", err), tt.kind) {
+				t.Errorf("Expected wrapped error to match %v", tt.kind)
+			}
+			if IsRetryable(err) != tt.retryable {
+				t.Errorf("Expected retryable %v, got %v", tt.retryable, IsRetryable(err))
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"Unclassified", errors.New("unexpected response"), false},
+		{"Network", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"Connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"Deadline", fmt.Errorf("call: %w", context.DeadlineExceeded), true},
+		{"Canceled", fmt.Errorf("call: %w", context.Canceled), false},
+		{"Overloaded", fmt.Errorf("custom: %w", ErrOverloaded), true},
+		{"Provider without status", &ProviderError{Provider: "test"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.retryable {
+				t.Errorf("Expected retryable %v, got %v", tt.retryable, got)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "7")
+	if d := parseRetryAfter(header); d != 7*time.Second {
+		t.Errorf("Expected 7s, got %s", d)
+	}
+
+	header.Set("retry-after-ms", "1500")
+	if d := parseRetryAfter(header); d != 1500*time.Millisecond {
+		t.Errorf("Expected 1.5s, got %s", d)
+	}
+}
+
+func TestFromClientErrors(t *testing.T) {
+	openAIErr := fromOpenAIError("openai", &goopenai.APIError{
+		HTTPStatusCode: http.StatusBadRequest,
+		Code:           "context_length_exceeded",
+		Message:        "This model's maximum context length is 8192 tokens",
+	}, &errorResponse{})
+	if !errors.Is(openAIErr, ErrContextTooLong) {
+		t.Errorf("Expected ErrContextTooLong, got %v", openAIErr)
+	}
+
+	ollamaErr := fromOllamaError(api.StatusError{StatusCode: http.StatusServiceUnavailable, ErrorMessage: "server busy"},
+		&errorResponse{})
+	if !errors.Is(ollamaErr, ErrOverloaded) {
+		t.Errorf("Expected ErrOverloaded, got %v", ollamaErr)
+	}
+
+	plain := errors.New("connection reset")
+	if got := fromOpenAIError("openai", plain, &errorResponse{}); got != plain {
+		t.Errorf("Expected transport errors to be returned as is, got %v", got)
+	}
+}
+
+func TestProviderErrors_RetryAfter(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// rateLimitedServer answers every request with a 429 carrying a Retry-After header and body.
+	rateLimitedServer := func(t *testing.T, body string) *httptest.Server {
+		t.Helper()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = io.WriteString(w, body)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	openAIBody := `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`
+
+	tests := []struct {
+		name string
+		chat func(t *testing.T) error
+	}{
+		{
+			name: "OpenAI",
+			chat: func(t *testing.T) error {
+				config := goopenai.DefaultConfig("key")
+				config.BaseURL = rateLimitedServer(t, openAIBody).URL
+				c := NewOpenAI("key", "m", Parameters{}, logger)
+				c.client = newOpenAIClient(config)
+				_, err := c.Chat([]string{"question"})
+				return err
+			},
+		},
+		{
+			name: "OpenAICompat",
+			chat: func(t *testing.T) error {
+				c := NewOpenAICompat(rateLimitedServer(t, openAIBody).URL, "key", "m", Parameters{}, logger)
+				_, err := c.Chat([]string{"question"})
+				return err
+			},
+		},
+		{
+			name: "Ollama",
+			chat: func(t *testing.T) error {
+				c := NewOllama(rateLimitedServer(t, `{"error":"too many requests"}`).URL, "m", Parameters{}, logger)
+				_, err := c.Chat([]string{"question"})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.chat(t)
+			if !errors.Is(err, ErrRateLimited) {
+				t.Errorf("Expected ErrRateLimited, got %v", err)
+			}
+			if d := RetryAfter(err); d != 7*time.Second {
+				t.Errorf("Expected a retry after 7s, got %s", d)
+			}
+		})
+	}
+}
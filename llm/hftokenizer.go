@@ -0,0 +1,715 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// This file implements the normalizers, pre-tokenizers and decoders of the Hugging Face
+// tokenizers library that BpeTokenizer understands when it is loaded from a tokenizer.json file.
+
+// gpt2SplitPattern is the pre-tokenization regex of the GPT-2 byte-level tokenizer,
+// used by ByteLevel pre-tokenizers with use_regex enabled.
+const gpt2SplitPattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+var (
+	gpt2SplitRe = regexp2.MustCompile(gpt2SplitPattern, regexp2.None)
+
+	byteEncoder, byteDecoder = bytesToUnicode()
+)
+
+// bytesToUnicode returns the GPT-2 mapping between bytes and printable unicode characters.
+// Byte-level BPE vocabularies store tokens in this representation so that no token contains
+// whitespace or control characters.
+func bytesToUnicode() ([256]rune, map[rune]byte) {
+	var enc [256]rune
+	dec := make(map[rune]byte, 256)
+	n := 0
+	for b := range 256 {
+		printable := (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF)
+		r := rune(b)
+		if !printable {
+			r = rune(256 + n)
+			n++
+		}
+		enc[b] = r
+		dec[r] = byte(b)
+	}
+	return enc, dec
+}
+
+type tokenNormalizer interface {
+	normalize(text string) string
+}
+
+type tokenPreTokenizer interface {
+	// preTokenize splits every piece into smaller pieces. first reports whether the pieces
+	// come from the start of the input, before any added token.
+	preTokenize(pieces []string, first bool) []string
+}
+
+type tokenDecoder interface {
+	decode(tokens []string) []string
+}
+
+// hfComponent is the union of the fields used by the tokenizer.json components supported here.
+type hfComponent struct {
+	Type string `json:"type"`
+
+	Normalizers   []hfComponent `json:"normalizers"`
+	PreTokenizers []hfComponent `json:"pretokenizers"`
+	Decoders      []hfComponent `json:"decoders"`
+
+	Pattern  *hfPattern `json:"pattern"`
+	Content  string     `json:"content"`
+	Behavior string     `json:"behavior"`
+	Invert   bool       `json:"invert"`
+
+	Prepend     string `json:"prepend"`
+	Replacement string `json:"replacement"`
+	// PrependScheme replaced AddPrefixSpace in Metaspace components.
+	PrependScheme    string `json:"prepend_scheme"`
+	AddPrefixSpace   *bool  `json:"add_prefix_space"`
+	UseRegex         *bool  `json:"use_regex"`
+	Split            *bool  `json:"split"`
+	IndividualDigits bool   `json:"individual_digits"`
+
+	StripLeft  bool   `json:"strip_left"`
+	StripRight bool   `json:"strip_right"`
+	Start      int    `json:"start"`
+	Stop       int    `json:"stop"`
+	Suffix     string `json:"suffix"`
+
+	CleanText          bool  `json:"clean_text"`
+	HandleChineseChars bool  `json:"handle_chinese_chars"`
+	StripAccents       *bool `json:"strip_accents"`
+	Lowercase          bool  `json:"lowercase"`
+}
+
+// hfPattern is either a literal string or a regular expression.
+type hfPattern struct {
+	String *string `json:"String"`
+	Regex  *string `json:"Regex"`
+}
+
+func (p *hfPattern) compile() (*regexp2.Regexp, error) {
+	switch {
+	case p == nil:
+		return nil, fmt.Errorf("missing pattern")
+	case p.Regex != nil:
+		re, err := regexp2.Compile(*p.Regex, regexp2.None)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile pattern %q: %w", *p.Regex, err)
+		}
+		return re, nil
+	case p.String != nil:
+		return regexp2.MustCompile(regexp2.Escape(*p.String), regexp2.None), nil
+	}
+	return nil, fmt.Errorf("pattern is neither a string nor a regex")
+}
+
+func boolOr(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+// regexSegment is a part of a text that either matched a regex or lies between two matches.
+type regexSegment struct {
+	text    string
+	matched bool
+}
+
+func regexSegments(re *regexp2.Regexp, text string) []regexSegment {
+	runes := []rune(text)
+	var segs []regexSegment
+	last := 0
+	match, err := re.FindRunesMatch(runes)
+	for match != nil && err == nil {
+		if match.Index > last {
+			segs = append(segs, regexSegment{text: string(runes[last:match.Index])})
+		}
+		if match.Length > 0 {
+			segs = append(segs, regexSegment{text: match.String(), matched: true})
+		}
+		last = match.Index + match.Length
+		match, err = re.FindNextMatch(match)
+	}
+	if last < len(runes) {
+		segs = append(segs, regexSegment{text: string(runes[last:])})
+	}
+	return segs
+}
+
+// --- Normalizers ---
+
+type normalizerFunc func(string) string
+
+func (f normalizerFunc) normalize(text string) string { return f(text) }
+
+type normalizerSequence []tokenNormalizer
+
+func (s normalizerSequence) normalize(text string) string {
+	for _, n := range s {
+		text = n.normalize(text)
+	}
+	return text
+}
+
+func stripAccents(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+func newNormalizer(c *hfComponent) (tokenNormalizer, error) {
+	if c == nil {
+		return nil, nil
+	}
+	switch c.Type {
+	case "Sequence":
+		seq := make(normalizerSequence, 0, len(c.Normalizers))
+		for i := range c.Normalizers {
+			n, err := newNormalizer(&c.Normalizers[i])
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, n)
+		}
+		return seq, nil
+	case "NFC":
+		return normalizerFunc(norm.NFC.String), nil
+	case "NFD":
+		return normalizerFunc(norm.NFD.String), nil
+	case "NFKC":
+		return normalizerFunc(norm.NFKC.String), nil
+	case "NFKD":
+		return normalizerFunc(norm.NFKD.String), nil
+	case "Lowercase":
+		return normalizerFunc(strings.ToLower), nil
+	case "StripAccents":
+		return normalizerFunc(stripAccents), nil
+	case "Strip":
+		left, right := c.StripLeft, c.StripRight
+		return normalizerFunc(func(text string) string {
+			if left {
+				text = strings.TrimLeftFunc(text, unicode.IsSpace)
+			}
+			if right {
+				text = strings.TrimRightFunc(text, unicode.IsSpace)
+			}
+			return text
+		}), nil
+	case "Prepend":
+		prepend := c.Prepend
+		return normalizerFunc(func(text string) string {
+			if text == "" {
+				return text
+			}
+			return prepend + text
+		}), nil
+	case "Replace":
+		re, err := c.Pattern.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Replace normalizer: %w", err)
+		}
+		// The replacement is literal, escape regexp2 substitutions.
+		content := strings.ReplaceAll(c.Content, "$", "$$")
+		return normalizerFunc(func(text string) string {
+			out, err := re.Replace(text, content, -1, -1)
+			if err != nil {
+				return text
+			}
+			return out
+		}), nil
+	case "BertNormalizer":
+		return newBertNormalizer(c), nil
+	}
+	return nil, fmt.Errorf("unsupported normalizer type %q", c.Type)
+}
+
+func newBertNormalizer(c *hfComponent) tokenNormalizer {
+	cleanText, chineseChars, lowercase := c.CleanText, c.HandleChineseChars, c.Lowercase
+	// Like the reference implementation, accents are stripped by default when lowercasing.
+	accents := boolOr(c.StripAccents, lowercase)
+	return normalizerFunc(func(text string) string {
+		var sb strings.Builder
+		for _, r := range text {
+			switch {
+			case cleanText && (r == 0 || r == utf8.RuneError || (unicode.IsControl(r) && !unicode.IsSpace(r))):
+				continue
+			case cleanText && unicode.IsSpace(r):
+				sb.WriteRune(' ')
+			case chineseChars && unicode.Is(unicode.Han, r):
+				sb.WriteRune(' ')
+				sb.WriteRune(r)
+				sb.WriteRune(' ')
+			default:
+				sb.WriteRune(r)
+			}
+		}
+		text = sb.String()
+		if accents {
+			text = stripAccents(norm.NFD.String(text))
+		}
+		if lowercase {
+			text = strings.ToLower(text)
+		}
+		return text
+	})
+}
+
+// --- Pre-tokenizers ---
+
+type preTokenizerSequence []tokenPreTokenizer
+
+func (s preTokenizerSequence) preTokenize(pieces []string, first bool) []string {
+	for _, p := range s {
+		pieces = p.preTokenize(pieces, first)
+	}
+	return pieces
+}
+
+// splitPreTokenizer splits pieces on a pattern and decides what happens with the matches
+// according to one of the Hugging Face split behaviors.
+type splitPreTokenizer struct {
+	re       *regexp2.Regexp
+	behavior string
+	invert   bool
+}
+
+func (s splitPreTokenizer) preTokenize(pieces []string, _ bool) []string {
+	var out []string
+	for _, piece := range pieces {
+		out = append(out, s.split(piece)...)
+	}
+	return out
+}
+
+func (s splitPreTokenizer) split(text string) []string {
+	segs := regexSegments(s.re, text)
+	if s.invert {
+		for i := range segs {
+			segs[i].matched = !segs[i].matched
+		}
+	}
+
+	return applySplitBehavior(segs, s.behavior)
+}
+
+// applySplitBehavior joins segments into pieces, treating matched segments as delimiters.
+func applySplitBehavior(segs []regexSegment, behavior string) []string {
+	var out []string
+	switch behavior {
+	case "Removed":
+		for _, seg := range segs {
+			if !seg.matched {
+				out = append(out, seg.text)
+			}
+		}
+	case "MergedWithPrevious":
+		for i, seg := range segs {
+			if seg.matched && i > 0 && !segs[i-1].matched {
+				out[len(out)-1] += seg.text
+				continue
+			}
+			out = append(out, seg.text)
+		}
+	case "MergedWithNext":
+		pending := ""
+		for _, seg := range segs {
+			if seg.matched {
+				pending += seg.text
+				continue
+			}
+			out = append(out, pending+seg.text)
+			pending = ""
+		}
+		if pending != "" {
+			out = append(out, pending)
+		}
+	case "Contiguous":
+		for i, seg := range segs {
+			if seg.matched && i > 0 && segs[i-1].matched {
+				out[len(out)-1] += seg.text
+				continue
+			}
+			out = append(out, seg.text)
+		}
+	default: // Isolated
+		for _, seg := range segs {
+			out = append(out, seg.text)
+		}
+	}
+	return out
+}
+
+// byteLevelPreTokenizer maps every byte to its GPT-2 unicode representation, optionally
+// splitting with the GPT-2 regex first.
+type byteLevelPreTokenizer struct {
+	addPrefixSpace bool
+	useRegex       bool
+}
+
+func (b byteLevelPreTokenizer) preTokenize(pieces []string, _ bool) []string {
+	var out []string
+	for _, piece := range pieces {
+		if b.addPrefixSpace && !strings.HasPrefix(piece, " ") {
+			piece = " " + piece
+		}
+		parts := []string{piece}
+		if b.useRegex {
+			parts = nil
+			for _, seg := range regexSegments(gpt2SplitRe, piece) {
+				parts = append(parts, seg.text)
+			}
+		}
+		for _, part := range parts {
+			out = append(out, byteLevelEncode(part))
+		}
+	}
+	return out
+}
+
+func byteLevelEncode(text string) string {
+	var sb strings.Builder
+	sb.Grow(len(text) * 2)
+	for i := 0; i < len(text); i++ {
+		sb.WriteRune(byteEncoder[text[i]])
+	}
+	return sb.String()
+}
+
+// metaspacePreTokenizer replaces spaces with a visible replacement character, as used by
+// SentencePiece-style tokenizers.
+type metaspacePreTokenizer struct {
+	replacement   string
+	prependScheme string
+	split         bool
+}
+
+func newMetaspace(c *hfComponent) metaspacePreTokenizer {
+	m := metaspacePreTokenizer{
+		replacement:   c.Replacement,
+		prependScheme: c.PrependScheme,
+		split:         boolOr(c.Split, true),
+	}
+	if m.replacement == "" {
+		m.replacement = "▁"
+	}
+	if m.prependScheme == "" {
+		m.prependScheme = "never"
+		if boolOr(c.AddPrefixSpace, true) {
+			m.prependScheme = "always"
+		}
+	}
+	return m
+}
+
+func (m metaspacePreTokenizer) preTokenize(pieces []string, first bool) []string {
+	var out []string
+	for i, piece := range pieces {
+		piece = strings.ReplaceAll(piece, " ", m.replacement)
+		prepend := m.prependScheme == "always" || (m.prependScheme == "first" && first && i == 0)
+		if prepend && !strings.HasPrefix(piece, m.replacement) {
+			piece = m.replacement + piece
+		}
+		if !m.split {
+			out = append(out, piece)
+			continue
+		}
+		var cur strings.Builder
+		for len(piece) > 0 {
+			if strings.HasPrefix(piece, m.replacement) && cur.Len() > 0 {
+				out = append(out, cur.String())
+				cur.Reset()
+			}
+			_, size := utf8.DecodeRuneInString(piece)
+			if strings.HasPrefix(piece, m.replacement) {
+				size = len(m.replacement)
+			}
+			cur.WriteString(piece[:size])
+			piece = piece[size:]
+		}
+		if cur.Len() > 0 {
+			out = append(out, cur.String())
+		}
+	}
+	return out
+}
+
+// regexPreTokenizer keeps only the matches of a regex, dropping everything in between.
+type regexPreTokenizer struct {
+	re *regexp2.Regexp
+}
+
+func (r regexPreTokenizer) preTokenize(pieces []string, _ bool) []string {
+	var out []string
+	for _, piece := range pieces {
+		for _, seg := range regexSegments(r.re, piece) {
+			if seg.matched {
+				out = append(out, seg.text)
+			}
+		}
+	}
+	return out
+}
+
+type preTokenizerFunc func(piece string) []string
+
+func (f preTokenizerFunc) preTokenize(pieces []string, _ bool) []string {
+	var out []string
+	for _, piece := range pieces {
+		out = append(out, f(piece)...)
+	}
+	return out
+}
+
+func isBertPunctuation(r rune) bool {
+	return (r >= 33 && r <= 47) || (r >= 58 && r <= 64) || (r >= 91 && r <= 96) ||
+		(r >= 123 && r <= 126) || unicode.IsPunct(r)
+}
+
+// splitRunes splits text around runes for which isDelimiter returns true, following behavior.
+func splitRunes(text string, isDelimiter func(rune) bool, behavior string) []string {
+	var segs []regexSegment
+	for _, r := range text {
+		matched := isDelimiter(r)
+		if n := len(segs); n > 0 && !matched && !segs[n-1].matched {
+			segs[n-1].text += string(r)
+			continue
+		}
+		segs = append(segs, regexSegment{text: string(r), matched: matched})
+	}
+	return applySplitBehavior(segs, behavior)
+}
+
+func newPreTokenizer(c *hfComponent) (tokenPreTokenizer, error) {
+	if c == nil {
+		return nil, nil
+	}
+	switch c.Type {
+	case "Sequence":
+		seq := make(preTokenizerSequence, 0, len(c.PreTokenizers))
+		for i := range c.PreTokenizers {
+			p, err := newPreTokenizer(&c.PreTokenizers[i])
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, p)
+		}
+		return seq, nil
+	case "ByteLevel":
+		return byteLevelPreTokenizer{
+			addPrefixSpace: boolOr(c.AddPrefixSpace, true),
+			useRegex:       boolOr(c.UseRegex, true),
+		}, nil
+	case "Split":
+		re, err := c.Pattern.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Split pre-tokenizer: %w", err)
+		}
+		return splitPreTokenizer{re: re, behavior: c.Behavior, invert: c.Invert}, nil
+	case "Metaspace":
+		return newMetaspace(c), nil
+	case "Whitespace":
+		return regexPreTokenizer{re: regexp2.MustCompile(`\w+|[^\w\s]+`, regexp2.None)}, nil
+	case "WhitespaceSplit":
+		return preTokenizerFunc(strings.Fields), nil
+	case "Digits":
+		re := regexp2.MustCompile(`\p{N}+`, regexp2.None)
+		if c.IndividualDigits {
+			re = regexp2.MustCompile(`\p{N}`, regexp2.None)
+		}
+		return splitPreTokenizer{re: re, behavior: "Isolated"}, nil
+	case "Punctuation":
+		behavior := c.Behavior
+		return preTokenizerFunc(func(piece string) []string {
+			return splitRunes(piece, isBertPunctuation, behavior)
+		}), nil
+	case "BertPreTokenizer":
+		return preTokenizerFunc(func(piece string) []string {
+			var out []string
+			for _, word := range strings.Fields(piece) {
+				out = append(out, splitRunes(word, isBertPunctuation, "Isolated")...)
+			}
+			return out
+		}), nil
+	}
+	return nil, fmt.Errorf("unsupported pre-tokenizer type %q", c.Type)
+}
+
+// --- Decoders ---
+
+type decoderFunc func(tokens []string) []string
+
+func (f decoderFunc) decode(tokens []string) []string { return f(tokens) }
+
+type decoderSequence []tokenDecoder
+
+func (s decoderSequence) decode(tokens []string) []string {
+	for _, d := range s {
+		tokens = d.decode(tokens)
+	}
+	return tokens
+}
+
+func mapTokens(f func(i int, token string) string) decoderFunc {
+	return func(tokens []string) []string {
+		out := make([]string, len(tokens))
+		for i, token := range tokens {
+			out[i] = f(i, token)
+		}
+		return out
+	}
+}
+
+func byteLevelDecode(tokens []string) []string {
+	var buf []byte
+	for _, token := range tokens {
+		for _, r := range token {
+			if b, ok := byteDecoder[r]; ok {
+				buf = append(buf, b)
+				continue
+			}
+			buf = utf8.AppendRune(buf, r)
+		}
+	}
+	return []string{string(buf)}
+}
+
+// byteFallbackToken parses tokens of the form <0xNN> emitted by models with byte_fallback.
+func byteFallbackToken(token string) (byte, bool) {
+	if len(token) != 6 || !strings.HasPrefix(token, "<0x") || token[5] != '>' {
+		return 0, false
+	}
+	b, err := strconv.ParseUint(token[3:5], 16, 8)
+	if err != nil {
+		return 0, false
+	}
+	return byte(b), true
+}
+
+func byteFallbackDecode(tokens []string) []string {
+	var out []string
+	var pending []byte
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if utf8.Valid(pending) {
+			out = append(out, string(pending))
+		} else {
+			for range pending {
+				out = append(out, string(utf8.RuneError))
+			}
+		}
+		pending = nil
+	}
+	for _, token := range tokens {
+		if b, ok := byteFallbackToken(token); ok {
+			pending = append(pending, b)
+			continue
+		}
+		flush()
+		out = append(out, token)
+	}
+	flush()
+	return out
+}
+
+func newDecoder(c *hfComponent) (tokenDecoder, error) {
+	if c == nil {
+		return nil, nil
+	}
+	switch c.Type {
+	case "Sequence":
+		seq := make(decoderSequence, 0, len(c.Decoders))
+		for i := range c.Decoders {
+			d, err := newDecoder(&c.Decoders[i])
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, d)
+		}
+		return seq, nil
+	case "ByteLevel":
+		return decoderFunc(byteLevelDecode), nil
+	case "ByteFallback":
+		return decoderFunc(byteFallbackDecode), nil
+	case "Fuse":
+		return decoderFunc(func(tokens []string) []string {
+			return []string{strings.Join(tokens, "")}
+		}), nil
+	case "Metaspace":
+		m := newMetaspace(c)
+		return mapTokens(func(i int, token string) string {
+			token = strings.ReplaceAll(token, m.replacement, " ")
+			if i == 0 && m.prependScheme != "never" {
+				token = strings.TrimPrefix(token, " ")
+			}
+			return token
+		}), nil
+	case "Strip":
+		content, start, stop := c.Content, c.Start, c.Stop
+		return mapTokens(func(_ int, token string) string {
+			for n := 0; n < start && strings.HasPrefix(token, content); n++ {
+				token = strings.TrimPrefix(token, content)
+			}
+			for n := 0; n < stop && strings.HasSuffix(token, content); n++ {
+				token = strings.TrimSuffix(token, content)
+			}
+			return token
+		}), nil
+	case "Replace":
+		re, err := c.Pattern.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Replace decoder: %w", err)
+		}
+		// The replacement is literal, escape regexp2 substitutions.
+		content := strings.ReplaceAll(c.Content, "$", "$$")
+		return mapTokens(func(_ int, token string) string {
+			out, err := re.Replace(token, content, -1, -1)
+			if err != nil {
+				return token
+			}
+			return out
+		}), nil
+	case "BPEDecoder":
+		suffix := c.Suffix
+		if suffix == "" {
+			suffix = "</w>"
+		}
+		return mapTokens(func(i int, token string) string {
+			replacement := " "
+			if i == 0 {
+				replacement = ""
+			}
+			return strings.ReplaceAll(token, suffix, replacement)
+		}), nil
+	}
+	return nil, fmt.Errorf("unsupported decoder type %q", c.Type)
+}
+
+// parseHFComponent decodes an optional tokenizer.json component, treating null as absent.
+func parseHFComponent(raw json.RawMessage) (*hfComponent, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var c hfComponent
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
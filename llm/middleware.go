@@ -0,0 +1,565 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chatter is implemented by every provider in this package and by the middlewares wrapping
+// them. It has the same method set as golightrag.LLM, so a wrapped provider can be passed
+// anywhere an LLM is expected.
+type Chatter interface {
+	Chat(messages []string) (string, error)
+}
+
+// Middleware wraps a Chatter with additional behavior.
+type Middleware func(next Chatter) Chatter
+
+// Chain wraps c with the given middlewares. The first middleware is the outermost one, so
+// with Chain(provider, WithRetry(...), WithCircuitBreaker(...)) every retry goes through the
+// circuit breaker.
+func Chain(c Chatter, middlewares ...Middleware) Chatter {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		c = middlewares[i](c)
+	}
+	return c
+}
+
+// RetryConfig configures the Retry middleware.
+type RetryConfig struct {
+	// MaxAttempts is the total number of calls made, including the first one. Defaults to 4.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. Defaults to 1 second.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts. Defaults to 1 minute.
+	MaxBackoff time.Duration
+	// Multiplier is the factor applied to the delay after every retry. Defaults to 2.
+	Multiplier float64
+	// Jitter is the fraction of every delay that is randomized, between 0 and 1, so that
+	// concurrent callers don't retry in lockstep. Defaults to 0.5, a negative value disables it.
+	Jitter float64
+	// Retryable decides whether a failed call is retried. Defaults to IsRetryable.
+	Retryable func(error) bool
+}
+
+// Retry retries failed calls with exponential backoff and jitter. A delay requested by the
+// provider, such as the Retry-After header of a rate limited response, is honored when it is
+// longer than the computed backoff.
+type Retry struct {
+	next Chatter
+	cfg  RetryConfig
+
+	sleep  func(time.Duration)
+	random func() float64
+}
+
+// RateLimitConfig configures the RateLimiter middleware.
+type RateLimitConfig struct {
+	// RequestsPerMinute limits the number of calls started per minute. Zero means no limit.
+	RequestsPerMinute int
+	// TokensPerMinute limits the number of prompt and response tokens per minute. Prompt
+	// tokens are counted before the call and response tokens after it. Zero means no limit.
+	TokensPerMinute int
+	// Tokenizer counts the tokens for TokensPerMinute. Defaults to the default tokenizer.
+	Tokenizer Tokenizer
+}
+
+// RateLimiter spaces out calls to stay under requests-per-minute and tokens-per-minute limits,
+// using token buckets that allow short bursts up to the per-minute budget. When a call is
+// rejected with ErrRateLimited and a retry delay, every caller waits for that delay.
+type RateLimiter struct {
+	next Chatter
+	cfg  RateLimitConfig
+
+	mu          sync.Mutex
+	requests    *bucket
+	tokens      *bucket
+	pausedUntil time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// bucket is a token bucket refilled continuously up to its capacity.
+type bucket struct {
+	capacity  float64
+	available float64
+	perSecond float64
+	last      time.Time
+}
+
+// ConcurrencyLimiter caps the number of calls in flight.
+type ConcurrencyLimiter struct {
+	next Chatter
+	sem  chan struct{}
+}
+
+// CircuitBreakerConfig configures the CircuitBreaker middleware.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit. Defaults to 5.
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open before a trial call is let through.
+	// Defaults to 30 seconds.
+	Cooldown time.Duration
+	// IsFailure decides whether an error counts as a failure. Defaults to IsRetryable, so client
+	// errors such as ErrContextTooLong don't open the circuit.
+	IsFailure func(error) bool
+}
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+// CircuitBreaker stops calling a provider that keeps failing. After FailureThreshold
+// consecutive failures it rejects calls with ErrCircuitOpen for the Cooldown duration, then
+// lets a single trial call through: the circuit closes if it succeeds and opens again otherwise.
+// Only the trial call decides the state of a half-open circuit. Calls that were started before
+// the last state change, and return after it, are ignored.
+type CircuitBreaker struct {
+	next Chatter
+	cfg  CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// generation is incremented on every state change, so the results of calls started in an
+	// earlier state can be told apart.
+	generation uint64
+
+	now func() time.Time
+}
+
+const (
+	// CircuitClosed lets every call through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every call.
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial call through.
+	CircuitHalfOpen
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker while the circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const (
+	defaultRetryMaxAttempts    = 4
+	defaultRetryInitialBackoff = 1 * time.Second
+	defaultRetryMaxBackoff     = 1 * time.Minute
+	defaultRetryMultiplier     = 2
+	defaultRetryJitter         = 0.5
+
+	defaultCircuitFailureThreshold = 5
+	defaultCircuitCooldown         = 30 * time.Second
+)
+
+// NewRetry wraps next with the Retry middleware.
+func NewRetry(next Chatter, cfg RetryConfig) *Retry {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if cfg.Multiplier <= 0 {
+		cfg.Multiplier = defaultRetryMultiplier
+	}
+	if cfg.Jitter == 0 {
+		cfg.Jitter = defaultRetryJitter
+	}
+	cfg.Jitter = min(max(cfg.Jitter, 0), 1)
+	if cfg.Retryable == nil {
+		cfg.Retryable = IsRetryable
+	}
+	return &Retry{
+		next:   next,
+		cfg:    cfg,
+		sleep:  time.Sleep,
+		random: rand.Float64,
+	}
+}
+
+// WithRetry returns a Middleware that wraps a Chatter with NewRetry.
+func WithRetry(cfg RetryConfig) Middleware {
+	return func(next Chatter) Chatter { return NewRetry(next, cfg) }
+}
+
+// Chat implements Chatter.
+func (r *Retry) Chat(messages []string) (string, error) {
+	res, _, err := r.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage implements UsageReporter, returning the usage of the successful attempt.
+func (r *Retry) ChatWithUsage(messages []string) (string, Usage, error) {
+	return r.do(func() (string, Usage, error) { return ChatWithUsage(r.next, messages) })
+}
+
+// SupportsStructuredOutput implements StructuredChatter.
+func (r *Retry) SupportsStructuredOutput() bool {
+	return SupportsStructuredOutput(r.next)
+}
+
+// ChatStructured implements StructuredChatter.
+func (r *Retry) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	return r.do(func() (string, Usage, error) { return ChatStructured(r.next, messages, schema) })
+}
+
+// Complete implements Completer.
+func (r *Retry) Complete(req ChatRequest) (ChatResponse, error) {
+	return completeResponse(r.do(func() (string, Usage, error) { return completeParts(r.next, req) }))
+}
+
+func (r *Retry) do(call func() (string, Usage, error)) (string, Usage, error) {
+	for attempt := 1; ; attempt++ {
+		res, usage, err := call()
+		if err == nil {
+			return res, usage, nil
+		}
+		if !r.cfg.Retryable(err) {
+			return "", Usage{}, err
+		}
+		if attempt >= r.cfg.MaxAttempts {
+			return "", Usage{}, fmt.Errorf("failed after %d attempts: %w", attempt, err)
+		}
+		r.sleep(r.backoff(attempt, err))
+	}
+}
+
+func (r *Retry) backoff(attempt int, err error) time.Duration {
+	delay := float64(r.cfg.InitialBackoff) * math.Pow(r.cfg.Multiplier, float64(attempt-1))
+	delay = min(delay, float64(r.cfg.MaxBackoff))
+	delay = delay*(1-r.cfg.Jitter) + delay*r.cfg.Jitter*r.random()
+
+	return max(time.Duration(delay), RetryAfter(err))
+}
+
+// NewRateLimiter wraps next with the RateLimiter middleware.
+func NewRateLimiter(next Chatter, cfg RateLimitConfig) *RateLimiter {
+	r := &RateLimiter{
+		next:  next,
+		cfg:   cfg,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+	if cfg.RequestsPerMinute > 0 {
+		r.requests = newBucket(cfg.RequestsPerMinute)
+	}
+	if cfg.TokensPerMinute > 0 {
+		r.tokens = newBucket(cfg.TokensPerMinute)
+	}
+	return r
+}
+
+// WithRateLimit returns a Middleware that wraps a Chatter with NewRateLimiter.
+func WithRateLimit(cfg RateLimitConfig) Middleware {
+	return func(next Chatter) Chatter { return NewRateLimiter(next, cfg) }
+}
+
+func newBucket(perMinute int) *bucket {
+	return &bucket{
+		capacity:  float64(perMinute),
+		available: float64(perMinute),
+		perSecond: float64(perMinute) / 60,
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.available = min(b.capacity, b.available+now.Sub(b.last).Seconds()*b.perSecond)
+	}
+	b.last = now
+}
+
+// wait returns how long to wait until n units are available.
+func (b *bucket) wait(n float64) time.Duration {
+	if b == nil || b.available >= n {
+		return 0
+	}
+	return time.Duration((n - b.available) / b.perSecond * float64(time.Second))
+}
+
+// Chat implements Chatter.
+func (r *RateLimiter) Chat(messages []string) (string, error) {
+	res, _, err := r.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage implements UsageReporter. When the wrapped client reports usage, the reported
+// completion tokens are charged against TokensPerMinute instead of a local count.
+func (r *RateLimiter) ChatWithUsage(messages []string) (string, Usage, error) {
+	return r.do(strings.Join(messages, "\n"), func() (string, Usage, error) { return ChatWithUsage(r.next, messages) })
+}
+
+// SupportsStructuredOutput implements StructuredChatter.
+func (r *RateLimiter) SupportsStructuredOutput() bool {
+	return SupportsStructuredOutput(r.next)
+}
+
+// ChatStructured implements StructuredChatter.
+func (r *RateLimiter) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	return r.do(strings.Join(messages, "\n"), func() (string, Usage, error) {
+		return ChatStructured(r.next, messages, schema)
+	})
+}
+
+// Complete implements Completer.
+func (r *RateLimiter) Complete(req ChatRequest) (ChatResponse, error) {
+	return completeResponse(r.do(req.text(), func() (string, Usage, error) { return completeParts(r.next, req) }))
+}
+
+func (r *RateLimiter) do(prompt string, call func() (string, Usage, error)) (string, Usage, error) {
+	var promptTokens float64
+	if r.tokens != nil {
+		count, err := CountTokens(r.cfg.Tokenizer, prompt)
+		if err != nil {
+			return "", Usage{}, fmt.Errorf("failed to count prompt tokens: %w", err)
+		}
+		// A prompt larger than the whole budget would never fit, let it through a full bucket.
+		promptTokens = min(float64(count), r.tokens.capacity)
+	}
+
+	r.acquire(promptTokens)
+
+	res, usage, err := call()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tokens != nil && err == nil {
+		completionTokens := usage.CompletionTokens
+		if completionTokens == 0 {
+			completionTokens, _ = CountTokens(r.cfg.Tokenizer, res)
+		}
+		r.tokens.refill(r.now())
+		r.tokens.available -= float64(completionTokens)
+	}
+	if errors.Is(err, ErrRateLimited) {
+		if until := r.now().Add(RetryAfter(err)); until.After(r.pausedUntil) {
+			r.pausedUntil = until
+		}
+	}
+
+	return res, usage, err
+}
+
+func (r *RateLimiter) acquire(promptTokens float64) {
+	for {
+		r.mu.Lock()
+		now := r.now()
+		wait := r.pausedUntil.Sub(now)
+		if r.requests != nil {
+			r.requests.refill(now)
+			wait = max(wait, r.requests.wait(1))
+		}
+		if r.tokens != nil {
+			r.tokens.refill(now)
+			wait = max(wait, r.tokens.wait(promptTokens))
+		}
+		if wait <= 0 {
+			if r.requests != nil {
+				r.requests.available--
+			}
+			if r.tokens != nil {
+				r.tokens.available -= promptTokens
+			}
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+		r.sleep(wait)
+	}
+}
+
+// NewConcurrencyLimiter wraps next so that at most limit calls run at the same time.
+// A limit lower than 1 is treated as 1.
+func NewConcurrencyLimiter(next Chatter, limit int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		next: next,
+		sem:  make(chan struct{}, max(limit, 1)),
+	}
+}
+
+// WithConcurrencyLimit returns a Middleware that wraps a Chatter with NewConcurrencyLimiter.
+func WithConcurrencyLimit(limit int) Middleware {
+	return func(next Chatter) Chatter { return NewConcurrencyLimiter(next, limit) }
+}
+
+// Chat implements Chatter.
+func (c *ConcurrencyLimiter) Chat(messages []string) (string, error) {
+	res, _, err := c.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage implements UsageReporter.
+func (c *ConcurrencyLimiter) ChatWithUsage(messages []string) (string, Usage, error) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	return ChatWithUsage(c.next, messages)
+}
+
+// SupportsStructuredOutput implements StructuredChatter.
+func (c *ConcurrencyLimiter) SupportsStructuredOutput() bool {
+	return SupportsStructuredOutput(c.next)
+}
+
+// ChatStructured implements StructuredChatter.
+func (c *ConcurrencyLimiter) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	return ChatStructured(c.next, messages, schema)
+}
+
+// Complete implements Completer.
+func (c *ConcurrencyLimiter) Complete(req ChatRequest) (ChatResponse, error) {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	return Complete(c.next, req)
+}
+
+// NewCircuitBreaker wraps next with the CircuitBreaker middleware.
+func NewCircuitBreaker(next Chatter, cfg CircuitBreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultCircuitFailureThreshold
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaultCircuitCooldown
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = IsRetryable
+	}
+	return &CircuitBreaker{
+		next: next,
+		cfg:  cfg,
+		now:  time.Now,
+	}
+}
+
+// WithCircuitBreaker returns a Middleware that wraps a Chatter with NewCircuitBreaker.
+func WithCircuitBreaker(cfg CircuitBreakerConfig) Middleware {
+	return func(next Chatter) Chatter { return NewCircuitBreaker(next, cfg) }
+}
+
+// Chat implements Chatter.
+func (c *CircuitBreaker) Chat(messages []string) (string, error) {
+	res, _, err := c.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage implements UsageReporter.
+func (c *CircuitBreaker) ChatWithUsage(messages []string) (string, Usage, error) {
+	return c.do(func() (string, Usage, error) { return ChatWithUsage(c.next, messages) })
+}
+
+// SupportsStructuredOutput implements StructuredChatter.
+func (c *CircuitBreaker) SupportsStructuredOutput() bool {
+	return SupportsStructuredOutput(c.next)
+}
+
+// ChatStructured implements StructuredChatter.
+func (c *CircuitBreaker) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	return c.do(func() (string, Usage, error) { return ChatStructured(c.next, messages, schema) })
+}
+
+// Complete implements Completer.
+func (c *CircuitBreaker) Complete(req ChatRequest) (ChatResponse, error) {
+	return completeResponse(c.do(func() (string, Usage, error) { return completeParts(c.next, req) }))
+}
+
+func (c *CircuitBreaker) do(call func() (string, Usage, error)) (string, Usage, error) {
+	generation, probe, err := c.allow()
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	res, usage, err := call()
+	failed := err != nil && c.cfg.IsFailure(err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case probe:
+		c.probing = false
+		if failed {
+			c.open()
+		} else {
+			c.setState(CircuitClosed)
+		}
+	case generation != c.generation:
+		// The circuit changed state while the call was in flight, so its result says nothing
+		// about the current state.
+	case failed:
+		c.failures++
+		if c.failures >= c.cfg.FailureThreshold {
+			c.open()
+		}
+	default:
+		c.failures = 0
+	}
+	if failed {
+		return "", Usage{}, err
+	}
+
+	return res, usage, err
+}
+
+// allow reports whether a call may go through, returning the generation it is started in and
+// whether it is the trial call of a half-open circuit.
+func (c *CircuitBreaker) allow() (uint64, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == CircuitOpen {
+		remaining := c.cfg.Cooldown - c.now().Sub(c.openedAt)
+		if remaining > 0 {
+			return 0, false, fmt.Errorf("%w: retry in %s", ErrCircuitOpen, remaining.Round(time.Millisecond))
+		}
+		c.setState(CircuitHalfOpen)
+	}
+	if c.state == CircuitHalfOpen {
+		if c.probing {
+			return 0, false, fmt.Errorf("%w: trial call in progress", ErrCircuitOpen)
+		}
+		c.probing = true
+		return c.generation, true, nil
+	}
+	return c.generation, false, nil
+}
+
+func (c *CircuitBreaker) open() {
+	c.setState(CircuitOpen)
+	c.openedAt = c.now()
+}
+
+func (c *CircuitBreaker) setState(state CircuitState) {
+	c.state = state
+	c.failures = 0
+	c.generation++
+}
+
+// State returns the current state of the circuit.
+func (c *CircuitBreaker) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
@@ -0,0 +1,378 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type chatterFunc func(messages []string) (string, error)
+
+func (f chatterFunc) Chat(messages []string) (string, error) { return f(messages) }
+
+// fakeClock is a manually advanced clock whose sleep advances the time.
+type fakeClock struct {
+	mu    sync.Mutex
+	t     time.Time
+	slept []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slept = append(c.slept, d)
+	c.t = c.t.Add(d)
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("Retries transient errors", func(t *testing.T) {
+		calls := 0
+		next := chatterFunc(func([]string) (string, error) {
+			calls++
+			if calls < 3 {
+				return "", &ProviderError{Provider: "test", StatusCode: 503, Kind: ErrOverloaded}
+			}
+			return "ok", nil
+		})
+
+		clock := newFakeClock()
+		r := NewRetry(next, RetryConfig{InitialBackoff: time.Second, Jitter: -1})
+		r.sleep = clock.sleep
+
+		res, err := r.Chat([]string{"hi"})
+		if err != nil {
+			t.Fatalf("Expected success, got %v", err)
+		}
+		if res != "ok" || calls != 3 {
+			t.Errorf("Expected ok after 3 calls, got %q after %d calls", res, calls)
+		}
+		if len(clock.slept) != 2 || clock.slept[0] != time.Second || clock.slept[1] != 2*time.Second {
+			t.Errorf("Expected exponential backoff [1s 2s], got %v", clock.slept)
+		}
+	})
+
+	t.Run("Honors retry after", func(t *testing.T) {
+		calls := 0
+		next := chatterFunc(func([]string) (string, error) {
+			calls++
+			if calls == 1 {
+				return "", &ProviderError{Provider: "test", StatusCode: 429, Kind: ErrRateLimited, RetryAfter: 10 * time.Second}
+			}
+			return "ok", nil
+		})
+
+		clock := newFakeClock()
+		r := NewRetry(next, RetryConfig{InitialBackoff: time.Second})
+		r.sleep = clock.sleep
+
+		if _, err := r.Chat([]string{"hi"}); err != nil {
+			t.Fatalf("Expected success, got %v", err)
+		}
+		if len(clock.slept) != 1 || clock.slept[0] != 10*time.Second {
+			t.Errorf("Expected to wait 10s, got %v", clock.slept)
+		}
+	})
+
+	t.Run("Does not retry permanent errors", func(t *testing.T) {
+		calls := 0
+		next := chatterFunc(func([]string) (string, error) {
+			calls++
+			return "", &ProviderError{Provider: "test", StatusCode: 400, Kind: ErrContextTooLong}
+		})
+
+		r := NewRetry(next, RetryConfig{})
+		r.sleep = newFakeClock().sleep
+
+		_, err := r.Chat([]string{"hi"})
+		if !errors.Is(err, ErrContextTooLong) {
+			t.Errorf("Expected ErrContextTooLong, got %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("Expected 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("Gives up after max attempts", func(t *testing.T) {
+		calls := 0
+		next := chatterFunc(func([]string) (string, error) {
+			calls++
+			return "", &ProviderError{Provider: "test", StatusCode: 429, Kind: ErrRateLimited}
+		})
+
+		r := NewRetry(next, RetryConfig{MaxAttempts: 3})
+		r.sleep = newFakeClock().sleep
+
+		_, err := r.Chat([]string{"hi"})
+		if !errors.Is(err, ErrRateLimited) {
+			t.Errorf("Expected ErrRateLimited, got %v", err)
+		}
+		if calls != 3 {
+			t.Errorf("Expected 3 calls, got %d", calls)
+		}
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("Requests per minute", func(t *testing.T) {
+		next := chatterFunc(func([]string) (string, error) { return "ok", nil })
+
+		clock := newFakeClock()
+		r := NewRateLimiter(next, RateLimitConfig{RequestsPerMinute: 2})
+		r.now, r.sleep = clock.now, clock.sleep
+
+		for range 3 {
+			if _, err := r.Chat([]string{"hi"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		// Two requests fit in the burst, the third waits for a refill of one request.
+		if len(clock.slept) != 1 || clock.slept[0] != 30*time.Second {
+			t.Errorf("Expected a single 30s wait, got %v", clock.slept)
+		}
+	})
+
+	t.Run("Tokens per minute", func(t *testing.T) {
+		next := chatterFunc(func([]string) (string, error) { return "", nil })
+
+		clock := newFakeClock()
+		r := NewRateLimiter(next, RateLimitConfig{TokensPerMinute: 60, Tokenizer: wordCounter{}})
+		r.now, r.sleep = clock.now, clock.sleep
+
+		prompt := "one two three four five six seven eight nine ten"
+		for range 7 {
+			if _, err := r.Chat([]string{prompt}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		// Six prompts of 10 tokens fit in the burst, the seventh waits for 10 tokens at 1 token/s.
+		if len(clock.slept) != 1 || clock.slept[0] != 10*time.Second {
+			t.Errorf("Expected a single 10s wait, got %v", clock.slept)
+		}
+	})
+
+	t.Run("Pauses after rate limited response", func(t *testing.T) {
+		calls := 0
+		next := chatterFunc(func([]string) (string, error) {
+			calls++
+			if calls == 1 {
+				return "", &ProviderError{Provider: "test", StatusCode: 429, Kind: ErrRateLimited, RetryAfter: 5 * time.Second}
+			}
+			return "ok", nil
+		})
+
+		clock := newFakeClock()
+		r := NewRateLimiter(next, RateLimitConfig{})
+		r.now, r.sleep = clock.now, clock.sleep
+
+		if _, err := r.Chat([]string{"hi"}); !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("Expected ErrRateLimited, got %v", err)
+		}
+		if _, err := r.Chat([]string{"hi"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(clock.slept) != 1 || clock.slept[0] != 5*time.Second {
+			t.Errorf("Expected a single 5s wait, got %v", clock.slept)
+		}
+	})
+}
+
+type wordCounter struct{}
+
+func (wordCounter) Encode(text string) ([]int, error) {
+	var ids []int
+	inWord := false
+	for _, r := range text {
+		if r == ' ' || r == '\n' {
+			inWord = false
+			continue
+		}
+		if !inWord {
+			ids = append(ids, len(ids))
+		}
+		inWord = true
+	}
+	return ids, nil
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	next := chatterFunc(func([]string) (string, error) {
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		inFlight.Add(-1)
+		return "ok", nil
+	})
+
+	c := NewConcurrencyLimiter(next, 2)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = c.Chat([]string{"hi"})
+		}()
+	}
+	wg.Wait()
+
+	if peak.Load() > 2 {
+		t.Errorf("Expected at most 2 concurrent calls, got %d", peak.Load())
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	fail := true
+	calls := 0
+	next := chatterFunc(func([]string) (string, error) {
+		calls++
+		if fail {
+			return "", &ProviderError{Provider: "test", StatusCode: 500}
+		}
+		return "ok", nil
+	})
+
+	clock := newFakeClock()
+	c := NewCircuitBreaker(next, CircuitBreakerConfig{FailureThreshold: 2, Cooldown: time.Minute})
+	c.now = clock.now
+
+	for range 2 {
+		if _, err := c.Chat([]string{"hi"}); err == nil {
+			t.Fatal("Expected an error")
+		}
+	}
+	if c.State() != CircuitOpen {
+		t.Fatalf("Expected the circuit to be open, got %s", c.State())
+	}
+
+	if _, err := c.Chat([]string{"hi"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the open circuit to reject the call, got %d calls", calls)
+	}
+
+	// After the cooldown a failing trial call opens the circuit again.
+	clock.sleep(time.Minute)
+	if _, err := c.Chat([]string{"hi"}); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected the trial call to reach the provider, got %v", err)
+	}
+	if c.State() != CircuitOpen {
+		t.Fatalf("Expected the circuit to be open, got %s", c.State())
+	}
+
+	// A successful trial call closes it.
+	clock.sleep(time.Minute)
+	fail = false
+	if res, err := c.Chat([]string{"hi"}); err != nil || res != "ok" {
+		t.Fatalf("Expected the trial call to succeed, got %q, %v", res, err)
+	}
+	if c.State() != CircuitClosed {
+		t.Errorf("Expected the circuit to be closed, got %s", c.State())
+	}
+}
+
+func TestCircuitBreakerIgnoresLateCalls(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	next := chatterFunc(func(messages []string) (string, error) {
+		if messages[0] == "slow" {
+			close(started)
+			<-release
+			return "ok", nil
+		}
+		return "", &ProviderError{Provider: "test", StatusCode: 500}
+	})
+
+	clock := newFakeClock()
+	c := NewCircuitBreaker(next, CircuitBreakerConfig{FailureThreshold: 1, Cooldown: time.Minute})
+	c.now = clock.now
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Chat([]string{"slow"})
+		done <- err
+	}()
+	<-started
+
+	if _, err := c.Chat([]string{"fail"}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if c.State() != CircuitOpen {
+		t.Fatalf("Expected the circuit to be open, got %s", c.State())
+	}
+
+	// A call started before the circuit opened doesn't close it when it succeeds late.
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.State() != CircuitOpen {
+		t.Errorf("Expected the late call to leave the circuit open, got %s", c.State())
+	}
+}
+
+func TestCircuitBreakerProbeDecides(t *testing.T) {
+	next := chatterFunc(func([]string) (string, error) {
+		return "", fmt.Errorf("wrapped: %w", ErrContextTooLong)
+	})
+	clock := newFakeClock()
+	c := NewCircuitBreaker(next, CircuitBreakerConfig{FailureThreshold: 1, Cooldown: time.Minute})
+	c.now = clock.now
+	c.open()
+
+	// While open, no call reaches the provider, so none can close the circuit.
+	if _, err := c.Chat([]string{"hi"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if c.State() != CircuitOpen {
+		t.Fatalf("Expected the circuit to be open, got %s", c.State())
+	}
+
+	// A trial call answered by the provider closes it, even with a client error.
+	clock.sleep(time.Minute)
+	if _, err := c.Chat([]string{"hi"}); !errors.Is(err, ErrContextTooLong) {
+		t.Fatalf("Expected ErrContextTooLong, got %v", err)
+	}
+	if c.State() != CircuitClosed {
+		t.Errorf("Expected the circuit to be closed, got %s", c.State())
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next Chatter) Chatter {
+			return chatterFunc(func(messages []string) (string, error) {
+				order = append(order, name)
+				return next.Chat(messages)
+			})
+		}
+	}
+	c := Chain(chatterFunc(func([]string) (string, error) { return "ok", nil }), mark("outer"), mark("inner"))
+
+	if _, err := c.Chat([]string{"hi"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("Expected [outer inner], got %v", order)
+	}
+}
@@ -38,43 +38,74 @@ func NewOllama(host, model string, params Parameters, logger *slog.Logger) Ollam
 		host:   host,
 		model:  model,
 		params: params,
-		client: api.NewClient(u, &http.Client{Timeout: time.Second * 110}),
+		client: api.NewClient(u, &http.Client{Timeout: time.Second * 110, Transport: errorResponseTransport{}}),
 		logger: logger.With(slog.String("module", "ollama")),
 	}
 }
 
 // Chat sends a chat message to the Ollama API.
 func (o Ollama) Chat(messages []string) (string, error) {
-	msgs := make([]api.Message, len(messages))
-	for i, msg := range messages {
-		role := "user"
-		if i%2 == 1 {
-			role = "assistant"
-		}
-		msgs[i] = api.Message{
-			Role:    role,
-			Content: msg,
-		}
+	res, _, err := o.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage sends a chat message to the Ollama API and returns the token usage of the call.
+func (o Ollama) ChatWithUsage(messages []string) (string, Usage, error) {
+	res, err := o.Complete(NewChatRequest(messages))
+	return res.Content, res.Usage, err
+}
+
+// SupportsStructuredOutput reports true, Ollama constrains the output to the schema passed as
+// the request format.
+func (o Ollama) SupportsStructuredOutput() bool {
+	return true
+}
+
+// ChatStructured sends a chat message to the Ollama API with the schema as the request format.
+func (o Ollama) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	req := NewChatRequest(messages)
+	req.Schema = &schema
+	res, err := o.Complete(req)
+	return res.Content, res.Usage, err
+}
+
+// Complete sends a chat request to the Ollama API.
+func (o Ollama) Complete(creq ChatRequest) (ChatResponse, error) {
+	msgs := make([]api.Message, 0, len(creq.Messages)+1)
+	if creq.System != "" {
+		msgs = append(msgs, api.Message{Role: string(RoleSystem), Content: creq.System})
+	}
+	for _, msg := range creq.Messages {
+		msgs = append(msgs, api.Message{Role: string(msg.Role), Content: msg.Content})
 	}
 
-	req := o.chatRequest(msgs)
+	req := o.chatRequest(msgs, o.params.override(creq.Parameters))
+	if creq.Schema != nil {
+		req.Format = creq.Schema.Schema
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 110*time.Second)
 	defer cancel()
+	ctx, errRes := withErrorResponse(ctx)
 
 	var result strings.Builder
+	usage := Usage{Model: o.model}
 
 	if err := o.client.Chat(ctx, &req, func(res api.ChatResponse) error {
 		result.WriteString(res.Message.Content)
+		if res.Done {
+			usage.PromptTokens = res.PromptEvalCount
+			usage.CompletionTokens = res.EvalCount
+		}
 		return nil
 	}); err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
+		return ChatResponse{}, fmt.Errorf("error sending request: %w", fromOllamaError(err, errRes))
 	}
 
-	return result.String(), nil
+	return ChatResponse{Content: result.String(), Usage: usage}, nil
 }
 
-func (o Ollama) chatRequest(messages []api.Message) api.ChatRequest {
+func (o Ollama) chatRequest(messages []api.Message, params Parameters) api.ChatRequest {
 	req := api.ChatRequest{
 		Model:    o.model,
 		Messages: messages,
@@ -87,26 +118,29 @@ func (o Ollama) chatRequest(messages []api.Message) api.ChatRequest {
 	num_ctx := 32768
 	opts["num_ctx"] = &num_ctx
 
-	if o.params.Temperature != nil {
-		opts["temperature"] = *o.params.Temperature
+	if params.Temperature != nil {
+		opts["temperature"] = *params.Temperature
+	}
+	if params.Seed != nil {
+		opts["seed"] = *params.Seed
 	}
-	if o.params.Seed != nil {
-		opts["seed"] = *o.params.Seed
+	if params.Stop != nil {
+		opts["stop"] = params.Stop
 	}
-	if o.params.Stop != nil {
-		opts["stop"] = o.params.Stop
+	if params.TopK != nil {
+		opts["top_k"] = *params.TopK
 	}
-	if o.params.TopK != nil {
-		opts["top_k"] = *o.params.TopK
+	if params.TopP != nil {
+		opts["top_p"] = *params.TopP
 	}
-	if o.params.TopP != nil {
-		opts["top_p"] = *o.params.TopP
+	if params.MinP != nil {
+		opts["min_p"] = *params.MinP
 	}
-	if o.params.MinP != nil {
-		opts["min_p"] = *o.params.MinP
+	if params.MaxTokens != nil {
+		opts["num_predict"] = *params.MaxTokens
 	}
-	if o.params.IncludeReasoning != nil {
-		req.Think = o.params.IncludeReasoning
+	if params.IncludeReasoning != nil {
+		req.Think = params.IncludeReasoning
 	}
 
 	req.Options = opts
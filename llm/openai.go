@@ -24,74 +24,130 @@ func NewOpenAI(apiKey, model string, params Parameters, logger *slog.Logger) Ope
 	return OpenAI{
 		model:  model,
 		params: params,
-		client: goopenai.NewClient(apiKey),
+		client: newOpenAIClient(goopenai.DefaultConfig(apiKey)),
 		logger: logger.With(slog.String("module", "openai")),
 	}
 }
 
 // Chat sends a chat message to the OpenAI API.
 func (o OpenAI) Chat(messages []string) (string, error) {
-	msgs := make([]goopenai.ChatCompletionMessage, len(messages))
-	for i, msg := range messages {
-		role := goopenai.ChatMessageRoleUser
-		if i%2 == 1 {
-			role = goopenai.ChatMessageRoleAssistant
-		}
-		msgs[i] = goopenai.ChatCompletionMessage{
-			Role:    role,
-			Content: msg,
-		}
+	res, _, err := o.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage sends a chat message to the OpenAI API and returns the token usage of the call.
+func (o OpenAI) ChatWithUsage(messages []string) (string, Usage, error) {
+	res, err := o.Complete(NewChatRequest(messages))
+	return res.Content, res.Usage, err
+}
+
+// SupportsStructuredOutput reports true, OpenAI models enforce schemas through response_format.
+func (o OpenAI) SupportsStructuredOutput() bool {
+	return true
+}
+
+// ChatStructured sends a chat message to the OpenAI API with a JSON schema response_format.
+func (o OpenAI) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	req := NewChatRequest(messages)
+	req.Schema = &schema
+	res, err := o.Complete(req)
+	return res.Content, res.Usage, err
+}
+
+// Complete sends a chat request to the OpenAI API.
+func (o OpenAI) Complete(req ChatRequest) (ChatResponse, error) {
+	msgs := make([]goopenai.ChatCompletionMessage, 0, len(req.Messages)+1)
+	if req.System != "" {
+		msgs = append(msgs, goopenai.ChatCompletionMessage{
+			Role:    goopenai.ChatMessageRoleSystem,
+			Content: req.System,
+		})
+	}
+	for _, msg := range req.Messages {
+		msgs = append(msgs, goopenai.ChatCompletionMessage{
+			Role:    string(msg.Role),
+			Content: msg.Content,
+		})
 	}
 
-	req := o.chatRequest(msgs)
+	creq := o.chatRequest(msgs, o.params.override(req.Parameters))
+	if req.Schema != nil {
+		creq.ResponseFormat = openAIResponseFormat(*req.Schema)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
+	ctx, errRes := withErrorResponse(ctx)
 
-	resp, err := o.client.CreateChatCompletion(ctx, req)
+	resp, err := o.client.CreateChatCompletion(ctx, creq)
 	if err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
+		return ChatResponse{}, fmt.Errorf("error sending request: %w", fromOpenAIError("openai", err, errRes))
 	}
 
 	if len(resp.Choices) == 0 {
-		return "", errors.New("no choices found")
+		return ChatResponse{}, errors.New("no choices found")
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return ChatResponse{
+		Content: resp.Choices[0].Message.Content,
+		Usage: Usage{
+			Model:            resp.Model,
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+		},
+	}, nil
 }
 
-func (o OpenAI) chatRequest(messages []goopenai.ChatCompletionMessage) goopenai.ChatCompletionRequest {
+func openAIResponseFormat(schema Schema) *goopenai.ChatCompletionResponseFormat {
+	return &goopenai.ChatCompletionResponseFormat{
+		Type: goopenai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &goopenai.ChatCompletionResponseFormatJSONSchema{
+			Name:        schema.Name,
+			Description: schema.Description,
+			Schema:      schema.Schema,
+			Strict:      schema.Strict,
+		},
+	}
+}
+
+func (o OpenAI) chatRequest(
+	messages []goopenai.ChatCompletionMessage,
+	params Parameters,
+) goopenai.ChatCompletionRequest {
 	req := goopenai.ChatCompletionRequest{
 		Model:    o.model,
 		Messages: messages,
 	}
 
-	if o.params.Temperature != nil {
-		req.Temperature = *o.params.Temperature
+	if params.Temperature != nil {
+		req.Temperature = *params.Temperature
+	}
+	if params.TopP != nil {
+		req.TopP = *params.TopP
 	}
-	if o.params.TopP != nil {
-		req.TopP = *o.params.TopP
+	if params.Stop != nil {
+		req.Stop = params.Stop
 	}
-	if o.params.Stop != nil {
-		req.Stop = o.params.Stop
+	if params.PresencePenalty != nil {
+		req.PresencePenalty = *params.PresencePenalty
 	}
-	if o.params.PresencePenalty != nil {
-		req.PresencePenalty = *o.params.PresencePenalty
+	if params.Seed != nil {
+		req.Seed = params.Seed
 	}
-	if o.params.Seed != nil {
-		req.Seed = o.params.Seed
+	if params.FrequencyPenalty != nil {
+		req.FrequencyPenalty = *params.FrequencyPenalty
 	}
-	if o.params.FrequencyPenalty != nil {
-		req.FrequencyPenalty = *o.params.FrequencyPenalty
+	if params.LogitBias != nil {
+		req.LogitBias = params.LogitBias
 	}
-	if o.params.LogitBias != nil {
-		req.LogitBias = o.params.LogitBias
+	if params.Logprobs != nil {
+		req.LogProbs = *params.Logprobs
 	}
-	if o.params.Logprobs != nil {
-		req.LogProbs = *o.params.Logprobs
+	if params.TopLogprobs != nil {
+		req.TopLogProbs = *params.TopLogprobs
 	}
-	if o.params.TopLogprobs != nil {
-		req.TopLogProbs = *o.params.TopLogprobs
+	if params.MaxTokens != nil {
+		req.MaxCompletionTokens = *params.MaxTokens
 	}
 
 	return req
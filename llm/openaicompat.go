@@ -21,6 +21,9 @@ type OpenAICompat struct {
 	client             *goopenai.Client
 	logger             *slog.Logger
 	ChatTemplateKwargs map[string]interface{}
+	// DisableStructuredOutput stops sending JSON schemas as response_format, for servers
+	// that reject it.
+	DisableStructuredOutput bool
 }
 
 // NewOpenAICompat creates a new OpenAICompat instance with the specified host URL and model name.
@@ -31,7 +34,7 @@ func NewOpenAICompat(host, apiKey string, model string, params Parameters, logge
 	// Create client configuration with custom base URL
 	config := goopenai.DefaultConfig(apiKey)
 	config.BaseURL = strings.TrimSuffix(host, "/")
-	client := goopenai.NewClientWithConfig(config)
+	client := newOpenAIClient(config)
 
 	return OpenAICompat{
 		BaseUrl: baseUrl,
@@ -50,60 +53,104 @@ type ChatMessage struct {
 
 // ChatCompletionRequest represents the request payload for chat completions
 type ChatCompletionRequest struct {
-	Model              string                 `json:"model"`
-	Messages           []ChatMessage          `json:"messages"`
-	Temperature        *float32               `json:"temperature,omitempty"`
-	TopP               *float32               `json:"top_p,omitempty"`
-	Stop               []string               `json:"stop,omitempty"`
-	PresencePenalty    *float32               `json:"presence_penalty,omitempty"`
-	FrequencyPenalty   *float32               `json:"frequency_penalty,omitempty"`
-	Seed               *int                   `json:"seed,omitempty"`
-	LogitBias          map[string]int         `json:"logit_bias,omitempty"`
-	Logprobs           *bool                  `json:"logprobs,omitempty"`
-	TopLogprobs        *int                   `json:"top_logprobs,omitempty"`
-	MaxTokens          *int                   `json:"max_tokens,omitempty"`
-	ChatTemplateKwargs map[string]interface{} `json:"chat_template_kwargs,omitempty"`
+	Model              string                                 `json:"model"`
+	Messages           []ChatMessage                          `json:"messages"`
+	Temperature        *float32                               `json:"temperature,omitempty"`
+	TopP               *float32                               `json:"top_p,omitempty"`
+	Stop               []string                               `json:"stop,omitempty"`
+	PresencePenalty    *float32                               `json:"presence_penalty,omitempty"`
+	FrequencyPenalty   *float32                               `json:"frequency_penalty,omitempty"`
+	Seed               *int                                   `json:"seed,omitempty"`
+	LogitBias          map[string]int                         `json:"logit_bias,omitempty"`
+	Logprobs           *bool                                  `json:"logprobs,omitempty"`
+	TopLogprobs        *int                                   `json:"top_logprobs,omitempty"`
+	MaxTokens          *int                                   `json:"max_tokens,omitempty"`
+	ChatTemplateKwargs map[string]interface{}                 `json:"chat_template_kwargs,omitempty"`
+	ResponseFormat     *goopenai.ChatCompletionResponseFormat `json:"response_format,omitempty"`
 }
 
 // ChatCompletionResponse represents the response from the chat completion API
 type ChatCompletionResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message ChatMessage `json:"message"`
 	} `json:"choices"`
+	Usage ChatCompletionUsage `json:"usage"`
+}
+
+// ChatCompletionUsage represents the token usage reported by the chat completion API
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
 // Chat sends a chat message to the OpenAI-compatible API.
 func (o OpenAICompat) Chat(messages []string) (string, error) {
-	msgs := make([]ChatMessage, len(messages))
-	for i, msg := range messages {
-		role := "user"
-		if i%2 == 1 {
-			role = "assistant"
-		}
-		msgs[i] = ChatMessage{
-			Role:    role,
-			Content: msg,
-		}
+	res, _, err := o.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage sends a chat message to the OpenAI-compatible API and returns the token usage
+// of the call.
+func (o OpenAICompat) ChatWithUsage(messages []string) (string, Usage, error) {
+	res, err := o.Complete(NewChatRequest(messages))
+	return res.Content, res.Usage, err
+}
+
+// SupportsStructuredOutput reports whether JSON schemas are sent as response_format, which is
+// the case unless DisableStructuredOutput is set.
+func (o OpenAICompat) SupportsStructuredOutput() bool {
+	return !o.DisableStructuredOutput
+}
+
+// ChatStructured sends a chat message to the OpenAI-compatible API with a JSON schema
+// response_format.
+func (o OpenAICompat) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	req := NewChatRequest(messages)
+	req.Schema = &schema
+	res, err := o.Complete(req)
+	return res.Content, res.Usage, err
+}
+
+// Complete sends a chat request to the OpenAI-compatible API. The schema of the request is
+// ignored when DisableStructuredOutput is set.
+func (o OpenAICompat) Complete(req ChatRequest) (ChatResponse, error) {
+	msgs := make([]ChatMessage, 0, len(req.Messages)+1)
+	if req.System != "" {
+		msgs = append(msgs, ChatMessage{Role: string(RoleSystem), Content: req.System})
+	}
+	for _, msg := range req.Messages {
+		msgs = append(msgs, ChatMessage{Role: string(msg.Role), Content: msg.Content})
 	}
 
-	req := o.chatRequest(msgs)
+	creq := o.chatRequest(msgs, o.params.override(req.Parameters))
+	if req.Schema != nil && !o.DisableStructuredOutput {
+		creq.ResponseFormat = openAIResponseFormat(*req.Schema)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 110*time.Second)
 	defer cancel()
 
-	resp, err := o.sendRequest(ctx, req)
+	resp, err := o.sendRequest(ctx, creq)
 	if err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
+		return ChatResponse{}, fmt.Errorf("error sending request: %w", err)
 	}
 
 	if len(resp.Choices) == 0 {
-		return "", errors.New("no choices found")
+		return ChatResponse{}, errors.New("no choices found")
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return ChatResponse{
+		Content: resp.Choices[0].Message.Content,
+		Usage: Usage{
+			Model:            resp.Model,
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+		},
+	}, nil
 }
 
-func (o OpenAICompat) chatRequest(messages []ChatMessage) ChatCompletionRequest {
+func (o OpenAICompat) chatRequest(messages []ChatMessage, params Parameters) ChatCompletionRequest {
 	req := ChatCompletionRequest{
 		Model:    o.model,
 		Messages: messages,
@@ -112,35 +159,35 @@ func (o OpenAICompat) chatRequest(messages []ChatMessage) ChatCompletionRequest
 		},
 	}
 
-	if o.params.Temperature != nil {
-		req.Temperature = o.params.Temperature
+	if params.Temperature != nil {
+		req.Temperature = params.Temperature
 	}
-	if o.params.TopP != nil {
-		req.TopP = o.params.TopP
+	if params.TopP != nil {
+		req.TopP = params.TopP
 	}
-	if o.params.Stop != nil {
-		req.Stop = o.params.Stop
+	if params.Stop != nil {
+		req.Stop = params.Stop
 	}
-	if o.params.PresencePenalty != nil {
-		req.PresencePenalty = o.params.PresencePenalty
+	if params.PresencePenalty != nil {
+		req.PresencePenalty = params.PresencePenalty
 	}
-	if o.params.Seed != nil {
-		req.Seed = o.params.Seed
+	if params.Seed != nil {
+		req.Seed = params.Seed
 	}
-	if o.params.FrequencyPenalty != nil {
-		req.FrequencyPenalty = o.params.FrequencyPenalty
+	if params.FrequencyPenalty != nil {
+		req.FrequencyPenalty = params.FrequencyPenalty
 	}
-	if o.params.LogitBias != nil {
-		req.LogitBias = o.params.LogitBias
+	if params.LogitBias != nil {
+		req.LogitBias = params.LogitBias
 	}
-	if o.params.Logprobs != nil {
-		req.Logprobs = o.params.Logprobs
+	if params.Logprobs != nil {
+		req.Logprobs = params.Logprobs
 	}
-	if o.params.TopLogprobs != nil {
-		req.TopLogprobs = o.params.TopLogprobs
+	if params.TopLogprobs != nil {
+		req.TopLogprobs = params.TopLogprobs
 	}
-	if o.params.MaxTokens != nil {
-		req.MaxTokens = o.params.MaxTokens
+	if params.MaxTokens != nil {
+		req.MaxTokens = params.MaxTokens
 	}
 
 	if o.ChatTemplateKwargs != nil {
@@ -197,18 +244,27 @@ func (o OpenAICompat) sendRequest(ctx context.Context, req ChatCompletionRequest
 	if req.MaxTokens != nil {
 		openaiReq.MaxTokens = *req.MaxTokens
 	}
+	if req.ResponseFormat != nil {
+		openaiReq.ResponseFormat = req.ResponseFormat
+	}
 
 	// Make the request using the OpenAI client
+	ctx, errRes := withErrorResponse(ctx)
 	resp, err := o.client.CreateChatCompletion(ctx, openaiReq)
 	if err != nil {
-		return nil, fmt.Errorf("error making request: %w", err)
+		return nil, fmt.Errorf("error making request: %w", fromOpenAIError("openaicompat", err, errRes))
 	}
 
 	// Convert response back to our format
 	chatResp := &ChatCompletionResponse{
+		Model: resp.Model,
 		Choices: make([]struct {
 			Message ChatMessage `json:"message"`
 		}, len(resp.Choices)),
+		Usage: ChatCompletionUsage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+		},
 	}
 
 	for i, choice := range resp.Choices {
@@ -47,10 +47,31 @@ type openRouterChatRequest struct {
 	TopLogprobs       *int           `json:"top_logprobs,omitempty"`
 	Stop              []string       `json:"stop,omitempty"`
 	IncludeReasoning  *bool          `json:"include_reasoning,omitempty"`
+
+	ResponseFormat *openRouterResponseFormat `json:"response_format,omitempty"`
+}
+
+type openRouterResponseFormat struct {
+	Type       string               `json:"type"`
+	JSONSchema openRouterJSONSchema `json:"json_schema"`
+}
+
+type openRouterJSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      bool            `json:"strict,omitempty"`
 }
 
 type openRouterResponse struct {
+	Model   string             `json:"model"`
 	Choices []openRouterChoice `json:"choices"`
+	Usage   openRouterUsage    `json:"usage"`
+}
+
+type openRouterUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
 type openRouterChoice struct {
@@ -74,64 +95,104 @@ func NewOpenRouter(apiKey, model string, params Parameters, logger *slog.Logger)
 
 // Chat sends a chat message to the OpenRouter API.
 func (o OpenRouter) Chat(messages []string) (string, error) {
-	msgs := make([]openRouterMessage, len(messages))
-	for i, msg := range messages {
-		role := "user"
-		if i%2 == 1 {
-			role = "assistant"
-		}
-		msgs[i] = openRouterMessage{
-			Role:    role,
-			Content: msg,
-		}
+	res, _, err := o.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage sends a chat message to the OpenRouter API and returns the token usage of the call.
+func (o OpenRouter) ChatWithUsage(messages []string) (string, Usage, error) {
+	res, err := o.Complete(NewChatRequest(messages))
+	return res.Content, res.Usage, err
+}
+
+// SupportsStructuredOutput reports true. OpenRouter forwards response_format to the models
+// that support it.
+func (o OpenRouter) SupportsStructuredOutput() bool {
+	return true
+}
+
+// ChatStructured sends a chat message to the OpenRouter API with a JSON schema response_format.
+func (o OpenRouter) ChatStructured(messages []string, schema Schema) (string, Usage, error) {
+	req := NewChatRequest(messages)
+	req.Schema = &schema
+	res, err := o.Complete(req)
+	return res.Content, res.Usage, err
+}
+
+// Complete sends a chat request to the OpenRouter API.
+func (o OpenRouter) Complete(req ChatRequest) (ChatResponse, error) {
+	msgs := make([]openRouterMessage, 0, len(req.Messages)+1)
+	if req.System != "" {
+		msgs = append(msgs, openRouterMessage{Role: string(RoleSystem), Content: req.System})
+	}
+	for _, msg := range req.Messages {
+		msgs = append(msgs, openRouterMessage{Role: string(msg.Role), Content: msg.Content})
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
-	resp, err := o.doRequest(ctx, msgs)
+	resp, err := o.doRequest(ctx, msgs, o.params.override(req.Parameters), req.Schema)
 	if err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
+		return ChatResponse{}, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
-	}
-
 	var res openRouterResponse
 	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return "", fmt.Errorf("error decoding response: %w", err)
+		return ChatResponse{}, fmt.Errorf("error decoding response: %w", err)
 	}
 
 	if len(res.Choices) == 0 {
-		return "", errors.New("no choices found")
+		return ChatResponse{}, errors.New("no choices found")
 	}
 
-	return res.Choices[0].Message.Content, nil
+	return ChatResponse{
+		Content: res.Choices[0].Message.Content,
+		Usage: Usage{
+			Model:            res.Model,
+			PromptTokens:     res.Usage.PromptTokens,
+			CompletionTokens: res.Usage.CompletionTokens,
+		},
+	}, nil
 }
 
-func (o OpenRouter) doRequest(ctx context.Context, messages []openRouterMessage) (*http.Response, error) {
+func (o OpenRouter) doRequest(
+	ctx context.Context,
+	messages []openRouterMessage,
+	params Parameters,
+	schema *Schema,
+) (*http.Response, error) {
 	reqBody := openRouterChatRequest{
 		Model:    o.model,
 		Messages: messages,
 
-		Temperature:       o.params.Temperature,
-		TopP:              o.params.TopP,
-		TopK:              o.params.TopK,
-		FrequencyPenalty:  o.params.FrequencyPenalty,
-		PresencePenalty:   o.params.PresencePenalty,
-		RepetitionPenalty: o.params.RepetitionPenalty,
-		MinP:              o.params.MinP,
-		TopA:              o.params.TopA,
-		Seed:              o.params.Seed,
-		MaxTokens:         o.params.MaxTokens,
-		LogitBias:         o.params.LogitBias,
-		Logprobs:          o.params.Logprobs,
-		TopLogprobs:       o.params.TopLogprobs,
-		Stop:              o.params.Stop,
-		IncludeReasoning:  o.params.IncludeReasoning,
+		Temperature:       params.Temperature,
+		TopP:              params.TopP,
+		TopK:              params.TopK,
+		FrequencyPenalty:  params.FrequencyPenalty,
+		PresencePenalty:   params.PresencePenalty,
+		RepetitionPenalty: params.RepetitionPenalty,
+		MinP:              params.MinP,
+		TopA:              params.TopA,
+		Seed:              params.Seed,
+		MaxTokens:         params.MaxTokens,
+		LogitBias:         params.LogitBias,
+		Logprobs:          params.Logprobs,
+		TopLogprobs:       params.TopLogprobs,
+		Stop:              params.Stop,
+		IncludeReasoning:  params.IncludeReasoning,
+	}
+	if schema != nil {
+		reqBody.ResponseFormat = &openRouterResponseFormat{
+			Type: "json_schema",
+			JSONSchema: openRouterJSONSchema{
+				Name:        schema.Name,
+				Description: schema.Description,
+				Schema:      schema.Schema,
+				Strict:      schema.Strict,
+			},
+		}
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -157,8 +218,9 @@ func (o OpenRouter) doRequest(ctx context.Context, messages []openRouterMessage)
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s, request: %s", resp.StatusCode, string(body), jsonBody)
+		return nil, newProviderError("openrouter", resp.StatusCode, errorMessage(body), resp.Header)
 	}
 
 	return resp, nil
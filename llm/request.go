@@ -0,0 +1,184 @@
+package llm
+
+import "strings"
+
+// Role is the author of a message.
+type Role string
+
+// Defines the roles of the messages in a ChatRequest.
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is a single message of a conversation.
+type Message struct {
+	Role    Role
+	Content string
+}
+
+// ChatRequest is a chat call with role-tagged messages and per-call settings.
+type ChatRequest struct {
+	// System is the system prompt. System messages in Messages are appended to it by the
+	// providers that only accept a single system prompt.
+	System   string
+	Messages []Message
+	// Parameters overrides the parameters of the client for this call. Only the fields that are
+	// set are applied, so Parameters{Temperature: &zero} changes the temperature and keeps the
+	// rest of the client's parameters, including Stop and MaxTokens.
+	Parameters Parameters
+	// Schema, if set, constrains the response like ChatStructured.
+	Schema *Schema
+}
+
+// ChatResponse is the response to a ChatRequest.
+type ChatResponse struct {
+	Content string
+	Usage   Usage
+}
+
+// Completer is implemented by clients that accept a ChatRequest.
+// All providers and middlewares in this package implement it.
+type Completer interface {
+	Complete(req ChatRequest) (ChatResponse, error)
+}
+
+// NewChatRequest returns the request equivalent to Chat(messages): messages with an even index
+// are sent by the user and messages with an odd index by the assistant.
+func NewChatRequest(messages []string) ChatRequest {
+	msgs := make([]Message, len(messages))
+	for i, msg := range messages {
+		role := RoleUser
+		if i%2 == 1 {
+			role = RoleAssistant
+		}
+		msgs[i] = Message{Role: role, Content: msg}
+	}
+	return ChatRequest{Messages: msgs}
+}
+
+// Complete sends req to c. Clients that don't implement Completer receive the message contents
+// through Chat, ChatWithUsage or ChatStructured, with the system prompt prepended to the first
+// message. Their roles are inferred from the order of the messages, and per-call parameters
+// are ignored.
+func Complete(c Chatter, req ChatRequest) (ChatResponse, error) {
+	if r, ok := c.(Completer); ok {
+		return r.Complete(req)
+	}
+
+	messages := make([]string, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		messages = append(messages, msg.Content)
+	}
+	if req.System != "" {
+		if len(messages) == 0 {
+			messages = append(messages, req.System)
+		} else {
+			messages[0] = req.System + "\n\n" + messages[0]
+		}
+	}
+
+	var (
+		res   string
+		usage Usage
+		err   error
+	)
+	if req.Schema != nil {
+		res, usage, err = ChatStructured(c, messages, *req.Schema)
+	} else {
+		res, usage, err = ChatWithUsage(c, messages)
+	}
+	return ChatResponse{Content: res, Usage: usage}, err
+}
+
+// completeParts calls Complete and splits its response, to share the call paths of
+// ChatWithUsage and Complete in the middlewares.
+func completeParts(c Chatter, req ChatRequest) (string, Usage, error) {
+	res, err := Complete(c, req)
+	return res.Content, res.Usage, err
+}
+
+func completeResponse(content string, usage Usage, err error) (ChatResponse, error) {
+	return ChatResponse{Content: content, Usage: usage}, err
+}
+
+// systemPrompt returns the system prompt of req, merged with its system messages, and the
+// remaining messages.
+func (req ChatRequest) systemPrompt() (string, []Message) {
+	system := []string{}
+	if req.System != "" {
+		system = append(system, req.System)
+	}
+	messages := make([]Message, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		if msg.Role == RoleSystem {
+			system = append(system, msg.Content)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return strings.Join(system, "\n\n"), messages
+}
+
+// text returns the system prompt and message contents of req, used to count its tokens.
+func (req ChatRequest) text() string {
+	parts := make([]string, 0, len(req.Messages)+1)
+	if req.System != "" {
+		parts = append(parts, req.System)
+	}
+	for _, msg := range req.Messages {
+		parts = append(parts, msg.Content)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// override returns p with the fields set in o replaced.
+func (p Parameters) override(o Parameters) Parameters {
+	if o.Temperature != nil {
+		p.Temperature = o.Temperature
+	}
+	if o.TopP != nil {
+		p.TopP = o.TopP
+	}
+	if o.TopK != nil {
+		p.TopK = o.TopK
+	}
+	if o.FrequencyPenalty != nil {
+		p.FrequencyPenalty = o.FrequencyPenalty
+	}
+	if o.PresencePenalty != nil {
+		p.PresencePenalty = o.PresencePenalty
+	}
+	if o.RepetitionPenalty != nil {
+		p.RepetitionPenalty = o.RepetitionPenalty
+	}
+	if o.MinP != nil {
+		p.MinP = o.MinP
+	}
+	if o.TopA != nil {
+		p.TopA = o.TopA
+	}
+	if o.Seed != nil {
+		p.Seed = o.Seed
+	}
+	if o.MaxTokens != nil {
+		p.MaxTokens = o.MaxTokens
+	}
+	if o.LogitBias != nil {
+		p.LogitBias = o.LogitBias
+	}
+	if o.Logprobs != nil {
+		p.Logprobs = o.Logprobs
+	}
+	if o.TopLogprobs != nil {
+		p.TopLogprobs = o.TopLogprobs
+	}
+	if o.Stop != nil {
+		p.Stop = o.Stop
+	}
+	if o.IncludeReasoning != nil {
+		p.IncludeReasoning = o.IncludeReasoning
+	}
+	return p
+}
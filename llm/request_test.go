@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+)
+
+func TestComplete_Providers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	openAIResponse := `{"model":"m","choices":[{"message":{"role":"assistant","content":"ok"}}]}`
+
+	temperature, low := float32(0.7), float32(0.2)
+	maxTokens := 50
+	params := Parameters{Temperature: &temperature, Stop: []string{"END"}}
+	req := ChatRequest{
+		System: "Be brief.",
+		Messages: []Message{
+			{Role: RoleUser, Content: "question"},
+		},
+		Parameters: Parameters{Temperature: &low, MaxTokens: &maxTokens},
+	}
+
+	t.Run("OpenAICompat", func(t *testing.T) {
+		srv, rec := recordServer(t, openAIResponse)
+		c := NewOpenAICompat(srv.URL, "key", "m", params, logger)
+
+		if _, err := c.Complete(req); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		messages, _ := rec.body["messages"].([]any)
+		first, _ := messages[0].(map[string]any)
+		if len(messages) != 2 || first["role"] != "system" || first["content"] != "Be brief." {
+			t.Errorf("Expected the system prompt as the first message, got %v", messages)
+		}
+		// The request overrides the temperature, the client's stop sequences are kept.
+		if rec.body["temperature"] != 0.2 {
+			t.Errorf("Expected temperature 0.2, got %v", rec.body["temperature"])
+		}
+		stop, _ := rec.body["stop"].([]any)
+		if len(stop) != 1 || rec.body["max_tokens"] != float64(50) {
+			t.Errorf("Expected stop [END] and max_tokens 50, got %v and %v", stop, rec.body["max_tokens"])
+		}
+	})
+
+	t.Run("Anthropic", func(t *testing.T) {
+		rec := &recordTransport{response: `{"model":"m","content":[{"type":"text","text":"ok"}]}`}
+		c := NewAnthropic("key", "m", 100, params)
+		c.client = &http.Client{Transport: rec}
+
+		r := req
+		r.Messages = append([]Message{{Role: RoleSystem, Content: "Use English."}}, req.Messages...)
+		res, err := c.Complete(r)
+		if err != nil || res.Content != "ok" {
+			t.Fatalf("Expected ok, got %q, %v", res.Content, err)
+		}
+		if rec.body["system"] != "Be brief.\n\nUse English." {
+			t.Errorf("Expected system messages to be merged into the system prompt, got %v", rec.body["system"])
+		}
+		if messages, _ := rec.body["messages"].([]any); len(messages) != 1 {
+			t.Errorf("Expected a single user message, got %v", messages)
+		}
+		if rec.body["temperature"] != 0.2 || rec.body["max_tokens"] != float64(50) {
+			t.Errorf("Expected temperature 0.2 and max_tokens 50, got %v and %v",
+				rec.body["temperature"], rec.body["max_tokens"])
+		}
+	})
+
+	t.Run("Ollama", func(t *testing.T) {
+		srv, rec := recordServer(t, `{"model":"m","message":{"role":"assistant","content":"ok"},"done":true}`)
+		c := NewOllama(srv.URL, "m", params, logger)
+
+		if _, err := c.Complete(req); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		options, _ := rec.body["options"].(map[string]any)
+		if options["temperature"] != 0.2 || options["num_predict"] != float64(50) {
+			t.Errorf("Expected temperature 0.2 and num_predict 50, got %v", options)
+		}
+	})
+}
+
+func TestComplete_Fallback(t *testing.T) {
+	var received []string
+	c := chatterFunc(func(messages []string) (string, error) {
+		received = messages
+		return "ok", nil
+	})
+
+	res, err := Complete(Chain(c, WithRetry(RetryConfig{}), WithConcurrencyLimit(1)), ChatRequest{
+		System:   "Be brief.",
+		Messages: []Message{{Role: RoleUser, Content: "question"}},
+	})
+	if err != nil || res.Content != "ok" {
+		t.Fatalf("Expected ok, got %q, %v", res.Content, err)
+	}
+	if len(received) != 1 || received[0] != "Be brief.\n\nquestion" {
+		t.Errorf("Expected the system prompt to be prepended to the first message, got %q", received)
+	}
+}
@@ -0,0 +1,41 @@
+package llm
+
+import "encoding/json"
+
+// Schema is a JSON schema the response of a call must conform to.
+type Schema struct {
+	// Name identifies the schema. Some providers require it to match ^[a-zA-Z0-9_-]+$.
+	Name        string
+	Description string
+	// Schema is the JSON schema document. The response must be a JSON object, so the top
+	// level type must be "object".
+	Schema json.RawMessage
+	// Strict asks providers that support it to enforce the schema exactly. Strict schemas must
+	// list every property as required and disallow additional properties.
+	Strict bool
+}
+
+// StructuredChatter is implemented by clients that can constrain their response to a JSON schema.
+// All providers and middlewares in this package implement it.
+type StructuredChatter interface {
+	// SupportsStructuredOutput reports whether ChatStructured enforces the schema.
+	SupportsStructuredOutput() bool
+	// ChatStructured sends messages like Chat and returns a response conforming to schema,
+	// along with the usage of the call.
+	ChatStructured(messages []string, schema Schema) (string, Usage, error)
+}
+
+// SupportsStructuredOutput reports whether c can constrain its response to a JSON schema.
+func SupportsStructuredOutput(c Chatter) bool {
+	s, ok := c.(StructuredChatter)
+	return ok && s.SupportsStructuredOutput()
+}
+
+// ChatStructured calls c with schema when it supports structured output, and falls back to a
+// plain call otherwise, in which case the prompt itself has to ask for the expected JSON.
+func ChatStructured(c Chatter, messages []string, schema Schema) (string, Usage, error) {
+	if s, ok := c.(StructuredChatter); ok && s.SupportsStructuredOutput() {
+		return s.ChatStructured(messages, schema)
+	}
+	return ChatWithUsage(c, messages)
+}
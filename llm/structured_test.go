@@ -0,0 +1,158 @@
+package llm
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testSchema = Schema{
+	Name:   "answer",
+	Schema: json.RawMessage(`{"type":"object","properties":{"answer":{"type":"string"}},"required":["answer"]}`),
+	Strict: true,
+}
+
+// recordTransport returns a canned response and records the body of the request.
+type recordTransport struct {
+	response string
+	body     map[string]any
+}
+
+func (r *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.body = nil
+	if err := json.Unmarshal(b, &r.body); err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(r.response)),
+		Request:    req,
+	}, nil
+}
+
+func recordServer(t *testing.T, response string) (*httptest.Server, *recordTransport) {
+	t.Helper()
+	rec := &recordTransport{response: response}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		res, err := rec.RoundTrip(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.Copy(w, res.Body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, rec
+}
+
+func TestChatStructured_Providers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	openAIResponse := `{"model":"m","choices":[{"message":{"role":"assistant","content":"{\"answer\":\"42\"}"}}],` +
+		`"usage":{"prompt_tokens":10,"completion_tokens":5}}`
+
+	t.Run("OpenAICompat sends response_format", func(t *testing.T) {
+		srv, rec := recordServer(t, openAIResponse)
+		c := NewOpenAICompat(srv.URL, "key", "m", Parameters{}, logger)
+
+		res, usage, err := ChatStructured(c, []string{"question"}, testSchema)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if res != `{"answer":"42"}` || usage.PromptTokens != 10 {
+			t.Errorf("Unexpected response %q, usage %+v", res, usage)
+		}
+		format, _ := rec.body["response_format"].(map[string]any)
+		jsonSchema, _ := format["json_schema"].(map[string]any)
+		if format["type"] != "json_schema" || jsonSchema["name"] != "answer" || jsonSchema["strict"] != true {
+			t.Errorf("Expected a json_schema response_format, got %v", rec.body["response_format"])
+		}
+
+		c.DisableStructuredOutput = true
+		if _, _, err := ChatStructured(c, []string{"question"}, testSchema); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, ok := rec.body["response_format"]; ok {
+			t.Errorf("Expected no response_format when structured output is disabled")
+		}
+	})
+
+	t.Run("OpenRouter sends response_format", func(t *testing.T) {
+		rec := &recordTransport{response: openAIResponse}
+		c := NewOpenRouter("key", "m", Parameters{}, logger)
+		c.client = &http.Client{Transport: rec}
+
+		if _, _, err := ChatStructured(c, []string{"question"}, testSchema); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		format, _ := rec.body["response_format"].(map[string]any)
+		jsonSchema, _ := format["json_schema"].(map[string]any)
+		if format["type"] != "json_schema" || jsonSchema["schema"] == nil {
+			t.Errorf("Expected a json_schema response_format, got %v", rec.body["response_format"])
+		}
+	})
+
+	t.Run("Anthropic forces a tool call", func(t *testing.T) {
+		rec := &recordTransport{response: `{"model":"m","content":[{"type":"tool_use","name":"answer",` +
+			`"input":{"answer":"42"}}],"usage":{"input_tokens":10,"output_tokens":5}}`}
+		c := NewAnthropic("key", "m", 100, Parameters{})
+		c.client = &http.Client{Transport: rec}
+
+		res, _, err := ChatStructured(c, []string{"question"}, testSchema)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if res != `{"answer":"42"}` {
+			t.Errorf("Expected the tool input as the response, got %q", res)
+		}
+		choice, _ := rec.body["tool_choice"].(map[string]any)
+		tools, _ := rec.body["tools"].([]any)
+		if choice["type"] != "tool" || choice["name"] != "answer" || len(tools) != 1 {
+			t.Errorf("Expected a forced call to the schema tool, got tools %v, tool_choice %v", tools, choice)
+		}
+	})
+
+	t.Run("Ollama sends format", func(t *testing.T) {
+		srv, rec := recordServer(t, `{"model":"m","message":{"role":"assistant","content":"{\"answer\":\"42\"}"},`+
+			`"done":true,"prompt_eval_count":10,"eval_count":5}`)
+		c := NewOllama(srv.URL, "m", Parameters{}, logger)
+
+		if _, _, err := ChatStructured(c, []string{"question"}, testSchema); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		format, _ := rec.body["format"].(map[string]any)
+		if format["type"] != "object" {
+			t.Errorf("Expected the schema as format, got %v", rec.body["format"])
+		}
+	})
+}
+
+func TestChatStructured_Fallback(t *testing.T) {
+	var received []string
+	c := chatterFunc(func(messages []string) (string, error) {
+		received = messages
+		return "ok", nil
+	})
+
+	if SupportsStructuredOutput(c) {
+		t.Error("Expected a plain client not to support structured output")
+	}
+	res, _, err := ChatStructured(Chain(c, WithRetry(RetryConfig{})), []string{"question"}, testSchema)
+	if err != nil || res != "ok" || len(received) != 1 {
+		t.Errorf("Expected a plain call, got %q, %v, %v", res, err, received)
+	}
+
+	if !SupportsStructuredOutput(Chain(NewOllama("http://localhost", "m", Parameters{}, slog.Default()),
+		WithRetry(RetryConfig{}), WithConcurrencyLimit(1))) {
+		t.Error("Expected middlewares to forward structured output support")
+	}
+}
@@ -0,0 +1,311 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// Tokenizer is an interface for tokenizing text.
+type Tokenizer interface {
+	Encode(text string) ([]int, error)
+}
+
+// Decoder is implemented by tokenizers that can convert token IDs back into text.
+// Chunkers that split on token boundaries require it.
+type Decoder interface {
+	Decode(ids []int) (string, error)
+}
+
+// Tiktoken is a Tokenizer backed by the tiktoken encodings used by OpenAI models.
+type Tiktoken struct {
+	codec tokenizer.Codec
+}
+
+// TokenizerRegistry loads tokenizers by name and caches them for the lifetime of the process.
+//
+// A name can be a tiktoken model name (for example "gpt-4o"), a local tokenizer.json file, a
+// local directory containing tokenizer.json (or the legacy vocab.json and merges.txt pair), or
+// a Hugging Face model ID (for example "Qwen/Qwen1.5-0.5B"). Hugging Face files are downloaded
+// once into CacheDir and reused on subsequent loads.
+// When Offline is true, only CacheDir is consulted and nothing is downloaded.
+type TokenizerRegistry struct {
+	CacheDir string
+	Offline  bool
+
+	client *http.Client
+
+	mu     sync.Mutex
+	loaded map[string]Tokenizer
+}
+
+// ErrTokenizerNotCached is returned by an offline TokenizerRegistry when the requested
+// tokenizer files are not present in its cache directory.
+var ErrTokenizerNotCached = errors.New("tokenizer not found in offline cache")
+
+// DefaultTokenizerModel is the tiktoken model used when no tokenizer is configured.
+const DefaultTokenizerModel = string(tokenizer.GPT4o)
+
+// DefaultTokenizerRegistry is the process-wide registry used by LoadTokenizer.
+// Its cache directory is taken from GOLIGHTRAG_TOKENIZER_CACHE, falling back to the user's
+// cache directory, and it runs offline when HF_HUB_OFFLINE is set to "1" or "true".
+var DefaultTokenizerRegistry = NewTokenizerRegistry(defaultTokenizerCacheDir(), envOffline())
+
+var (
+	hfTokenizerJSONFiles = []string{"tokenizer.json"}
+	hfTokenizerBPEFiles  = []string{"vocab.json", "merges.txt"}
+
+	errFileNotFound = errors.New("file not found")
+)
+
+// NewTiktoken creates a tiktoken-based Tokenizer for the given OpenAI model name.
+func NewTiktoken(model string) (Tiktoken, error) {
+	codec, err := tokenizer.ForModel(tokenizer.Model(model))
+	if err != nil {
+		return Tiktoken{}, fmt.Errorf("failed to get tiktoken codec for model %s: %w", model, err)
+	}
+	return Tiktoken{codec: codec}, nil
+}
+
+// Encode converts a string into a slice of token IDs.
+func (t Tiktoken) Encode(text string) ([]int, error) {
+	ids, _, err := t.codec.Encode(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode string: %w", err)
+	}
+	res := make([]int, len(ids))
+	for i, id := range ids {
+		res[i] = int(id)
+	}
+	return res, nil
+}
+
+// Decode converts a slice of token IDs back into a string.
+func (t Tiktoken) Decode(ids []int) (string, error) {
+	uids := make([]uint, len(ids))
+	for i, id := range ids {
+		uids[i] = uint(id)
+	}
+	return t.codec.Decode(uids)
+}
+
+// NewTokenizerRegistry creates an empty TokenizerRegistry that caches downloaded files in cacheDir.
+func NewTokenizerRegistry(cacheDir string, offline bool) *TokenizerRegistry {
+	return &TokenizerRegistry{
+		CacheDir: cacheDir,
+		Offline:  offline,
+		client:   &http.Client{},
+		loaded:   make(map[string]Tokenizer),
+	}
+}
+
+// Load returns the tokenizer identified by name, loading it on first use.
+// Subsequent calls with the same name return the same instance without touching the disk
+// or the network.
+func (r *TokenizerRegistry) Load(name string) (Tokenizer, error) {
+	if name == "" {
+		name = DefaultTokenizerModel
+	}
+
+	r.mu.Lock()
+	tk, ok := r.loaded[name]
+	r.mu.Unlock()
+	if ok {
+		return tk, nil
+	}
+
+	tk, err := r.load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Another goroutine may have loaded the same tokenizer meanwhile, keep the first one.
+	if existing, ok := r.loaded[name]; ok {
+		return existing, nil
+	}
+	r.loaded[name] = tk
+
+	return tk, nil
+}
+
+// Register adds a tokenizer under the given name, replacing any previously loaded one.
+// This is useful for tokenizers constructed outside of the registry.
+func (r *TokenizerRegistry) Register(name string, tk Tokenizer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loaded[name] = tk
+}
+
+func (r *TokenizerRegistry) load(name string) (Tokenizer, error) {
+	// A local file or directory holding the tokenizer files takes precedence.
+	if info, err := os.Stat(name); err == nil && !info.IsDir() {
+		return NewBpeTokenizerFromFile(name)
+	}
+	if tk, ok, err := loadTokenizerDir(name); ok {
+		return tk, err
+	}
+
+	// Hugging Face model IDs are always namespaced, tiktoken model names never are.
+	if !strings.Contains(name, "/") {
+		return NewTiktoken(name)
+	}
+
+	dir, err := r.cachedModelDir(name)
+	if err != nil {
+		return nil, err
+	}
+	tk, _, err := loadTokenizerDir(dir)
+	return tk, err
+}
+
+// loadTokenizerDir loads the tokenizer stored in dir, preferring tokenizer.json over
+// vocab.json and merges.txt. It reports false if dir holds neither.
+func loadTokenizerDir(dir string) (Tokenizer, bool, error) {
+	if dirHasFiles(dir, hfTokenizerJSONFiles) {
+		tk, err := NewBpeTokenizerFromFile(filepath.Join(dir, "tokenizer.json"))
+		return tk, true, err
+	}
+	if dirHasFiles(dir, hfTokenizerBPEFiles) {
+		tk, err := NewBpeTokenizer(filepath.Join(dir, "vocab.json"), filepath.Join(dir, "merges.txt"))
+		return tk, true, err
+	}
+	return nil, false, nil
+}
+
+// cachedModelDir returns the cache directory of a Hugging Face model, downloading the
+// tokenizer files into it if they are missing and the registry is online. tokenizer.json is
+// fetched when the model has one, vocab.json and merges.txt otherwise.
+func (r *TokenizerRegistry) cachedModelDir(modelName string) (string, error) {
+	if r.CacheDir == "" {
+		return "", fmt.Errorf("no tokenizer cache directory configured for model %s", modelName)
+	}
+	dir := filepath.Join(r.CacheDir, filepath.FromSlash(modelName))
+	if dirHasFiles(dir, hfTokenizerJSONFiles) || dirHasFiles(dir, hfTokenizerBPEFiles) {
+		return dir, nil
+	}
+	if r.Offline {
+		return "", fmt.Errorf("%w: %s in %s", ErrTokenizerNotCached, modelName, r.CacheDir)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create tokenizer cache directory: %w", err)
+	}
+	err := r.downloadFiles(modelName, dir, hfTokenizerJSONFiles)
+	if errors.Is(err, errFileNotFound) {
+		err = r.downloadFiles(modelName, dir, hfTokenizerBPEFiles)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+func (r *TokenizerRegistry) downloadFiles(modelName, dir string, files []string) error {
+	for _, file := range files {
+		url := fmt.Sprintf("https://huggingface.co/%s/resolve/main/%s", modelName, file)
+		if err := r.downloadFile(url, filepath.Join(dir, file)); err != nil {
+			return fmt.Errorf("failed to download %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// downloadFile writes url into dst. The content is first written to a temporary file in the
+// destination directory and then renamed, so an interrupted download never leaves a partial
+// file that would be mistaken for a cached one.
+func (r *TokenizerRegistry) downloadFile(url, dst string) error {
+	resp, err := r.client.Get(url) //nolint:noctx // Downloads are bounded by the client configuration
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errFileNotFound, url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download file: %s", resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+// LoadTokenizer loads a tokenizer by name through DefaultTokenizerRegistry.
+// An empty name loads the default GPT-4o tiktoken tokenizer.
+func LoadTokenizer(name string) (Tokenizer, error) {
+	return DefaultTokenizerRegistry.Load(name)
+}
+
+// DefaultTokenizer returns the GPT-4o tiktoken tokenizer used when no tokenizer is configured.
+func DefaultTokenizer() (Tokenizer, error) {
+	tk, err := LoadTokenizer(DefaultTokenizerModel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load default tokenizer: %w", err)
+	}
+	return tk, nil
+}
+
+// CountTokens counts the tokens of text using tk, or the default tokenizer if tk is nil.
+func CountTokens(tk Tokenizer, text string) (int, error) {
+	if tk == nil {
+		var err error
+		if tk, err = DefaultTokenizer(); err != nil {
+			return 0, err
+		}
+	}
+	ids, err := tk.Encode(text)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode string: %w", err)
+	}
+	return len(ids), nil
+}
+
+func dirHasFiles(dir string, files []string) bool {
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(dir, file))
+		if err != nil || info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+func defaultTokenizerCacheDir() string {
+	if dir := os.Getenv("GOLIGHTRAG_TOKENIZER_CACHE"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "go-light-rag", "tokenizers")
+	}
+	return filepath.Join(dir, "go-light-rag", "tokenizers")
+}
+
+func envOffline() bool {
+	v := strings.ToLower(os.Getenv("HF_HUB_OFFLINE"))
+	return v == "1" || v == "true"
+}
@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTokenizerFiles(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("Failed to create tokenizer dir: %v", err)
+	}
+	vocab := `{"h": 0, "i": 1, "hi": 2, "!": 3}`
+	merges := "#version: 0.2\nh i\n"
+	if err := os.WriteFile(filepath.Join(dir, "vocab.json"), []byte(vocab), 0o600); err != nil {
+		t.Fatalf("Failed to write vocab: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "merges.txt"), []byte(merges), 0o600); err != nil {
+		t.Fatalf("Failed to write merges: %v", err)
+	}
+}
+
+func TestTokenizerRegistry_Load(t *testing.T) {
+	t.Run("Local directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestTokenizerFiles(t, dir)
+
+		r := NewTokenizerRegistry(t.TempDir(), true)
+		tk, err := r.Load(dir)
+		if err != nil {
+			t.Fatalf("Failed to load tokenizer: %v", err)
+		}
+
+		ids, err := tk.Encode("hi!")
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		if len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+			t.Errorf("Expected [2 3], got %v", ids)
+		}
+	})
+
+	t.Run("Offline cache hit", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		writeTestTokenizerFiles(t, filepath.Join(cacheDir, "org", "model"))
+
+		r := NewTokenizerRegistry(cacheDir, true)
+		first, err := r.Load("org/model")
+		if err != nil {
+			t.Fatalf("Failed to load tokenizer: %v", err)
+		}
+		second, err := r.Load("org/model")
+		if err != nil {
+			t.Fatalf("Failed to load tokenizer again: %v", err)
+		}
+		if first != second {
+			t.Error("Expected the cached tokenizer instance to be reused")
+		}
+	})
+
+	t.Run("Offline cache miss", func(t *testing.T) {
+		r := NewTokenizerRegistry(t.TempDir(), true)
+		_, err := r.Load("org/missing")
+		if !errors.Is(err, ErrTokenizerNotCached) {
+			t.Errorf("Expected ErrTokenizerNotCached, got %v", err)
+		}
+	})
+
+	t.Run("Tiktoken model", func(t *testing.T) {
+		r := NewTokenizerRegistry(t.TempDir(), true)
+		tk, err := r.Load("")
+		if err != nil {
+			t.Fatalf("Failed to load default tokenizer: %v", err)
+		}
+		if _, ok := tk.(Decoder); !ok {
+			t.Error("Expected the default tokenizer to implement Decoder")
+		}
+
+		text := "Hello world!"
+		ids, err := tk.Encode(text)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		decoded, err := tk.(Decoder).Decode(ids)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		if decoded != text {
+			t.Errorf("Expected %q, got %q", text, decoded)
+		}
+	})
+
+	t.Run("Registered tokenizer", func(t *testing.T) {
+		r := NewTokenizerRegistry(t.TempDir(), true)
+		custom := Tiktoken{}
+		r.Register("custom", custom)
+		tk, err := r.Load("custom")
+		if err != nil {
+			t.Fatalf("Failed to load registered tokenizer: %v", err)
+		}
+		if tk != custom {
+			t.Error("Expected the registered tokenizer to be returned")
+		}
+	})
+}
+
+func TestCountTokens(t *testing.T) {
+	count, err := CountTokens(nil, "Hello world!")
+	if err != nil {
+		t.Fatalf("Failed to count tokens: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 tokens, got %d", count)
+	}
+}
@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"sort"
+	"strings"
+)
+
+// Usage is the number of tokens a provider billed for a single call.
+type Usage struct {
+	// Model is the model that served the call, as reported by the provider.
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// UsageReporter is implemented by clients that report the token usage of their calls.
+// All providers and middlewares in this package implement it.
+type UsageReporter interface {
+	ChatWithUsage(messages []string) (string, Usage, error)
+}
+
+// ModelPrice is the price of a model in US dollars per million tokens.
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// PriceTable maps model names to their prices.
+type PriceTable map[string]ModelPrice
+
+// DefaultPrices holds the list prices of common models. Prices change over time, so pass
+// your own PriceTable where accuracy matters.
+var DefaultPrices = PriceTable{
+	"gpt-4o":            {Prompt: 2.50, Completion: 10.00},
+	"gpt-4o-mini":       {Prompt: 0.15, Completion: 0.60},
+	"gpt-4.1":           {Prompt: 2.00, Completion: 8.00},
+	"gpt-4.1-mini":      {Prompt: 0.40, Completion: 1.60},
+	"gpt-4.1-nano":      {Prompt: 0.10, Completion: 0.40},
+	"o3-mini":           {Prompt: 1.10, Completion: 4.40},
+	"o4-mini":           {Prompt: 1.10, Completion: 4.40},
+	"claude-3-5-haiku":  {Prompt: 0.80, Completion: 4.00},
+	"claude-3-5-sonnet": {Prompt: 3.00, Completion: 15.00},
+	"claude-3-7-sonnet": {Prompt: 3.00, Completion: 15.00},
+	"claude-sonnet-4":   {Prompt: 3.00, Completion: 15.00},
+	"claude-3-opus":     {Prompt: 15.00, Completion: 75.00},
+	"claude-opus-4":     {Prompt: 15.00, Completion: 75.00},
+}
+
+// TotalTokens returns the sum of prompt and completion tokens.
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Price returns the price of model. Dated model versions such as "gpt-4o-2024-08-06" and
+// provider-prefixed names such as "openai/gpt-4o" match the longest known model name they
+// start with. It reports false if the model is unknown.
+func (p PriceTable) Price(model string) (ModelPrice, bool) {
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	// Longest names first, so "gpt-4o-mini-2024-07-18" matches "gpt-4o-mini" rather than "gpt-4o".
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		if strings.HasPrefix(model, name+"-") {
+			return p[name], true
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// Cost returns the cost of usage in US dollars. It reports false if the model is unknown.
+func (p PriceTable) Cost(usage Usage) (float64, bool) {
+	price, ok := p.Price(usage.Model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6, true
+}
+
+// ChatWithUsage calls c and returns the usage it reports. Clients that don't implement
+// UsageReporter return a zero Usage.
+func ChatWithUsage(c Chatter, messages []string) (string, Usage, error) {
+	if r, ok := c.(UsageReporter); ok {
+		return r.ChatWithUsage(messages)
+	}
+	res, err := c.Chat(messages)
+	return res, Usage{}, err
+}
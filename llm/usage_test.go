@@ -0,0 +1,66 @@
+package llm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPriceTable_Cost(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		cost  float64
+		known bool
+	}{
+		{name: "Exact name", model: "gpt-4o", cost: 2.50 + 10.00, known: true},
+		{name: "Dated version", model: "gpt-4o-mini-2024-07-18", cost: 0.15 + 0.60, known: true},
+		{name: "Provider prefix", model: "anthropic/claude-3-5-sonnet-20241022", cost: 3.00 + 15.00, known: true},
+		{name: "Unknown model", model: "llama3", known: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, ok := DefaultPrices.Cost(Usage{Model: tt.model, PromptTokens: 1e6, CompletionTokens: 1e6})
+			if ok != tt.known {
+				t.Fatalf("Expected known %v, got %v", tt.known, ok)
+			}
+			if math.Abs(cost-tt.cost) > 1e-9 {
+				t.Errorf("Expected cost %f, got %f", tt.cost, cost)
+			}
+		})
+	}
+}
+
+type usageChatter struct {
+	usage Usage
+}
+
+func (u usageChatter) Chat([]string) (string, error) { return "ok", nil }
+
+func (u usageChatter) ChatWithUsage([]string) (string, Usage, error) { return "ok", u.usage, nil }
+
+func TestChatWithUsage_Middlewares(t *testing.T) {
+	usage := Usage{Model: "gpt-4o", PromptTokens: 12, CompletionTokens: 3}
+	c := Chain(usageChatter{usage: usage},
+		WithRetry(RetryConfig{}),
+		WithCircuitBreaker(CircuitBreakerConfig{}),
+		WithRateLimit(RateLimitConfig{RequestsPerMinute: 100}),
+		WithConcurrencyLimit(1),
+	)
+
+	_, got, err := ChatWithUsage(c, []string{"hi"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != usage {
+		t.Errorf("Expected usage %+v to be forwarded, got %+v", usage, got)
+	}
+
+	_, got, err = ChatWithUsage(chatterFunc(func([]string) (string, error) { return "ok", nil }), []string{"hi"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != (Usage{}) {
+		t.Errorf("Expected zero usage for a client without usage reporting, got %+v", got)
+	}
+}
@@ -0,0 +1,106 @@
+package golightrag
+
+import (
+	"maps"
+	"slices"
+)
+
+// maxLouvainPasses bounds the passes over the nodes of a level, in case rounding errors make
+// nodes move back and forth.
+const maxLouvainPasses = 100
+
+// louvain detects the communities of the graph with the Louvain method, returning the
+// community of every node at each level of the hierarchy, from the finest to the coarsest.
+// Each level merges the communities of the previous one, and the detection stops when a level
+// merges none. Nodes are visited in index order, so the result is deterministic.
+func louvain(g *weightedGraph) [][]int {
+	levels := make([][]int, 0)
+	// membership is the community of every original node in the current graph.
+	membership := make([]int, len(g.adj))
+	for i := range membership {
+		membership[i] = i
+	}
+
+	for g.total > 0 {
+		communities, count := g.moveNodes()
+		if count == len(g.adj) {
+			break
+		}
+		for i, node := range membership {
+			membership[i] = communities[node]
+		}
+		levels = append(levels, append([]int(nil), membership...))
+		g = g.aggregate(communities, count)
+	}
+
+	return levels
+}
+
+// moveNodes moves every node to the neighboring community with the greatest modularity gain
+// until no move improves the modularity, and returns the communities numbered from zero in
+// order of first appearance, along with their count.
+func (g *weightedGraph) moveNodes() ([]int, int) {
+	n := len(g.adj)
+	community := make([]int, n)
+	// tot is the sum of the degrees of the nodes of each community.
+	tot := make([]float64, n)
+	for i := range community {
+		community[i] = i
+		tot[i] = g.degree[i]
+	}
+
+	moved := true
+	for pass := 0; moved && pass < maxLouvainPasses; pass++ {
+		moved = false
+		for i := range n {
+			current := community[i]
+			tot[current] -= g.degree[i]
+
+			// Weights of the edges from i to each neighboring community.
+			links := make(map[int]float64)
+			for _, j := range slices.Sorted(maps.Keys(g.adj[i])) {
+				if j != i {
+					links[community[j]] += g.adj[i][j]
+				}
+			}
+
+			// Ties keep the node in its community, or move it to the lowest numbered one.
+			best := current
+			bestGain := links[current] - tot[current]*g.degree[i]/g.total
+			for _, c := range slices.Sorted(maps.Keys(links)) {
+				gain := links[c] - tot[c]*g.degree[i]/g.total
+				if gain > bestGain {
+					best, bestGain = c, gain
+				}
+			}
+
+			community[i] = best
+			tot[best] += g.degree[i]
+			if best != current {
+				moved = true
+			}
+		}
+	}
+
+	renumbered := make(map[int]int)
+	for i, c := range community {
+		if _, ok := renumbered[c]; !ok {
+			renumbered[c] = len(renumbered)
+		}
+		community[i] = renumbered[c]
+	}
+	return community, len(renumbered)
+}
+
+// aggregate returns the graph whose nodes are the communities of g.
+func (g *weightedGraph) aggregate(community []int, count int) *weightedGraph {
+	aggregated := newWeightedGraph(count)
+	for i, edges := range g.adj {
+		for j, weight := range edges {
+			aggregated.adj[community[i]][community[j]] += weight
+		}
+		aggregated.degree[community[i]] += g.degree[i]
+	}
+	aggregated.total = g.total
+	return aggregated
+}
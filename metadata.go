@@ -0,0 +1,260 @@
+package golightrag
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataFilter selects the sources whose document metadata has, for every key of the filter,
+// the value of the filter. An empty filter selects every source.
+type MetadataFilter map[string]string
+
+// VectorFilterStorage is implemented by vector storages that can store the metadata of the
+// entities and relationships, and restrict their semantic search to the ones matching a
+// MetadataFilter. Query falls back to the unfiltered search for storages without it, pruning
+// the results afterwards.
+type VectorFilterStorage interface {
+	// VectorUpsertEntityWithMetadata is VectorUpsertEntity storing the metadata of the entity.
+	VectorUpsertEntityWithMetadata(name, content string, metadata map[string][]string) error
+	// VectorUpsertRelationshipWithMetadata is VectorUpsertRelationship storing the metadata of
+	// the relationship.
+	VectorUpsertRelationshipWithMetadata(source, target, content string, metadata map[string][]string) error
+	// VectorQueryEntityWithFilter is VectorQueryEntity among the entities having, for every key
+	// of the filter, its value among their metadata.
+	VectorQueryEntityWithFilter(keywords string, filter MetadataFilter) ([]string, error)
+	// VectorQueryRelationshipWithFilter is VectorQueryRelationship among the relationships
+	// having, for every key of the filter, its value among their metadata.
+	VectorQueryRelationshipWithFilter(keywords string, filter MetadataFilter) ([][2]string, error)
+}
+
+// WithMetadataFilter restricts the Query context to the sources matching f, and to the entities
+// and relationships extracted from them: the vector search is filtered by storages implementing
+// VectorFilterStorage, the graph expansion leaves out the elements without a matching source,
+// and the source IDs, sources and claims of the result are pruned to the matching sources.
+func WithMetadataFilter(f MetadataFilter) Option {
+	return option("WithMetadataFilter", callQuery, func(o *options) {
+		o.metadataFilter = f
+	})
+}
+
+// matches reports whether metadata has, for every key of the filter, its value.
+func (f MetadataFilter) matches(metadata map[string]string) bool {
+	for key, value := range f {
+		if v, ok := metadata[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeMetadata returns the union of existing and metadata, with the values of each key sorted.
+func mergeMetadata(existing, metadata map[string][]string) map[string][]string {
+	if len(existing) == 0 && len(metadata) == 0 {
+		return nil
+	}
+	result := make(map[string][]string, len(existing)+len(metadata))
+	for key, values := range existing {
+		result[key] = slices.Clone(values)
+	}
+	for key, values := range metadata {
+		for _, value := range values {
+			if !slices.Contains(result[key], value) {
+				result[key] = append(result[key], value)
+			}
+		}
+		slices.Sort(result[key])
+	}
+	return result
+}
+
+// upsertEntityVector upserts the entity in the vector storage, with its metadata if the storage
+// implements VectorFilterStorage.
+func upsertEntityVector(storage Storage, entity GraphEntity) error {
+	content := entity.Name + entity.Descriptions
+	if filterStorage, ok := storage.(VectorFilterStorage); ok {
+		return filterStorage.VectorUpsertEntityWithMetadata(entity.Name, content, entity.Metadata)
+	}
+	return storage.VectorUpsertEntity(entity.Name, content)
+}
+
+// upsertRelationshipVector is upsertEntityVector for relationships.
+func upsertRelationshipVector(storage Storage, relationship GraphRelationship, content string) error {
+	if filterStorage, ok := storage.(VectorFilterStorage); ok {
+		return filterStorage.VectorUpsertRelationshipWithMetadata(relationship.SourceEntity,
+			relationship.TargetEntity, content, relationship.Metadata)
+	}
+	return storage.VectorUpsertRelationship(relationship.SourceEntity, relationship.TargetEntity, content)
+}
+
+// withStoredDocuments returns the sources with the document, origin and metadata stored with
+// them filled in, for sources given without them. Sources missing from storage are kept as is.
+func withStoredDocuments(sources []Source, storage Storage) ([]Source, error) {
+	result := slices.Clone(sources)
+	for i, source := range result {
+		if source.DocumentID != "" || source.Origin != (Origin{}) || len(source.Metadata) > 0 {
+			continue
+		}
+		stored, err := storage.KVSource(source.ID)
+		if err != nil {
+			if errors.Is(err, ErrSourceNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get source with id %s: %w", source.ID, err)
+		}
+		result[i].DocumentID = stored.DocumentID
+		result[i].Origin = stored.Origin
+		result[i].Metadata = stored.Metadata
+	}
+	return result, nil
+}
+
+// queryScope restricts the graph retrieved by Query to the versions valid at asOf and the
+// sources matching filter.
+type queryScope struct {
+	asOf   time.Time
+	filter *sourceFilter
+}
+
+func newQueryScope(o options, storage Storage) queryScope {
+	scope := queryScope{asOf: o.asOf}
+	if len(o.metadataFilter) > 0 {
+		scope.filter = &sourceFilter{
+			filter:  o.metadataFilter,
+			storage: storage,
+			matches: make(map[string]bool),
+		}
+	}
+	return scope
+}
+
+// vectorQueryEntity performs the vector search of entities, filtered by the storage if it
+// implements VectorFilterStorage.
+func (s queryScope) vectorQueryEntity(keywords string, storage Storage) ([]string, error) {
+	if filterStorage, ok := storage.(VectorFilterStorage); ok && s.filter != nil {
+		return filterStorage.VectorQueryEntityWithFilter(keywords, s.filter.filter)
+	}
+	return storage.VectorQueryEntity(keywords)
+}
+
+// vectorQueryRelationship is vectorQueryEntity for relationships.
+func (s queryScope) vectorQueryRelationship(keywords string, storage Storage) ([][2]string, error) {
+	if filterStorage, ok := storage.(VectorFilterStorage); ok && s.filter != nil {
+		return filterStorage.VectorQueryRelationshipWithFilter(keywords, s.filter.filter)
+	}
+	return storage.VectorQueryRelationship(keywords)
+}
+
+// entities returns the entities with a matching source, with their source IDs pruned to the
+// matching ones.
+func (s queryScope) entities(entities map[string]GraphEntity) (map[string]GraphEntity, error) {
+	if s.filter == nil {
+		return entities, nil
+	}
+	result := make(map[string]GraphEntity, len(entities))
+	for key, entity := range entities {
+		sourceIDs, err := s.filter.sourceIDs(entity.SourceIDs)
+		if err != nil {
+			return nil, err
+		}
+		if sourceIDs == "" {
+			continue
+		}
+		entity.SourceIDs = sourceIDs
+		result[key] = entity
+	}
+	return result, nil
+}
+
+// relationships returns the versions of the relationships valid at asOf with a matching source,
+// with their source IDs pruned to the matching ones.
+func (s queryScope) relationships(
+	relationships map[string]GraphRelationship,
+) (map[string]GraphRelationship, error) {
+	relationships = relationshipsAsOf(relationships, s.asOf)
+	if s.filter == nil {
+		return relationships, nil
+	}
+	result := make(map[string]GraphRelationship, len(relationships))
+	for key, relationship := range relationships {
+		sourceIDs, err := s.filter.sourceIDs(relationship.SourceIDs)
+		if err != nil {
+			return nil, err
+		}
+		if sourceIDs == "" {
+			continue
+		}
+		relationship.SourceIDs = sourceIDs
+		result[key] = relationship
+	}
+	return result, nil
+}
+
+// claims returns the claims extracted from a matching source.
+func (s queryScope) claims(claims []Claim) ([]Claim, error) {
+	if s.filter == nil {
+		return claims, nil
+	}
+	result := make([]Claim, 0, len(claims))
+	for _, claim := range claims {
+		ok, err := s.filter.match(claim.SourceID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, claim)
+		}
+	}
+	return result, nil
+}
+
+// sourceFilter matches source IDs against a MetadataFilter, caching the metadata lookups of the
+// concurrent local and global retrievals.
+type sourceFilter struct {
+	filter  MetadataFilter
+	storage Storage
+
+	mu      sync.Mutex
+	matches map[string]bool
+}
+
+// match reports whether the source with the id matches the filter. Sources missing from storage
+// don't.
+func (f *sourceFilter) match(id string) (bool, error) {
+	f.mu.Lock()
+	matched, ok := f.matches[id]
+	f.mu.Unlock()
+	if ok {
+		return matched, nil
+	}
+
+	source, err := f.storage.KVSource(id)
+	if err != nil && !errors.Is(err, ErrSourceNotFound) {
+		return false, fmt.Errorf("failed to get source with id %s: %w", id, err)
+	}
+	matched = err == nil && f.filter.matches(source.Metadata)
+
+	f.mu.Lock()
+	f.matches[id] = matched
+	f.mu.Unlock()
+	return matched, nil
+}
+
+// sourceIDs returns the matching source IDs of the ones joined by GraphFieldSeparator in
+// sourceIDs, joined the same way.
+func (f *sourceFilter) sourceIDs(sourceIDs string) (string, error) {
+	matching := make([]string, 0)
+	for _, id := range splitSourceIDs(sourceIDs) {
+		ok, err := f.match(id)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			matching = append(matching, id)
+		}
+	}
+	return strings.Join(matching, GraphFieldSeparator), nil
+}
@@ -0,0 +1,321 @@
+package golightrag
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameCase determines how the case of extracted entity names is normalized, so mentions of the
+// same entity with a different case are merged.
+type NameCase string
+
+// Defines the name case normalizations of an ExtractionPolicy.
+const (
+	// NameCaseUpper uppercases names. It's the default of the zero ExtractionPolicy.
+	NameCaseUpper NameCase = "upper"
+	// NameCasePreserve keeps names as extracted, for case-sensitive names such as identifiers
+	// of source code.
+	NameCasePreserve NameCase = "preserve"
+	// NameCaseFold applies Unicode case folding, which merges case variants of scripts that
+	// uppercasing doesn't, such as the German ß and ss.
+	NameCaseFold NameCase = "fold"
+)
+
+// UnknownEntityType is the type given to entities whose extracted type isn't one of the
+// expected EntityTypes, and to the placeholder entities of relationships.
+const UnknownEntityType = "UNKNOWN"
+
+// ExtractionPolicy configures how the entities and relationships extracted from a chunk are
+// normalized and validated before they are merged into the graph.
+// The zero value uppercases names and accepts every entity and relationship with a non-empty
+// name.
+type ExtractionPolicy struct {
+	// NameCase is the case normalization of entity names. Defaults to NameCaseUpper.
+	NameCase NameCase
+	// CanonicalizeNames normalizes names to Unicode NFC, collapses whitespace runs to a single
+	// space and trims surrounding quotes and trailing sentence punctuation.
+	CanonicalizeNames bool
+	// KeepUnknownTypes keeps entity types that aren't one of the expected EntityTypes,
+	// uppercased, instead of replacing them with UnknownEntityType.
+	KeepUnknownTypes bool
+	// DropDanglingRelationships drops relationships whose source or target entity wasn't
+	// extracted from the same chunk, instead of creating placeholder entities for them.
+	DropDanglingRelationships bool
+	// DropSelfLoops drops relationships whose source and target are the same entity.
+	DropSelfLoops bool
+	// MinDescriptionLength drops entities and relationships whose description is shorter than
+	// this number of characters. Zero disables the check.
+	MinDescriptionLength int
+	// MaxDescriptionLength truncates descriptions longer than this number of characters.
+	// Zero disables the check.
+	MaxDescriptionLength int
+}
+
+// ExtractionPolicyProvider is implemented by document handlers that configure the
+// ExtractionPolicy of their extractions. Handlers that don't implement it use the zero
+// ExtractionPolicy.
+type ExtractionPolicyProvider interface {
+	ExtractionPolicy() ExtractionPolicy
+}
+
+// ExtractionRule identifies a normalization or validation rule of an ExtractionPolicy.
+type ExtractionRule string
+
+// Defines the rules counted by ExtractionStats.
+const (
+	// RuleEmptyName drops entities and relationships with an empty name. It's always applied.
+	RuleEmptyName ExtractionRule = "empty_name"
+	// RuleCanonicalizedName counts the names changed by CanonicalizeNames.
+	RuleCanonicalizedName ExtractionRule = "canonicalized_name"
+	// RuleUnknownType counts the entities whose type isn't one of the expected EntityTypes.
+	RuleUnknownType ExtractionRule = "unknown_type"
+	// RuleDanglingRelationship counts the relationships dropped by DropDanglingRelationships.
+	RuleDanglingRelationship ExtractionRule = "dangling_relationship"
+	// RuleSelfLoop counts the relationships dropped by DropSelfLoops.
+	RuleSelfLoop ExtractionRule = "self_loop"
+	// RuleShortDescription counts the entities and relationships dropped by
+	// MinDescriptionLength.
+	RuleShortDescription ExtractionRule = "short_description"
+	// RuleLongDescription counts the descriptions truncated by MaxDescriptionLength.
+	RuleLongDescription ExtractionRule = "long_description"
+	// RuleUnknownAttribute counts the attributes dropped because the Ontology doesn't declare
+	// them for the entity type.
+	RuleUnknownAttribute ExtractionRule = "unknown_attribute"
+	// RuleInvalidAttribute counts the attributes dropped because their value can't be
+	// converted to the type declared by the Ontology.
+	RuleInvalidAttribute ExtractionRule = "invalid_attribute"
+	// RuleUnknownPredicate counts the relationship predicates dropped because the Ontology
+	// doesn't declare them.
+	RuleUnknownPredicate ExtractionRule = "unknown_predicate"
+)
+
+// ExtractionReport is a snapshot of the extractions recorded by ExtractionStats.
+type ExtractionReport struct {
+	// Entities and Relationships are the numbers accepted by the ExtractionPolicy.
+	Entities      int
+	Relationships int
+	// Rules is the number of times each rule was applied.
+	Rules map[ExtractionRule]int
+}
+
+// ExtractionStats aggregates the result of applying the ExtractionPolicy to every extracted
+// chunk. The zero value is ready to use, and it's safe for concurrent use.
+type ExtractionStats struct {
+	mu     sync.Mutex
+	report ExtractionReport
+}
+
+// Report returns the extractions recorded so far.
+func (s *ExtractionStats) Report() ExtractionReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := s.report
+	report.Rules = maps.Clone(s.report.Rules)
+	return report
+}
+
+func (s *ExtractionStats) add(report ExtractionReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.Entities += report.Entities
+	s.report.Relationships += report.Relationships
+	for rule, count := range report.Rules {
+		if s.report.Rules == nil {
+			s.report.Rules = make(map[ExtractionRule]int)
+		}
+		s.report.Rules[rule] += count
+	}
+}
+
+// normalizeName applies the name rules of the policy to name, and reports whether the
+// canonicalization changed it.
+func (p ExtractionPolicy) normalizeName(name string) (string, bool) {
+	canonicalized := false
+	if p.CanonicalizeNames {
+		canonical := norm.NFC.String(name)
+		canonical = strings.Join(strings.Fields(canonical), " ")
+		canonical = strings.TrimFunc(canonical, func(r rune) bool {
+			return unicode.Is(unicode.Quotation_Mark, r)
+		})
+		canonical = strings.TrimRight(canonical, ".,;:!?")
+		canonical = strings.TrimSpace(canonical)
+		canonicalized = canonical != strings.TrimSpace(name)
+		name = canonical
+	} else {
+		name = strings.TrimSpace(name)
+	}
+
+	switch p.NameCase {
+	case NameCasePreserve:
+	case NameCaseFold:
+		name = cases.Fold().String(name)
+	default:
+		name = strings.ToUpper(name)
+	}
+	return name, canonicalized
+}
+
+// description applies the description length rules of the policy, and reports whether the
+// description is too short to be kept.
+func (p ExtractionPolicy) description(description string, rules map[ExtractionRule]int) (string, bool) {
+	length := utf8.RuneCountInString(strings.TrimSpace(description))
+	if p.MinDescriptionLength > 0 && length < p.MinDescriptionLength {
+		rules[RuleShortDescription]++
+		return "", false
+	}
+	if p.MaxDescriptionLength > 0 && length > p.MaxDescriptionLength {
+		rules[RuleLongDescription]++
+		description = string([]rune(strings.TrimSpace(description))[:p.MaxDescriptionLength])
+	}
+	return description, true
+}
+
+// ontologyAttributes returns the properties declared for entityType by the ontology, converted
+// to their declared type. Properties are only kept when the ontology declares entity types.
+func ontologyAttributes(
+	entityType string,
+	properties map[string]any,
+	ontology Ontology,
+	rules map[ExtractionRule]int,
+) map[string]any {
+	if len(ontology.EntityTypes) == 0 {
+		return nil
+	}
+	schema, _ := ontology.entityType(entityType)
+
+	var attributes map[string]any
+	for name, value := range properties {
+		// The attributes the text doesn't state are null.
+		if value == nil {
+			continue
+		}
+		i := slices.IndexFunc(schema.Attributes, func(attribute AttributeSchema) bool {
+			return attribute.Name == name
+		})
+		if i < 0 {
+			rules[RuleUnknownAttribute]++
+			continue
+		}
+		coerced, ok := coerceAttribute(value, schema.Attributes[i].Type)
+		if !ok {
+			rules[RuleInvalidAttribute]++
+			continue
+		}
+		if attributes == nil {
+			attributes = make(map[string]any)
+		}
+		attributes[name] = coerced
+	}
+	return attributes
+}
+
+// ontologyPredicate returns the properties of a relationship holding its predicate, if the
+// ontology declares it.
+func ontologyPredicate(properties map[string]any, ontology Ontology, rules map[ExtractionRule]int) map[string]any {
+	extracted, ok := properties[PredicateProperty].(string)
+	if len(ontology.Predicates) == 0 || !ok {
+		return nil
+	}
+	predicate, ok := ontology.predicate(extracted)
+	if !ok {
+		rules[RuleUnknownPredicate]++
+		return nil
+	}
+	return map[string]any{PredicateProperty: predicate}
+}
+
+// applyExtractionPolicy normalizes and validates the extracted entities and relationships,
+// grouping the accepted entities by name and relationships by their source-target pair.
+func applyExtractionPolicy(
+	result extractionResult,
+	entityTypes []string,
+	ontology Ontology,
+	policy ExtractionPolicy,
+) (map[string][]GraphEntity, map[string][]GraphRelationship, ExtractionReport) {
+	ents := make(map[string][]GraphEntity)
+	rels := make(map[string][]GraphRelationship)
+	report := ExtractionReport{Rules: make(map[ExtractionRule]int)}
+
+	// Convert entity types to uppercase for case-insensitive matching
+	expectedEntityTypes := make([]string, 0, len(entityTypes)+1)
+	for _, et := range entityTypes {
+		expectedEntityTypes = append(expectedEntityTypes, strings.ToUpper(et))
+	}
+	expectedEntityTypes = append(expectedEntityTypes, UnknownEntityType)
+
+	name := func(name string) string {
+		normalized, canonicalized := policy.normalizeName(name)
+		if canonicalized {
+			report.Rules[RuleCanonicalizedName]++
+		}
+		return normalized
+	}
+
+	// Process and group entities by name
+	for _, entity := range result.Entities {
+		entity.Name = name(entity.Name)
+		if entity.Name == "" {
+			report.Rules[RuleEmptyName]++
+			continue
+		}
+		var ok bool
+		if entity.Descriptions, ok = policy.description(entity.Descriptions, report.Rules); !ok {
+			continue
+		}
+
+		entity.Type = strings.ToUpper(strings.TrimSpace(entity.Type))
+		if !slices.Contains(expectedEntityTypes, entity.Type) {
+			report.Rules[RuleUnknownType]++
+			if !policy.KeepUnknownTypes || entity.Type == "" {
+				entity.Type = UnknownEntityType
+			}
+		}
+		entity.Properties = ontologyAttributes(entity.Type, entity.Properties, ontology, report.Rules)
+
+		ents[entity.Name] = append(ents[entity.Name], entity)
+		report.Entities++
+	}
+
+	// Process and group relationships by composite key: sourceEntity-targetEntity
+	for _, relationship := range result.Relationships {
+		relationship.SourceEntity = name(relationship.SourceEntity)
+		relationship.TargetEntity = name(relationship.TargetEntity)
+		if relationship.SourceEntity == "" || relationship.TargetEntity == "" {
+			report.Rules[RuleEmptyName]++
+			continue
+		}
+		if policy.DropSelfLoops && relationship.SourceEntity == relationship.TargetEntity {
+			report.Rules[RuleSelfLoop]++
+			continue
+		}
+		if policy.DropDanglingRelationships {
+			_, sourceFound := ents[relationship.SourceEntity]
+			_, targetFound := ents[relationship.TargetEntity]
+			if !sourceFound || !targetFound {
+				report.Rules[RuleDanglingRelationship]++
+				continue
+			}
+		}
+		var ok bool
+		if relationship.Descriptions, ok = policy.description(relationship.Descriptions, report.Rules); !ok {
+			continue
+		}
+		relationship.Properties = ontologyPredicate(relationship.Properties, ontology, report.Rules)
+
+		relationKey := fmt.Sprintf("%s-%s", relationship.SourceEntity, relationship.TargetEntity)
+		rels[relationKey] = append(rels[relationKey], relationship)
+		report.Relationships++
+	}
+
+	return ents, rels, report
+}
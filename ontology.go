@@ -0,0 +1,139 @@
+package golightrag
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Ontology declares the entity types a handler extracts, the attributes of each type and the
+// predicates allowed on relationships. When a handler declares one, the extraction prompts ask
+// for the attributes and predicates, and the extracted values are validated against it.
+type Ontology struct {
+	EntityTypes []EntityTypeSchema
+	// Predicates are the allowed relationship predicates, such as WORKS_AT. The predicate of an
+	// extracted relationship is stored in its Properties under PredicateProperty.
+	Predicates []string
+}
+
+// EntityTypeSchema declares an entity type of an Ontology and its attributes.
+type EntityTypeSchema struct {
+	Name        string
+	Description string
+	Attributes  []AttributeSchema
+}
+
+// AttributeSchema declares an attribute of an entity type.
+type AttributeSchema struct {
+	Name        string
+	Type        AttributeType
+	Description string
+}
+
+// AttributeType is the type of the value of an attribute.
+type AttributeType string
+
+// Defines the attribute types, stored in the property maps as string, float64 and bool values.
+const (
+	AttributeTypeString  AttributeType = "string"
+	AttributeTypeNumber  AttributeType = "number"
+	AttributeTypeBoolean AttributeType = "boolean"
+)
+
+// PredicateProperty is the key of the predicate in the Properties of a GraphRelationship.
+const PredicateProperty = "predicate"
+
+// typeNames returns the names of the entity types of the ontology.
+func (o Ontology) typeNames() []string {
+	names := make([]string, len(o.EntityTypes))
+	for i, entityType := range o.EntityTypes {
+		names[i] = entityType.Name
+	}
+	return names
+}
+
+// entityType returns the schema of the entity type named name, compared case-insensitively.
+func (o Ontology) entityType(name string) (EntityTypeSchema, bool) {
+	for _, entityType := range o.EntityTypes {
+		if strings.EqualFold(entityType.Name, name) {
+			return entityType, true
+		}
+	}
+	return EntityTypeSchema{}, false
+}
+
+// attributeTypes returns the types of every attribute of the ontology, by attribute name. An
+// attribute declared with different types by several entity types has all of them.
+func (o Ontology) attributeTypes() map[string][]AttributeType {
+	types := make(map[string][]AttributeType)
+	for _, entityType := range o.EntityTypes {
+		for _, attribute := range entityType.Attributes {
+			if !slices.Contains(types[attribute.Name], attribute.Type) {
+				types[attribute.Name] = append(types[attribute.Name], attribute.Type)
+			}
+		}
+	}
+	return types
+}
+
+// predicate returns the declared predicate matching predicate case-insensitively.
+func (o Ontology) predicate(predicate string) (string, bool) {
+	predicate = strings.TrimSpace(predicate)
+	for _, declared := range o.Predicates {
+		if strings.EqualFold(declared, predicate) {
+			return declared, true
+		}
+	}
+	return "", false
+}
+
+// coerceAttribute converts value to the attribute type, accepting the string forms of numbers
+// and booleans LLMs often return.
+func coerceAttribute(value any, typ AttributeType) (any, bool) {
+	switch typ {
+	case AttributeTypeNumber:
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return f, err == nil
+		}
+	case AttributeTypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			return b, err == nil
+		}
+	case AttributeTypeString:
+		switch v := value.(type) {
+		case string:
+			return v, strings.TrimSpace(v) != ""
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	}
+	return nil, false
+}
+
+// mergeProperties merges the properties of an extraction into the existing ones, attribute by
+// attribute: the extracted value of an attribute replaces the existing one, and the existing
+// attributes that weren't extracted again are kept.
+func mergeProperties(existing map[string]any, extracted ...map[string]any) map[string]any {
+	var merged map[string]any
+	for _, properties := range append([]map[string]any{existing}, extracted...) {
+		if len(properties) == 0 {
+			continue
+		}
+		if merged == nil {
+			merged = make(map[string]any, len(properties))
+		}
+		maps.Copy(merged, properties)
+	}
+	return merged
+}
@@ -0,0 +1,232 @@
+package golightrag
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Option configures a single call of a function of the package. Each option documents the
+// functions it applies to, and passing it to any other function returns ErrInvalidOption.
+// WithWorkspace applies to all of them.
+type Option func(*options)
+
+// call identifies the functions taking options, so options can reject the functions they don't
+// apply to.
+type call uint
+
+const (
+	callInsert call = 1 << iota
+	callInsertBatch
+	callProcessUnprocessedChunk
+	callInsertChunk
+	callInsertChunks
+	callQuery
+	callUpdateCommunities
+	callQueryCommunities
+
+	// callsExtracting are the functions extracting entities and relationships from chunks.
+	callsExtracting = callInsert | callInsertBatch | callProcessUnprocessedChunk
+	// callsWithLLM are the functions calling an LLM.
+	callsWithLLM = callsExtracting | callQuery | callUpdateCommunities | callQueryCommunities
+	callsAll     = callsWithLLM | callInsertChunk | callInsertChunks
+)
+
+var callNames = map[call]string{
+	callInsert:                  "Insert",
+	callInsertBatch:             "InsertBatch",
+	callProcessUnprocessedChunk: "ProcessUnprocessedChunk",
+	callInsertChunk:             "InsertChunk",
+	callInsertChunks:            "InsertChunks",
+	callQuery:                   "Query",
+	callUpdateCommunities:       "UpdateCommunities",
+	callQueryCommunities:        "QueryCommunities",
+}
+
+func (c call) String() string {
+	return callNames[c]
+}
+
+type options struct {
+	// call is the function the options are passed to, and invalid the names of the options
+	// that don't apply to it.
+	call    call
+	invalid []string
+
+	usage           *UsageCollector
+	routing         Routing
+	extractionStats *ExtractionStats
+	resolution      *EntityResolution
+	claims          *ClaimExtraction
+	communities     *CommunityConfig
+	centrality      *CentralityConfig
+	ranking         *Ranking
+	asOf            time.Time
+	changeLog       bool
+	metadataFilter  MetadataFilter
+	workspace       string
+	progress        func(BatchProgress)
+	hooks           Hooks
+
+	// scope is the merge scope of the functions merging entities, set before the storage is
+	// scoped to the workspace.
+	scope mergeScope
+}
+
+// Routing selects the LLM used by each stage of the pipeline. Stages left nil use the LLM passed
+// to Insert, ProcessUnprocessedChunk or Query, except SemanticChunking, which falls back to the
+// handler's own LLM.
+type Routing struct {
+	Extraction        LLM
+	Glean             LLM
+	GleanDecision     LLM
+	Summarization     LLM
+	KeywordExtraction LLM
+	// SemanticChunking is used by document handlers implementing LLMChunker.
+	SemanticChunking LLM
+	// EntityResolution confirms the candidates of the entity resolution enabled with
+	// WithEntityResolution and EntityResolution.Confirm.
+	EntityResolution LLM
+	// ClaimExtraction extracts the claims of the claim extraction enabled with
+	// WithClaimExtraction.
+	ClaimExtraction LLM
+	// CommunityReport writes the community reports of UpdateCommunities and
+	// WithCommunityUpdate.
+	CommunityReport LLM
+	// CommunityQuery maps the community reports of QueryCommunities.
+	CommunityQuery LLM
+
+	// ExtractionTiers is an escalation chain of progressively stronger LLMs for extraction,
+	// replacing Extraction. Each chunk is extracted with the first tier, and a failed attempt,
+	// whether the output can't be parsed, is empty or the provider returns an error, moves to
+	// the next tier. So each tier but the last one gets a single attempt, and the last tier gets
+	// up to the handler's MaxRetries attempts.
+	// Unless Glean is set, gleaning continues with the tier that produced the extraction.
+	ExtractionTiers []LLM
+}
+
+// WithUsage records the token usage of every LLM call made by the operation in c.
+// It applies to the functions calling an LLM: Insert, InsertBatch, ProcessUnprocessedChunk,
+// Query, UpdateCommunities and QueryCommunities.
+// The same collector may be passed to several calls to aggregate their usage.
+func WithUsage(c *UsageCollector) Option {
+	return option("WithUsage", callsWithLLM, func(o *options) {
+		o.usage = c
+	})
+}
+
+// WithRouting routes the stages of the operation to the LLMs in r, for example a small local
+// model for the glean decision and summarization and a stronger one for extraction.
+// It applies to the same functions as WithUsage.
+func WithRouting(r Routing) Option {
+	return option("WithRouting", callsWithLLM, func(o *options) {
+		o.routing = r
+	})
+}
+
+// WithExtractionStats records in s the number of entities and relationships accepted by the
+// handler's ExtractionPolicy, and how many times each of its rules was applied, during Insert,
+// InsertBatch or ProcessUnprocessedChunk.
+// The same stats may be passed to several calls to aggregate their extractions.
+func WithExtractionStats(s *ExtractionStats) Option {
+	return option("WithExtractionStats", callsExtracting, func(o *options) {
+		o.extractionStats = s
+	})
+}
+
+// WithEntityResolution resolves the extracted entities to existing entities with a different
+// name before merging them, as configured by r. It applies to Insert, InsertBatch and
+// ProcessUnprocessedChunk.
+func WithEntityResolution(r EntityResolution) Option {
+	return option("WithEntityResolution", callsExtracting, func(o *options) {
+		o.resolution = &r
+	})
+}
+
+// WithClaimExtraction extracts the claims about the entities of each chunk, as configured by c,
+// and stores them with the storage's ClaimStorage. It applies to Insert, InsertBatch and
+// ProcessUnprocessedChunk.
+func WithClaimExtraction(c ClaimExtraction) Option {
+	return option("WithClaimExtraction", callsExtracting, func(o *options) {
+		o.claims = &c
+	})
+}
+
+// option returns an Option that applies set when it's passed to one of calls, and is recorded
+// as invalid otherwise.
+func option(name string, calls call, set func(*options)) Option {
+	return func(o *options) {
+		if o.call&calls == 0 {
+			o.invalid = append(o.invalid, name)
+			return
+		}
+		set(o)
+	}
+}
+
+// newOptions applies opts to the options of c. It returns ErrInvalidOption if any of them
+// doesn't apply to c.
+func newOptions(c call, opts []Option) (options, error) {
+	o := options{call: c}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.invalid) > 0 {
+		return o, fmt.Errorf("%w: %s doesn't apply to %s", ErrInvalidOption, strings.Join(o.invalid, ", "), c)
+	}
+	return o, nil
+}
+
+// stageLLMs holds the LLM used by each stage of the pipeline.
+type stageLLMs struct {
+	// extractionTiers has a single LLM unless an escalation chain is configured, and gleanTiers
+	// either matches it or holds the routed glean LLM.
+	extractionTiers   []LLM
+	gleanTiers        []LLM
+	gleanDecision     LLM
+	summarization     LLM
+	keywordExtraction LLM
+	// semanticChunking is nil unless it's routed, so handlers use their own LLM.
+	semanticChunking LLM
+	entityResolution LLM
+	claimExtraction  LLM
+	communityReport  LLM
+	communityQuery   LLM
+}
+
+func (o options) stageLLMs(llm LLM) stageLLMs {
+	route := func(op Operation, routed, fallback LLM) LLM {
+		if routed == nil {
+			routed = fallback
+		}
+		if routed == nil || o.usage == nil {
+			return routed
+		}
+		return o.usage.Track(op, routed)
+	}
+	llms := stageLLMs{
+		extractionTiers:   []LLM{route(OperationExtraction, o.routing.Extraction, llm)},
+		gleanTiers:        []LLM{route(OperationGlean, o.routing.Glean, llm)},
+		gleanDecision:     route(OperationGleanDecision, o.routing.GleanDecision, llm),
+		summarization:     route(OperationSummarization, o.routing.Summarization, llm),
+		keywordExtraction: route(OperationKeywordExtraction, o.routing.KeywordExtraction, llm),
+		semanticChunking:  route(OperationSemanticChunking, o.routing.SemanticChunking, nil),
+		entityResolution:  route(OperationEntityResolution, o.routing.EntityResolution, llm),
+		claimExtraction:   route(OperationClaimExtraction, o.routing.ClaimExtraction, llm),
+		communityReport:   route(OperationCommunityReport, o.routing.CommunityReport, llm),
+		communityQuery:    route(OperationCommunityQuery, o.routing.CommunityQuery, llm),
+	}
+	if len(o.routing.ExtractionTiers) > 0 {
+		llms.extractionTiers = make([]LLM, len(o.routing.ExtractionTiers))
+		for i, tier := range o.routing.ExtractionTiers {
+			llms.extractionTiers[i] = route(OperationExtraction, tier, llm)
+		}
+		if o.routing.Glean == nil {
+			llms.gleanTiers = make([]LLM, len(o.routing.ExtractionTiers))
+			for i, tier := range o.routing.ExtractionTiers {
+				llms.gleanTiers[i] = route(OperationGlean, tier, llm)
+			}
+		}
+	}
+	return llms
+}
@@ -0,0 +1,321 @@
+package golightrag_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/llm"
+)
+
+// MockLLMChunker is a MockDocumentHandler that records the LLM it chunks documents with.
+type MockLLMChunker struct {
+	*MockDocumentHandler
+	llm golightrag.LLM
+}
+
+func (m *MockLLMChunker) ChunksDocumentWithLLM(content string, llm golightrag.LLM) ([]golightrag.Source, error) {
+	m.llm = llm
+	return m.ChunksDocument(content)
+}
+
+func TestWithRouting(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("Insert routes each stage", func(t *testing.T) {
+		extractionLLM := &MockLLM{
+			chatResponse: `{"entities": [{"entity_name": "ENTITY1", "entity_type": "PERSON", ` +
+				`"entity_description": "Entity1"}], "relationships": []}`,
+			chatCalls: make([][]string, 0),
+		}
+		decisionLLM := &MockLLM{chatResponse: "no", chatCalls: make([][]string, 0)}
+		chunkingLLM := &MockLLM{}
+		defaultLLM := &MockLLM{chatCalls: make([][]string, 0)}
+
+		handler := &MockLLMChunker{
+			MockDocumentHandler: &MockDocumentHandler{
+				sources: []golightrag.Source{
+					{Content: "Test content", TokenSize: 2, OrderIndex: 0},
+				},
+				entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+					Goal:        "Extract entities",
+					EntityTypes: []string{"PERSON"},
+					Language:    "English",
+				},
+				maxRetries:  1,
+				gleanCount:  1,
+				maxTokenLen: 1000,
+			},
+		}
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		doc := golightrag.Document{ID: "test-doc", Content: "Test content"}
+		err := golightrag.Insert(doc, handler, storage, defaultLLM, logger, golightrag.WithRouting(golightrag.Routing{
+			Extraction:       extractionLLM,
+			Glean:            extractionLLM,
+			GleanDecision:    decisionLLM,
+			SemanticChunking: chunkingLLM,
+		}))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(extractionLLM.chatCalls) != 2 {
+			t.Errorf("Expected extraction and glean calls on the extraction LLM, got %d calls", len(extractionLLM.chatCalls))
+		}
+		if len(decisionLLM.chatCalls) != 1 {
+			t.Errorf("Expected the glean decision on the decision LLM, got %d calls", len(decisionLLM.chatCalls))
+		}
+		if len(defaultLLM.chatCalls) != 0 {
+			t.Errorf("Expected no calls on the default LLM, got %d", len(defaultLLM.chatCalls))
+		}
+		if handler.llm != chunkingLLM {
+			t.Errorf("Expected the document to be chunked with the semantic chunking LLM")
+		}
+	})
+
+	t.Run("Insert escalates extraction tiers", func(t *testing.T) {
+		var logs bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+		emptyLLM := &MockLLM{chatResponse: " ", chatCalls: make([][]string, 0)}
+		failingLLM := &MockLLM{chatErr: fmt.Errorf("wrapped: %w", llm.ErrContextTooLong), chatCalls: make([][]string, 0)}
+		strongLLM := &MockLLM{
+			chatResponse: `{"entities": [{"entity_name": "ENTITY1", "entity_type": "PERSON", ` +
+				`"entity_description": "Entity1"}], "relationships": []}`,
+			chatCalls: make([][]string, 0),
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{Content: "Test content", TokenSize: 2, OrderIndex: 0},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON"},
+				Language:    "English",
+			},
+			maxRetries:  1,
+			maxTokenLen: 1000,
+		}
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		doc := golightrag.Document{ID: "test-doc", Content: "Test content"}
+		err := golightrag.Insert(doc, handler, storage, &MockLLM{}, logger, golightrag.WithRouting(golightrag.Routing{
+			ExtractionTiers: []golightrag.LLM{emptyLLM, failingLLM, strongLLM},
+		}))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(emptyLLM.chatCalls) != 1 || len(failingLLM.chatCalls) != 1 {
+			t.Errorf("Expected a single call on each failing tier, got %d and %d",
+				len(emptyLLM.chatCalls), len(failingLLM.chatCalls))
+		}
+		// The strong tier extracts and gleans.
+		if len(strongLLM.chatCalls) != 2 {
+			t.Errorf("Expected extraction and glean calls on the last tier, got %d", len(strongLLM.chatCalls))
+		}
+		if _, ok := storage.entities["ENTITY1"]; !ok {
+			t.Errorf("Expected ENTITY1 to be stored")
+		}
+		if !strings.Contains(logs.String(), "tier=2 entities=") {
+			t.Errorf("Expected the tier to be logged, got %s", logs.String())
+		}
+	})
+
+	t.Run("Insert retries the last extraction tier", func(t *testing.T) {
+		tiers := make([]*MockLLM, 3)
+		for i := range tiers {
+			tiers[i] = &MockLLM{chatResponse: " ", chatCalls: make([][]string, 0)}
+		}
+
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{Content: "Test content", TokenSize: 2, OrderIndex: 0},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON"},
+				Language:    "English",
+			},
+			maxRetries:  3,
+			maxTokenLen: 1000,
+		}
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		doc := golightrag.Document{ID: "test-doc", Content: "Test content"}
+		err := golightrag.Insert(doc, handler, storage, &MockLLM{}, logger, golightrag.WithRouting(golightrag.Routing{
+			ExtractionTiers: []golightrag.LLM{tiers[0], tiers[1], tiers[2]},
+		}))
+		if err == nil {
+			t.Fatal("Expected an error once every tier failed, got nil")
+		}
+
+		// Each tier but the last one is attempted once, and the last one MaxRetries times.
+		for i, want := range []int{1, 1, 3} {
+			if got := len(tiers[i].chatCalls); got != want {
+				t.Errorf("Expected %d attempts on tier %d, got %d", want, i, got)
+			}
+		}
+	})
+
+	t.Run("Insert escalates extraction tiers on glean failures", func(t *testing.T) {
+		extraction := `{"entities": [{"entity_name": "ENTITY1", "entity_type": "PERSON", ` +
+			`"entity_description": "Entity1"}], "relationships": []}`
+		newHandler := func(maxRetries int) *MockDocumentHandler {
+			return &MockDocumentHandler{
+				sources: []golightrag.Source{
+					{Content: "Test content", TokenSize: 2, OrderIndex: 0},
+				},
+				entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+					Goal:        "Extract entities",
+					EntityTypes: []string{"PERSON"},
+					Language:    "English",
+				},
+				maxRetries:  maxRetries,
+				maxTokenLen: 1000,
+			}
+		}
+		newStorage := func() *MockStorage {
+			return &MockStorage{
+				entities:      make(map[string]golightrag.GraphEntity),
+				relationships: make(map[string]golightrag.GraphRelationship),
+			}
+		}
+		doc := golightrag.Document{ID: "test-doc", Content: "Test content"}
+
+		// The weak tier extracts, but its glean keeps being rate limited.
+		weakLLM := &gleanFailingLLM{extraction: extraction, gleanErr: fmt.Errorf("wrapped: %w", llm.ErrRateLimited)}
+		strongLLM := &MockLLM{chatResponse: extraction, chatCalls: make([][]string, 0)}
+		storage := newStorage()
+		err := golightrag.Insert(doc, newHandler(1), storage, &MockLLM{}, logger,
+			golightrag.WithRouting(golightrag.Routing{ExtractionTiers: []golightrag.LLM{weakLLM, strongLLM}}))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if weakLLM.calls != 2 {
+			t.Errorf("Expected an extraction and a glean call on the weak tier, got %d", weakLLM.calls)
+		}
+		if len(strongLLM.chatCalls) != 2 {
+			t.Errorf("Expected extraction and glean calls on the strong tier, got %d", len(strongLLM.chatCalls))
+		}
+		if _, ok := storage.entities["ENTITY1"]; !ok {
+			t.Errorf("Expected ENTITY1 to be stored")
+		}
+
+		// A glean failing on every attempt stops after the maximum attempts.
+		failingLLM := &gleanFailingLLM{extraction: extraction, gleanErr: fmt.Errorf("wrapped: %w", llm.ErrRateLimited)}
+		err = golightrag.Insert(doc, newHandler(3), newStorage(), &MockLLM{}, logger,
+			golightrag.WithRouting(golightrag.Routing{Extraction: failingLLM, Glean: failingLLM}))
+		if err == nil {
+			t.Fatal("Expected an error after the maximum attempts")
+		}
+		if failingLLM.calls != 6 {
+			t.Errorf("Expected an extraction and a glean call on each of the 3 attempts, got %d calls", failingLLM.calls)
+		}
+	})
+
+	t.Run("Query falls back to the default LLM", func(t *testing.T) {
+		defaultLLM := &MockLLM{
+			chatResponse: `{"high_level_keywords": ["Knowledge"], "low_level_keywords": ["Entity1"]}`,
+			chatCalls:    make([][]string, 0),
+		}
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		conversations := []golightrag.QueryConversation{
+			{Role: golightrag.RoleUser, Message: "Tell me about Entity1"},
+		}
+		_, err := golightrag.Query(conversations, &MockQueryHandler{}, storage, defaultLLM, logger,
+			golightrag.WithRouting(golightrag.Routing{Extraction: &MockLLM{}}))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(defaultLLM.chatCalls) != 1 {
+			t.Errorf("Expected keyword extraction on the default LLM, got %d calls", len(defaultLLM.chatCalls))
+		}
+	})
+}
+
+// gleanFailingLLM answers the extraction of a chunk, and fails its gleaning with gleanErr.
+type gleanFailingLLM struct {
+	extraction string
+	gleanErr   error
+	calls      int
+}
+
+func (l *gleanFailingLLM) Chat(messages []string) (string, error) {
+	l.calls++
+	if len(messages) > 1 {
+		return "", l.gleanErr
+	}
+	return l.extraction, nil
+}
+
+func TestInvalidOptions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	doc := golightrag.Document{ID: "test-doc", Content: "Test content"}
+	conversations := []golightrag.QueryConversation{{Role: golightrag.RoleUser, Message: "Test"}}
+
+	t.Run("Rejects options that don't apply to the call", func(t *testing.T) {
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+		mockLLM := &MockLLM{chatCalls: make([][]string, 0)}
+
+		err := golightrag.Insert(doc, &MockDocumentHandler{}, storage, mockLLM, logger,
+			golightrag.WithRanking(golightrag.Ranking{}))
+		if !errors.Is(err, golightrag.ErrInvalidOption) || !strings.Contains(err.Error(), "WithRanking") {
+			t.Errorf("Expected ErrInvalidOption naming WithRanking for Insert, got %v", err)
+		}
+		_, err = golightrag.Query(conversations, &MockQueryHandler{}, storage, mockLLM, logger,
+			golightrag.WithClaimExtraction(golightrag.ClaimExtraction{}))
+		if !errors.Is(err, golightrag.ErrInvalidOption) {
+			t.Errorf("Expected ErrInvalidOption for WithClaimExtraction on Query, got %v", err)
+		}
+		err = golightrag.InsertChunk(doc, &MockDocumentHandler{}, storage, logger, golightrag.WithUsage(nil))
+		if !errors.Is(err, golightrag.ErrInvalidOption) {
+			t.Errorf("Expected ErrInvalidOption for WithUsage on InsertChunk, got %v", err)
+		}
+		if len(mockLLM.chatCalls) != 0 || len(storage.entities) != 0 {
+			t.Errorf("Expected nothing to run with an invalid option")
+		}
+	})
+
+	t.Run("Accepts the workspace on every call", func(t *testing.T) {
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+		workspace := golightrag.WithWorkspace("")
+		errs := map[string]error{
+			"InsertChunks": golightrag.InsertChunks(nil, storage, logger, workspace),
+			"UpdateCommunities": golightrag.UpdateCommunities(storage, &MockLLM{}, golightrag.CommunityConfig{},
+				logger, workspace),
+		}
+		_, errs["Query"] = golightrag.Query(nil, &MockQueryHandler{}, storage, &MockLLM{}, logger, workspace)
+		for name, err := range errs {
+			if errors.Is(err, golightrag.ErrInvalidOption) {
+				t.Errorf("Expected WithWorkspace to apply to %s, got %v", name, err)
+			}
+		}
+	})
+}
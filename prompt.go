@@ -10,10 +10,31 @@ type EntityExtractionPromptData struct {
 	EntityTypes []string
 	Language    string
 	Examples    []EntityExtractionPromptExample
+	// Format is the output format the LLM is asked to extract with. Defaults to
+	// ExtractionFormatJSON.
+	Format ExtractionFormat
+	// Ontology declares the attributes of the entity types and the relationship predicates the
+	// LLM is asked to extract. If EntityTypes is empty, the entity types of the ontology are
+	// used.
+	Ontology Ontology
 
 	Input string
 }
 
+// ExtractionFormat is the output format of the entity extraction prompts.
+type ExtractionFormat string
+
+// Defines the supported extraction formats.
+const (
+	// ExtractionFormatJSON asks for a JSON object, constrained with a JSON schema when the LLM
+	// supports structured output.
+	ExtractionFormatJSON ExtractionFormat = "json"
+	// ExtractionFormatTuple asks for the tuple records of the Python LightRAG, such as
+	// ("entity"<|>NAME<|>TYPE<|>DESCRIPTION)##, ended by <|COMPLETE|>. Small local models often
+	// follow it more reliably than JSON.
+	ExtractionFormatTuple ExtractionFormat = "tuple"
+)
+
 // EntityExtractionPromptExample provides sample inputs and outputs
 // for demonstrating entity extraction to language models.
 // It includes sample text content along with the expected entities
@@ -76,6 +97,9 @@ const extractEntitiesPrompt = `---Goal---
 - entity_name: Name of the entity, use same language as input text. If {{.Language}}, capitalized the name.
 - entity_type: STRICTLY use ONLY one of the exact entity types provided here (no variations, plurals, or additions): [{{range $i, $v := .EntityTypes}}{{if $i}}, {{end}}{{$v}}{{end}}]
 - entity_description: Comprehensive description of the entity's attributes and activities
+{{- if .Ontology.EntityTypes}}
+- entity_attributes: object with the attributes declared for the entity type in the ontology below, using null for the attributes the text doesn't state
+{{- end}}
 
 2. From the entities identified in step 1, identify all pairs of (source_entity, target_entity) that are *clearly related* to each other.
 For each pair of related entities, extract the following information:
@@ -84,6 +108,11 @@ For each pair of related entities, extract the following information:
 - relationship_description: explanation as to why you think the source entity and the target entity are related to each other
 - relationship_strength: a numeric score indicating strength of the relationship between the source entity and target entity (use a number between 1-10)
 - relationship_keywords: one or more high-level key words that summarize the overarching nature of the relationship, focusing on concepts or themes rather than specific details
+- valid_from: the date from which the relationship holds, as YYYY-MM-DD, YYYY-MM or YYYY, ONLY if the text states it, otherwise null
+- valid_to: the date until which the relationship held, in the same format, ONLY if the text states that it ended, otherwise null
+{{- if .Ontology.Predicates}}
+- relationship_predicate: STRICTLY use ONLY one of the relationship predicates of the ontology below
+{{- end}}
 
 3. Extract high-level keywords that summarize the main concepts or themes present in the document.
 
@@ -93,7 +122,8 @@ For each pair of related entities, extract the following information:
     {
       "entity_name": string,
       "entity_type": string (one of the provided entity types ONLY),
-      "entity_description": string
+      "entity_description": string{{if .Ontology.EntityTypes}},
+      "entity_attributes": object{{end}}
     }
   ],
   "relationships": [
@@ -102,13 +132,17 @@ For each pair of related entities, extract the following information:
       "target_entity": string,
       "relationship_description": string,
       "relationship_keywords": array of strings,
-      "relationship_strength": number (1-10)
+      "relationship_strength": number (1-10),
+      "valid_from": string or null,
+      "valid_to": string or null{{if .Ontology.Predicates}},
+      "relationship_predicate": string (one of the relationship predicates ONLY){{end}}
     }
   ],
 }
 
 5. The JSON output MUST be valid JSON with no explanation text before or after it. Do not include any markdown formatting like backticks, and do not include any text outside the JSON structure.
 
+{{template "ontology" .}}
 ######################
 ---Examples---
 ######################
@@ -164,6 +198,9 @@ MANY entities and relationships were missed in the last extraction. Please ident
 - entity_name: Name of the entity, use same language as input text. If {{.Language}}, capitalized the name.
 - entity_type: STRICTLY use ONLY one of the exact entity types provided here (no variations, plurals, or additions): [{{range $i, $v := .EntityTypes}}{{if $i}}, {{end}}{{$v}}{{end}}]
 - entity_description: Comprehensive description of the entity's attributes and activities
+{{- if .Ontology.EntityTypes}}
+- entity_attributes: object with the attributes declared for the entity type in the ontology below, using null for the attributes the text doesn't state
+{{- end}}
 
 2. From the entities identified in step 1, identify all pairs of (source_entity, target_entity) that are *clearly related* to each other.
 For each pair of related entities, extract the following information:
@@ -172,6 +209,11 @@ For each pair of related entities, extract the following information:
 - relationship_description: explanation as to why you think the source entity and the target entity are related to each other
 - relationship_strength: a numeric score indicating strength of the relationship between the source entity and target entity (use a number between 1-10)
 - relationship_keywords: one or more high-level key words that summarize the overarching nature of the relationship, focusing on concepts or themes rather than specific details
+- valid_from: the date from which the relationship holds, as YYYY-MM-DD, YYYY-MM or YYYY, ONLY if the text states it, otherwise null
+- valid_to: the date until which the relationship held, in the same format, ONLY if the text states that it ended, otherwise null
+{{- if .Ontology.Predicates}}
+- relationship_predicate: STRICTLY use ONLY one of the relationship predicates of the ontology below
+{{- end}}
 
 3. Extract additional high-level keywords that summarize concepts or themes that may have been missed in the initial extraction.
 
@@ -181,7 +223,8 @@ For each pair of related entities, extract the following information:
     {
       "entity_name": string,
       "entity_type": string (one of the provided entity types ONLY),
-      "entity_description": string
+      "entity_description": string{{if .Ontology.EntityTypes}},
+      "entity_attributes": object{{end}}
     }
   ],
   "relationships": [
@@ -190,17 +233,127 @@ For each pair of related entities, extract the following information:
       "target_entity": string,
       "relationship_description": string,
       "relationship_keywords": array of strings,
-      "relationship_strength": number (1-10)
+      "relationship_strength": number (1-10),
+      "valid_from": string or null,
+      "valid_to": string or null{{if .Ontology.Predicates}},
+      "relationship_predicate": string (one of the relationship predicates ONLY){{end}}
     }
   ],
 }
 
 5. The JSON output MUST be valid JSON with no explanation text before or after it. Do not include any markdown formatting like backticks, and do not include any text outside the JSON structure.
 
+{{template "ontology" .}}
 ---Output---
 
 Please provide the additional entities and relationships in valid JSON format:`
 
+const extractEntitiesTuplePrompt = `---Goal---
+{{.Goal}}
+
+---Steps---
+1. Identify all entities. For each identified entity, extract the following information:
+- entity_name: Name of the entity, use same language as input text. If {{.Language}}, capitalized the name.
+- entity_type: STRICTLY use ONLY one of the exact entity types provided here (no variations, plurals, or additions): [{{range $i, $v := .EntityTypes}}{{if $i}}, {{end}}{{$v}}{{end}}]
+- entity_description: Comprehensive description of the entity's attributes and activities
+{{- if .Ontology.EntityTypes}}
+- entity_attributes: JSON object with the attributes declared for the entity type in the ontology below, omitting the attributes the text doesn't state
+Format each entity as ("entity"<|><entity_name><|><entity_type><|><entity_description><|><entity_attributes>)
+{{- else}}
+Format each entity as ("entity"<|><entity_name><|><entity_type><|><entity_description>)
+{{- end}}
+
+2. From the entities identified in step 1, identify all pairs of (source_entity, target_entity) that are *clearly related* to each other.
+For each pair of related entities, extract the following information:
+- source_entity: name of the source entity, as identified in step 1
+- target_entity: name of the target entity, as identified in step 1
+- relationship_description: explanation as to why you think the source entity and the target entity are related to each other
+- relationship_keywords: one or more high-level key words, separated by commas, that summarize the overarching nature of the relationship, focusing on concepts or themes rather than specific details
+- relationship_strength: a numeric score indicating strength of the relationship between the source entity and target entity (use a number between 1-10)
+- valid_from: the date from which the relationship holds, as YYYY-MM-DD, YYYY-MM or YYYY, ONLY if the text states it, otherwise leave it empty
+- valid_to: the date until which the relationship held, in the same format, ONLY if the text states that it ended, otherwise leave it empty
+{{- if .Ontology.Predicates}}
+- relationship_predicate: STRICTLY use ONLY one of the relationship predicates of the ontology below
+Format each relationship as ("relationship"<|><source_entity><|><target_entity><|><relationship_description><|><relationship_keywords><|><relationship_strength><|><valid_from><|><valid_to><|><relationship_predicate>)
+{{- else}}
+Format each relationship as ("relationship"<|><source_entity><|><target_entity><|><relationship_description><|><relationship_keywords><|><relationship_strength><|><valid_from><|><valid_to>)
+{{- end}}
+
+3. Return output in {{.Language}} as a single list of all the entities and relationships identified in steps 1 and 2. Use **##** as the list delimiter.
+
+4. When finished, output <|COMPLETE|>
+
+{{template "ontology" .}}
+######################
+---Examples---
+######################
+{{- range $i, $example := .Examples}}
+Example {{add $i 1}}:
+
+Text:
+{{$example.Text}}
+################
+Output:
+{{- range $output := $example.EntitiesOutputs}}
+("entity"<|>{{$output.Name}}<|>{{$output.Type}}<|>{{$output.Description}})##
+{{- end}}
+{{- range $output := $example.RelationshipsOutputs}}
+("relationship"<|>{{$output.SourceEntity}}<|>{{$output.TargetEntity}}<|>{{$output.Description}}<|>{{range $k, $v := $output.Keywords}}{{if $k}}, {{end}}{{$v}}{{end}}<|>{{$output.Strength}})##
+{{- end}}
+<|COMPLETE|>
+#############################
+{{- end}}
+
+#############################
+---Real Data---
+######################
+Entity_types: [{{range $i, $v := .EntityTypes}}{{if $i}}, {{end}}{{$v}}{{end}}]
+Text:
+{{.Input}}
+######################
+Output:`
+
+const gleanEntitiesTuplePrompt = `
+MANY entities and relationships were missed in the last extraction. Please identify additional entities and relationships.
+
+---Remember Steps---
+
+1. Identify all entities. For each identified entity, extract the following information:
+- entity_name: Name of the entity, use same language as input text. If {{.Language}}, capitalized the name.
+- entity_type: STRICTLY use ONLY one of the exact entity types provided here (no variations, plurals, or additions): [{{range $i, $v := .EntityTypes}}{{if $i}}, {{end}}{{$v}}{{end}}]
+- entity_description: Comprehensive description of the entity's attributes and activities
+{{- if .Ontology.EntityTypes}}
+- entity_attributes: JSON object with the attributes declared for the entity type in the ontology below, omitting the attributes the text doesn't state
+Format each entity as ("entity"<|><entity_name><|><entity_type><|><entity_description><|><entity_attributes>)
+{{- else}}
+Format each entity as ("entity"<|><entity_name><|><entity_type><|><entity_description>)
+{{- end}}
+
+2. From the entities identified in step 1, identify all pairs of (source_entity, target_entity) that are *clearly related* to each other.
+For each pair of related entities, extract the following information:
+- source_entity: name of the source entity, as identified in step 1
+- target_entity: name of the target entity, as identified in step 1
+- relationship_description: explanation as to why you think the source entity and the target entity are related to each other
+- relationship_keywords: one or more high-level key words, separated by commas, that summarize the overarching nature of the relationship, focusing on concepts or themes rather than specific details
+- relationship_strength: a numeric score indicating strength of the relationship between the source entity and target entity (use a number between 1-10)
+- valid_from: the date from which the relationship holds, as YYYY-MM-DD, YYYY-MM or YYYY, ONLY if the text states it, otherwise leave it empty
+- valid_to: the date until which the relationship held, in the same format, ONLY if the text states that it ended, otherwise leave it empty
+{{- if .Ontology.Predicates}}
+- relationship_predicate: STRICTLY use ONLY one of the relationship predicates of the ontology below
+Format each relationship as ("relationship"<|><source_entity><|><target_entity><|><relationship_description><|><relationship_keywords><|><relationship_strength><|><valid_from><|><valid_to><|><relationship_predicate>)
+{{- else}}
+Format each relationship as ("relationship"<|><source_entity><|><target_entity><|><relationship_description><|><relationship_keywords><|><relationship_strength><|><valid_from><|><valid_to>)
+{{- end}}
+
+3. Return output in {{.Language}} as a single list of all the entities and relationships identified in steps 1 and 2. Use **##** as the list delimiter.
+
+4. When finished, output <|COMPLETE|>
+
+{{template "ontology" .}}
+---Output---
+
+Add the additional entities and relationships below using the same format:`
+
 const gleanDecideContinuePrompt = `
 ---Goal---
 
@@ -210,6 +363,106 @@ It appears some entities may have still been missed.
 
 Answer ONLY by "YES" OR "NO" if there are still entities that need to be added.`
 
+const entityResolutionPrompt = `
+---Goal---
+
+Decide whether two entities extracted from different texts are the same real-world entity, for example an organization and its abbreviation or its full legal name.
+
+---Entities---
+
+Entity 1: {{.Name}} ({{.Type}})
+{{.Description}}
+
+Entity 2: {{.CandidateName}} ({{.CandidateType}})
+{{.CandidateDescription}}
+
+---Output---
+
+Answer ONLY by "YES" OR "NO" if the two entities are the same.`
+
+const claimExtractionPrompt = `
+---Goal---
+
+Given a text document, a list of entities and a claim description, extract all the claims about the entities that match the claim description.
+Use {{.Language}} as output language.
+
+---Claim Description---
+
+{{.Description}}
+
+---Entities---
+
+[{{range $i, $v := .Entities}}{{if $i}}, {{end}}{{$v}}{{end}}]
+
+---Steps---
+
+For each claim about one of the entities, extract:
+- subject: the name of the entity the claim is about, exactly as in the list of entities.
+- object: the name of the entity affected by the claim, if any, or null.
+- claim_type: a short category of the claim in capital letters, repeatable across claims, such as ACQUISITION, LAWSUIT or REGULATORY_FINE.
+- claim_status: TRUE if the claim is confirmed, FALSE if it's refuted, or SUSPECTED if it's not verified.
+- claim_description: a detailed description of the claim, with the evidence and references supporting it.
+- start_date and end_date: the period of the claim in ISO 8601 format (YYYY-MM-DD). Use the same date for both if the claim happened on a single date, and null if the text doesn't state it.
+- source_text: the quotes of the text relevant to the claim.
+
+---Output---
+
+Return a JSON object with a "claims" array holding the claims. Return an empty array if there are no claims.
+
+---Real Data---
+
+Text: {{.Input}}
+
+Output:`
+
+const communityReportPrompt = `
+---Goal---
+
+Write a report about a community of entities of a knowledge graph, given the entities of the community and the relationships between them. The report informs decision-makers about the entities of the community, their relationships and the significant information associated with them.
+Use {{.Language}} as output language.
+
+---Steps---
+
+Write the report as a JSON object with:
+- title: a short and specific name of the community, with its most representative entities.
+- summary: an executive summary of the overall structure of the community, how its entities are related to each other, and the significant information associated with them.
+- rating: a float score from 0 to 10 of the importance of the community, 10 being the most important.
+- findings: a list of 5 to 10 key insights about the community, each with a "summary" and an "explanation" grounded in the data. Don't include information that isn't supported by the data.
+
+---Entities---
+
+{{.Entities}}
+
+---Relationships---
+
+{{.Relationships}}
+
+Output:`
+
+const communityMapPrompt = `
+---Goal---
+
+Find the points of the community reports below that help answer the user's question, and rate their importance to answer it.
+
+---Steps---
+
+Return a JSON object with a "points" array, each point with:
+- description: a comprehensive description of the point.
+- score: an integer from 0 to 100 of how important the point is to answer the question. Points that don't help answering it score 0.
+- reports: the numbers of the reports supporting the point.
+
+Only include points supported by the reports. Return an empty array if the reports don't help answer the question.
+
+---Question---
+
+{{.Query}}
+
+---Reports---
+
+{{.Reports}}
+
+Output:`
+
 const summarizeDescriptionsPrompt = `
 You are a helpful assistant responsible for generating a comprehensive summary of the data provided below.
 Given one or two entities, and a list of descriptions, all related to the same entity or group of entities.
@@ -268,3 +521,23 @@ The "Output" should be human text, not unicode characters. Keep the same languag
 Output:
 
 `
+
+// ontologyPromptTemplate defines the "ontology" template, listing the ontology of the handler
+// in the entity extraction prompts.
+const ontologyPromptTemplate = `{{define "ontology"}}
+{{- if or .Ontology.EntityTypes .Ontology.Predicates}}
+---Ontology---
+{{- range .Ontology.EntityTypes}}
+Entity type {{.Name}}{{if .Description}}: {{.Description}}{{end}}
+{{- if .Attributes}}
+Attributes:
+{{- range .Attributes}}
+- {{.Name}} ({{.Type}}){{if .Description}}: {{.Description}}{{end}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Ontology.Predicates}}
+Relationship predicates: [{{range $i, $v := .Ontology.Predicates}}{{if $i}}, {{end}}{{$v}}{{end}}]
+{{- end}}
+{{end}}
+{{- end}}`
@@ -40,6 +40,9 @@ type QueryResult struct {
 	LocalEntities       []EntityContext
 	LocalRelationships  []RelationshipContext
 	LocalSources        []SourceContext
+	// Claims are the claims of the local and global entities, ordered by start date. They are
+	// only retrieved from storages implementing ClaimStorage.
+	Claims []Claim
 }
 
 // EntityContext represents an entity retrieved from the knowledge graph with its context.
@@ -49,6 +52,15 @@ type EntityContext struct {
 	Description string
 	RefCount    int
 	CreatedAt   time.Time
+	// Properties are the attributes of the entity declared by the Ontology of the handler.
+	Properties map[string]any
+	// SourceIDs are the IDs of the chunks the entity was extracted from, and Citations the
+	// same chunks with their documents.
+	SourceIDs []string
+	Citations []Citation
+	// Rank is the score the entity is ordered by when Query is called WithRanking, zero
+	// otherwise.
+	Rank float64
 }
 
 // RelationshipContext represents a relationship between entities retrieved from the knowledge graph.
@@ -60,6 +72,18 @@ type RelationshipContext struct {
 	Weight      float64
 	RefCount    int
 	CreatedAt   time.Time
+	// Properties are the properties of the relationship, such as its predicate.
+	Properties map[string]any
+	// ValidFrom and ValidTo are the period the relationship holds, zero when unknown.
+	ValidFrom time.Time
+	ValidTo   time.Time
+	// SourceIDs are the IDs of the chunks the relationship was extracted from, and Citations
+	// the same chunks with their documents.
+	SourceIDs []string
+	Citations []Citation
+	// Rank is the score the relationship is ordered by when Query is called WithRanking, zero
+	// otherwise.
+	Rank float64
 }
 
 // SourceContext represents a source document chunk with reference count.
@@ -67,6 +91,10 @@ type SourceContext struct {
 	Content  string
 	SourceId string
 	RefCount int
+	// DocumentID is the ID of the document the chunk belongs to, and Origin where it was
+	// sourced from. They are empty when unknown.
+	DocumentID string
+	Origin     Origin
 }
 
 type keywordExtractionOutput struct {
@@ -77,6 +105,7 @@ type keywordExtractionOutput struct {
 type refContext struct {
 	context  string
 	refCount int
+	rank     float64
 }
 
 const (
@@ -89,18 +118,30 @@ const (
 // Query performs a RAG search using the provided conversations.
 // It extracts keywords from the user's query, searches for relevant entities and relationships
 // in both local and global contexts, and returns the combined results.
+// Use WithUsage to record the token usage of the keyword extraction.
 func Query(
 	conversations []QueryConversation,
 	handler QueryHandler,
 	storage Storage,
 	llm LLM,
 	logger *slog.Logger,
+	opts ...Option,
 ) (QueryResult, error) {
+	o, err := newOptions(callQuery, opts)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
 	logger = logger.With(
 		slog.String("package", "golightrag"),
 		slog.String("function", "Query"),
 	)
 
+	storage, err = o.workspaceStorage(storage)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
 	query, histories, err := extractQueryAndHistories(conversations)
 	if err != nil {
 		return QueryResult{}, fmt.Errorf("failed to extract query and histories: %w", err)
@@ -123,7 +164,7 @@ func Query(
 
 	logger.Debug("Use LLM to extract keywords from query", "keywordPrompt", keywordPrompt)
 
-	keywordRes, err := llm.Chat([]string{keywordPrompt})
+	keywordRes, err := chatJSON(o.stageLLMs(llm).keywordExtraction, []string{keywordPrompt}, keywordExtractionSchema)
 	if err != nil {
 		return QueryResult{}, fmt.Errorf("failed to call LLM: %w", err)
 	}
@@ -139,6 +180,16 @@ func Query(
 		return QueryResult{}, fmt.Errorf("failed to unmarshal keyword extraction output: %w", err)
 	}
 
+	keywords := KeywordExtractionEvent{
+		Query:             query,
+		HighLevelKeywords: output.HighLevelKeywords,
+		LowLevelKeywords:  output.LowLevelKeywords,
+	}
+	if err := callHook("after keyword extraction", o.hooks.AfterKeywordExtraction, &keywords); err != nil {
+		return QueryResult{}, err
+	}
+	output.HighLevelKeywords, output.LowLevelKeywords = keywords.HighLevelKeywords, keywords.LowLevelKeywords
+
 	logger.Info("Query keywords",
 		"highLevelKeywords", output.HighLevelKeywords,
 		"lowLevelKeywords", output.LowLevelKeywords,
@@ -147,6 +198,15 @@ func Query(
 	llKeywords := strings.Join(output.LowLevelKeywords, ", ")
 	hlKeywords := strings.Join(output.HighLevelKeywords, ", ")
 
+	// Low-level keywords naming an entity, or one of its aliases, find it regardless of how it
+	// ranks in the vector search.
+	exactNames, err := exactEntityNames(output.LowLevelKeywords, queryExtractionPolicy(handler), storage)
+	if err != nil {
+		return QueryResult{}, fmt.Errorf("failed to look up entities by name: %w", err)
+	}
+
+	scope := newQueryScope(o, storage)
+
 	// Run local and global context retrieval concurrently
 	var localEntities []EntityContext
 	var localRelationships []RelationshipContext
@@ -161,12 +221,13 @@ func Query(
 
 	go func() {
 		defer wg.Done()
-		localEntities, localRelationships, localSources, localErr = localContext(llKeywords, storage, logger)
+		localEntities, localRelationships, localSources, localErr = localContext(
+			llKeywords, exactNames, scope, storage, logger)
 	}()
 
 	go func() {
 		defer wg.Done()
-		globalEntities, globalRelationships, globalSources, globalErr = globalContext(hlKeywords, storage, logger)
+		globalEntities, globalRelationships, globalSources, globalErr = globalContext(hlKeywords, scope, storage, logger)
 	}()
 
 	wg.Wait()
@@ -179,14 +240,64 @@ func Query(
 		return QueryResult{}, fmt.Errorf("failed to get global context: %w", globalErr)
 	}
 
-	return QueryResult{
+	local := RetrievalEvent{
+		Query:         query,
+		Stage:         RetrievalStageLocal,
+		Entities:      localEntities,
+		Relationships: localRelationships,
+		Sources:       localSources,
+	}
+	if err := callHook("after local retrieval", o.hooks.AfterRetrieval, &local); err != nil {
+		return QueryResult{}, err
+	}
+	localEntities, localRelationships, localSources = local.Entities, local.Relationships, local.Sources
+
+	global := RetrievalEvent{
+		Query:         query,
+		Stage:         RetrievalStageGlobal,
+		Entities:      globalEntities,
+		Relationships: globalRelationships,
+		Sources:       globalSources,
+	}
+	if err := callHook("after global retrieval", o.hooks.AfterRetrieval, &global); err != nil {
+		return QueryResult{}, err
+	}
+	globalEntities, globalRelationships, globalSources = global.Entities, global.Relationships, global.Sources
+
+	result := QueryResult{
 		LocalEntities:       localEntities,
 		LocalRelationships:  localRelationships,
 		LocalSources:        localSources,
 		GlobalEntities:      globalEntities,
 		GlobalRelationships: globalRelationships,
 		GlobalSources:       globalSources,
-	}, nil
+	}
+
+	if o.ranking != nil {
+		if err := rankContexts(&result, *o.ranking, storage); err != nil {
+			return QueryResult{}, fmt.Errorf("failed to rank contexts: %w", err)
+		}
+	}
+
+	if err := citeContexts(&result, storage); err != nil {
+		return QueryResult{}, fmt.Errorf("failed to cite contexts: %w", err)
+	}
+
+	result.Claims, err = entitiesClaims(append(slices.Clone(localEntities), globalEntities...), storage)
+	if err != nil {
+		return QueryResult{}, fmt.Errorf("failed to get claims: %w", err)
+	}
+	if result.Claims, err = scope.claims(result.Claims); err != nil {
+		return QueryResult{}, fmt.Errorf("failed to filter claims: %w", err)
+	}
+
+	claims := RetrievalEvent{Query: query, Stage: RetrievalStageClaims, Claims: result.Claims}
+	if err := callHook("after claims retrieval", o.hooks.AfterRetrieval, &claims); err != nil {
+		return QueryResult{}, err
+	}
+	result.Claims = claims.Claims
+
+	return result, nil
 }
 
 func extractQueryAndHistories(conversations []QueryConversation) (string, []QueryConversation, error) {
@@ -199,16 +310,68 @@ func extractQueryAndHistories(conversations []QueryConversation) (string, []Quer
 	return "", nil, errors.New("no user message found")
 }
 
+// queryExtractionPolicy returns the ExtractionPolicy of handler, if it provides one, to
+// normalize the keywords like the names of the extracted entities.
+func queryExtractionPolicy(handler QueryHandler) ExtractionPolicy {
+	if provider, ok := handler.(ExtractionPolicyProvider); ok {
+		return provider.ExtractionPolicy()
+	}
+	return ExtractionPolicy{}
+}
+
+// exactEntityNames returns the names of the entities named by keywords, normalized with policy,
+// either as their name or, if the storage implements GraphAliasStorage, as one of their
+// aliases.
+func exactEntityNames(keywords []string, policy ExtractionPolicy, storage Storage) ([]string, error) {
+	candidates := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		if name, _ := policy.normalizeName(keyword); name != "" {
+			candidates = appendIfUnique(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	entities, err := storage.GraphEntities(candidates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to batch get entities: %w", err)
+	}
+	aliased := make(map[string]GraphEntity)
+	if aliasStorage, ok := storage.(GraphAliasStorage); ok {
+		aliased, err = aliasStorage.GraphEntitiesByAlias(candidates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get entities by alias: %w", err)
+		}
+	}
+
+	names := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if entity, ok := entities[candidate]; ok {
+			names = appendIfUnique(names, entity.Name)
+		} else if entity, ok := aliased[candidate]; ok {
+			names = appendIfUnique(names, entity.Name)
+		}
+	}
+	return names, nil
+}
+
 func localContext(
 	keywords string,
+	exactNames []string,
+	scope queryScope,
 	storage Storage,
 	logger *slog.Logger,
 ) ([]EntityContext, []RelationshipContext, []SourceContext, error) {
 	// First find relevant entities using vector similarity search
-	entitiesNames, err := storage.VectorQueryEntity(keywords)
+	vectorNames, err := scope.vectorQueryEntity(keywords, storage)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to query entities: %w", err)
 	}
+	entitiesNames := slices.Clone(exactNames)
+	for _, name := range vectorNames {
+		entitiesNames = appendIfUnique(entitiesNames, name)
+	}
 
 	if len(entitiesNames) == 0 {
 		return []EntityContext{}, []RelationshipContext{}, []SourceContext{}, nil
@@ -221,6 +384,10 @@ func localContext(
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to batch get entities: %w", err)
 	}
+	entitiesMap, err = scope.entities(entitiesMap)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to filter entities: %w", err)
+	}
 	// Get relationship counts to determine entity importance
 	refCountMap, err := storage.GraphCountEntitiesRelationships(entitiesNames)
 	if err != nil {
@@ -243,13 +410,15 @@ func localContext(
 			Description: entity.Descriptions,
 			RefCount:    refCount,
 			CreatedAt:   entity.CreatedAt,
+			Properties:  entity.Properties,
+			SourceIDs:   splitSourceIDs(entity.SourceIDs),
 		})
 	}
 
 	logger.Debug("Entities from graph storage", "entities", entities)
 
 	// Get and rank relationships between the found entities
-	rankedRelationships, err := entitiesRankedRelationships(entities, storage)
+	rankedRelationships, err := entitiesRankedRelationships(entities, scope, storage)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to get ranked relationships: %w", err)
 	}
@@ -265,12 +434,13 @@ func localContext(
 
 func globalContext(
 	keywords string,
+	scope queryScope,
 	storage Storage,
 	logger *slog.Logger,
 ) ([]EntityContext, []RelationshipContext, []SourceContext, error) {
 	// Start by querying relationships (unlike localContext which queries entities first)
 	// This prioritizes connections between concepts rather than specific entities
-	relationshipNames, err := storage.VectorQueryRelationship(keywords)
+	relationshipNames, err := scope.vectorQueryRelationship(keywords, storage)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to query relationships: %w", err)
 	}
@@ -286,6 +456,10 @@ func globalContext(
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to query relationships: %w", err)
 	}
+	relationshipsMap, err = scope.relationships(relationshipsMap)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to filter relationships: %w", err)
+	}
 
 	// Collect all unique entity names that are part of these relationships
 	entitiesNames := make([]string, 0, len(relationshipsMap))
@@ -326,13 +500,17 @@ func globalContext(
 			Weight:      rel.Weight,
 			RefCount:    refCount,
 			CreatedAt:   rel.CreatedAt,
+			Properties:  rel.Properties,
+			ValidFrom:   rel.ValidFrom,
+			ValidTo:     rel.ValidTo,
+			SourceIDs:   splitSourceIDs(rel.SourceIDs),
 		})
 	}
 
 	logger.Debug("Relationships from graph storage", "relationships", relationships)
 
 	// Get entities connected by these relationships (inverse of localContext flow)
-	rankedEntities, err := relationshipsRankedEntities(relationships, storage)
+	rankedEntities, err := relationshipsRankedEntities(relationships, scope, storage)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to get ranked entities: %w", err)
 	}
@@ -346,7 +524,9 @@ func globalContext(
 	return rankedEntities, relationshipsContexts, rankedSources, nil
 }
 
-func entitiesRankedRelationships(entities []GraphEntity, storage Storage) ([]RelationshipContext, error) {
+func entitiesRankedRelationships(
+	entities []GraphEntity, scope queryScope, storage Storage,
+) ([]RelationshipContext, error) {
 	entityNames := make([]string, len(entities))
 	for i, entity := range entities {
 		entityNames[i] = entity.Name
@@ -373,6 +553,10 @@ func entitiesRankedRelationships(entities []GraphEntity, storage Storage) ([]Rel
 	if err != nil {
 		return nil, fmt.Errorf("failed to query relationships: %w", err)
 	}
+	relationshipsMap, err = scope.relationships(relationshipsMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter relationships: %w", err)
+	}
 
 	// Count relationships for relevance scoring
 	refCountMap, err := storage.GraphCountEntitiesRelationships(allEntities)
@@ -381,14 +565,11 @@ func entitiesRankedRelationships(entities []GraphEntity, storage Storage) ([]Rel
 	}
 
 	result := make([]RelationshipContext, 0, len(relationshipsMap))
-	for key, rel := range relationshipsMap {
-		// Parse composite key back into source and target entities
-		arrKey := strings.Split(key, "-")
-		if len(arrKey) != 2 {
-			continue
-		}
-		source := arrKey[0]
-		target := arrKey[1]
+	for _, rel := range relationshipsMap {
+		// The endpoints are taken from the relationship rather than parsed from the
+		// "SOURCE-TARGET" key, since entity names may contain hyphens.
+		source := rel.SourceEntity
+		target := rel.TargetEntity
 
 		// Calculate importance by summing relationship counts of both entities
 		sourceDegree, ok := refCountMap[source]
@@ -410,6 +591,10 @@ func entitiesRankedRelationships(entities []GraphEntity, storage Storage) ([]Rel
 			Weight:      rel.Weight,
 			RefCount:    refCount,
 			CreatedAt:   rel.CreatedAt,
+			Properties:  rel.Properties,
+			ValidFrom:   rel.ValidFrom,
+			ValidTo:     rel.ValidTo,
+			SourceIDs:   splitSourceIDs(rel.SourceIDs),
 		})
 	}
 
@@ -468,16 +653,20 @@ func entitiesRankedSources(entities []GraphEntity, storage Storage) ([]SourceCon
 			return nil, fmt.Errorf("failed to get source with id %s: %w", id, err)
 		}
 		result = append(result, SourceContext{
-			Content:  source.Content,
-			SourceId: source.ID,
-			RefCount: count,
+			Content:    source.Content,
+			SourceId:   source.ID,
+			RefCount:   count,
+			DocumentID: sourceDocumentID(source),
+			Origin:     source.Origin,
 		})
 	}
 
 	return result, nil
 }
 
-func relationshipsRankedEntities(relationships []GraphRelationship, storage Storage) ([]EntityContext, error) {
+func relationshipsRankedEntities(
+	relationships []GraphRelationship, scope queryScope, storage Storage,
+) ([]EntityContext, error) {
 	// Extract all unique entity names from both sides of the relationships
 	entityNames := make([]string, 0, len(relationships))
 	for _, rel := range relationships {
@@ -490,6 +679,10 @@ func relationshipsRankedEntities(relationships []GraphRelationship, storage Stor
 	if err != nil {
 		return nil, fmt.Errorf("failed to batch get entities: %w", err)
 	}
+	entitiesMap, err = scope.entities(entitiesMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter entities: %w", err)
+	}
 
 	// Get relationship counts to determine entity importance
 	refCountMap, err := storage.GraphCountEntitiesRelationships(entityNames)
@@ -510,6 +703,8 @@ func relationshipsRankedEntities(relationships []GraphRelationship, storage Stor
 			Description: entity.Descriptions,
 			RefCount:    refCount,
 			CreatedAt:   entity.CreatedAt,
+			Properties:  entity.Properties,
+			SourceIDs:   splitSourceIDs(entity.SourceIDs),
 		})
 	}
 
@@ -536,9 +731,12 @@ func relationshipsRankedSources(relationships []GraphRelationship, storage Stora
 			// Initialize source entry if it's new
 			_, ok := sourcesMap[sourceID]
 			if !ok {
+				source.ID = sourceID
 				sourcesMap[sourceID] = SourceContext{
-					Content:  source.Content,
-					SourceId: sourceID,
+					Content:    source.Content,
+					SourceId:   sourceID,
+					DocumentID: sourceDocumentID(source),
+					Origin:     source.Origin,
 				}
 			}
 
@@ -576,9 +774,9 @@ func combineContexts(headers []string, ctx1, ctx2 []refContext) string {
 		arrRes = append(arrRes, ctx)
 	}
 
-	// Sort by reference count in descending order (most relevant first)
+	// Sort by rank, then reference count in descending order (most relevant first)
 	slices.SortFunc(arrRes, func(a, b refContext) int {
-		return cmp.Compare(b.refCount, a.refCount)
+		return cmp.Or(cmp.Compare(b.rank, a.rank), cmp.Compare(b.refCount, a.refCount))
 	})
 
 	// Format as CSV with numbered IDs
@@ -604,6 +802,7 @@ func (q QueryResult) String() string {
 		globalEntities[i] = refContext{
 			context:  entity.String(),
 			refCount: entity.RefCount,
+			rank:     entity.Rank,
 		}
 	}
 	localEntities := make([]refContext, len(q.LocalEntities))
@@ -611,9 +810,10 @@ func (q QueryResult) String() string {
 		localEntities[i] = refContext{
 			context:  entity.String(),
 			refCount: entity.RefCount,
+			rank:     entity.Rank,
 		}
 	}
-	entities := combineContexts([]string{"id", "name", "type", "description", "ref_count", "created_at"},
+	entities := combineContexts([]string{"id", "name", "type", "description", "ref_count", "created_at", "properties"},
 		globalEntities, localEntities)
 
 	globalRelationships := make([]refContext, len(q.GlobalRelationships))
@@ -621,6 +821,7 @@ func (q QueryResult) String() string {
 		globalRelationships[i] = refContext{
 			context:  relationship.String(),
 			refCount: relationship.RefCount,
+			rank:     relationship.Rank,
 		}
 	}
 	localRelationships := make([]refContext, len(q.LocalRelationships))
@@ -628,10 +829,14 @@ func (q QueryResult) String() string {
 		localRelationships[i] = refContext{
 			context:  relationship.String(),
 			refCount: relationship.RefCount,
+			rank:     relationship.Rank,
 		}
 	}
 	relationships := combineContexts(
-		[]string{"id", "source", "target", "keywords", "description", "weight", "ref_count", "created_at"},
+		[]string{
+			"id", "source", "target", "keywords", "description", "weight", "ref_count", "created_at", "properties",
+			"valid_from", "valid_to",
+		},
 		globalRelationships, localRelationships)
 
 	globalSources := make([]refContext, len(q.GlobalSources))
@@ -650,6 +855,35 @@ func (q QueryResult) String() string {
 	}
 	sources := combineContexts([]string{"id", "content", "ref_count"}, globalSources, localSources)
 
+	// The claims section is left out when there are no claims, so the context of storages
+	// without claims is unchanged.
+	claimsSection := ""
+	if len(q.Claims) > 0 {
+		claims := "id,subject,object,type,status,description,start_date,end_date,source_id\n"
+		for i, claim := range q.Claims {
+			claims += fmt.Sprintf("%q,%s\n", strconv.Itoa(i), claim.String())
+		}
+		claimsSection = `
+-----Claims-----
+` + threeBacktick("csv") + `
+` + claims + `
+` + threeBacktick("")
+	}
+
+	// The references section is left out when the documents of the sources are unknown.
+	referencesSection := ""
+	if references := q.References(); len(references) > 0 {
+		refs := "id,document_id,origin_type,origin_location,source_ids\n"
+		for _, reference := range references {
+			refs += reference.String() + "\n"
+		}
+		referencesSection = `
+-----References-----
+` + threeBacktick("csv") + `
+` + refs + `
+` + threeBacktick("")
+	}
+
 	return fmt.Sprintf(`
 -----Entities-----
 `+threeBacktick("csv")+`
@@ -662,21 +896,43 @@ func (q QueryResult) String() string {
 -----Sources-----
 `+threeBacktick("csv")+`
 %s
-`+threeBacktick(""), entities, relationships, sources)
+`+threeBacktick("")+`%s%s`, entities, relationships, sources, claimsSection, referencesSection)
 }
 
 // String returns a CSV-formatted string representation of the EntityContext.
 func (e EntityContext) String() string {
 	refStr := strconv.Itoa(e.RefCount)
-	return fmt.Sprintf("%q,%q,%q,%q,%q", e.Name, e.Type, e.Description, refStr, e.CreatedAt)
+	return fmt.Sprintf("%q,%q,%q,%q,%q,%q", e.Name, e.Type, e.Description, refStr, e.CreatedAt,
+		propertiesString(e.Properties))
 }
 
 // String returns a CSV-formatted string representation of the RelationshipContext.
 func (r RelationshipContext) String() string {
 	weightStr := strconv.FormatFloat(r.Weight, 'f', 2, 64)
 	refStr := strconv.Itoa(r.RefCount)
-	return fmt.Sprintf("%q,%q,%q,%q,%q,%q,%q",
-		r.Source, r.Target, r.Keywords, r.Description, weightStr, refStr, r.CreatedAt)
+	return fmt.Sprintf("%q,%q,%q,%q,%q,%q,%q,%q,%q,%q",
+		r.Source, r.Target, r.Keywords, r.Description, weightStr, refStr, r.CreatedAt,
+		propertiesString(r.Properties), dateString(r.ValidFrom), dateString(r.ValidTo))
+}
+
+// propertiesString returns the properties as a JSON object, or an empty string if there are none.
+func propertiesString(properties map[string]any) string {
+	if len(properties) == 0 {
+		return ""
+	}
+	bs, err := json.Marshal(properties)
+	if err != nil {
+		return ""
+	}
+	return string(bs)
+}
+
+// dateString returns the date of t, or an empty string if it's zero.
+func dateString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(validityDateLayouts[0])
 }
 
 // String returns a CSV-formatted string representation of the SourceContext.
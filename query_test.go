@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -305,6 +306,605 @@ func TestQuery(t *testing.T) {
 			t.Errorf("Expected 0 global entities, got %d", len(result.GlobalEntities))
 		}
 	})
+
+	t.Run("Exact name and alias lookup", func(t *testing.T) {
+		conversations := []golightrag.QueryConversation{
+			{
+				Role:    golightrag.RoleUser,
+				Message: "What does MSFT sell?",
+			},
+		}
+
+		keywordExtraction := map[string][]string{
+			"high_level_keywords": {"Products"},
+			"low_level_keywords":  {"msft", "Windows"},
+		}
+		keywordExtractionJSON, _ := json.Marshal(keywordExtraction)
+
+		mockLLM := &MockLLM{
+			chatResponse: string(keywordExtractionJSON),
+		}
+
+		handler := &MockQueryHandler{
+			keywordExtractionPromptData: golightrag.KeywordExtractionPromptData{
+				Goal: "Extract keywords",
+			},
+		}
+
+		// The vector search finds nothing, the entities are only found by their name and alias.
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"MICROSOFT": {
+					Name:         "MICROSOFT",
+					Type:         "ORGANIZATION",
+					Descriptions: "Microsoft is a software company.",
+					Aliases:      []string{"MSFT", "MICROSOFT CORPORATION"},
+				},
+				"WINDOWS": {
+					Name:         "WINDOWS",
+					Type:         "PRODUCT",
+					Descriptions: "Windows is an operating system.",
+				},
+			},
+			vectorQueryEntityResults:       []string{},
+			vectorQueryRelationshipResults: [][2]string{},
+		}
+
+		result, err := golightrag.Query(conversations, handler, storage, mockLLM, logger)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		names := make([]string, 0, len(result.LocalEntities))
+		for _, entity := range result.LocalEntities {
+			names = append(names, entity.Name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, []string{"MICROSOFT", "WINDOWS"}) {
+			t.Errorf("Expected MICROSOFT and WINDOWS in the local entities, got %v", names)
+		}
+	})
+	t.Run("Claims of the context entities", func(t *testing.T) {
+		conversations := []golightrag.QueryConversation{
+			{
+				Role:    golightrag.RoleUser,
+				Message: "What did Acme acquire?",
+			},
+		}
+
+		keywordExtraction := map[string][]string{
+			"high_level_keywords": {"Acquisitions"},
+			"low_level_keywords":  {"Acme"},
+		}
+		keywordExtractionJSON, _ := json.Marshal(keywordExtraction)
+
+		mockLLM := &MockLLM{
+			chatResponse: string(keywordExtractionJSON),
+		}
+
+		handler := &MockQueryHandler{
+			keywordExtractionPromptData: golightrag.KeywordExtractionPromptData{
+				Goal: "Extract keywords",
+			},
+		}
+
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"ACME": {
+					Name:         "ACME",
+					Type:         "ORGANIZATION",
+					Descriptions: "Acme is a company.",
+				},
+			},
+			claims: map[string]golightrag.Claim{
+				"doc-chunk-1-claim-0": {
+					ID:        "doc-chunk-1-claim-0",
+					Subject:   "ACME",
+					Type:      "REGULATORY_FINE",
+					Status:    golightrag.ClaimStatusSuspected,
+					StartDate: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+					SourceID:  "doc-chunk-1",
+				},
+				"doc-chunk-0-claim-0": {
+					ID:        "doc-chunk-0-claim-0",
+					Subject:   "WIDGETS INC",
+					Object:    "ACME",
+					Type:      "ACQUISITION",
+					Status:    golightrag.ClaimStatusTrue,
+					StartDate: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+					SourceID:  "doc-chunk-0",
+				},
+				"doc-chunk-2-claim-0": {
+					ID:       "doc-chunk-2-claim-0",
+					Subject:  "GLOBEX",
+					Type:     "LAWSUIT",
+					SourceID: "doc-chunk-2",
+				},
+			},
+			vectorQueryEntityResults:       []string{"ACME"},
+			vectorQueryRelationshipResults: [][2]string{},
+		}
+
+		result, err := golightrag.Query(conversations, handler, storage, mockLLM, logger)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		ids := make([]string, 0, len(result.Claims))
+		for _, claim := range result.Claims {
+			ids = append(ids, claim.ID)
+		}
+		if !slices.Equal(ids, []string{"doc-chunk-0-claim-0", "doc-chunk-1-claim-0"}) {
+			t.Errorf("Expected the claims of ACME ordered by start date, got %v", ids)
+		}
+
+		output := result.String()
+		if !strings.Contains(output, "-----Claims-----") ||
+			!strings.Contains(output, `"WIDGETS INC","ACME","ACQUISITION","TRUE","","2021-03-01"`) {
+			t.Errorf("Expected the claims in the context, got %s", output)
+		}
+	})
+
+	t.Run("Ranking by centrality", func(t *testing.T) {
+		conversations := []golightrag.QueryConversation{
+			{
+				Role:    golightrag.RoleUser,
+				Message: "Who are Bob and Frank?",
+			},
+		}
+
+		keywordExtraction := map[string][]string{
+			"high_level_keywords": {"People"},
+			"low_level_keywords":  {"Bob", "Frank"},
+		}
+		keywordExtractionJSON, _ := json.Marshal(keywordExtraction)
+
+		handler := &MockQueryHandler{
+			keywordExtractionPromptData: golightrag.KeywordExtractionPromptData{
+				Goal: "Extract keywords",
+			},
+		}
+
+		// BOB is a leaf of a star centered on ANN, and FRANK is paired with HANK.
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"BOB":   {Name: "BOB", Type: "PERSON", PageRank: 0.1},
+				"FRANK": {Name: "FRANK", Type: "PERSON", PageRank: 0.3},
+				"ANN":   {Name: "ANN", Type: "PERSON"},
+				"CAROL": {Name: "CAROL", Type: "PERSON"},
+				"DAVE":  {Name: "DAVE", Type: "PERSON"},
+				"HANK":  {Name: "HANK", Type: "PERSON"},
+			},
+			relationships: map[string]golightrag.GraphRelationship{
+				"ANN:BOB":    {SourceEntity: "ANN", TargetEntity: "BOB", Weight: 1},
+				"ANN:CAROL":  {SourceEntity: "ANN", TargetEntity: "CAROL", Weight: 1},
+				"ANN:DAVE":   {SourceEntity: "ANN", TargetEntity: "DAVE", Weight: 1},
+				"FRANK:HANK": {SourceEntity: "FRANK", TargetEntity: "HANK", Weight: 1},
+			},
+			vectorQueryEntityResults:       []string{"BOB", "FRANK"},
+			vectorQueryRelationshipResults: [][2]string{},
+			entityRelationshipCountMap: map[string]int{
+				"BOB":   4,
+				"FRANK": 1,
+			},
+		}
+
+		ranks := func(ranking golightrag.Ranking) map[string]float64 {
+			t.Helper()
+			mockLLM := &MockLLM{chatResponse: string(keywordExtractionJSON)}
+			result, err := golightrag.Query(conversations, handler, storage, mockLLM, logger,
+				golightrag.WithRanking(ranking))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			ranks := make(map[string]float64)
+			for _, entity := range result.LocalEntities {
+				ranks[entity.Name] = entity.Rank
+			}
+			return ranks
+		}
+
+		if r := ranks(golightrag.Ranking{Degree: 1}); r["BOB"] != 1 || r["FRANK"] != 0.25 {
+			t.Errorf("Expected the degrees divided by the maximum, got %v", r)
+		}
+		if r := ranks(golightrag.Ranking{Degree: 1, PageRank: 2}); r["FRANK"] <= r["BOB"] {
+			t.Errorf("Expected the stored PageRank to outweigh the degree, got %v", r)
+		}
+		// The PageRank seeded from BOB spreads to the other leaves of the star, while the one
+		// seeded from FRANK comes back from HANK.
+		if r := ranks(golightrag.Ranking{PersonalizedPageRank: 1}); r["FRANK"] != 1 || r["BOB"] >= 1 {
+			t.Errorf("Expected FRANK to rank first with the personalized PageRank, got %v", r)
+		}
+	})
+
+	t.Run("As-of query", func(t *testing.T) {
+		conversations := []golightrag.QueryConversation{
+			{
+				Role:    golightrag.RoleUser,
+				Message: "Where did Alice work?",
+			},
+		}
+
+		keywordExtraction := map[string][]string{
+			"high_level_keywords": {"Employment"},
+			"low_level_keywords":  {},
+		}
+		keywordExtractionJSON, _ := json.Marshal(keywordExtraction)
+
+		handler := &MockQueryHandler{
+			keywordExtractionPromptData: golightrag.KeywordExtractionPromptData{
+				Goal: "Extract keywords",
+			},
+		}
+
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"ALICE":  {Name: "ALICE", Type: "PERSON", SourceIDs: "chunk-0"},
+				"ACME":   {Name: "ACME", Type: "ORGANIZATION", SourceIDs: "chunk-0"},
+				"GLOBEX": {Name: "GLOBEX", Type: "ORGANIZATION", SourceIDs: "chunk-1"},
+			},
+			relationships: map[string]golightrag.GraphRelationship{
+				"ALICE:ACME": {
+					SourceEntity: "ALICE",
+					TargetEntity: "ACME",
+					Descriptions: "Alice rejoined Acme.",
+					SourceIDs:    "chunk-2",
+					ValidFrom:    time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+					History: []golightrag.RelationshipVersion{
+						{
+							Descriptions: "Alice worked at Acme.",
+							SourceIDs:    "chunk-0",
+							ValidFrom:    time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC),
+							ValidTo:      time.Date(2015, 12, 31, 0, 0, 0, 0, time.UTC),
+						},
+					},
+				},
+				"ALICE:GLOBEX": {
+					SourceEntity: "ALICE",
+					TargetEntity: "GLOBEX",
+					Descriptions: "Alice consulted for Globex.",
+					SourceIDs:    "chunk-1",
+					ValidFrom:    time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+					ValidTo:      time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			vectorQueryRelationshipResults: [][2]string{{"ALICE", "ACME"}, {"ALICE", "GLOBEX"}},
+			sources: map[string]golightrag.Source{
+				"chunk-0": {ID: "chunk-0", Content: "Alice worked at Acme from 2010 to 2015."},
+				"chunk-1": {ID: "chunk-1", Content: "Alice consulted for Globex until 2019."},
+				"chunk-2": {ID: "chunk-2", Content: "Alice rejoined Acme in March 2020."},
+			},
+		}
+
+		query := func(asOf time.Time) golightrag.QueryResult {
+			t.Helper()
+			mockLLM := &MockLLM{chatResponse: string(keywordExtractionJSON)}
+			result, err := golightrag.Query(conversations, handler, storage, mockLLM, logger, golightrag.WithAsOf(asOf))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			return result
+		}
+
+		result := query(time.Date(2012, 6, 1, 0, 0, 0, 0, time.UTC))
+		if len(result.GlobalRelationships) != 1 || result.GlobalRelationships[0].Description != "Alice worked at Acme." {
+			t.Fatalf("Expected the 2010 version of ALICE-ACME only, got %+v", result.GlobalRelationships)
+		}
+		for _, entity := range result.GlobalEntities {
+			if entity.Name == "GLOBEX" {
+				t.Errorf("Expected GLOBEX to be left out in 2012")
+			}
+		}
+		if len(result.GlobalSources) != 1 || result.GlobalSources[0].SourceId != "chunk-0" {
+			t.Errorf("Expected the source of the 2010 version only, got %+v", result.GlobalSources)
+		}
+		if !strings.Contains(result.String(), `"2010-01-01","2015-12-31"`) {
+			t.Errorf("Expected the validity in the context, got %s", result.String())
+		}
+
+		// ValidTo includes its whole day.
+		result = query(time.Date(2019, 12, 31, 18, 0, 0, 0, time.UTC))
+		if len(result.GlobalRelationships) != 1 || result.GlobalRelationships[0].Target != "GLOBEX" {
+			t.Errorf("Expected ALICE-GLOBEX only at the end of 2019, got %+v", result.GlobalRelationships)
+		}
+
+		result = query(time.Time{})
+		if len(result.GlobalRelationships) != 2 {
+			t.Errorf("Expected every relationship without an as-of time, got %+v", result.GlobalRelationships)
+		}
+	})
+
+	t.Run("Citations and references", func(t *testing.T) {
+		conversations := []golightrag.QueryConversation{
+			{
+				Role:    golightrag.RoleUser,
+				Message: "Who does Alice work with?",
+			},
+		}
+
+		keywordExtraction := map[string][]string{
+			"high_level_keywords": {"Collaboration"},
+			"low_level_keywords":  {"Alice"},
+		}
+		keywordExtractionJSON, _ := json.Marshal(keywordExtraction)
+		mockLLM := &MockLLM{chatResponse: string(keywordExtractionJSON)}
+
+		handler := &MockQueryHandler{
+			keywordExtractionPromptData: golightrag.KeywordExtractionPromptData{
+				Goal: "Extract keywords",
+			},
+		}
+
+		docA := golightrag.Origin{Type: golightrag.SourceTypeURL, Location: "https://example.com/a"}
+		docB := golightrag.Origin{Type: golightrag.SourceTypeFile, Location: "/docs/b.md"}
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"ALICE": {Name: "ALICE", Type: "PERSON", SourceIDs: "doc-a-chunk-0<SEP>doc-b-chunk-1"},
+				// Sources stored without their document are cited by the document of their ID.
+				"BOB": {Name: "BOB", Type: "PERSON", SourceIDs: "legacy-chunk-3"},
+			},
+			relationships: map[string]golightrag.GraphRelationship{
+				"ALICE:BOB": {
+					SourceEntity: "ALICE",
+					TargetEntity: "BOB",
+					Descriptions: "Alice works with Bob.",
+					SourceIDs:    "doc-a-chunk-0<SEP>legacy-chunk-3",
+				},
+			},
+			vectorQueryEntityResults:       []string{"ALICE"},
+			vectorQueryRelationshipResults: [][2]string{{"ALICE", "BOB"}},
+			sources: map[string]golightrag.Source{
+				"doc-a-chunk-0":  {ID: "doc-a-chunk-0", Content: "Alice works with Bob.", DocumentID: "doc-a", Origin: docA},
+				"doc-b-chunk-1":  {ID: "doc-b-chunk-1", Content: "Alice is an engineer.", DocumentID: "doc-b", Origin: docB},
+				"legacy-chunk-3": {ID: "legacy-chunk-3", Content: "Bob is a designer."},
+			},
+		}
+
+		result, err := golightrag.Query(conversations, handler, storage, mockLLM, logger)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(result.LocalEntities) != 1 {
+			t.Fatalf("Expected ALICE in the local context, got %+v", result.LocalEntities)
+		}
+		alice := result.LocalEntities[0]
+		if !slices.Equal(alice.SourceIDs, []string{"doc-a-chunk-0", "doc-b-chunk-1"}) || len(alice.Citations) != 2 ||
+			alice.Citations[1].DocumentID != "doc-b" || alice.Citations[1].Origin != docB {
+			t.Errorf("Expected ALICE to cite its chunks and their documents, got %+v", alice)
+		}
+
+		// doc-a is cited by both ALICE contexts, the relationship and both of its sources.
+		want := []golightrag.Reference{
+			{Number: 1, DocumentID: "doc-a", Origin: docA, SourceIDs: []string{"doc-a-chunk-0"}},
+			{Number: 2, DocumentID: "doc-b", Origin: docB, SourceIDs: []string{"doc-b-chunk-1"}},
+			{Number: 3, DocumentID: "legacy", SourceIDs: []string{"legacy-chunk-3"}},
+		}
+		references := result.References()
+		if len(references) != len(want) {
+			t.Fatalf("Expected %d references, got %+v", len(want), references)
+		}
+		for i, reference := range references {
+			if reference.Number != want[i].Number || reference.DocumentID != want[i].DocumentID ||
+				reference.Origin != want[i].Origin || !slices.Equal(reference.SourceIDs, want[i].SourceIDs) {
+				t.Errorf("Expected reference %d to be %+v, got %+v", i, want[i], reference)
+			}
+		}
+		if !strings.Contains(result.String(), `"1","doc-a","url","https://example.com/a","doc-a-chunk-0"`) {
+			t.Errorf("Expected the references in the context, got %s", result.String())
+		}
+	})
+
+	t.Run("Metadata filter", func(t *testing.T) {
+		conversations := []golightrag.QueryConversation{
+			{
+				Role:    golightrag.RoleUser,
+				Message: "Who did Alice work with in 2024?",
+			},
+		}
+
+		keywordExtraction := map[string][]string{
+			"high_level_keywords": {"Collaboration"},
+			"low_level_keywords":  {"Alice"},
+		}
+		keywordExtractionJSON, _ := json.Marshal(keywordExtraction)
+		mockLLM := &MockLLM{chatResponse: string(keywordExtractionJSON)}
+
+		handler := &MockQueryHandler{
+			keywordExtractionPromptData: golightrag.KeywordExtractionPromptData{
+				Goal: "Extract keywords",
+			},
+		}
+
+		year2023 := map[string][]string{"year": {"2023"}}
+		year2024 := map[string][]string{"year": {"2024"}}
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"ALICE": {
+					Name:      "ALICE",
+					Type:      "PERSON",
+					SourceIDs: "doc-2023-chunk-0<SEP>doc-2024-chunk-0",
+					Metadata:  map[string][]string{"year": {"2023", "2024"}},
+				},
+				"BOB":   {Name: "BOB", Type: "PERSON", SourceIDs: "doc-2023-chunk-0", Metadata: year2023},
+				"CAROL": {Name: "CAROL", Type: "PERSON", SourceIDs: "doc-2024-chunk-0", Metadata: year2024},
+			},
+			relationships: map[string]golightrag.GraphRelationship{
+				"ALICE:BOB": {
+					SourceEntity: "ALICE",
+					TargetEntity: "BOB",
+					Descriptions: "Alice worked with Bob.",
+					SourceIDs:    "doc-2023-chunk-0",
+					Metadata:     year2023,
+				},
+				"ALICE:CAROL": {
+					SourceEntity: "ALICE",
+					TargetEntity: "CAROL",
+					Descriptions: "Alice works with Carol.",
+					SourceIDs:    "doc-2024-chunk-0",
+					Metadata:     year2024,
+				},
+			},
+			entityRelatedEntitiesMap: map[string][]golightrag.GraphEntity{
+				"ALICE": {{Name: "BOB"}, {Name: "CAROL"}},
+			},
+			vectorQueryEntityResults:       []string{"ALICE", "BOB"},
+			vectorQueryRelationshipResults: [][2]string{{"ALICE", "BOB"}, {"ALICE", "CAROL"}},
+			sources: map[string]golightrag.Source{
+				"doc-2023-chunk-0": {
+					ID:         "doc-2023-chunk-0",
+					Content:    "Alice worked with Bob.",
+					DocumentID: "doc-2023",
+					Metadata:   map[string]string{"year": "2023"},
+				},
+				"doc-2024-chunk-0": {
+					ID:         "doc-2024-chunk-0",
+					Content:    "Alice works with Carol.",
+					DocumentID: "doc-2024",
+					Metadata:   map[string]string{"year": "2024"},
+				},
+			},
+		}
+
+		// Storages without filtered vector search get the same context, pruned after the search.
+		for _, s := range []golightrag.Storage{storage, struct{ golightrag.Storage }{storage}} {
+			result, err := golightrag.Query(conversations, handler, s, mockLLM, logger,
+				golightrag.WithMetadataFilter(golightrag.MetadataFilter{"year": "2024"}))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			for _, entity := range slices.Concat(result.LocalEntities, result.GlobalEntities) {
+				if entity.Name == "BOB" {
+					t.Errorf("Expected BOB to be filtered out, got %+v", entity)
+				}
+				if entity.Name == "ALICE" && !slices.Equal(entity.SourceIDs, []string{"doc-2024-chunk-0"}) {
+					t.Errorf("Expected ALICE to keep the matching source only, got %v", entity.SourceIDs)
+				}
+			}
+			relationships := slices.Concat(result.LocalRelationships, result.GlobalRelationships)
+			if len(relationships) == 0 {
+				t.Errorf("Expected the relationship with CAROL in the context")
+			}
+			for _, relationship := range relationships {
+				if relationship.Target != "CAROL" {
+					t.Errorf("Expected the relationship with BOB to be filtered out, got %+v", relationship)
+				}
+			}
+			sources := slices.Concat(result.LocalSources, result.GlobalSources)
+			if len(sources) == 0 {
+				t.Errorf("Expected the matching source in the context")
+			}
+			for _, source := range sources {
+				if source.DocumentID != "doc-2024" {
+					t.Errorf("Expected the sources of doc-2024 only, got %+v", source)
+				}
+			}
+		}
+	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		conversations := []golightrag.QueryConversation{
+			{
+				Role:    golightrag.RoleUser,
+				Message: "Tell me about Entity1",
+			},
+		}
+
+		keywordExtractionJSON, _ := json.Marshal(map[string][]string{
+			"high_level_keywords": {"Knowledge"},
+			"low_level_keywords":  {"Information"},
+		})
+		mockLLM := &MockLLM{chatResponse: string(keywordExtractionJSON)}
+
+		handler := &MockQueryHandler{
+			keywordExtractionPromptData: golightrag.KeywordExtractionPromptData{
+				Goal: "Extract keywords",
+			},
+		}
+
+		storage := &MockStorage{
+			entities: map[string]golightrag.GraphEntity{
+				"ENTITY1": {Name: "ENTITY1", Type: "PERSON", Descriptions: "Description of Entity1",
+					SourceIDs: "doc-1-chunk-0"},
+				"ENTITY2": {Name: "ENTITY2", Type: "ORGANIZATION", Descriptions: "Description of Entity2",
+					SourceIDs: "doc-1-chunk-0"},
+			},
+			relationships: map[string]golightrag.GraphRelationship{
+				"ENTITY1:ENTITY2": {
+					SourceEntity: "ENTITY1",
+					TargetEntity: "ENTITY2",
+					Descriptions: "Entity1 is related to Entity2",
+					Keywords:     []string{"RELATED_TO"},
+					Weight:       1.0,
+					SourceIDs:    "doc-1-chunk-0",
+				},
+			},
+			vectorQueryEntityResults:       []string{"ENTITY1"},
+			vectorQueryRelationshipResults: [][2]string{{"ENTITY1", "ENTITY2"}},
+			sources: map[string]golightrag.Source{
+				"doc-1-chunk-0": {ID: "doc-1-chunk-0", Content: "Content about Entity1 and Entity2"},
+			},
+		}
+
+		var keywords golightrag.KeywordExtractionEvent
+		var stages []golightrag.RetrievalStage
+		hooks := golightrag.Hooks{
+			AfterKeywordExtraction: func(e *golightrag.KeywordExtractionEvent) error {
+				// An exact entity name among the low-level keywords finds the entity.
+				e.LowLevelKeywords = append(e.LowLevelKeywords, "ENTITY2")
+				keywords = *e
+				return nil
+			},
+			AfterRetrieval: func(e *golightrag.RetrievalEvent) error {
+				stages = append(stages, e.Stage)
+				switch e.Stage {
+				case golightrag.RetrievalStageLocal:
+					e.Sources = nil
+				case golightrag.RetrievalStageGlobal:
+					e.Relationships = nil
+				case golightrag.RetrievalStageClaims:
+				}
+				return nil
+			},
+		}
+
+		result, err := golightrag.Query(conversations, handler, storage, mockLLM, logger, golightrag.WithHooks(hooks))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if keywords.Query != "Tell me about Entity1" ||
+			!slices.Equal(keywords.LowLevelKeywords, []string{"Information", "ENTITY2"}) {
+			t.Errorf("Unexpected keyword extraction event %+v", keywords)
+		}
+		if !slices.ContainsFunc(result.LocalEntities, func(e golightrag.EntityContext) bool {
+			return e.Name == "ENTITY2"
+		}) {
+			t.Errorf("Expected the keyword added by the hook to find ENTITY2, got %+v", result.LocalEntities)
+		}
+		wantStages := []golightrag.RetrievalStage{
+			golightrag.RetrievalStageLocal, golightrag.RetrievalStageGlobal, golightrag.RetrievalStageClaims,
+		}
+		if !slices.Equal(stages, wantStages) {
+			t.Errorf("Expected retrieval stages %v, got %v", wantStages, stages)
+		}
+		if len(result.LocalSources) != 0 || len(result.GlobalRelationships) != 0 {
+			t.Errorf("Expected the items removed by the hook to be left out, got %d local sources and %d global "+
+				"relationships", len(result.LocalSources), len(result.GlobalRelationships))
+		}
+		if len(result.GlobalEntities) == 0 {
+			t.Error("Expected the global entities to be kept")
+		}
+
+		// A hook returning an error fails the query.
+		errHook := errors.New("hook error")
+		hooks.AfterRetrieval = func(*golightrag.RetrievalEvent) error { return errHook }
+		_, err = golightrag.Query(conversations, handler, storage, mockLLM, logger, golightrag.WithHooks(hooks))
+		if !errors.Is(err, errHook) {
+			t.Errorf("Expected the hook error, got %v", err)
+		}
+	})
 }
 
 func TestQueryResultString(t *testing.T) {
@@ -26,16 +26,21 @@ type GraphStorage interface {
 	// GraphEntity retrieves a single entity by name from the graph storage.
 	// Returns ErrEntityNotFound if the entity doesn't exist.
 	GraphEntity(name string) (GraphEntity, error)
-	// GraphRelationship retrieves a relationship between sourceEntity and targetEntity.
+	// GraphRelationship retrieves a relationship between sourceEntity and targetEntity, in either
+	// direction, with SourceEntity and TargetEntity set to the requested ones.
 	// Returns ErrRelationshipNotFound if the relationship doesn't exist.
 	GraphRelationship(sourceEntity, targetEntity string) (GraphRelationship, error)
 
 	// GraphUpsertEntity creates a new entity or updates an existing entity in the graph storage.
-	// If the entity already exists, it should merge the new data with existing data.
+	// If the entity already exists, all its fields are replaced by the given ones, which the
+	// caller has already merged with the existing data, so the fields left unset are cleared.
+	// Only CreatedAt is kept, as the time the entity was created.
 	GraphUpsertEntity(entity GraphEntity) error
 	// GraphUpsertRelationship creates a new relationship or updates an existing relationship
-	// between two entities in the graph storage.
-	// If the relationship already exists, it should merge the new data with existing data.
+	// between two entities in the graph storage. Both entities must already exist.
+	// Relationships are undirected: an existing relationship between the two entities is
+	// updated whichever way round they are given, and its fields and CreatedAt are handled
+	// like in GraphUpsertEntity.
 	GraphUpsertRelationship(relationship GraphRelationship) error
 
 	// GraphEntities batch retrieves multiple entities by their names.
@@ -58,6 +63,16 @@ type GraphStorage interface {
 	GraphRelatedEntities(names []string) (map[string][]GraphEntity, error)
 }
 
+// GraphAliasStorage is implemented by graph storages that can look up entities by their
+// aliases. Insert uses it to resolve extracted names that are known aliases, and Query to
+// find the entities whose alias is one of the query keywords.
+type GraphAliasStorage interface {
+	// GraphEntitiesByAlias batch retrieves the entities having one of the aliases.
+	// Returns a map with the aliases as keys and the entities as values.
+	// If no entity has an alias, it should be omitted from the result map.
+	GraphEntitiesByAlias(aliases []string) (map[string]GraphEntity, error)
+}
+
 // VectorStorage defines the interface for vector database operations.
 // It provides methods to query and store entities and relationships
 // in a vector space for semantic search capabilities.
@@ -84,8 +99,9 @@ type VectorStorage interface {
 // KeyValueStorage defines the interface for key-value storage operations.
 // It provides methods to access and store source documents.
 type KeyValueStorage interface {
-	// KVSource retrieves a source document chunk by its ID.
-	// Returns an error if the source doesn't exist or can't be retrieved.
+	// KVSource retrieves a source document chunk by its ID, with the DocumentID and Origin it
+	// was upserted with.
+	// Returns ErrSourceNotFound if the source doesn't exist.
 	KVSource(id string) (Source, error)
 	KVUnprocessed(id string) (string, error)
 	// KVUpsertSources creates or updates multiple source document chunks at once.
@@ -111,6 +127,13 @@ type Source struct {
 	Content    string
 	TokenSize  int
 	OrderIndex int
+	// DocumentID is the ID of the document the chunk belongs to, and Origin where the document
+	// was sourced from.
+	DocumentID string
+	Origin     Origin
+	// Metadata is the metadata of the document the chunk belongs to, matched by
+	// WithMetadataFilter.
+	Metadata map[string]string
 }
 
 // SourceType defines the type of the content's origin.
@@ -154,6 +177,8 @@ type ContentChunk struct {
 	CreatedAt time.Time `json:"created_at"`
 	// Origin describes where the content was sourced from.
 	Origin Origin `json:"origin"`
+	// Metadata is the metadata of the content, such as its tags, author or tenant.
+	Metadata map[string]string `json:"metadata,omitempty"`
 	// Embeddings are the vector embeddings for this chunk from different models.
 	Embeddings []ContentEmbedding `json:"embeddings,omitempty"`
 }
@@ -177,18 +202,34 @@ type ContentEmbedding struct {
 
 // GraphEntity represents an entity in the knowledge graph.
 // It contains information about the entity's name, type,
-// descriptions, sources, and creation timestamp.
+// descriptions, sources, and creation and update timestamps.
 type GraphEntity struct {
 	Name         string `json:"entity_name"`
 	Type         string `json:"entity_type"`
 	Descriptions string `json:"entity_description"`
 	SourceIDs    string
-	CreatedAt    time.Time
+	// Aliases are the other names of the entity merged into it by the entity resolution.
+	Aliases []string
+	// Properties are the attributes of the entity declared by the Ontology of the handler, with
+	// string, float64 or bool values.
+	Properties map[string]any `json:"entity_attributes,omitempty"`
+	// PageRank and Betweenness are the centrality scores of the entity in the graph, computed
+	// by UpdateCentrality. They are zero until it runs, and kept as the entity is merged.
+	PageRank    float64 `json:"-"`
+	Betweenness float64 `json:"-"`
+	// Metadata is the union of the metadata of the sources the entity was extracted from, with
+	// the values of each key sorted.
+	Metadata map[string][]string `json:"-"`
+	// CreatedAt is the time the entity was first extracted, and UpdatedAt the time it was last
+	// merged with a new extraction. UpdateCount is the number of merges since its creation.
+	CreatedAt   time.Time
+	UpdatedAt   time.Time `json:"-"`
+	UpdateCount int       `json:"-"`
 }
 
 // GraphRelationship represents a relationship between two entities in the knowledge graph.
 // It contains information about the source and target entities,
-// relationship weight, descriptions, keywords, sources, and creation timestamp.
+// relationship weight, descriptions, keywords, sources, and creation and update timestamps.
 type GraphRelationship struct {
 	SourceEntity string   `json:"source_entity"`
 	TargetEntity string   `json:"target_entity"`
@@ -196,7 +237,36 @@ type GraphRelationship struct {
 	Descriptions string   `json:"relationship_description"`
 	Keywords     []string `json:"relationship_keywords"`
 	SourceIDs    string
-	CreatedAt    time.Time
+	// Properties are the properties of the relationship, such as its predicate under
+	// PredicateProperty when the Ontology of the handler declares predicates.
+	Properties map[string]any `json:"-"`
+	// ValidFrom and ValidTo are the period the relationship holds, as stated by the text it was
+	// extracted from. A zero ValidFrom holds since always, and a zero ValidTo still holds.
+	// ValidTo is inclusive of its whole day.
+	ValidFrom time.Time `json:"-"`
+	ValidTo   time.Time `json:"-"`
+	// History is the previous versions of the relationship, oldest first, recorded when a merge
+	// changes its validity.
+	History []RelationshipVersion `json:"-"`
+	// Metadata is the union of the metadata of the sources of the relationship, like that of
+	// GraphEntity.
+	Metadata map[string][]string `json:"-"`
+	// CreatedAt, UpdatedAt and UpdateCount track the merges of the relationship like those of
+	// GraphEntity.
+	CreatedAt   time.Time
+	UpdatedAt   time.Time `json:"-"`
+	UpdateCount int       `json:"-"`
+}
+
+// RelationshipVersion is a previous version of a GraphRelationship.
+type RelationshipVersion struct {
+	Descriptions string
+	SourceIDs    string
+	ValidFrom    time.Time
+	ValidTo      time.Time
+	// CreatedAt is the time the version was recorded, the UpdatedAt of the relationship when it
+	// was replaced, or its CreatedAt if it has no UpdatedAt. It's zero if neither is known.
+	CreatedAt time.Time
 }
 
 var (
@@ -204,6 +274,10 @@ var (
 	ErrEntityNotFound = errors.New("entity not found")
 	// ErrRelationshipNotFound is returned when a relationship is not found in the storage.
 	ErrRelationshipNotFound = errors.New("relationship not found")
+	// ErrSourceNotFound is returned when a source is not found in the storage.
+	ErrSourceNotFound = errors.New("source not found")
+	// ErrInvalidOption is returned when an option is passed to a function it doesn't apply to.
+	ErrInvalidOption = errors.New("invalid option")
 )
 
 func cleanContent(content string) string {
@@ -3,9 +3,11 @@ package golightrag_test
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/llm"
 )
 
 type MockDocumentHandler struct {
@@ -18,6 +20,7 @@ type MockDocumentHandler struct {
 	backoffDuration  time.Duration
 	gleanCount       int
 	maxTokenLen      int
+	tokenizer        llm.Tokenizer
 }
 
 type MockQueryHandler struct {
@@ -59,6 +62,9 @@ type MockStorage struct {
 	vectorQueryRelationshipResults [][2]string
 	entityRelatedEntitiesMap       map[string][]golightrag.GraphEntity
 	entityRelationshipCountMap     map[string]int
+	claims                         map[string]golightrag.Claim
+	communities                    map[string]golightrag.Community
+	changes                        map[string][]golightrag.Change
 
 	vectorQueryEntityErr       error
 	vectorQueryRelationshipErr error
@@ -95,10 +101,30 @@ func (m *MockDocumentHandler) MaxSummariesTokenLength() int {
 	return m.maxTokenLen
 }
 
+func (m *MockDocumentHandler) Tokenizer() llm.Tokenizer {
+	return m.tokenizer
+}
+
 func (m *MockQueryHandler) KeywordExtractionPromptData() golightrag.KeywordExtractionPromptData {
 	return m.keywordExtractionPromptData
 }
 
+// MockStructuredLLM is a MockLLM that supports structured output and records the schemas it receives.
+type MockStructuredLLM struct {
+	*MockLLM
+	schemas []llm.Schema
+}
+
+func (m *MockStructuredLLM) SupportsStructuredOutput() bool {
+	return true
+}
+
+func (m *MockStructuredLLM) ChatStructured(messages []string, schema llm.Schema) (string, llm.Usage, error) {
+	m.schemas = append(m.schemas, schema)
+	res, err := m.Chat(messages)
+	return res, llm.Usage{}, err
+}
+
 func (m *MockLLM) Chat(messages []string) (string, error) {
 	// Record this call
 	if m.chatCalls != nil {
@@ -173,7 +199,10 @@ func (m *MockStorage) GraphUpsertEntity(entity golightrag.GraphEntity) error {
 		m.entities = make(map[string]golightrag.GraphEntity)
 	}
 
-	// Store the entity
+	// Store the entity, keeping the creation time of an existing one
+	if existing, ok := m.entities[entity.Name]; ok {
+		entity.CreatedAt = existing.CreatedAt
+	}
 	m.entities[entity.Name] = entity
 	return nil
 }
@@ -188,6 +217,81 @@ func (m *MockStorage) GraphEntities(names []string) (map[string]golightrag.Graph
 	return result, nil
 }
 
+func (m *MockStorage) GraphEntitiesByAlias(aliases []string) (map[string]golightrag.GraphEntity, error) {
+	result := make(map[string]golightrag.GraphEntity)
+	for _, alias := range aliases {
+		for _, entity := range m.entities {
+			if slices.Contains(entity.Aliases, alias) {
+				result[alias] = entity
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *MockStorage) KVUpsertClaims(claims []golightrag.Claim) error {
+	if m.claims == nil {
+		m.claims = make(map[string]golightrag.Claim)
+	}
+	for _, claim := range claims {
+		m.claims[claim.ID] = claim
+	}
+	return nil
+}
+
+func (m *MockStorage) KVClaimsByEntities(names []string) (map[string][]golightrag.Claim, error) {
+	result := make(map[string][]golightrag.Claim)
+	for _, name := range names {
+		for _, claim := range m.claims {
+			if claim.Subject == name || claim.Object == name {
+				result[name] = append(result[name], claim)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *MockStorage) GraphAllEntities() ([]golightrag.GraphEntity, error) {
+	result := make([]golightrag.GraphEntity, 0, len(m.entities))
+	for _, entity := range m.entities {
+		result = append(result, entity)
+	}
+	return result, nil
+}
+
+func (m *MockStorage) GraphAllRelationships() ([]golightrag.GraphRelationship, error) {
+	result := make([]golightrag.GraphRelationship, 0, len(m.relationships))
+	for _, relationship := range m.relationships {
+		result = append(result, relationship)
+	}
+	return result, nil
+}
+
+func (m *MockStorage) KVCommunities() ([]golightrag.Community, error) {
+	result := make([]golightrag.Community, 0, len(m.communities))
+	for _, community := range m.communities {
+		result = append(result, community)
+	}
+	return result, nil
+}
+
+func (m *MockStorage) KVUpsertCommunities(communities []golightrag.Community) error {
+	if m.communities == nil {
+		m.communities = make(map[string]golightrag.Community)
+	}
+	for _, community := range communities {
+		m.communities[community.ID] = community
+	}
+	return nil
+}
+
+func (m *MockStorage) KVDeleteCommunities(ids []string) error {
+	for _, id := range ids {
+		delete(m.communities, id)
+	}
+	return nil
+}
+
 func (m *MockStorage) GraphUpsertRelationship(relationship golightrag.GraphRelationship) error {
 	m.graphUpsertRelationshipCalled = true
 	if m.graphUpsertRelationshipErr != nil {
@@ -198,12 +302,29 @@ func (m *MockStorage) GraphUpsertRelationship(relationship golightrag.GraphRelat
 		m.relationships = make(map[string]golightrag.GraphRelationship)
 	}
 
-	// Store the relationship
+	// Store the relationship, keeping the creation time of an existing one
 	key := fmt.Sprintf("%s:%s", relationship.SourceEntity, relationship.TargetEntity)
+	if existing, ok := m.relationships[key]; ok {
+		relationship.CreatedAt = existing.CreatedAt
+	}
 	m.relationships[key] = relationship
 	return nil
 }
 
+func (m *MockStorage) KVAppendChanges(changes []golightrag.Change) error {
+	if m.changes == nil {
+		m.changes = make(map[string][]golightrag.Change)
+	}
+	for _, change := range changes {
+		m.changes[change.Key] = append(m.changes[change.Key], change)
+	}
+	return nil
+}
+
+func (m *MockStorage) KVChanges(key string) ([]golightrag.Change, error) {
+	return m.changes[key], nil
+}
+
 func (m *MockStorage) GraphRelationships(pairs [][2]string) (map[string]golightrag.GraphRelationship, error) {
 	result := make(map[string]golightrag.GraphRelationship)
 	for _, pair := range pairs {
@@ -259,6 +380,51 @@ func (m *MockStorage) VectorUpsertRelationship(_, _, _ string) error {
 	return m.vectorUpsertRelationshipErr
 }
 
+func (m *MockStorage) VectorUpsertEntityWithMetadata(name, content string, _ map[string][]string) error {
+	return m.VectorUpsertEntity(name, content)
+}
+
+func (m *MockStorage) VectorUpsertRelationshipWithMetadata(
+	source, target, content string, _ map[string][]string,
+) error {
+	return m.VectorUpsertRelationship(source, target, content)
+}
+
+// VectorQueryEntityWithFilter keeps the vector query results whose entity has every value of
+// the filter in its metadata.
+func (m *MockStorage) VectorQueryEntityWithFilter(
+	keywords string, filter golightrag.MetadataFilter,
+) ([]string, error) {
+	names, err := m.VectorQueryEntity(keywords)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		return !metadataContains(m.entities[name].Metadata, filter)
+	}), nil
+}
+
+func (m *MockStorage) VectorQueryRelationshipWithFilter(
+	keywords string, filter golightrag.MetadataFilter,
+) ([][2]string, error) {
+	pairs, err := m.VectorQueryRelationship(keywords)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(slices.Clone(pairs), func(pair [2]string) bool {
+		return !metadataContains(m.relationships[pair[0]+":"+pair[1]].Metadata, filter)
+	}), nil
+}
+
+func metadataContains(metadata map[string][]string, filter golightrag.MetadataFilter) bool {
+	for key, value := range filter {
+		if !slices.Contains(metadata[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
 func (m *MockStorage) VectorQueryEntity(string) ([]string, error) {
 	if m.vectorQueryEntityErr != nil {
 		return nil, m.vectorQueryEntityErr
@@ -0,0 +1,308 @@
+package golightrag
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+// EntityResolution configures the resolution of extracted entities to existing entities with
+// a different name, such as "MICROSOFT CORPORATION" to "MICROSOFT". An extracted entity that
+// resolves to an existing one is merged into it, and its name is recorded as an alias of the
+// existing entity.
+//
+// The candidates of an extracted entity are the entities returned by VectorQueryEntity for its
+// name, with the same type and a name similar enough to the extracted one. Extracted names
+// that are known aliases resolve without a search, if the storage implements
+// GraphAliasStorage. Relationship endpoints that weren't extracted as entities have no type to
+// check, so they only resolve to entities or aliases of the same name.
+//
+// Merges can't be undone, so without Confirm the names must be alike on their own: by default,
+// they must only differ in case, punctuation and spacing.
+type EntityResolution struct {
+	// Candidates is the maximum number of entities returned by VectorQueryEntity considered for
+	// each extracted entity. Defaults to 5.
+	Candidates int
+	// MinSimilarity is the minimum similarity, from 0 to 1, of a candidate's name to the
+	// extracted name. The similarity is the Jaro-Winkler similarity of the names, ignoring case,
+	// punctuation and spacing. When confirming, it's the greater of that and the share of the
+	// shorter name's words found in the other one, which would otherwise resolve "APPLE MUSIC"
+	// to "APPLE". Defaults to 0.85 when confirming, and to 1 otherwise.
+	MinSimilarity float64
+	// Confirm asks the LLM whether each candidate is the same entity before merging, using the
+	// LLM routed to Routing.EntityResolution if set. Lowering MinSimilarity when confirming
+	// lets the LLM resolve names that aren't alike, such as "MSFT".
+	Confirm bool
+}
+
+const (
+	defaultResolutionCandidates           = 5
+	defaultResolutionMinSimilarity        = 1
+	defaultResolutionConfirmMinSimilarity = 0.85
+)
+
+type entityResolutionPromptData struct {
+	Name                 string
+	Type                 string
+	Description          string
+	CandidateName        string
+	CandidateType        string
+	CandidateDescription string
+}
+
+type entityResolver struct {
+	config  EntityResolution
+	storage Storage
+	llm     LLM
+	logger  *slog.Logger
+}
+
+func newEntityResolver(config EntityResolution, storage Storage, llm LLM, logger *slog.Logger) entityResolver {
+	if config.Candidates == 0 {
+		config.Candidates = defaultResolutionCandidates
+	}
+	if config.MinSimilarity == 0 {
+		config.MinSimilarity = defaultResolutionMinSimilarity
+		if config.Confirm {
+			config.MinSimilarity = defaultResolutionConfirmMinSimilarity
+		}
+	}
+	return entityResolver{config: config, storage: storage, llm: llm, logger: logger}
+}
+
+// resolveAll resolves the names of the extracted entities and of the relationships endpoints,
+// and regroups them under the resolved names. The extracted entities keep their name, so it's
+// recorded as an alias when merged. The endpoints are resolved after the entities, so the
+// extracted ones take the resolution checked against their type.
+func (r entityResolver) resolveAll(
+	entities map[string][]GraphEntity,
+	relationships map[string][]GraphRelationship,
+) (map[string][]GraphEntity, map[string][]GraphRelationship, error) {
+	resolved := make(map[string]string)
+	resolve := func(entity GraphEntity) (string, error) {
+		if name, ok := resolved[entity.Name]; ok {
+			return name, nil
+		}
+		name, err := r.resolve(entity)
+		if err != nil {
+			return "", err
+		}
+		resolved[entity.Name] = name
+		return name, nil
+	}
+
+	resolvedEntities := make(map[string][]GraphEntity, len(entities))
+	for name, group := range entities {
+		resolvedName, err := resolve(group[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve entity %s: %w", name, err)
+		}
+		resolvedEntities[resolvedName] = append(resolvedEntities[resolvedName], group...)
+	}
+
+	resolvedRelationships := make(map[string][]GraphRelationship, len(relationships))
+	for _, group := range relationships {
+		for _, relationship := range group {
+			source, err := resolve(GraphEntity{Name: relationship.SourceEntity, Type: UnknownEntityType})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to resolve entity %s: %w", relationship.SourceEntity, err)
+			}
+			target, err := resolve(GraphEntity{Name: relationship.TargetEntity, Type: UnknownEntityType})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to resolve entity %s: %w", relationship.TargetEntity, err)
+			}
+			// A relationship between an entity and one of its aliases says nothing more than
+			// the alias itself.
+			if source == target && relationship.SourceEntity != relationship.TargetEntity {
+				continue
+			}
+			relationship.SourceEntity, relationship.TargetEntity = source, target
+			key := fmt.Sprintf("%s-%s", source, target)
+			resolvedRelationships[key] = append(resolvedRelationships[key], relationship)
+		}
+	}
+
+	return resolvedEntities, resolvedRelationships, nil
+}
+
+// resolve returns the name of the existing entity that entity resolves to, or its own name.
+func (r entityResolver) resolve(entity GraphEntity) (string, error) {
+	if _, err := r.storage.GraphEntity(entity.Name); err == nil {
+		return entity.Name, nil
+	} else if !errors.Is(err, ErrEntityNotFound) {
+		return "", fmt.Errorf("failed to get entity: %w", err)
+	}
+
+	if aliasStorage, ok := r.storage.(GraphAliasStorage); ok {
+		aliased, err := aliasStorage.GraphEntitiesByAlias([]string{entity.Name})
+		if err != nil {
+			return "", fmt.Errorf("failed to get entities by alias: %w", err)
+		}
+		if canonical, ok := aliased[entity.Name]; ok {
+			return canonical.Name, nil
+		}
+	}
+
+	// Without a type, the candidates can't be told apart from distinct entities of similar
+	// names, such as a product named after its company.
+	if entity.Type == UnknownEntityType {
+		return entity.Name, nil
+	}
+
+	names, err := r.storage.VectorQueryEntity(entity.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to query entities: %w", err)
+	}
+	names = names[:min(len(names), r.config.Candidates)]
+	candidates, err := r.storage.GraphEntities(names)
+	if err != nil {
+		return "", fmt.Errorf("failed to batch get entities: %w", err)
+	}
+
+	// The candidates are considered in the order of relevance of the vector search.
+	for _, name := range names {
+		candidate, ok := candidates[name]
+		if !ok || candidate.Name == entity.Name {
+			continue
+		}
+		if entity.Type != candidate.Type {
+			continue
+		}
+		similarity := nameSimilarity(entity.Name, candidate.Name, r.config.Confirm)
+		if similarity < r.config.MinSimilarity {
+			continue
+		}
+		if r.config.Confirm {
+			same, err := r.confirm(entity, candidate)
+			if err != nil {
+				return "", err
+			}
+			if !same {
+				continue
+			}
+		}
+		r.logger.Info("Resolved entity", "entity", entity.Name, "resolved", candidate.Name, "similarity", similarity)
+		return candidate.Name, nil
+	}
+
+	return entity.Name, nil
+}
+
+// confirm asks the LLM whether entity and candidate are the same entity.
+func (r entityResolver) confirm(entity, candidate GraphEntity) (bool, error) {
+	prompt, err := promptTemplate("entity-resolution", entityResolutionPrompt, entityResolutionPromptData{
+		Name:                 entity.Name,
+		Type:                 entity.Type,
+		Description:          entity.Descriptions,
+		CandidateName:        candidate.Name,
+		CandidateType:        candidate.Type,
+		CandidateDescription: candidate.Descriptions,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to generate entity resolution prompt: %w", err)
+	}
+
+	answer, err := r.llm.Chat([]string{prompt})
+	if err != nil {
+		return false, fmt.Errorf("failed to call LLM on entity resolution: %w", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(strings.Trim(strings.Trim(removeThinkTags(answer), `"`), `'`)))
+
+	r.logger.Debug("Entity resolution answer from LLM", "entity", entity.Name, "candidate", candidate.Name,
+		"answer", answer)
+
+	return answer == "yes", nil
+}
+
+// nameSimilarity returns the similarity of two entity names from 0 to 1, as their Jaro-Winkler
+// similarity ignoring case, punctuation and spacing. If containment is true, it's the greater
+// of that and the share of the shorter name's words found in the other one.
+func nameSimilarity(a, b string, containment bool) float64 {
+	a, b = normalizeName(a), normalizeName(b)
+	if a == b && a != "" {
+		return 1
+	}
+	similarity := jaroWinkler(a, b)
+	if containment {
+		similarity = max(similarity, wordContainment(a, b))
+	}
+	return similarity
+}
+
+// normalizeName lowercases name and separates its words by single spaces, dropping
+// punctuation.
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+func wordContainment(a, b string) float64 {
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	if len(wordsA) > len(wordsB) {
+		wordsA, wordsB = wordsB, wordsA
+	}
+	if len(wordsA) == 0 {
+		return 0
+	}
+	found := 0
+	for _, word := range wordsA {
+		for _, other := range wordsB {
+			if word == other {
+				found++
+				break
+			}
+		}
+	}
+	return float64(found) / float64(len(wordsA))
+}
+
+func jaroWinkler(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	window := max(max(len(ra), len(rb))/2-1, 0)
+	matchedA := make([]bool, len(ra))
+	matchedB := make([]bool, len(rb))
+	matches := 0
+	for i := range ra {
+		for j := max(0, i-window); j < min(len(rb), i+window+1); j++ {
+			if !matchedB[j] && ra[i] == rb[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	for i, j := 0, 0; i < len(ra); i++ {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra), len(rb)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
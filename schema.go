@@ -0,0 +1,154 @@
+package golightrag
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	llmod "github.com/MegaGrindStone/go-light-rag/llm"
+)
+
+// entityExtractionSchema returns the JSON schema of the extraction and glean responses, requested
+// from LLMs that support structured output. The entity types are restricted to entityTypes when
+// it's not empty, and the attributes and predicates of the ontology are added when it declares
+// them.
+func entityExtractionSchema(entityTypes []string, ontology Ontology) llmod.Schema {
+	entityType := map[string]any{"type": "string"}
+	if len(entityTypes) > 0 {
+		entityType["enum"] = entityTypes
+	}
+	entity := map[string]any{
+		"entity_name":        map[string]any{"type": "string"},
+		"entity_type":        entityType,
+		"entity_description": map[string]any{"type": "string"},
+	}
+	if attributeTypes := ontology.attributeTypes(); len(attributeTypes) > 0 {
+		// Strict schemas require every property, so the attributes the text doesn't state
+		// are null.
+		attributes := make(map[string]any, len(attributeTypes))
+		for name, types := range attributeTypes {
+			jsonTypes := make([]string, 0, len(types)+1)
+			for _, typ := range types {
+				jsonTypes = append(jsonTypes, string(typ))
+			}
+			attributes[name] = map[string]any{"type": append(jsonTypes, "null")}
+		}
+		entity["entity_attributes"] = schemaObject(attributes)
+	}
+	relationship := map[string]any{
+		"source_entity":            map[string]any{"type": "string"},
+		"target_entity":            map[string]any{"type": "string"},
+		"relationship_description": map[string]any{"type": "string"},
+		"relationship_keywords":    schemaArray(map[string]any{"type": "string"}),
+		"relationship_strength":    map[string]any{"type": "number"},
+		"valid_from":               map[string]any{"type": []string{"string", "null"}},
+		"valid_to":                 map[string]any{"type": []string{"string", "null"}},
+	}
+	if len(ontology.Predicates) > 0 {
+		relationship["relationship_predicate"] = map[string]any{"type": "string", "enum": ontology.Predicates}
+	}
+	schema := schemaObject(map[string]any{
+		"entities":      schemaArray(schemaObject(entity)),
+		"relationships": schemaArray(schemaObject(relationship)),
+	})
+	return llmod.Schema{
+		Name:        "extract_entities",
+		Description: "Entities and relationships extracted from the text.",
+		Schema:      mustMarshalSchema(schema),
+		Strict:      true,
+	}
+}
+
+// keywordExtractionSchema is the JSON schema of the keyword extraction response.
+var keywordExtractionSchema = llmod.Schema{
+	Name:        "extract_keywords",
+	Description: "High-level and low-level keywords extracted from the query.",
+	Schema: mustMarshalSchema(schemaObject(map[string]any{
+		"high_level_keywords": schemaArray(map[string]any{"type": "string"}),
+		"low_level_keywords":  schemaArray(map[string]any{"type": "string"}),
+	})),
+	Strict: true,
+}
+
+// claimExtractionSchema is the JSON schema of the claim extraction response.
+var claimExtractionSchema = llmod.Schema{
+	Name:        "extract_claims",
+	Description: "Claims about the entities extracted from the text.",
+	Schema: mustMarshalSchema(schemaObject(map[string]any{
+		"claims": schemaArray(schemaObject(map[string]any{
+			"subject":    map[string]any{"type": "string"},
+			"object":     map[string]any{"type": []string{"string", "null"}},
+			"claim_type": map[string]any{"type": "string"},
+			"claim_status": map[string]any{
+				"type": "string",
+				"enum": []ClaimStatus{ClaimStatusTrue, ClaimStatusFalse, ClaimStatusSuspected},
+			},
+			"claim_description": map[string]any{"type": "string"},
+			"start_date":        map[string]any{"type": []string{"string", "null"}},
+			"end_date":          map[string]any{"type": []string{"string", "null"}},
+			"source_text":       map[string]any{"type": "string"},
+		})),
+	})),
+	Strict: true,
+}
+
+// communityReportSchema is the JSON schema of the community report response.
+var communityReportSchema = llmod.Schema{
+	Name:        "community_report",
+	Description: "Report about a community of entities.",
+	Schema: mustMarshalSchema(schemaObject(map[string]any{
+		"title":   map[string]any{"type": "string"},
+		"summary": map[string]any{"type": "string"},
+		"rating":  map[string]any{"type": "number"},
+		"findings": schemaArray(schemaObject(map[string]any{
+			"summary":     map[string]any{"type": "string"},
+			"explanation": map[string]any{"type": "string"},
+		})),
+	})),
+	Strict: true,
+}
+
+// communityMapSchema is the JSON schema of the community map response.
+var communityMapSchema = llmod.Schema{
+	Name:        "community_points",
+	Description: "Points of the community reports relevant to the question.",
+	Schema: mustMarshalSchema(schemaObject(map[string]any{
+		"points": schemaArray(schemaObject(map[string]any{
+			"description": map[string]any{"type": "string"},
+			"score":       map[string]any{"type": "integer"},
+			"reports":     schemaArray(map[string]any{"type": "integer"}),
+		})),
+	})),
+	Strict: true,
+}
+
+// object returns a strict JSON schema object, with every property required.
+func schemaObject(properties map[string]any) map[string]any {
+	required := slices.Sorted(maps.Keys(properties))
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func schemaArray(items map[string]any) map[string]any {
+	return map[string]any{"type": "array", "items": items}
+}
+
+func mustMarshalSchema(schema map[string]any) json.RawMessage {
+	b, err := json.Marshal(schema)
+	if err != nil {
+		panic(fmt.Sprintf("failed to marshal schema: %v", err))
+	}
+	return b
+}
+
+// chatJSON calls llm with schema when it supports structured output, and with the prompt alone
+// otherwise.
+func chatJSON(llm LLM, messages []string, schema llmod.Schema) (string, error) {
+	res, _, err := llmod.ChatStructured(llm, messages, schema)
+	return res, err
+}
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
@@ -12,6 +15,8 @@ import (
 // It handles database operations for storing and retrieving source documents.
 type Bolt struct {
 	DB *bolt.DB
+
+	workspace string
 }
 
 // NewBolt creates a new BoltDB client connection with the provided file path.
@@ -23,57 +28,147 @@ func NewBolt(path string) (Bolt, error) {
 		return Bolt{}, fmt.Errorf("failed to open bolt database: %w", err)
 	}
 
-	if err := db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("sources"))
-		return err
-	}); err != nil {
-		return Bolt{}, fmt.Errorf("failed to create sources bucket: %w", err)
+	b := Bolt{DB: db}
+	if err := b.createBuckets(); err != nil {
+		return Bolt{}, err
+	}
+
+	return b, nil
+}
+
+// Workspace returns the Bolt scoped to the workspace, whose buckets are prefixed with its name
+// and created if needed. The empty workspace is the default one. It shares the database of b.
+func (b Bolt) Workspace(name string) (Bolt, error) {
+	if err := validateWorkspace(name); err != nil {
+		return Bolt{}, err
 	}
-	if err := db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("unprocessed"))
-		return err
-	}); err != nil {
-		return Bolt{}, fmt.Errorf("failed to create unprocessed bucket: %w", err)
+	w := Bolt{DB: b.DB, workspace: name}
+	if err := w.createBuckets(); err != nil {
+		return Bolt{}, err
+	}
+	return w, nil
+}
+
+// createBuckets ensures that the buckets of the workspace exist.
+func (b Bolt) createBuckets() error {
+	for _, name := range []string{
+		"sources", "unprocessed", "claims", "entity_claims", "communities", "changes", "source_documents",
+	} {
+		if err := b.DB.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(b.bucketName(name))
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to create %s bucket: %w", name, err)
+		}
 	}
+	return nil
+}
 
-	return Bolt{DB: db}, nil
+// bucketName returns the name of the bucket in the workspace.
+func (b Bolt) bucketName(name string) []byte {
+	if b.workspace == "" {
+		return []byte(name)
+	}
+	return []byte(b.workspace + ":" + name)
 }
 
 // KVSource retrieves a source document by ID from the BoltDB database.
-// It returns the found source or an error if the source doesn't exist or if the query fails.
+// It returns the found source, golightrag.ErrSourceNotFound if the source doesn't exist or an
+// error if the query fails.
 func (b Bolt) KVSource(id string) (golightrag.Source, error) {
 	var result golightrag.Source
 
 	err := b.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("sources"))
+		documents := tx.Bucket(b.bucketName("source_documents"))
+		b := tx.Bucket(b.bucketName("sources"))
 
 		content := b.Get([]byte(id))
 		if content == nil {
-			return fmt.Errorf("source not found")
+			return golightrag.ErrSourceNotFound
 		}
 
+		result.ID = id
 		result.Content = string(content)
 
+		if documents == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		if bs := documents.Get([]byte(id)); bs != nil {
+			return unmarshalSourceDocument(bs, &result)
+		}
+
 		return nil
 	})
 
 	return result, err
 }
 
+// sourceDocument is the document of a source, stored apart from its content so sources
+// stored before documents were recorded keep working.
+type sourceDocument struct {
+	DocumentID string            `json:"document_id"`
+	Origin     golightrag.Origin `json:"origin"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// marshalSourceDocument returns the document of source, or nil if it has none.
+func marshalSourceDocument(source golightrag.Source) ([]byte, error) {
+	if source.DocumentID == "" && source.Origin == (golightrag.Origin{}) && len(source.Metadata) == 0 {
+		return nil, nil
+	}
+	bs, err := json.Marshal(sourceDocument{
+		DocumentID: source.DocumentID,
+		Origin:     source.Origin,
+		Metadata:   source.Metadata,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal source document: %w", err)
+	}
+	return bs, nil
+}
+
+func unmarshalSourceDocument(bs []byte, source *golightrag.Source) error {
+	var document sourceDocument
+	if err := json.Unmarshal(bs, &document); err != nil {
+		return fmt.Errorf("failed to unmarshal source document: %w", err)
+	}
+	source.DocumentID = document.DocumentID
+	source.Origin = document.Origin
+	source.Metadata = document.Metadata
+	return nil
+}
+
 // KVUpsertSources creates or updates multiple source documents in the BoltDB database.
 // It returns an error if any database operation fails during the process.
 func (b Bolt) KVUpsertSources(sources []golightrag.Source) error {
 	return b.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("sources"))
+		documents := tx.Bucket(b.bucketName("source_documents"))
+		b := tx.Bucket(b.bucketName("sources"))
 		if b == nil {
 			return fmt.Errorf("bucket not found")
 		}
 
+		if documents == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
 		for _, chunk := range sources {
 			err := b.Put([]byte(chunk.ID), []byte(chunk.Content))
 			if err != nil {
 				return fmt.Errorf("failed to put sources: %w", err)
 			}
+			document, err := marshalSourceDocument(chunk)
+			if err != nil {
+				return err
+			}
+			if document == nil {
+				err = documents.Delete([]byte(chunk.ID))
+			} else {
+				err = documents.Put([]byte(chunk.ID), document)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to put source document: %w", err)
+			}
 		}
 
 		return nil
@@ -82,7 +177,7 @@ func (b Bolt) KVUpsertSources(sources []golightrag.Source) error {
 
 func (b Bolt) KVUpsertUnprocessed(sources []golightrag.Source) error {
 	return b.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("unprocessed"))
+		b := tx.Bucket(b.bucketName("unprocessed"))
 		if b == nil {
 			return fmt.Errorf("bucket not found")
 		}
@@ -106,7 +201,7 @@ func (b Bolt) KVUnprocessed(id string) (string, error) {
 	var result string
 
 	err := b.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("unprocessed"))
+		b := tx.Bucket(b.bucketName("unprocessed"))
 
 		content := b.Get([]byte(id))
 		if content == nil {
@@ -125,7 +220,7 @@ func (b Bolt) KVUnprocessedKeys() ([]string, error) {
 	var result = []string{}
 
 	err := b.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("unprocessed"))
+		b := tx.Bucket(b.bucketName("unprocessed"))
 
 		b.ForEach(
 			func(k, _ []byte) error {
@@ -141,3 +236,229 @@ func (b Bolt) KVUnprocessedKeys() ([]string, error) {
 
 	return result, err
 }
+
+// claimEntities returns the names of the entities a claim is indexed by.
+func claimEntities(claim golightrag.Claim) []string {
+	if claim.Object == "" || claim.Object == claim.Subject {
+		return []string{claim.Subject}
+	}
+	return []string{claim.Subject, claim.Object}
+}
+
+// KVUpsertClaims creates or updates multiple claims in the BoltDB database, indexing them by
+// their subject and object entities.
+// It returns an error if any database operation fails during the process.
+func (b Bolt) KVUpsertClaims(claims []golightrag.Claim) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		claimsBucket := tx.Bucket(b.bucketName("claims"))
+		indexBucket := tx.Bucket(b.bucketName("entity_claims"))
+		if claimsBucket == nil || indexBucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		for _, claim := range claims {
+			bs, err := json.Marshal(claim)
+			if err != nil {
+				return fmt.Errorf("failed to marshal claim: %w", err)
+			}
+			if err := claimsBucket.Put([]byte(claim.ID), bs); err != nil {
+				return fmt.Errorf("failed to put claim: %w", err)
+			}
+
+			for _, name := range claimEntities(claim) {
+				var ids []string
+				if existing := indexBucket.Get([]byte(name)); existing != nil {
+					if err := json.Unmarshal(existing, &ids); err != nil {
+						return fmt.Errorf("failed to unmarshal entity claims: %w", err)
+					}
+				}
+				if slices.Contains(ids, claim.ID) {
+					continue
+				}
+				bs, err := json.Marshal(append(ids, claim.ID))
+				if err != nil {
+					return fmt.Errorf("failed to marshal entity claims: %w", err)
+				}
+				if err := indexBucket.Put([]byte(name), bs); err != nil {
+					return fmt.Errorf("failed to put entity claims: %w", err)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// KVClaimsByEntities retrieves the claims whose subject or object is one of the entities from
+// the BoltDB database. It returns a map of entity names to their claims, or an error if the
+// query fails.
+func (b Bolt) KVClaimsByEntities(names []string) (map[string][]golightrag.Claim, error) {
+	result := make(map[string][]golightrag.Claim)
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		claimsBucket := tx.Bucket(b.bucketName("claims"))
+		indexBucket := tx.Bucket(b.bucketName("entity_claims"))
+		if claimsBucket == nil || indexBucket == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		for _, name := range names {
+			existing := indexBucket.Get([]byte(name))
+			if existing == nil {
+				continue
+			}
+			var ids []string
+			if err := json.Unmarshal(existing, &ids); err != nil {
+				return fmt.Errorf("failed to unmarshal entity claims: %w", err)
+			}
+			for _, id := range ids {
+				bs := claimsBucket.Get([]byte(id))
+				if bs == nil {
+					continue
+				}
+				var claim golightrag.Claim
+				if err := json.Unmarshal(bs, &claim); err != nil {
+					return fmt.Errorf("failed to unmarshal claim: %w", err)
+				}
+				// A claim replaced with other entities is still indexed by the previous ones.
+				if slices.Contains(claimEntities(claim), name) {
+					result[name] = append(result[name], claim)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// KVCommunities retrieves every community stored in the BoltDB database, in the order of their
+// IDs. It returns an error if the query fails.
+func (b Bolt) KVCommunities() ([]golightrag.Community, error) {
+	result := make([]golightrag.Community, 0)
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(b.bucketName("communities"))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var community golightrag.Community
+			if err := json.Unmarshal(v, &community); err != nil {
+				return fmt.Errorf("failed to unmarshal community: %w", err)
+			}
+			result = append(result, community)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to view communities: %w", err)
+	}
+
+	return result, nil
+}
+
+// KVUpsertCommunities creates or updates multiple communities in the BoltDB database.
+// It returns an error if any database operation fails during the process.
+func (b Bolt) KVUpsertCommunities(communities []golightrag.Community) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(b.bucketName("communities"))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		for _, community := range communities {
+			bs, err := json.Marshal(community)
+			if err != nil {
+				return fmt.Errorf("failed to marshal community: %w", err)
+			}
+			if err := b.Put([]byte(community.ID), bs); err != nil {
+				return fmt.Errorf("failed to put community: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// KVDeleteCommunities deletes the communities with the IDs from the BoltDB database.
+// It returns an error if any database operation fails during the process.
+func (b Bolt) KVDeleteCommunities(ids []string) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(b.bucketName("communities"))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		for _, id := range ids {
+			if err := b.Delete([]byte(id)); err != nil {
+				return fmt.Errorf("failed to delete community: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// KVAppendChanges appends the changes to the change logs of their keys in the BoltDB database.
+// Each key has its own bucket, where the changes are keyed by their sequence number.
+// It returns an error if any database operation fails during the process.
+func (b Bolt) KVAppendChanges(changes []golightrag.Change) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(b.bucketName("changes"))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		for _, change := range changes {
+			keyBucket, err := b.CreateBucketIfNotExists([]byte(change.Key))
+			if err != nil {
+				return fmt.Errorf("failed to create change log bucket: %w", err)
+			}
+			seq, err := keyBucket.NextSequence()
+			if err != nil {
+				return fmt.Errorf("failed to get change sequence: %w", err)
+			}
+			bs, err := json.Marshal(change)
+			if err != nil {
+				return fmt.Errorf("failed to marshal change: %w", err)
+			}
+			if err := keyBucket.Put(binary.BigEndian.AppendUint64(nil, seq), bs); err != nil {
+				return fmt.Errorf("failed to put change: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// KVChanges retrieves the change log of the key from the BoltDB database, oldest first.
+// It returns an error if the query fails.
+func (b Bolt) KVChanges(key string) ([]golightrag.Change, error) {
+	result := make([]golightrag.Change, 0)
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(b.bucketName("changes"))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		keyBucket := b.Bucket([]byte(key))
+		if keyBucket == nil {
+			return nil
+		}
+		return keyBucket.ForEach(func(_, v []byte) error {
+			var change golightrag.Change
+			if err := json.Unmarshal(v, &change); err != nil {
+				return fmt.Errorf("failed to unmarshal change: %w", err)
+			}
+			result = append(result, change)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to view changes: %w", err)
+	}
+
+	return result, nil
+}
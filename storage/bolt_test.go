@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBolt_Conformance(t *testing.T) {
+	storagetest.TestKeyValueStorage(t, func(t *testing.T) golightrag.KeyValueStorage {
+		b, err := NewBolt(filepath.Join(t.TempDir(), "kv.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { b.DB.Close() })
+		return b
+	})
+}
+
+func TestBolt_Workspaces(t *testing.T) {
+	storagetest.TestWorkspaces(t, func(t *testing.T) func(string) (Bolt, error) {
+		b, err := NewBolt(filepath.Join(t.TempDir(), "kv.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { b.DB.Close() })
+		return b.Workspace
+	})
+}
@@ -3,8 +3,11 @@ package storage
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"slices"
 	"time"
 
+	golightrag "github.com/MegaGrindStone/go-light-rag"
 	"github.com/philippgille/chromem-go"
 )
 
@@ -15,7 +18,9 @@ type Chromem struct {
 	EntitiesColl      *chromem.Collection
 	RelationshipsColl *chromem.Collection
 
-	topK int
+	db            *chromem.DB
+	embeddingFunc EmbeddingFunc
+	topK          int
 }
 
 // NewChromem creates a new ChromeM client with the provided parameters.
@@ -28,11 +33,30 @@ func NewChromem(dbPath string, topK int, embeddingFunc EmbeddingFunc) (Chromem,
 		return Chromem{}, fmt.Errorf("failed to create chromem db: %w", err)
 	}
 
-	entitiesColl, err := db.GetOrCreateCollection("entities", nil, chromem.EmbeddingFunc(embeddingFunc))
+	return Chromem{db: db, embeddingFunc: embeddingFunc, topK: topK}.Workspace("")
+}
+
+// Workspace returns the Chromem scoped to the workspace, whose collections are suffixed with its
+// name and created if needed. The empty workspace is the default one. It shares the database of
+// c.
+func (c Chromem) Workspace(name string) (Chromem, error) {
+	if err := validateWorkspace(name); err != nil {
+		return Chromem{}, err
+	}
+	if c.db == nil {
+		return Chromem{}, fmt.Errorf("chromem workspaces require a Chromem created by NewChromem")
+	}
+
+	suffix := ""
+	if name != "" {
+		suffix = "_" + name
+	}
+	entitiesColl, err := c.db.GetOrCreateCollection("entities"+suffix, nil, chromem.EmbeddingFunc(c.embeddingFunc))
 	if err != nil {
 		return Chromem{}, fmt.Errorf("failed to create entities collection: %w", err)
 	}
-	relationshipsColl, err := db.GetOrCreateCollection("relationships", nil, chromem.EmbeddingFunc(embeddingFunc))
+	relationshipsColl, err := c.db.GetOrCreateCollection("relationships"+suffix, nil,
+		chromem.EmbeddingFunc(c.embeddingFunc))
 	if err != nil {
 		return Chromem{}, fmt.Errorf("failed to create relationships collection: %w", err)
 	}
@@ -40,17 +64,35 @@ func NewChromem(dbPath string, topK int, embeddingFunc EmbeddingFunc) (Chromem,
 	return Chromem{
 		EntitiesColl:      entitiesColl,
 		RelationshipsColl: relationshipsColl,
-		topK:              topK,
+		db:                c.db,
+		embeddingFunc:     c.embeddingFunc,
+		topK:              c.topK,
 	}, nil
 }
 
 // VectorQueryEntity performs a semantic search for entities based on the provided keywords.
 // It returns a slice of matching entity names and any error encountered during the operation.
 func (c Chromem) VectorQueryEntity(keywords string) ([]string, error) {
+	return c.queryEntities(keywords, nil)
+}
+
+// VectorQueryEntityWithFilter performs VectorQueryEntity among the entities whose metadata
+// matches the filter.
+func (c Chromem) VectorQueryEntityWithFilter(keywords string, filter golightrag.MetadataFilter) ([]string, error) {
+	return c.queryEntities(keywords, chromemWhere(filter))
+}
+
+func (c Chromem) queryEntities(keywords string, where map[string]string) ([]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	vecRes, err := c.EntitiesColl.Query(ctx, keywords, c.topK, nil, nil)
+	// Chromem rejects queries for more results than the collection holds.
+	topK := min(c.topK, c.EntitiesColl.Count())
+	if topK == 0 {
+		return nil, nil
+	}
+
+	vecRes, err := c.EntitiesColl.Query(ctx, keywords, topK, where, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query entities: %w", err)
 	}
@@ -70,10 +112,27 @@ func (c Chromem) VectorQueryEntity(keywords string) ([]string, error) {
 // VectorQueryRelationship performs a semantic search for relationships based on the provided keywords.
 // It returns a slice of source-target entity pairs and any error encountered during the operation.
 func (c Chromem) VectorQueryRelationship(keywords string) ([][2]string, error) {
+	return c.queryRelationships(keywords, nil)
+}
+
+// VectorQueryRelationshipWithFilter performs VectorQueryRelationship among the relationships
+// whose metadata matches the filter.
+func (c Chromem) VectorQueryRelationshipWithFilter(
+	keywords string, filter golightrag.MetadataFilter,
+) ([][2]string, error) {
+	return c.queryRelationships(keywords, chromemWhere(filter))
+}
+
+func (c Chromem) queryRelationships(keywords string, where map[string]string) ([][2]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	vecRes, err := c.RelationshipsColl.Query(ctx, keywords, c.topK, nil, nil)
+	topK := min(c.topK, c.RelationshipsColl.Count())
+	if topK == 0 {
+		return nil, nil
+	}
+
+	vecRes, err := c.RelationshipsColl.Query(ctx, keywords, topK, where, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query relationships: %w", err)
 	}
@@ -97,12 +156,18 @@ func (c Chromem) VectorQueryRelationship(keywords string) ([][2]string, error) {
 // VectorUpsertEntity creates or updates an entity with vector embedding based on its content.
 // It returns an error if the database operation fails.
 func (c Chromem) VectorUpsertEntity(name, content string) error {
+	return c.VectorUpsertEntityWithMetadata(name, content, nil)
+}
+
+// VectorUpsertEntityWithMetadata creates or updates an entity like VectorUpsertEntity, storing
+// its metadata for VectorQueryEntityWithFilter.
+func (c Chromem) VectorUpsertEntityWithMetadata(name, content string, metadata map[string][]string) error {
+	docMetadata := chromemMetadata(metadata)
+	docMetadata["entity_name"] = name
 	doc := chromem.Document{
-		ID:      name,
-		Content: content,
-		Metadata: map[string]string{
-			"entity_name": name,
-		},
+		ID:       name,
+		Content:  content,
+		Metadata: docMetadata,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
@@ -114,14 +179,22 @@ func (c Chromem) VectorUpsertEntity(name, content string) error {
 // VectorUpsertRelationship creates or updates a relationship with vector embedding based on its content.
 // It returns an error if the database operation fails.
 func (c Chromem) VectorUpsertRelationship(source, target, content string) error {
+	return c.VectorUpsertRelationshipWithMetadata(source, target, content, nil)
+}
+
+// VectorUpsertRelationshipWithMetadata creates or updates a relationship like
+// VectorUpsertRelationship, storing its metadata for VectorQueryRelationshipWithFilter.
+func (c Chromem) VectorUpsertRelationshipWithMetadata(
+	source, target, content string, metadata map[string][]string,
+) error {
 	id := fmt.Sprintf("%s-%s", source, target)
+	docMetadata := chromemMetadata(metadata)
+	docMetadata["source_entity"] = source
+	docMetadata["target_entity"] = target
 	doc := chromem.Document{
-		ID:      id,
-		Content: content,
-		Metadata: map[string]string{
-			"source_entity": source,
-			"target_entity": target,
-		},
+		ID:       id,
+		Content:  content,
+		Metadata: docMetadata,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
@@ -129,3 +202,43 @@ func (c Chromem) VectorUpsertRelationship(source, target, content string) error
 
 	return c.RelationshipsColl.AddDocument(ctx, doc)
 }
+
+// chromemMetadataPrefix namespaces the metadata markers among the fields of the documents.
+const chromemMetadataPrefix = "metadata:"
+
+// chromemMetadata returns the document metadata marking each value of metadata, so a where
+// filter can match any of the values of a key.
+func chromemMetadata(metadata map[string][]string) map[string]string {
+	result := make(map[string]string)
+	for _, marker := range metadataMarkers(metadata) {
+		result[chromemMetadataPrefix+marker] = "true"
+	}
+	return result
+}
+
+// chromemWhere returns the where filter of the documents marked with every value of filter.
+func chromemWhere(filter golightrag.MetadataFilter) map[string]string {
+	where := make(map[string]string, len(filter))
+	for key, value := range filter {
+		where[chromemMetadataPrefix+metadataMarker(key, value)] = "true"
+	}
+	return where
+}
+
+// metadataMarker encodes a metadata key and value as a single string, for vector storages that
+// match metadata by equality or containment.
+func metadataMarker(key, value string) string {
+	return url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// metadataMarkers returns the sorted markers of every value of metadata.
+func metadataMarkers(metadata map[string][]string) []string {
+	markers := make([]string, 0)
+	for key, values := range metadata {
+		for _, value := range values {
+			markers = append(markers, metadataMarker(key, value))
+		}
+	}
+	slices.Sort(markers)
+	return markers
+}
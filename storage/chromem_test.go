@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+// bagOfWordsEmbedding embeds text as the normalized counts of its hashed words, so that texts
+// sharing words are close without calling an embedding model.
+func bagOfWordsEmbedding(_ context.Context, text string) ([]float32, error) {
+	vec := make([]float32, 64)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(word))
+		vec[h.Sum32()%uint32(len(vec))]++
+	}
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v * v)
+	}
+	norm = math.Sqrt(norm)
+	for i := range vec {
+		vec[i] = float32(float64(vec[i]) / norm)
+	}
+	return vec, nil
+}
+
+func TestChromem_Conformance(t *testing.T) {
+	storagetest.TestVectorStorage(t, func(t *testing.T) golightrag.VectorStorage {
+		c, err := NewChromem(filepath.Join(t.TempDir(), "vec"), 5, bagOfWordsEmbedding)
+		require.NoError(t, err)
+		return c
+	})
+}
+
+func TestChromem_Workspaces(t *testing.T) {
+	storagetest.TestWorkspaces(t, func(t *testing.T) func(string) (Chromem, error) {
+		c, err := NewChromem(filepath.Join(t.TempDir(), "vec"), 5, bagOfWordsEmbedding)
+		require.NoError(t, err)
+		return c.Workspace
+	})
+}
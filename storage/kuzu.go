@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -15,6 +18,8 @@ import (
 type Kuzu struct {
 	DB   *kuzu.Database
 	Conn *kuzu.Connection
+
+	workspace string
 }
 
 // NewKuzu creates a new Kuzu client connection with the provided database path.
@@ -44,6 +49,32 @@ func NewKuzu(dbPath string, systemConfig kuzu.SystemConfig) (Kuzu, error) {
 	return k, nil
 }
 
+// Workspace returns the Kuzu scoped to the workspace, whose entities and relationships are
+// stored in their own node and relationship tables, suffixed with its name and created if
+// needed. The empty workspace is the default one. It shares the connection of k, so only k
+// should be closed.
+func (k Kuzu) Workspace(name string) (Kuzu, error) {
+	if err := validateWorkspace(name); err != nil {
+		return Kuzu{}, err
+	}
+	w := Kuzu{DB: k.DB, Conn: k.Conn, workspace: name}
+	if err := w.SetupSchema(); err != nil {
+		return Kuzu{}, fmt.Errorf("failed to set up schema: %w", err)
+	}
+	return w, nil
+}
+
+// kuzuTables matches the tables named in the queries, which are renamed for workspaces.
+var kuzuTables = regexp.MustCompile(`\b(base|DIRECTED)\b`)
+
+// scopeQuery returns query with its tables renamed to those of the workspace.
+func (k Kuzu) scopeQuery(query string) string {
+	if k.workspace == "" {
+		return query
+	}
+	return kuzuTables.ReplaceAllString(query, "${1}_"+k.workspace)
+}
+
 // SetupSchema defines and creates the necessary node and relationship tables in Kuzu.
 // This is idempotent; it will not fail if the tables already exist.
 func (k Kuzu) SetupSchema() error {
@@ -55,8 +86,33 @@ func (k Kuzu) SetupSchema() error {
         description STRING,
         source_ids STRING,
         created_at STRING,
+        updated_at STRING,
+        update_count INT64,
+        aliases STRING,
+        properties STRING,
+        pagerank DOUBLE,
+        betweenness DOUBLE,
+        metadata STRING,
         PRIMARY KEY (entity_id)
     )`
+	// Add the columns introduced after the tables were first defined, to databases created
+	// before them.
+	migrationQueries := []string{
+		`ALTER TABLE base ADD IF NOT EXISTS aliases STRING DEFAULT ''`,
+		`ALTER TABLE base ADD IF NOT EXISTS properties STRING DEFAULT ''`,
+		`ALTER TABLE DIRECTED ADD IF NOT EXISTS properties STRING DEFAULT ''`,
+		`ALTER TABLE base ADD IF NOT EXISTS pagerank DOUBLE DEFAULT 0`,
+		`ALTER TABLE base ADD IF NOT EXISTS betweenness DOUBLE DEFAULT 0`,
+		`ALTER TABLE DIRECTED ADD IF NOT EXISTS valid_from STRING DEFAULT ''`,
+		`ALTER TABLE DIRECTED ADD IF NOT EXISTS valid_to STRING DEFAULT ''`,
+		`ALTER TABLE DIRECTED ADD IF NOT EXISTS history STRING DEFAULT ''`,
+		`ALTER TABLE base ADD IF NOT EXISTS updated_at STRING DEFAULT ''`,
+		`ALTER TABLE base ADD IF NOT EXISTS update_count INT64 DEFAULT 0`,
+		`ALTER TABLE DIRECTED ADD IF NOT EXISTS updated_at STRING DEFAULT ''`,
+		`ALTER TABLE DIRECTED ADD IF NOT EXISTS update_count INT64 DEFAULT 0`,
+		`ALTER TABLE base ADD IF NOT EXISTS metadata STRING DEFAULT ''`,
+		`ALTER TABLE DIRECTED ADD IF NOT EXISTS metadata STRING DEFAULT ''`,
+	}
 	// Define the relationship table.
 	relTableQuery := `
     CREATE REL TABLE IF NOT EXISTS DIRECTED (
@@ -65,22 +121,172 @@ func (k Kuzu) SetupSchema() error {
         description STRING,
         keywords STRING,
         source_ids STRING,
-        created_at STRING
+        created_at STRING,
+        updated_at STRING,
+        update_count INT64,
+        properties STRING,
+        valid_from STRING,
+        valid_to STRING,
+        history STRING,
+        metadata STRING
     )`
 
-	noteStmt, err := k.Conn.Query(nodeTableQuery)
+	if k.Conn == nil {
+		return errKuzuClosed
+	}
+
+	noteStmt, err := k.Conn.Query(k.scopeQuery(nodeTableQuery))
 	if err != nil {
 		return fmt.Errorf("failed to execute create base node table: %w", err)
 	}
 	defer noteStmt.Close()
 
-	relStmt, err := k.Conn.Query(relTableQuery)
+	relStmt, err := k.Conn.Query(k.scopeQuery(relTableQuery))
 	if err != nil {
 		return fmt.Errorf("failed to prepare create rel table statement: %w", err)
 	}
 	defer relStmt.Close()
 
-	return err
+	for _, query := range migrationQueries {
+		migrationStmt, err := k.Conn.Query(k.scopeQuery(query))
+		if err != nil {
+			return fmt.Errorf("failed to migrate tables: %w", err)
+		}
+		migrationStmt.Close()
+	}
+
+	return nil
+}
+
+// errKuzuClosed is returned by the queries of a closed Kuzu, since the driver crashes when
+// preparing a statement on a closed connection.
+var errKuzuClosed = errors.New("kuzu: Connection is closed")
+
+// execute prepares query and executes it with params.
+func (k Kuzu) execute(query string, params map[string]any) (*kuzu.QueryResult, error) {
+	if k.Conn == nil {
+		return nil, errKuzuClosed
+	}
+	prepped, err := k.Conn.Prepare(k.scopeQuery(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare query: %w", err)
+	}
+	defer prepped.Close()
+	return k.Conn.Execute(prepped, params)
+}
+
+// splitField splits a list field, such as the aliases of an entity or the keywords of a
+// relationship, stored joined by golightrag.GraphFieldSeparator. An empty field is an empty list.
+func splitField(field string) []string {
+	if field == "" {
+		return nil
+	}
+	return strings.Split(field, golightrag.GraphFieldSeparator)
+}
+
+// marshalProperties encodes the properties of an entity or relationship as a JSON object, or
+// an empty string if there are none.
+func marshalProperties(properties map[string]any) (string, error) {
+	if len(properties) == 0 {
+		return "", nil
+	}
+	bs, err := json.Marshal(properties)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal properties: %w", err)
+	}
+	return string(bs), nil
+}
+
+// formatValidity encodes a validity date of a relationship, or an empty string if it's zero.
+func formatValidity(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// parseValidity decodes the validity dates encoded by formatValidity.
+func parseValidity(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// marshalHistory encodes the previous versions of a relationship as a JSON array, or an empty
+// string if there are none.
+func marshalHistory(history []golightrag.RelationshipVersion) (string, error) {
+	if len(history) == 0 {
+		return "", nil
+	}
+	bs, err := json.Marshal(history)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal history: %w", err)
+	}
+	return string(bs), nil
+}
+
+// unmarshalHistory decodes the versions encoded by marshalHistory, ignoring an invalid history.
+func unmarshalHistory(history string) []golightrag.RelationshipVersion {
+	if history == "" {
+		return nil
+	}
+	var versions []golightrag.RelationshipVersion
+	if err := json.Unmarshal([]byte(history), &versions); err != nil {
+		return nil
+	}
+	return versions
+}
+
+// marshalMetadata encodes the metadata of an entity or relationship as a JSON object, or an
+// empty string if it has none.
+func marshalMetadata(metadata map[string][]string) (string, error) {
+	if len(metadata) == 0 {
+		return "", nil
+	}
+	bs, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	return string(bs), nil
+}
+
+// unmarshalMetadata decodes the metadata encoded by marshalMetadata, ignoring an invalid one.
+func unmarshalMetadata(metadata string) map[string][]string {
+	if metadata == "" {
+		return nil
+	}
+	var result map[string][]string
+	if err := json.Unmarshal([]byte(metadata), &result); err != nil {
+		return nil
+	}
+	return result
+}
+
+// unmarshalProperties decodes the properties encoded by marshalProperties. Invalid properties
+// are ignored like the other malformed fields.
+func unmarshalProperties(properties string) map[string]any {
+	if properties == "" {
+		return nil
+	}
+	var props map[string]any
+	if err := json.Unmarshal([]byte(properties), &props); err != nil {
+		return nil
+	}
+	return props
+}
+
+// updateFromMap returns the update time and count of the entity or relationship in props.
+// Entities and relationships stored before they were tracked were last updated when created.
+func updateFromMap(createdAt time.Time, props map[string]any) (time.Time, int) {
+	updateCount, _ := props["update_count"].(int64)
+	updatedAtStr, _ := props["updated_at"].(string)
+	updatedAt, err := time.Parse(time.RFC3339, updatedAtStr)
+	if err != nil {
+		updatedAt = createdAt
+	}
+	return updatedAt, int(updateCount)
 }
 
 func graphEntityFromMap(props map[string]any) golightrag.GraphEntity {
@@ -88,18 +294,31 @@ func graphEntityFromMap(props map[string]any) golightrag.GraphEntity {
 	typ, _ := props["entity_type"].(string)
 	desc, _ := props["description"].(string)
 	sourceIDs, _ := props["source_ids"].(string)
+	aliases, _ := props["aliases"].(string)
+	properties, _ := props["properties"].(string)
+	pageRank, _ := props["pagerank"].(float64)
+	betweenness, _ := props["betweenness"].(float64)
+	metadata, _ := props["metadata"].(string)
 	createdAtStr, _ := props["created_at"].(string)
 	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
 	if err != nil {
 		createdAt = time.Now()
 	}
+	updatedAt, updateCount := updateFromMap(createdAt, props)
 
 	return golightrag.GraphEntity{
 		Name:         name,
 		Type:         typ,
 		Descriptions: desc,
 		SourceIDs:    sourceIDs,
+		Aliases:      splitField(aliases),
+		Properties:   unmarshalProperties(properties),
+		PageRank:     pageRank,
+		Betweenness:  betweenness,
+		Metadata:     unmarshalMetadata(metadata),
 		CreatedAt:    createdAt,
+		UpdatedAt:    updatedAt,
+		UpdateCount:  updateCount,
 	}
 }
 
@@ -107,13 +326,19 @@ func graphRelationshipFromMap(source, target string, props map[string]any) golig
 	weight, _ := props["weight"].(float64)
 	description, _ := props["description"].(string)
 	keywords, _ := props["keywords"].(string)
-	arrKeywords := strings.Split(keywords, golightrag.GraphFieldSeparator)
+	arrKeywords := splitField(keywords)
 	sourceIDs, _ := props["source_ids"].(string)
+	properties, _ := props["properties"].(string)
+	validFrom, _ := props["valid_from"].(string)
+	validTo, _ := props["valid_to"].(string)
+	history, _ := props["history"].(string)
+	metadata, _ := props["metadata"].(string)
 	createdAtStr, _ := props["created_at"].(string)
 	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
 	if err != nil {
 		createdAt = time.Now()
 	}
+	updatedAt, updateCount := updateFromMap(createdAt, props)
 
 	return golightrag.GraphRelationship{
 		SourceEntity: source,
@@ -122,7 +347,14 @@ func graphRelationshipFromMap(source, target string, props map[string]any) golig
 		Descriptions: description,
 		Keywords:     arrKeywords,
 		SourceIDs:    sourceIDs,
+		Properties:   unmarshalProperties(properties),
+		ValidFrom:    parseValidity(validFrom),
+		ValidTo:      parseValidity(validTo),
+		History:      unmarshalHistory(history),
+		Metadata:     unmarshalMetadata(metadata),
 		CreatedAt:    createdAt,
+		UpdatedAt:    updatedAt,
+		UpdateCount:  updateCount,
 	}
 }
 
@@ -130,8 +362,7 @@ func graphRelationshipFromMap(source, target string, props map[string]any) golig
 func (k Kuzu) GraphEntity(name string) (golightrag.GraphEntity, error) {
 	query := `MATCH (n:base {entity_id: $entityID}) RETURN n`
 	params := map[string]any{"entityID": name}
-	prepped, _ := k.Conn.Prepare(query)
-	queryResult, err := k.Conn.Execute(prepped, params)
+	queryResult, err := k.execute(query, params)
 	if err != nil {
 		return golightrag.GraphEntity{}, fmt.Errorf("failed to run GraphEntity query: %w", err)
 	}
@@ -165,15 +396,21 @@ keywords: r.keywords,
 weight: r.weight,
 description: r.description,
 created_at: r.created_at,
-source_ids: r.source_ids
+updated_at: r.updated_at,
+update_count: r.update_count,
+source_ids: r.source_ids,
+properties: r.properties,
+valid_from: r.valid_from,
+valid_to: r.valid_to,
+history: r.history,
+metadata: r.metadata
 } as edge_properties
 `
 	params := map[string]any{
 		"source_entity_id": sourceEntity,
 		"target_entity_id": targetEntity,
 	}
-	prepped, _ := k.Conn.Prepare(query)
-	queryResult, err := k.Conn.Execute(prepped, params)
+	queryResult, err := k.execute(query, params)
 	if err != nil {
 		return golightrag.GraphRelationship{}, fmt.Errorf("failed to run GraphRelationship query: %w", err)
 	}
@@ -199,41 +436,77 @@ source_ids: r.source_ids
 	return graphRelationshipFromMap(sourceEntity, targetEntity, props), nil
 }
 
-// GraphUpsertEntity creates or updates an entity in the Kuzu graph database.
+// GraphUpsertEntity creates or updates an entity in the Kuzu graph database. The creation
+// time of an existing entity is kept.
 func (k Kuzu) GraphUpsertEntity(entity golightrag.GraphEntity) error {
 	query := `
 MERGE (n:base {entity_id: $entity_id})
-ON CREATE SET n.entity_type = $entity_type, n.source_ids = $source_ids, n.description = $description, n.created_at = $created_at
-ON MATCH SET n.entity_type = $entity_type, n.source_ids = $source_ids, n.description = $description, n.created_at = $created_at
+ON CREATE SET n.entity_type = $entity_type, n.source_ids = $source_ids, n.description = $description, n.created_at = $created_at, n.aliases = $aliases, n.properties = $properties, n.pagerank = $pagerank, n.betweenness = $betweenness,
+    n.updated_at = $updated_at, n.update_count = $update_count, n.metadata = $metadata
+ON MATCH SET n.entity_type = $entity_type, n.source_ids = $source_ids, n.description = $description, n.created_at = coalesce(n.created_at, $created_at), n.aliases = $aliases, n.properties = $properties, n.pagerank = $pagerank, n.betweenness = $betweenness,
+    n.updated_at = $updated_at, n.update_count = $update_count, n.metadata = $metadata
 `
-	params := map[string]any{
-		"entity_id":   entity.Name,
-		"entity_type": entity.Type,
-		"description": entity.Descriptions,
-		"source_ids":  entity.SourceIDs,
-		"created_at":  entity.CreatedAt.Format(time.RFC3339),
+	properties, err := marshalProperties(entity.Properties)
+	if err != nil {
+		return err
 	}
-	prepped, err := k.Conn.Prepare(query)
+	metadata, err := marshalMetadata(entity.Metadata)
 	if err != nil {
-		return fmt.Errorf("failed to prepare GraphUpsertEntity: %w", err)
+		return err
 	}
-	_, err = k.Conn.Execute(prepped, params)
-	return err
+	params := map[string]any{
+		"entity_id":    entity.Name,
+		"entity_type":  entity.Type,
+		"description":  entity.Descriptions,
+		"source_ids":   entity.SourceIDs,
+		"created_at":   entity.CreatedAt.Format(time.RFC3339),
+		"updated_at":   entity.UpdatedAt.Format(time.RFC3339),
+		"update_count": int64(entity.UpdateCount),
+		"aliases":      strings.Join(entity.Aliases, golightrag.GraphFieldSeparator),
+		"properties":   properties,
+		"pagerank":     entity.PageRank,
+		"betweenness":  entity.Betweenness,
+		"metadata":     metadata,
+	}
+	queryResult, err := k.execute(query, params)
+	if err != nil {
+		return fmt.Errorf("failed to run GraphUpsertEntity query: %w", err)
+	}
+	queryResult.Close()
+	return nil
 }
 
 // GraphUpsertRelationship creates or updates a relationship between two entities.
+// Relationships are undirected, so an existing relationship is updated whichever way its
+// entities are given. The creation time of an existing relationship is kept.
 func (k Kuzu) GraphUpsertRelationship(relationship golightrag.GraphRelationship) error {
-	query := `
-MATCH (s:base {entity_id: $source_entity_id})
-WITH s
-MATCH (t:base {entity_id: $target_entity_id})
-MERGE (s)<-[r:DIRECTED]-(t)
-ON CREATE SET  r.weight = $weight, r.description = $description, r.keywords = $keywords, r.source_ids = $source_ids, r.created_at = $created_at
-ON MATCH SET r.weight = $weight, r.description = $description, r.keywords = $keywords, r.source_ids = $source_ids, r.created_at = $created_at
-MERGE (t)-[r2:DIRECTED]->(s)
-ON CREATE SET r2.weight = $weight, r2.description = $description, r2.keywords = $keywords, r2.source_ids = $source_ids, r2.created_at = $created_at
-ON MATCH SET  r2.weight = $weight, r2.description = $description, r2.keywords = $keywords, r2.source_ids = $source_ids, r2.created_at = $created_at
+	// The update matches a directed pattern, once per direction, since updating through an
+	// undirected pattern trips an assertion in Kuzu.
+	updateQuery := `
+MATCH (s:base {entity_id: $source_entity_id})-[r:DIRECTED]->(t:base {entity_id: $target_entity_id})
+SET r.weight = $weight, r.description = $description, r.keywords = $keywords, r.source_ids = $source_ids, r.created_at = coalesce(r.created_at, $created_at), r.properties = $properties,
+    r.valid_from = $valid_from, r.valid_to = $valid_to, r.history = $history, r.updated_at = $updated_at, r.update_count = $update_count,
+    r.metadata = $metadata
+RETURN COUNT(r)
 `
+	createQuery := `
+MATCH (s:base {entity_id: $source_entity_id}), (t:base {entity_id: $target_entity_id})
+CREATE (s)-[r:DIRECTED {weight: $weight, description: $description, keywords: $keywords, source_ids: $source_ids, created_at: $created_at, properties: $properties,
+    valid_from: $valid_from, valid_to: $valid_to, history: $history, updated_at: $updated_at, update_count: $update_count,
+    metadata: $metadata}]->(t)
+`
+	properties, err := marshalProperties(relationship.Properties)
+	if err != nil {
+		return err
+	}
+	history, err := marshalHistory(relationship.History)
+	if err != nil {
+		return err
+	}
+	metadata, err := marshalMetadata(relationship.Metadata)
+	if err != nil {
+		return err
+	}
 	params := map[string]any{
 		"source_entity_id": relationship.SourceEntity,
 		"target_entity_id": relationship.TargetEntity,
@@ -242,13 +515,58 @@ ON MATCH SET  r2.weight = $weight, r2.description = $description, r2.keywords =
 		"keywords":         strings.Join(relationship.Keywords, golightrag.GraphFieldSeparator),
 		"source_ids":       relationship.SourceIDs,
 		"created_at":       relationship.CreatedAt.Format(time.RFC3339),
+		"properties":       properties,
+		"valid_from":       formatValidity(relationship.ValidFrom),
+		"valid_to":         formatValidity(relationship.ValidTo),
+		"history":          history,
+		"updated_at":       relationship.UpdatedAt.Format(time.RFC3339),
+		"update_count":     int64(relationship.UpdateCount),
+		"metadata":         metadata,
+	}
+
+	for _, pair := range [][2]string{
+		{relationship.SourceEntity, relationship.TargetEntity},
+		{relationship.TargetEntity, relationship.SourceEntity},
+	} {
+		params["source_entity_id"], params["target_entity_id"] = pair[0], pair[1]
+		updated, err := k.updateRelationship(updateQuery, params)
+		if err != nil {
+			return err
+		}
+		if updated {
+			return nil
+		}
 	}
-	prepped, err := k.Conn.Prepare(query)
+
+	params["source_entity_id"] = relationship.SourceEntity
+	params["target_entity_id"] = relationship.TargetEntity
+	createResult, err := k.execute(createQuery, params)
 	if err != nil {
-		return fmt.Errorf("failed to run GraphUpsertRelationship: %w", err)
+		return fmt.Errorf("failed to run GraphUpsertRelationship create query: %w", err)
 	}
-	_, err = k.Conn.Execute(prepped, params)
-	return err
+	createResult.Close()
+	return nil
+}
+
+// updateRelationship runs the update query of GraphUpsertRelationship and reports whether a
+// relationship was updated.
+func (k Kuzu) updateRelationship(query string, params map[string]any) (bool, error) {
+	queryResult, err := k.execute(query, params)
+	if err != nil {
+		return false, fmt.Errorf("failed to run GraphUpsertRelationship update query: %w", err)
+	}
+	defer queryResult.Close()
+
+	if !queryResult.HasNext() {
+		return false, nil
+	}
+	row, err := queryResult.Next()
+	if err != nil {
+		return false, fmt.Errorf("failed to get GraphUpsertRelationship result row: %w", err)
+	}
+	countVal, _ := row.GetValue(0)
+	count, _ := countVal.(int64)
+	return count > 0, nil
 }
 
 // GraphEntities retrieves multiple graph entities by their names from the Kuzu database.
@@ -263,11 +581,9 @@ func (k Kuzu) GraphEntities(names []string) (map[string]golightrag.GraphEntity,
 	RETURN n, n.entity_id as entity_id
 	`
 	params := map[string]any{"entityIDs": names}
-	prepped, _ := k.Conn.Prepare(query)
-
-	queryResult, err := k.Conn.Execute(prepped, params)
+	queryResult, err := k.execute(query, params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to run GraphUpsertRelationship query: %w", err)
+		return nil, fmt.Errorf("failed to run GraphEntities query: %w", err)
 	}
 	defer queryResult.Close()
 
@@ -275,23 +591,148 @@ func (k Kuzu) GraphEntities(names []string) (map[string]golightrag.GraphEntity,
 	for queryResult.HasNext() {
 		row, err := queryResult.Next()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get GraphUpsertRelationship result row: %w", err)
+			return nil, fmt.Errorf("failed to get GraphEntities result row: %w", err)
 		}
 		nodeVal, err := row.GetValue(0)
 		if err != nil {
 			continue
 		}
-		nodeProps, ok := nodeVal.(map[string]any)
+		node, ok := nodeVal.(kuzu.Node)
 		if !ok {
 			continue
 		}
-		entity := graphEntityFromMap(nodeProps)
+		entity := graphEntityFromMap(node.Properties)
 		entities[entity.Name] = entity
 	}
 
 	return entities, nil
 }
 
+// GraphAllEntities retrieves every entity of the Kuzu database.
+func (k Kuzu) GraphAllEntities() ([]golightrag.GraphEntity, error) {
+	queryResult, err := k.execute(`MATCH (n:base) RETURN n`, map[string]any{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run GraphAllEntities query: %w", err)
+	}
+	defer queryResult.Close()
+
+	entities := make([]golightrag.GraphEntity, 0)
+	for queryResult.HasNext() {
+		row, err := queryResult.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get GraphAllEntities result row: %w", err)
+		}
+		nodeVal, err := row.GetValue(0)
+		if err != nil {
+			continue
+		}
+		node, ok := nodeVal.(kuzu.Node)
+		if !ok {
+			continue
+		}
+		entities = append(entities, graphEntityFromMap(node.Properties))
+	}
+
+	return entities, nil
+}
+
+// GraphAllRelationships retrieves every relationship of the Kuzu database, in the direction it
+// was created.
+func (k Kuzu) GraphAllRelationships() ([]golightrag.GraphRelationship, error) {
+	query := `
+MATCH (s:base)-[r:DIRECTED]->(e:base)
+RETURN s.entity_id as source, e.entity_id as target, {
+keywords: r.keywords,
+weight: r.weight,
+description: r.description,
+created_at: r.created_at,
+updated_at: r.updated_at,
+update_count: r.update_count,
+source_ids: r.source_ids,
+properties: r.properties,
+valid_from: r.valid_from,
+valid_to: r.valid_to,
+history: r.history,
+metadata: r.metadata
+} as edge_properties
+`
+	queryResult, err := k.execute(query, map[string]any{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run GraphAllRelationships query: %w", err)
+	}
+	defer queryResult.Close()
+
+	relationships := make([]golightrag.GraphRelationship, 0)
+	for queryResult.HasNext() {
+		row, err := queryResult.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get GraphAllRelationships result row: %w", err)
+		}
+		sourceVal, _ := row.GetValue(0)
+		targetVal, _ := row.GetValue(1)
+		propsVal, _ := row.GetValue(2)
+
+		sourceStr, sourceOK := sourceVal.(string)
+		targetStr, targetOK := targetVal.(string)
+		props, propsOK := propsVal.(map[string]any)
+
+		if !sourceOK || !targetOK || !propsOK {
+			continue
+		}
+		relationships = append(relationships, graphRelationshipFromMap(sourceStr, targetStr, props))
+	}
+
+	return relationships, nil
+}
+
+// GraphEntitiesByAlias retrieves the graph entities having one of the aliases from the Kuzu
+// database, keyed by alias.
+func (k Kuzu) GraphEntitiesByAlias(aliases []string) (map[string]golightrag.GraphEntity, error) {
+	if len(aliases) == 0 {
+		return map[string]golightrag.GraphEntity{}, nil
+	}
+
+	query := `
+	UNWIND $aliases AS alias
+	MATCH (n:base)
+	WHERE list_contains(string_split(n.aliases, $separator), alias)
+	RETURN alias, n
+	`
+	params := map[string]any{"aliases": aliases, "separator": golightrag.GraphFieldSeparator}
+	queryResult, err := k.execute(query, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run GraphEntitiesByAlias query: %w", err)
+	}
+	defer queryResult.Close()
+
+	entities := make(map[string]golightrag.GraphEntity)
+	for queryResult.HasNext() {
+		row, err := queryResult.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get GraphEntitiesByAlias result row: %w", err)
+		}
+		aliasVal, err := row.GetValue(0)
+		if err != nil {
+			continue
+		}
+		alias, ok := aliasVal.(string)
+		if !ok {
+			continue
+		}
+		nodeVal, err := row.GetValue(1)
+		if err != nil {
+			continue
+		}
+		node, ok := nodeVal.(kuzu.Node)
+		if !ok {
+			continue
+		}
+		entities[alias] = graphEntityFromMap(node.Properties)
+	}
+
+	return entities, nil
+}
+
 // GraphRelationships retrieves multiple relationships between entity pairs.
 func (k Kuzu) GraphRelationships(pairs [][2]string) (map[string]golightrag.GraphRelationship, error) {
 	if len(pairs) == 0 {
@@ -306,7 +747,14 @@ keywords: r.keywords,
 weight: r.weight,
 description: r.description,
 created_at: r.created_at,
-source_ids: r.source_ids
+updated_at: r.updated_at,
+update_count: r.update_count,
+source_ids: r.source_ids,
+properties: r.properties,
+valid_from: r.valid_from,
+valid_to: r.valid_to,
+history: r.history,
+metadata: r.metadata
 } as edge_properties
 `
 	pairsParam := make([][]string, len(pairs))
@@ -314,9 +762,7 @@ source_ids: r.source_ids
 		pairsParam[i] = []string{p[0], p[1]}
 	}
 	params := map[string]any{"pairs": pairsParam}
-	prepped, _ := k.Conn.Prepare(query)
-
-	queryResult, err := k.Conn.Execute(prepped, params)
+	queryResult, err := k.execute(query, params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run query: %w", err)
 	}
@@ -359,9 +805,7 @@ OPTIONAL MATCH (n)-[r]-()
 RETURN n.entity_id AS entity_id, COUNT(r) AS degree
 `
 	params := map[string]any{"entity_ids": names}
-	prepped, _ := k.Conn.Prepare(query)
-
-	queryResult, err := k.Conn.Execute(prepped, params)
+	queryResult, err := k.execute(query, params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run GraphCountEntitiesRelationships query: %w", err)
 	}
@@ -399,9 +843,7 @@ WHERE connected.entity_id IS NOT NULL
 RETURN n.entity_id as source_id, collect(connected) as connected_nodes
 `
 	params := map[string]any{"entity_ids": names}
-	prepped, _ := k.Conn.Prepare(query)
-
-	queryResult, err := k.Conn.Execute(prepped, params)
+	queryResult, err := k.execute(query, params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run GraphRelatedEntities query: %w", err)
 	}
@@ -425,8 +867,8 @@ RETURN n.entity_id as source_id, collect(connected) as connected_nodes
 
 		entities := make([]golightrag.GraphEntity, 0, len(connectedNodes))
 		for _, node := range connectedNodes {
-			if nodeProps, ok := node.(map[string]any); ok {
-				entities = append(entities, graphEntityFromMap(nodeProps))
+			if connected, ok := node.(kuzu.Node); ok {
+				entities = append(entities, graphEntityFromMap(connected.Properties))
 			}
 		}
 		relatedEntities[sourceID] = entities
@@ -435,11 +877,14 @@ RETURN n.entity_id as source_id, collect(connected) as connected_nodes
 }
 
 // Close terminates the connection to the Kuzu database.
+// Queries on a closed Kuzu return an error.
 func (k *Kuzu) Close() {
 	if k.Conn != nil {
 		k.Conn.Close()
+		k.Conn = nil
 	}
 	if k.DB != nil {
 		k.DB.Close()
+		k.DB = nil
 	}
 }
@@ -1,11 +1,12 @@
 package storage
 
 import (
-	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/storage/storagetest"
 	"github.com/kuzudb/go-kuzu"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -56,13 +57,8 @@ var (
 // setupKuzuTestDB creates a temporary KuzuDB instance for testing.
 func setupKuzuTestDB(t *testing.T) Kuzu {
 	t.Helper()
-	dbPath, err := os.MkdirTemp("", "kuzu-test-*")
-	require.NoError(t, err)
-
-	// Use a cleanup function to remove the database directory after the test.
-	t.Cleanup(func() {
-		os.RemoveAll(dbPath)
-	})
+	// Kuzu creates the database itself, so it must not be an existing directory.
+	dbPath := filepath.Join(t.TempDir(), "db")
 	systemConfig := kuzu.DefaultSystemConfig()
 	// Use default system config for tests
 	k, err := NewKuzu(dbPath, systemConfig)
@@ -227,3 +223,19 @@ func TestKuzu_Close(t *testing.T) {
 		k.Close()
 	})
 }
+
+func TestKuzu_Conformance(t *testing.T) {
+	storagetest.TestGraphStorage(t, func(t *testing.T) golightrag.GraphStorage {
+		k := setupKuzuTestDB(t)
+		t.Cleanup(k.Close)
+		return k
+	})
+}
+
+func TestKuzu_Workspaces(t *testing.T) {
+	storagetest.TestWorkspaces(t, func(t *testing.T) func(string) (Kuzu, error) {
+		k := setupKuzuTestDB(t)
+		t.Cleanup(k.Close)
+		return k.Workspace
+	})
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/milvus-io/milvus/client/v2/column"
 	"github.com/milvus-io/milvus/client/v2/entity"
 	"github.com/milvus-io/milvus/client/v2/index"
 	"github.com/milvus-io/milvus/client/v2/milvusclient"
@@ -22,11 +26,17 @@ type Milvus struct {
 	embeddingFunc EmbeddingFunc
 	vectorDim     int
 	topK          int
+
+	entitiesCollection      string
+	relationshipsCollection string
 }
 
 const (
 	milvusEntitiesCollectionName      = "entities"
 	milvusRelationshipsCollectionName = "relationships"
+	// milvusMetadataField is the dynamic field holding the metadata markers of the entities and
+	// relationships.
+	milvusMetadataField = "metadata"
 
 	cosineThreshold = 0.2
 )
@@ -50,19 +60,51 @@ func NewMilvus(config *milvusclient.ClientConfig, topK, vectorDim int, embedding
 		topK:          topK,
 	}
 
-	if err := m.createEntitiesCollection(ctx); err != nil {
+	return m.Workspace("")
+}
+
+// Workspace returns the Milvus scoped to the workspace, whose collections are suffixed with its
+// name and created if needed. The empty workspace is the default one. It shares the client of
+// m, so only m should be closed.
+func (m Milvus) Workspace(name string) (Milvus, error) {
+	if err := validateWorkspace(name); err != nil {
 		return Milvus{}, err
 	}
 
-	if err := m.createRelationshipsCollection(ctx); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	w := m
+	w.entitiesCollection = milvusEntitiesCollectionName
+	w.relationshipsCollection = milvusRelationshipsCollectionName
+	if name != "" {
+		w.entitiesCollection += "_" + name
+		w.relationshipsCollection += "_" + name
+	}
+
+	if err := w.createEntitiesCollection(ctx); err != nil {
 		return Milvus{}, err
 	}
 
-	return m, nil
+	if err := w.createRelationshipsCollection(ctx); err != nil {
+		return Milvus{}, err
+	}
+
+	return w, nil
 }
 
 // VectorQueryEntity performs a semantic search for entities based on the provided keywords.
 func (m Milvus) VectorQueryEntity(keywords string) ([]string, error) {
+	return m.queryEntities(keywords, "")
+}
+
+// VectorQueryEntityWithFilter performs VectorQueryEntity among the entities whose metadata
+// matches the filter.
+func (m Milvus) VectorQueryEntityWithFilter(keywords string, filter golightrag.MetadataFilter) ([]string, error) {
+	return m.queryEntities(keywords, milvusFilterExpr(filter))
+}
+
+func (m Milvus) queryEntities(keywords, expr string) ([]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -75,9 +117,14 @@ func (m Milvus) VectorQueryEntity(keywords string) ([]string, error) {
 	annParam := index.NewCustomAnnParam()
 	annParam.WithRadius(cosineThreshold)
 	opt := milvusclient.
-		NewSearchOption(milvusEntitiesCollectionName, m.topK, vectors).
+		NewSearchOption(m.entitiesCollection, m.topK, vectors).
 		WithOutputFields("entity_name").
-		WithAnnParam(annParam)
+		WithAnnParam(annParam).
+		// Read the upserts made just before, such as those of the same insertion.
+		WithConsistencyLevel(entity.ClStrong)
+	if expr != "" {
+		opt = opt.WithFilter(expr)
+	}
 	searchResult, err := m.client.Search(ctx, opt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query entities: %w", err)
@@ -112,6 +159,18 @@ func (m Milvus) VectorQueryEntity(keywords string) ([]string, error) {
 
 // VectorQueryRelationship performs a semantic search for relationships based on the provided keywords.
 func (m Milvus) VectorQueryRelationship(keywords string) ([][2]string, error) {
+	return m.queryRelationships(keywords, "")
+}
+
+// VectorQueryRelationshipWithFilter performs VectorQueryRelationship among the relationships
+// whose metadata matches the filter.
+func (m Milvus) VectorQueryRelationshipWithFilter(
+	keywords string, filter golightrag.MetadataFilter,
+) ([][2]string, error) {
+	return m.queryRelationships(keywords, milvusFilterExpr(filter))
+}
+
+func (m Milvus) queryRelationships(keywords, expr string) ([][2]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -124,9 +183,13 @@ func (m Milvus) VectorQueryRelationship(keywords string) ([][2]string, error) {
 	annParam := index.NewCustomAnnParam()
 	annParam.WithRadius(cosineThreshold)
 	opt := milvusclient.
-		NewSearchOption(milvusRelationshipsCollectionName, m.topK, vectors).
+		NewSearchOption(m.relationshipsCollection, m.topK, vectors).
 		WithOutputFields("source_entity", "target_entity").
-		WithAnnParam(annParam)
+		WithAnnParam(annParam).
+		WithConsistencyLevel(entity.ClStrong)
+	if expr != "" {
+		opt = opt.WithFilter(expr)
+	}
 	searchResult, err := m.client.Search(ctx, opt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query relationships: %w", err)
@@ -180,6 +243,12 @@ func (m Milvus) VectorQueryRelationship(keywords string) ([][2]string, error) {
 
 // VectorUpsertEntity creates or updates an entity with vector embedding based on its content.
 func (m Milvus) VectorUpsertEntity(name, content string) error {
+	return m.VectorUpsertEntityWithMetadata(name, content, nil)
+}
+
+// VectorUpsertEntityWithMetadata creates or updates an entity like VectorUpsertEntity, storing
+// its metadata for VectorQueryEntityWithFilter.
+func (m Milvus) VectorUpsertEntityWithMetadata(name, content string, metadata map[string][]string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
@@ -188,10 +257,11 @@ func (m Milvus) VectorUpsertEntity(name, content string) error {
 		return fmt.Errorf("failed to generate embedding for entity: %w", err)
 	}
 
-	opt := milvusclient.NewColumnBasedInsertOption(milvusEntitiesCollectionName).
+	opt := milvusclient.NewColumnBasedInsertOption(m.entitiesCollection).
 		WithVarcharColumn("id", []string{name}).
 		WithVarcharColumn("entity_name", []string{name}).
-		WithFloatVectorColumn("vector", m.vectorDim, [][]float32{vector})
+		WithFloatVectorColumn("vector", m.vectorDim, [][]float32{vector}).
+		WithColumns(milvusMetadataColumn(metadata))
 	_, err = m.client.Upsert(ctx, opt)
 	if err != nil {
 		return fmt.Errorf("failed to upsert entity: %w", err)
@@ -202,6 +272,14 @@ func (m Milvus) VectorUpsertEntity(name, content string) error {
 
 // VectorUpsertRelationship creates or updates a relationship with vector embedding based on its content.
 func (m Milvus) VectorUpsertRelationship(source, target, content string) error {
+	return m.VectorUpsertRelationshipWithMetadata(source, target, content, nil)
+}
+
+// VectorUpsertRelationshipWithMetadata creates or updates a relationship like
+// VectorUpsertRelationship, storing its metadata for VectorQueryRelationshipWithFilter.
+func (m Milvus) VectorUpsertRelationshipWithMetadata(
+	source, target, content string, metadata map[string][]string,
+) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
@@ -211,11 +289,12 @@ func (m Milvus) VectorUpsertRelationship(source, target, content string) error {
 	}
 
 	id := fmt.Sprintf("%s-%s", source, target)
-	opt := milvusclient.NewColumnBasedInsertOption(milvusRelationshipsCollectionName).
+	opt := milvusclient.NewColumnBasedInsertOption(m.relationshipsCollection).
 		WithVarcharColumn("id", []string{id}).
 		WithVarcharColumn("source_entity", []string{source}).
 		WithVarcharColumn("target_entity", []string{target}).
-		WithFloatVectorColumn("vector", m.vectorDim, [][]float32{vector})
+		WithFloatVectorColumn("vector", m.vectorDim, [][]float32{vector}).
+		WithColumns(milvusMetadataColumn(metadata))
 	_, err = m.client.Upsert(ctx, opt)
 	if err != nil {
 		return fmt.Errorf("failed to upsert relationship: %w", err)
@@ -224,6 +303,23 @@ func (m Milvus) VectorUpsertRelationship(source, target, content string) error {
 	return nil
 }
 
+// milvusMetadataColumn returns the column of the metadata markers of a row, stored in the
+// dynamic field of the collections.
+func milvusMetadataColumn(metadata map[string][]string) column.Column {
+	return column.NewColumnVarCharArray(milvusMetadataField, [][]string{metadataMarkers(metadata)})
+}
+
+// milvusFilterExpr returns the boolean expression of the rows marked with every value of filter.
+func milvusFilterExpr(filter golightrag.MetadataFilter) string {
+	conditions := make([]string, 0, len(filter))
+	for key, value := range filter {
+		conditions = append(conditions, fmt.Sprintf("json_contains(%s, %s)", milvusMetadataField,
+			strconv.Quote(metadataMarker(key, value))))
+	}
+	slices.Sort(conditions)
+	return strings.Join(conditions, " && ")
+}
+
 // Close closes the connection to Milvus.
 func (m Milvus) Close(ctx context.Context) error {
 	if m.client != nil {
@@ -233,7 +329,7 @@ func (m Milvus) Close(ctx context.Context) error {
 }
 
 func (m Milvus) createEntitiesCollection(ctx context.Context) error {
-	has, err := m.client.HasCollection(ctx, milvusclient.NewHasCollectionOption(milvusEntitiesCollectionName))
+	has, err := m.client.HasCollection(ctx, milvusclient.NewHasCollectionOption(m.entitiesCollection))
 	if err != nil {
 		return fmt.Errorf("failed to check if entities collection exists: %w", err)
 	}
@@ -243,7 +339,7 @@ func (m Milvus) createEntitiesCollection(ctx context.Context) error {
 	}
 
 	err = m.client.CreateCollection(ctx,
-		milvusclient.SimpleCreateCollectionOptions(milvusEntitiesCollectionName, int64(m.vectorDim)).
+		milvusclient.SimpleCreateCollectionOptions(m.entitiesCollection, int64(m.vectorDim)).
 			WithAutoID(false).
 			WithVarcharPK(true, 64))
 	if err != nil {
@@ -254,7 +350,7 @@ func (m Milvus) createEntitiesCollection(ctx context.Context) error {
 }
 
 func (m Milvus) createRelationshipsCollection(ctx context.Context) error {
-	has, err := m.client.HasCollection(ctx, milvusclient.NewHasCollectionOption(milvusRelationshipsCollectionName))
+	has, err := m.client.HasCollection(ctx, milvusclient.NewHasCollectionOption(m.relationshipsCollection))
 	if err != nil {
 		return fmt.Errorf("failed to check if relationships collection exists: %w", err)
 	}
@@ -264,7 +360,7 @@ func (m Milvus) createRelationshipsCollection(ctx context.Context) error {
 	}
 
 	err = m.client.CreateCollection(ctx,
-		milvusclient.SimpleCreateCollectionOptions(milvusRelationshipsCollectionName, int64(m.vectorDim)).
+		milvusclient.SimpleCreateCollectionOptions(m.relationshipsCollection, int64(m.vectorDim)).
 			WithAutoID(false).
 			WithVarcharPK(true, 64))
 	if err != nil {
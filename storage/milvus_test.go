@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/storage/storagetest"
+	"github.com/milvus-io/milvus/client/v2/milvusclient"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMilvus connects to the database at MILVUS_TEST_ADDR and drops the collections of the
+// tested workspaces, which the returned Milvus creates again empty.
+func newTestMilvus(t *testing.T) Milvus {
+	t.Helper()
+
+	addr := os.Getenv("MILVUS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("MILVUS_TEST_ADDR is not set")
+	}
+
+	m, err := NewMilvus(&milvusclient.ClientConfig{
+		Address:  addr,
+		Username: os.Getenv("MILVUS_TEST_USER"),
+		Password: os.Getenv("MILVUS_TEST_PASSWORD"),
+	}, 5, 64, bagOfWordsEmbedding)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = m.Close(context.Background()) })
+
+	for _, workspace := range []string{"", "_acme", "_globex"} {
+		for _, collection := range []string{milvusEntitiesCollectionName, milvusRelationshipsCollectionName} {
+			require.NoError(t, m.client.DropCollection(context.Background(),
+				milvusclient.NewDropCollectionOption(collection+workspace)))
+		}
+	}
+	return m
+}
+
+// TestMilvus_Conformance runs against the database at MILVUS_TEST_ADDR, whose collections are
+// dropped before every subtest.
+func TestMilvus_Conformance(t *testing.T) {
+	storagetest.TestVectorStorage(t, func(t *testing.T) golightrag.VectorStorage {
+		m, err := newTestMilvus(t).Workspace("")
+		require.NoError(t, err)
+		return m
+	})
+}
+
+// TestMilvus_Workspaces runs against the database at MILVUS_TEST_ADDR, whose collections of the
+// tested workspaces are dropped before every subtest.
+func TestMilvus_Workspaces(t *testing.T) {
+	storagetest.TestWorkspaces(t, func(t *testing.T) func(string) (Milvus, error) {
+		return newTestMilvus(t).Workspace
+	})
+}
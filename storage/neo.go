@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -17,6 +18,8 @@ import (
 // and relationships.
 type Neo4J struct {
 	Client neo4j.DriverWithContext
+
+	workspace string
 }
 
 // NewNeo4J creates a new Neo4j client connection with the provided connection parameters.
@@ -32,6 +35,28 @@ func NewNeo4J(target, user, password string) (Neo4J, error) {
 	return Neo4J{Client: driver}, nil
 }
 
+// Workspace returns the Neo4J scoped to the workspace, whose entities are labeled with its
+// name instead of the base label, so its queries only match the entities and relationships of
+// the workspace. The empty workspace is the default one. It shares the client of n, so only n
+// should be closed.
+func (n Neo4J) Workspace(name string) (Neo4J, error) {
+	if err := validateWorkspace(name); err != nil {
+		return Neo4J{}, err
+	}
+	return Neo4J{Client: n.Client, workspace: name}, nil
+}
+
+// neo4JBaseLabel matches the base label of the entities in the queries.
+var neo4JBaseLabel = regexp.MustCompile(`:base\b`)
+
+// scopeQuery returns query with the base label replaced by the label of the workspace.
+func (n Neo4J) scopeQuery(query string) string {
+	if n.workspace == "" {
+		return query
+	}
+	return neo4JBaseLabel.ReplaceAllString(query, ":base_"+n.workspace)
+}
+
 func graphEntityFromNode(node dbtype.Node) golightrag.GraphEntity {
 	name, ok := node.Props["entity_id"].(string)
 	if !ok {
@@ -49,6 +74,26 @@ func graphEntityFromNode(node dbtype.Node) golightrag.GraphEntity {
 	if !ok {
 		sourceIDs = ""
 	}
+	aliases, ok := node.Props["aliases"].(string)
+	if !ok {
+		aliases = ""
+	}
+	properties, ok := node.Props["properties"].(string)
+	if !ok {
+		properties = ""
+	}
+	pageRank, ok := node.Props["pagerank"].(float64)
+	if !ok {
+		pageRank = 0
+	}
+	betweenness, ok := node.Props["betweenness"].(float64)
+	if !ok {
+		betweenness = 0
+	}
+	metadata, ok := node.Props["metadata"].(string)
+	if !ok {
+		metadata = ""
+	}
 	createdAtStr, ok := node.Props["created_at"].(string)
 	if !ok {
 		createdAtStr = time.Now().Format(time.RFC3339)
@@ -57,13 +102,21 @@ func graphEntityFromNode(node dbtype.Node) golightrag.GraphEntity {
 	if err != nil {
 		createdAt = time.Now()
 	}
+	updatedAt, updateCount := updateFromMap(createdAt, node.Props)
 
 	return golightrag.GraphEntity{
 		Name:         name,
 		Type:         typ,
 		Descriptions: desc,
 		SourceIDs:    sourceIDs,
+		Aliases:      splitField(aliases),
+		Properties:   unmarshalProperties(properties),
+		PageRank:     pageRank,
+		Betweenness:  betweenness,
+		Metadata:     unmarshalMetadata(metadata),
 		CreatedAt:    createdAt,
+		UpdatedAt:    updatedAt,
+		UpdateCount:  updateCount,
 	}
 }
 
@@ -80,11 +133,31 @@ func graphRelationshipFromEdge(source, target string, props map[string]any) goli
 	if !ok {
 		keywords = ""
 	}
-	arrKeywords := strings.Split(keywords, golightrag.GraphFieldSeparator)
+	arrKeywords := splitField(keywords)
 	sourceIDs, ok := props["source_ids"].(string)
 	if !ok {
 		sourceIDs = ""
 	}
+	properties, ok := props["properties"].(string)
+	if !ok {
+		properties = ""
+	}
+	validFrom, ok := props["valid_from"].(string)
+	if !ok {
+		validFrom = ""
+	}
+	validTo, ok := props["valid_to"].(string)
+	if !ok {
+		validTo = ""
+	}
+	history, ok := props["history"].(string)
+	if !ok {
+		history = ""
+	}
+	metadata, ok := props["metadata"].(string)
+	if !ok {
+		metadata = ""
+	}
 	createdAtStr, ok := props["created_at"].(string)
 	if !ok {
 		createdAtStr = time.Now().Format(time.RFC3339)
@@ -93,6 +166,7 @@ func graphRelationshipFromEdge(source, target string, props map[string]any) goli
 	if err != nil {
 		createdAt = time.Now()
 	}
+	updatedAt, updateCount := updateFromMap(createdAt, props)
 
 	return golightrag.GraphRelationship{
 		SourceEntity: source,
@@ -101,7 +175,14 @@ func graphRelationshipFromEdge(source, target string, props map[string]any) goli
 		Descriptions: description,
 		Keywords:     arrKeywords,
 		SourceIDs:    sourceIDs,
+		Properties:   unmarshalProperties(properties),
+		ValidFrom:    parseValidity(validFrom),
+		ValidTo:      parseValidity(validTo),
+		History:      unmarshalHistory(history),
+		Metadata:     unmarshalMetadata(metadata),
 		CreatedAt:    createdAt,
+		UpdatedAt:    updatedAt,
+		UpdateCount:  updateCount,
 	}
 }
 
@@ -111,7 +192,7 @@ func (n Neo4J) GraphEntity(name string) (golightrag.GraphEntity, error) {
 	res, err := n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
 		return sess.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
 			query := "MATCH (n:base {entity_id: $entityID}) RETURN n"
-			queryRes, err := tx.Run(ctx, query, map[string]any{
+			queryRes, err := tx.Run(ctx, n.scopeQuery(query), map[string]any{
 				"entityID": name,
 			})
 			if err != nil {
@@ -153,7 +234,7 @@ func (n Neo4J) GraphRelationship(sourceEntity, targetEntity string) (golightrag.
 MATCH (start:base {entity_id: $source_entity_id})-[r]-(end:base {entity_id: $target_entity_id})
 RETURN properties(r) as edge_properties
       `
-			queryRes, err := tx.Run(ctx, query, map[string]any{
+			queryRes, err := tx.Run(ctx, n.scopeQuery(query), map[string]any{
 				"source_entity_id": sourceEntity,
 				"target_entity_id": targetEntity,
 			})
@@ -188,24 +269,41 @@ RETURN properties(r) as edge_properties
 	return graphRelationshipFromEdge(sourceEntity, targetEntity, props), nil
 }
 
-// GraphUpsertEntity creates or updates an entity in the Neo4j graph database.
+// GraphUpsertEntity creates or updates an entity in the Neo4j graph database, keeping the
+// creation time of an existing entity.
 // It returns an error if the database operation fails.
 func (n Neo4J) GraphUpsertEntity(entity golightrag.GraphEntity) error {
-	_, err := n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
+	properties, err := marshalProperties(entity.Properties)
+	if err != nil {
+		return err
+	}
+	metadata, err := marshalMetadata(entity.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
 		return sess.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
 			return tx.Run(
 				ctx,
-				fmt.Sprintf(`
+				n.scopeQuery(fmt.Sprintf(`
 MERGE (n:base {entity_id: $properties.entity_id})
+ON CREATE SET n.created_at = $created_at
 SET n += $properties
-SET n:%s`, "`"+entity.Type+"`"),
+SET n:%s`, "`"+entity.Type+"`")),
 				map[string]any{
+					"created_at": entity.CreatedAt.Format(time.RFC3339),
 					"properties": map[string]any{
-						"entity_id":   entity.Name,
-						"entity_type": entity.Type,
-						"description": entity.Descriptions,
-						"source_ids":  entity.SourceIDs,
-						"created_at":  entity.CreatedAt.Format(time.RFC3339),
+						"entity_id":    entity.Name,
+						"entity_type":  entity.Type,
+						"description":  entity.Descriptions,
+						"source_ids":   entity.SourceIDs,
+						"aliases":      strings.Join(entity.Aliases, golightrag.GraphFieldSeparator),
+						"properties":   properties,
+						"pagerank":     entity.PageRank,
+						"betweenness":  entity.Betweenness,
+						"updated_at":   entity.UpdatedAt.Format(time.RFC3339),
+						"update_count": int64(entity.UpdateCount),
+						"metadata":     metadata,
 					},
 				},
 			)
@@ -215,30 +313,51 @@ SET n:%s`, "`"+entity.Type+"`"),
 	return err
 }
 
-// GraphUpsertRelationship creates or updates a relationship between two entities in the Neo4j graph database.
+// GraphUpsertRelationship creates or updates a relationship between two entities in the Neo4j graph database,
+// keeping the creation time of an existing relationship.
 // It returns an error if the database operation fails.
 func (n Neo4J) GraphUpsertRelationship(relationship golightrag.GraphRelationship) error {
-	_, err := n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
+	properties, err := marshalProperties(relationship.Properties)
+	if err != nil {
+		return err
+	}
+	history, err := marshalHistory(relationship.History)
+	if err != nil {
+		return err
+	}
+	metadata, err := marshalMetadata(relationship.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
 		return sess.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
 			keywords := strings.Join(relationship.Keywords, golightrag.GraphFieldSeparator)
 			return tx.Run(
 				ctx,
-				`
+				n.scopeQuery(`
 MATCH (source:base {entity_id: $source_entity_id})
 WITH source
 MATCH (target:base {entity_id: $target_entity_id})
 MERGE (source)-[r:DIRECTED]-(target)
+ON CREATE SET r.created_at = $created_at
 SET r += $properties
-`,
+`),
 				map[string]any{
+					"created_at":       relationship.CreatedAt.Format(time.RFC3339),
 					"source_entity_id": relationship.SourceEntity,
 					"target_entity_id": relationship.TargetEntity,
 					"properties": map[string]any{
-						"weight":      relationship.Weight,
-						"description": relationship.Descriptions,
-						"keywords":    keywords,
-						"source_ids":  relationship.SourceIDs,
-						"created_at":  relationship.CreatedAt.Format(time.RFC3339),
+						"weight":       relationship.Weight,
+						"description":  relationship.Descriptions,
+						"keywords":     keywords,
+						"source_ids":   relationship.SourceIDs,
+						"properties":   properties,
+						"valid_from":   formatValidity(relationship.ValidFrom),
+						"valid_to":     formatValidity(relationship.ValidTo),
+						"history":      history,
+						"updated_at":   relationship.UpdatedAt.Format(time.RFC3339),
+						"update_count": int64(relationship.UpdateCount),
+						"metadata":     metadata,
 					},
 				},
 			)
@@ -261,7 +380,7 @@ func (n Neo4J) GraphEntities(names []string) (map[string]golightrag.GraphEntity,
 MATCH (n:base) 
 WHERE n.entity_id IN $entityIDs 
 RETURN n, n.entity_id as entity_id`
-			queryRes, err := tx.Run(ctx, query, map[string]any{
+			queryRes, err := tx.Run(ctx, n.scopeQuery(query), map[string]any{
 				"entityIDs": names,
 			})
 			if err != nil {
@@ -317,6 +436,171 @@ RETURN n, n.entity_id as entity_id`
 	return entities, nil
 }
 
+// GraphAllEntities retrieves every entity of the Neo4j database.
+// It returns an error if the query fails.
+func (n Neo4J) GraphAllEntities() ([]golightrag.GraphEntity, error) {
+	res, err := n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
+		return sess.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+			queryRes, err := tx.Run(ctx, n.scopeQuery("MATCH (n:base) RETURN n"), nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to run query: %w", err)
+			}
+
+			result := make([]golightrag.GraphEntity, 0)
+			for record, err := range queryRes.Records(ctx) {
+				if err != nil {
+					return nil, fmt.Errorf("failed to get result: %w", err)
+				}
+
+				node, ok := record.Get("n")
+				if !ok {
+					continue
+				}
+				dbNode, ok := node.(dbtype.Node)
+				if !ok {
+					continue
+				}
+				result = append(result, graphEntityFromNode(dbNode))
+			}
+
+			return result, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entities, ok := res.([]golightrag.GraphEntity)
+	if !ok {
+		return nil, fmt.Errorf("invalid result type, got %T, want []golightrag.GraphEntity", res)
+	}
+
+	return entities, nil
+}
+
+// GraphAllRelationships retrieves every relationship of the Neo4j database, in the direction
+// it was created.
+// It returns an error if the query fails.
+func (n Neo4J) GraphAllRelationships() ([]golightrag.GraphRelationship, error) {
+	res, err := n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
+		return sess.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+			query := `
+MATCH (start:base)-[r:DIRECTED]->(end:base)
+RETURN start.entity_id as source, end.entity_id as target, properties(r) as edge_properties`
+			queryRes, err := tx.Run(ctx, n.scopeQuery(query), nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to run query: %w", err)
+			}
+
+			result := make([]golightrag.GraphRelationship, 0)
+			for record, err := range queryRes.Records(ctx) {
+				if err != nil {
+					return nil, fmt.Errorf("failed to get result: %w", err)
+				}
+
+				source, sourceOK := record.Get("source")
+				target, targetOK := record.Get("target")
+				edgeProps, propsOK := record.Get("edge_properties")
+
+				if !sourceOK || !targetOK || !propsOK {
+					continue
+				}
+
+				sourceStr, sourceOK := source.(string)
+				targetStr, targetOK := target.(string)
+				props, propsOK := edgeProps.(map[string]any)
+
+				if !sourceOK || !targetOK || !propsOK {
+					continue
+				}
+
+				result = append(result, graphRelationshipFromEdge(sourceStr, targetStr, props))
+			}
+
+			return result, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	relationships, ok := res.([]golightrag.GraphRelationship)
+	if !ok {
+		return nil, fmt.Errorf("invalid result type, got %T, want []golightrag.GraphRelationship", res)
+	}
+
+	return relationships, nil
+}
+
+// GraphEntitiesByAlias retrieves the graph entities having one of the aliases from the Neo4j
+// database. It returns a map of aliases to GraphEntity objects, or an error if the query fails.
+func (n Neo4J) GraphEntitiesByAlias(aliases []string) (map[string]golightrag.GraphEntity, error) {
+	if len(aliases) == 0 {
+		return map[string]golightrag.GraphEntity{}, nil
+	}
+
+	res, err := n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
+		return sess.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+			query := `
+UNWIND $aliases AS alias
+MATCH (n:base)
+WHERE alias IN split(n.aliases, $separator)
+RETURN alias, n`
+			queryRes, err := tx.Run(ctx, n.scopeQuery(query), map[string]any{
+				"aliases":   aliases,
+				"separator": golightrag.GraphFieldSeparator,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to run query: %w", err)
+			}
+
+			result := make(map[string]dbtype.Node)
+			for record, err := range queryRes.Records(ctx) {
+				if err != nil {
+					return nil, fmt.Errorf("failed to get result: %w", err)
+				}
+
+				alias, ok := record.Get("alias")
+				if !ok {
+					continue
+				}
+				aliasStr, ok := alias.(string)
+				if !ok {
+					continue
+				}
+
+				node, ok := record.Get("n")
+				if !ok {
+					continue
+				}
+				dbNode, ok := node.(dbtype.Node)
+				if !ok {
+					continue
+				}
+
+				result[aliasStr] = dbNode
+			}
+
+			return result, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nodeMap, ok := res.(map[string]dbtype.Node)
+	if !ok {
+		return nil, fmt.Errorf("invalid result type, got %T, want map[string]dbtype.Node", res)
+	}
+
+	entities := make(map[string]golightrag.GraphEntity)
+	for alias, node := range nodeMap {
+		entities[alias] = graphEntityFromNode(node)
+	}
+
+	return entities, nil
+}
+
 // GraphRelationships retrieves multiple relationships between entity pairs from the Neo4j database.
 // It returns a map where the key is "sourceEntity-targetEntity" and the value is the GraphRelationship.
 func (n Neo4J) GraphRelationships(pairs [][2]string) (map[string]golightrag.GraphRelationship, error) {
@@ -346,14 +630,14 @@ RETURN pair[0] as source, pair[1] as target, properties(r) as edge_properties
 				pairsParam[i] = []string{pair[0], pair[1]}
 			}
 
-			queryRes, err := tx.Run(ctx, query, map[string]any{
+			queryRes, err := tx.Run(ctx, n.scopeQuery(query), map[string]any{
 				"pairs": pairsParam,
 			})
 			if err != nil {
 				return nil, fmt.Errorf("failed to run query: %w", err)
 			}
 
-			result := make(map[string]map[string]any)
+			result := make(map[string]golightrag.GraphRelationship)
 			for record, err := range queryRes.Records(ctx) {
 				if err != nil {
 					return nil, fmt.Errorf("failed to get result: %w", err)
@@ -376,7 +660,7 @@ RETURN pair[0] as source, pair[1] as target, properties(r) as edge_properties
 				}
 
 				key := fmt.Sprintf("%s-%s", sourceStr, targetStr)
-				result[key] = props
+				result[key] = graphRelationshipFromEdge(sourceStr, targetStr, props)
 			}
 
 			return result, nil
@@ -386,20 +670,11 @@ RETURN pair[0] as source, pair[1] as target, properties(r) as edge_properties
 		return nil, err
 	}
 
-	propsMap, ok := res.(map[string]map[string]any)
+	// Entity names may contain the separator of the keys, so the relationships are built
+	// from the returned pairs rather than from the keys.
+	relationships, ok := res.(map[string]golightrag.GraphRelationship)
 	if !ok {
-		return nil, fmt.Errorf("invalid result type, got %T, want map[string]map[string]any", res)
-	}
-
-	relationships := make(map[string]golightrag.GraphRelationship)
-	for key, props := range propsMap {
-		parts := strings.Split(key, "-")
-		if len(parts) != 2 {
-			continue
-		}
-
-		rel := graphRelationshipFromEdge(parts[0], parts[1], props)
-		relationships[key] = rel
+		return nil, fmt.Errorf("invalid result type, got %T, want map[string]golightrag.GraphRelationship", res)
 	}
 
 	return relationships, nil
@@ -420,7 +695,7 @@ WHERE n.entity_id IN $entity_ids
 OPTIONAL MATCH (n)-[r]-()
 RETURN n.entity_id AS entity_id, COUNT(r) AS degree
             `
-			queryRes, err := tx.Run(ctx, query, map[string]any{
+			queryRes, err := tx.Run(ctx, n.scopeQuery(query), map[string]any{
 				"entity_ids": names,
 			})
 			if err != nil {
@@ -487,7 +762,7 @@ OPTIONAL MATCH (n)-[r]-(connected:base)
 WHERE connected.entity_id IS NOT NULL
 RETURN n.entity_id as source_id, collect(connected) as connected_nodes
             `
-			queryRes, err := tx.Run(ctx, query, map[string]any{
+			queryRes, err := tx.Run(ctx, n.scopeQuery(query), map[string]any{
 				"entity_ids": names,
 			})
 			if err != nil {
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/storage/storagetest"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNeo4J_Conformance runs against the database at NEO4J_TEST_URI, whose entities are deleted
+// before every subtest.
+func TestNeo4J_Conformance(t *testing.T) {
+	uri := os.Getenv("NEO4J_TEST_URI")
+	if uri == "" {
+		t.Skip("NEO4J_TEST_URI is not set")
+	}
+
+	storagetest.TestGraphStorage(t, func(t *testing.T) golightrag.GraphStorage {
+		n, err := NewNeo4J(uri, os.Getenv("NEO4J_TEST_USER"), os.Getenv("NEO4J_TEST_PASSWORD"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = n.Close(context.Background()) })
+
+		_, err = n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
+			return sess.Run(ctx, "MATCH (n:base) DETACH DELETE n", nil)
+		})
+		require.NoError(t, err)
+		return n
+	})
+}
+
+// TestNeo4J_Workspaces runs against the database at NEO4J_TEST_URI, whose entities of the tested
+// workspaces are deleted before every subtest.
+func TestNeo4J_Workspaces(t *testing.T) {
+	uri := os.Getenv("NEO4J_TEST_URI")
+	if uri == "" {
+		t.Skip("NEO4J_TEST_URI is not set")
+	}
+
+	storagetest.TestWorkspaces(t, func(t *testing.T) func(string) (Neo4J, error) {
+		n, err := NewNeo4J(uri, os.Getenv("NEO4J_TEST_USER"), os.Getenv("NEO4J_TEST_PASSWORD"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = n.Close(context.Background()) })
+
+		_, err = n.session(func(ctx context.Context, sess neo4j.SessionWithContext) (any, error) {
+			return sess.Run(ctx, "MATCH (n) WHERE n:base OR n:base_acme OR n:base_globex DETACH DELETE n", nil)
+		})
+		require.NoError(t, err)
+		return n.Workspace
+	})
+}
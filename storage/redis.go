@@ -2,8 +2,11 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 
 	golightrag "github.com/MegaGrindStone/go-light-rag"
@@ -14,8 +17,35 @@ import (
 // It handles database operations for storing and retrieving source documents.
 type Redis struct {
 	Client *redis.Client
+
+	workspace string
 }
 
+// Every type of key has its own prefix, in every workspace, so the IDs of one type, which are
+// chosen by the users, can't collide with the keys of another type or workspace.
+// Earlier versions stored the sources and unprocessed markers under their bare ID, so the
+// default workspace still reads them from there when the prefixed key doesn't exist.
+const (
+	// redisSourcePrefix namespaces the contents of the sources.
+	redisSourcePrefix = "source:"
+	// redisSourceDocumentPrefix namespaces the documents of the sources, stored apart from their
+	// content.
+	redisSourceDocumentPrefix = "source_document:"
+	// redisUnprocessedPrefix namespaces the unprocessed markers of the sources.
+	redisUnprocessedPrefix = "unprocessed:"
+	// redisClaimPrefix and redisEntityClaimsPrefix namespace the claims and the sets of claim
+	// IDs of each entity.
+	redisClaimPrefix        = "claim:"
+	redisEntityClaimsPrefix = "entity_claims:"
+	// redisCommunitiesKey is the hash of the communities, keyed by their ID.
+	redisCommunitiesKey = "communities"
+	// redisChangesPrefix namespaces the lists of the change logs.
+	redisChangesPrefix = "changes:"
+	// redisWorkspacePrefix namespaces the keys of the named workspaces. It's distinct from the
+	// prefixes of the key types, so the default workspace can't collide with a named one.
+	redisWorkspacePrefix = "workspace:"
+)
+
 // NewRedis creates a new Redis client connection with the provided configuration.
 // It returns an initialized Redis struct and any error encountered during connection setup.
 func NewRedis(addr, password string, db int) (Redis, error) {
@@ -39,24 +69,65 @@ func NewRedis(addr, password string, db int) (Redis, error) {
 	}, nil
 }
 
+// Workspace returns the Redis scoped to the workspace, whose keys are prefixed with its name.
+// The empty workspace is the default one. It shares the client of r.
+func (r Redis) Workspace(name string) (Redis, error) {
+	if err := validateWorkspace(name); err != nil {
+		return Redis{}, err
+	}
+	return Redis{Client: r.Client, workspace: name}, nil
+}
+
+// key returns the key in the workspace. key must start with the prefix of its type.
+func (r Redis) key(key string) string {
+	if r.workspace == "" {
+		return key
+	}
+	return redisWorkspacePrefix + r.workspace + ":" + key
+}
+
+// get returns the value of the key of the type prefix and id, falling back to the bare id that
+// earlier versions stored it under in the default workspace. It returns redis.Nil if neither
+// exists.
+func (r Redis) get(ctx context.Context, prefix, id string) (string, error) {
+	value, err := r.Client.Get(ctx, r.key(prefix+id)).Result()
+	if !errors.Is(err, redis.Nil) || r.workspace != "" {
+		return value, err
+	}
+	return r.Client.Get(ctx, id).Result()
+}
+
 // KVSource retrieves a source document by ID from the Redis database.
-// It returns the found source or an error if the source doesn't exist or if the query fails.
+// It returns the found source, golightrag.ErrSourceNotFound if the source doesn't exist or an
+// error if the query fails.
 func (r Redis) KVSource(id string) (golightrag.Source, error) {
 	var result golightrag.Source
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	content, err := r.Client.Get(ctx, id).Result()
+	content, err := r.get(ctx, redisSourcePrefix, id)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return result, fmt.Errorf("source not found")
+			return result, golightrag.ErrSourceNotFound
 		}
 		return result, fmt.Errorf("failed to get source: %w", err)
 	}
 
+	result.ID = id
 	result.Content = content
 
+	document, err := r.Client.Get(ctx, r.key(redisSourceDocumentPrefix+id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return result, nil
+		}
+		return result, fmt.Errorf("failed to get source document: %w", err)
+	}
+	if err := unmarshalSourceDocument(document, &result); err != nil {
+		return result, err
+	}
+
 	return result, nil
 }
 
@@ -69,7 +140,16 @@ func (r Redis) KVUpsertSources(sources []golightrag.Source) error {
 	defer setCancel()
 
 	for _, source := range sources {
-		pipe.Set(setCtx, source.ID, source.Content, 0)
+		pipe.Set(setCtx, r.key(redisSourcePrefix+source.ID), source.Content, 0)
+		document, err := marshalSourceDocument(source)
+		if err != nil {
+			return err
+		}
+		if document == nil {
+			pipe.Del(setCtx, r.key(redisSourceDocumentPrefix+source.ID))
+		} else {
+			pipe.Set(setCtx, r.key(redisSourceDocumentPrefix+source.ID), document, 0)
+		}
 	}
 
 	execCtx, execCancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -89,12 +169,12 @@ func (r Redis) KVUnprocessed(id string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	content, err := r.Client.Get(ctx, id).Result()
+	content, err := r.get(ctx, redisUnprocessedPrefix, id)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return result, fmt.Errorf("source not found")
+			return result, fmt.Errorf("unprocessed not found")
 		}
-		return result, fmt.Errorf("failed to get source: %w", err)
+		return result, fmt.Errorf("failed to get unprocessed: %w", err)
 	}
 
 	result = content
@@ -114,7 +194,7 @@ func (r Redis) KVUpsertUnprocessed(sources []golightrag.Source) error {
 	formattedTime := t.Format("2006-01-02T15:04:05")
 
 	for _, source := range sources {
-		pipe.Set(setCtx, source.ID, formattedTime, 0)
+		pipe.Set(setCtx, r.key(redisUnprocessedPrefix+source.ID), formattedTime, 0)
 	}
 
 	execCtx, execCancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -127,3 +207,195 @@ func (r Redis) KVUpsertUnprocessed(sources []golightrag.Source) error {
 
 	return nil
 }
+
+// KVUpsertClaims creates or updates multiple claims in the Redis database, indexing them by
+// their subject and object entities.
+// It returns an error if any database operation fails during the process.
+func (r Redis) KVUpsertClaims(claims []golightrag.Claim) error {
+	pipe := r.Client.Pipeline()
+
+	setCtx, setCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer setCancel()
+
+	for _, claim := range claims {
+		bs, err := json.Marshal(claim)
+		if err != nil {
+			return fmt.Errorf("failed to marshal claim: %w", err)
+		}
+		pipe.Set(setCtx, r.key(redisClaimPrefix+claim.ID), bs, 0)
+		for _, name := range claimEntities(claim) {
+			pipe.SAdd(setCtx, r.key(redisEntityClaimsPrefix+name), claim.ID)
+		}
+	}
+
+	execCtx, execCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer execCancel()
+
+	_, err := pipe.Exec(execCtx)
+	if err != nil {
+		return fmt.Errorf("failed to execute pipeline: %w", err)
+	}
+
+	return nil
+}
+
+// KVClaimsByEntities retrieves the claims whose subject or object is one of the entities from
+// the Redis database. It returns a map of entity names to their claims, or an error if the
+// query fails.
+func (r Redis) KVClaimsByEntities(names []string) (map[string][]golightrag.Claim, error) {
+	result := make(map[string][]golightrag.Claim)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, name := range names {
+		ids, err := r.Client.SMembers(ctx, r.key(redisEntityClaimsPrefix+name)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get entity claims: %w", err)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		// Sets are unordered, so the claims are returned in the order of their IDs.
+		slices.Sort(ids)
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = r.key(redisClaimPrefix + id)
+		}
+		values, err := r.Client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get claims: %w", err)
+		}
+		for _, value := range values {
+			str, ok := value.(string)
+			if !ok {
+				continue
+			}
+			var claim golightrag.Claim
+			if err := json.Unmarshal([]byte(str), &claim); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal claim: %w", err)
+			}
+			// A claim replaced with other entities is still indexed by the previous ones.
+			if slices.Contains(claimEntities(claim), name) {
+				result[name] = append(result[name], claim)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// KVCommunities retrieves every community stored in the Redis database, in the order of their
+// IDs. It returns an error if the query fails.
+func (r Redis) KVCommunities() ([]golightrag.Community, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	values, err := r.Client.HGetAll(ctx, r.key(redisCommunitiesKey)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get communities: %w", err)
+	}
+
+	result := make([]golightrag.Community, 0, len(values))
+	for _, id := range slices.Sorted(maps.Keys(values)) {
+		var community golightrag.Community
+		if err := json.Unmarshal([]byte(values[id]), &community); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal community: %w", err)
+		}
+		result = append(result, community)
+	}
+
+	return result, nil
+}
+
+// KVUpsertCommunities creates or updates multiple communities in the Redis database.
+// It returns an error if any database operation fails during the process.
+func (r Redis) KVUpsertCommunities(communities []golightrag.Community) error {
+	if len(communities) == 0 {
+		return nil
+	}
+
+	values := make([]any, 0, len(communities)*2)
+	for _, community := range communities {
+		bs, err := json.Marshal(community)
+		if err != nil {
+			return fmt.Errorf("failed to marshal community: %w", err)
+		}
+		values = append(values, community.ID, bs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.Client.HSet(ctx, r.key(redisCommunitiesKey), values...).Err(); err != nil {
+		return fmt.Errorf("failed to set communities: %w", err)
+	}
+
+	return nil
+}
+
+// KVDeleteCommunities deletes the communities with the IDs from the Redis database.
+// It returns an error if any database operation fails during the process.
+func (r Redis) KVDeleteCommunities(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.Client.HDel(ctx, r.key(redisCommunitiesKey), ids...).Err(); err != nil {
+		return fmt.Errorf("failed to delete communities: %w", err)
+	}
+
+	return nil
+}
+
+// KVAppendChanges appends the changes to the change logs of their keys in the Redis database,
+// each one a list.
+// It returns an error if any database operation fails during the process.
+func (r Redis) KVAppendChanges(changes []golightrag.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipe := r.Client.TxPipeline()
+	for _, change := range changes {
+		bs, err := json.Marshal(change)
+		if err != nil {
+			return fmt.Errorf("failed to marshal change: %w", err)
+		}
+		pipe.RPush(ctx, r.key(redisChangesPrefix+change.Key), bs)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to append changes: %w", err)
+	}
+
+	return nil
+}
+
+// KVChanges retrieves the change log of the key from the Redis database, oldest first.
+// It returns an error if the query fails.
+func (r Redis) KVChanges(key string) ([]golightrag.Change, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	values, err := r.Client.LRange(ctx, r.key(redisChangesPrefix+key), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changes: %w", err)
+	}
+
+	result := make([]golightrag.Change, 0, len(values))
+	for _, value := range values {
+		var change golightrag.Change
+		if err := json.Unmarshal([]byte(value), &change); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal change: %w", err)
+		}
+		result = append(result, change)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRedis_Conformance runs against the database at REDIS_TEST_ADDR, which is flushed before
+// every subtest.
+func TestRedis_Conformance(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+
+	storagetest.TestKeyValueStorage(t, func(t *testing.T) golightrag.KeyValueStorage {
+		r, err := NewRedis(addr, os.Getenv("REDIS_TEST_PASSWORD"), 0)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = r.Client.Close() })
+
+		require.NoError(t, r.Client.FlushDB(context.Background()).Err())
+		return r
+	})
+}
+
+// TestRedis_Workspaces runs against the database at REDIS_TEST_ADDR, which is flushed before
+// every subtest.
+func TestRedis_Workspaces(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+
+	storagetest.TestWorkspaces(t, func(t *testing.T) func(string) (Redis, error) {
+		r, err := NewRedis(addr, os.Getenv("REDIS_TEST_PASSWORD"), 0)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = r.Client.Close() })
+
+		require.NoError(t, r.Client.FlushDB(context.Background()).Err())
+		return r.Workspace
+	})
+}
+
+// TestRedis_LegacyKeys runs against the database at REDIS_TEST_ADDR, which is flushed first.
+func TestRedis_LegacyKeys(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+
+	r, err := NewRedis(addr, os.Getenv("REDIS_TEST_PASSWORD"), 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Client.Close() })
+
+	ctx := context.Background()
+	require.NoError(t, r.Client.FlushDB(ctx).Err())
+
+	// Earlier versions stored the sources under their bare ID.
+	require.NoError(t, r.Client.Set(ctx, "doc-chunk-0", "Legacy content", 0).Err())
+
+	source, err := r.KVSource("doc-chunk-0")
+	require.NoError(t, err)
+	require.Equal(t, "Legacy content", source.Content)
+
+	// Sources stored again take precedence over the legacy ones.
+	require.NoError(t, r.KVUpsertSources([]golightrag.Source{{ID: "doc-chunk-0", Content: "New content"}}))
+	source, err = r.KVSource("doc-chunk-0")
+	require.NoError(t, err)
+	require.Equal(t, "New content", source.Content)
+
+	// Named workspaces never read the legacy keys.
+	acme, err := r.Workspace("acme")
+	require.NoError(t, err)
+	require.NoError(t, r.Client.Set(ctx, "other-chunk-0", "Legacy content", 0).Err())
+	_, err = acme.KVSource("other-chunk-0")
+	require.ErrorIs(t, err, golightrag.ErrSourceNotFound)
+}
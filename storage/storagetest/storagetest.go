@@ -0,0 +1,898 @@
+// Package storagetest provides conformance suites for implementations of the storage interfaces
+// of golightrag. Each suite checks the contract documented on the interface: not-found
+// sentinels, omission of missing keys from batch lookups, upsert semantics and, for graph
+// storages, the directionality of relationships.
+//
+// A suite receives a constructor returning an empty storage, called once per subtest:
+//
+//	func TestBoltConformance(t *testing.T) {
+//		storagetest.TestKeyValueStorage(t, func(t *testing.T) golightrag.KeyValueStorage {
+//			db, err := storage.NewBolt(filepath.Join(t.TempDir(), "kv.db"))
+//			require.NoError(t, err)
+//			return db
+//		})
+//	}
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGraphStorage runs the GraphStorage conformance suite against the storages returned by
+// newStorage, which must be empty.
+//
+//nolint:funlen // The suite is a flat list of subtests.
+func TestGraphStorage(t *testing.T, newStorage func(t *testing.T) golightrag.GraphStorage) {
+	t.Helper()
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	alice := golightrag.GraphEntity{
+		Name:         "ALICE",
+		Type:         "PERSON",
+		Descriptions: "Alice is an engineer.",
+		SourceIDs:    "source-1",
+		Properties:   map[string]any{"role": "engineer", "age": 34.0, "remote": true},
+		PageRank:     0.4,
+		Betweenness:  0.5,
+		Metadata:     map[string][]string{"tenant": {"acme"}, "year": {"2023", "2024"}},
+		CreatedAt:    createdAt,
+		UpdatedAt:    createdAt.Add(time.Hour),
+		UpdateCount:  2,
+	}
+	bob := golightrag.GraphEntity{
+		Name:         "BOB",
+		Type:         "PERSON",
+		Descriptions: "Bob is a designer.",
+		SourceIDs:    "source-2",
+		CreatedAt:    createdAt,
+	}
+	acme := golightrag.GraphEntity{
+		Name:         "ACME",
+		Type:         "ORGANIZATION",
+		Descriptions: "Acme is a company.",
+		SourceIDs:    "source-3",
+		Aliases:      []string{"ACME CORP", "ACME INC"},
+		CreatedAt:    createdAt,
+	}
+	aliceBob := golightrag.GraphRelationship{
+		SourceEntity: alice.Name,
+		TargetEntity: bob.Name,
+		Weight:       0.8,
+		Descriptions: "Alice works with Bob.",
+		Keywords:     []string{"colleague", "team"},
+		SourceIDs:    "source-1",
+		Properties:   map[string]any{golightrag.PredicateProperty: "WORKS_WITH"},
+		ValidFrom:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		History: []golightrag.RelationshipVersion{
+			{
+				Descriptions: "Alice mentored Bob.",
+				SourceIDs:    "source-0",
+				ValidFrom:    time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC),
+				ValidTo:      time.Date(2016, 12, 31, 0, 0, 0, 0, time.UTC),
+				CreatedAt:    createdAt.Add(-time.Hour),
+			},
+		},
+		Metadata:    map[string][]string{"tenant": {"acme"}},
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt.Add(2 * time.Hour),
+		UpdateCount: 1,
+	}
+	bobAcme := golightrag.GraphRelationship{
+		SourceEntity: bob.Name,
+		TargetEntity: acme.Name,
+		Weight:       0.5,
+		Descriptions: "Bob works at Acme.",
+		Keywords:     []string{"employment"},
+		SourceIDs:    "source-2",
+		CreatedAt:    createdAt,
+	}
+
+	// seed returns a storage holding the three entities and the two relationships.
+	seed := func(t *testing.T) golightrag.GraphStorage {
+		t.Helper()
+		s := newStorage(t)
+		for _, entity := range []golightrag.GraphEntity{alice, bob, acme} {
+			require.NoError(t, s.GraphUpsertEntity(entity))
+		}
+		for _, relationship := range []golightrag.GraphRelationship{aliceBob, bobAcme} {
+			require.NoError(t, s.GraphUpsertRelationship(relationship))
+		}
+		return s
+	}
+
+	t.Run("Missing entity returns ErrEntityNotFound", func(t *testing.T) {
+		s := seed(t)
+		_, err := s.GraphEntity("MISSING")
+		require.ErrorIs(t, err, golightrag.ErrEntityNotFound)
+	})
+
+	t.Run("Missing relationship returns ErrRelationshipNotFound", func(t *testing.T) {
+		s := seed(t)
+		_, err := s.GraphRelationship(alice.Name, acme.Name)
+		require.ErrorIs(t, err, golightrag.ErrRelationshipNotFound)
+		_, err = s.GraphRelationship(alice.Name, "MISSING")
+		require.ErrorIs(t, err, golightrag.ErrRelationshipNotFound)
+	})
+
+	t.Run("Entity round trip", func(t *testing.T) {
+		s := seed(t)
+		got, err := s.GraphEntity(alice.Name)
+		require.NoError(t, err)
+		assertEntity(t, alice, got)
+	})
+
+	t.Run("Batch entities omit missing names", func(t *testing.T) {
+		s := seed(t)
+		got, err := s.GraphEntities([]string{alice.Name, "MISSING", acme.Name})
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assertEntity(t, alice, got[alice.Name])
+		assertEntity(t, acme, got[acme.Name])
+
+		got, err = s.GraphEntities(nil)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("Upsert replaces an existing entity", func(t *testing.T) {
+		s := seed(t)
+		updated := alice
+		updated.Type = "ENGINEER"
+		updated.Properties = map[string]any{"role": "lead"}
+		updated.Descriptions = "Alice is an engineer.<SEP>Alice leads the team."
+		updated.SourceIDs = "source-1<SEP>source-4"
+		updated.CreatedAt = createdAt.Add(24 * time.Hour)
+		updated.UpdatedAt = createdAt.Add(24 * time.Hour)
+		updated.UpdateCount = 3
+		require.NoError(t, s.GraphUpsertEntity(updated))
+
+		// The creation time of the entity is kept.
+		got, err := s.GraphEntity(alice.Name)
+		require.NoError(t, err)
+		updated.CreatedAt = alice.CreatedAt
+		assertEntity(t, updated, got)
+
+		entities, err := s.GraphEntities([]string{alice.Name})
+		require.NoError(t, err)
+		require.Len(t, entities, 1)
+
+		// The relationships of the entity are kept.
+		_, err = s.GraphRelationship(alice.Name, bob.Name)
+		require.NoError(t, err)
+	})
+
+	t.Run("Relationship round trip", func(t *testing.T) {
+		s := seed(t)
+		got, err := s.GraphRelationship(aliceBob.SourceEntity, aliceBob.TargetEntity)
+		require.NoError(t, err)
+		assertRelationship(t, aliceBob, got)
+	})
+
+	t.Run("Relationships are undirected", func(t *testing.T) {
+		s := seed(t)
+		got, err := s.GraphRelationship(bob.Name, alice.Name)
+		require.NoError(t, err)
+		reversed := aliceBob
+		reversed.SourceEntity, reversed.TargetEntity = bob.Name, alice.Name
+		assertRelationship(t, reversed, got)
+
+		// Upserting the reversed pair updates the existing relationship.
+		reversed.Descriptions = "Bob mentors Alice."
+		reversed.Weight = 0.9
+		reversed.CreatedAt = createdAt.Add(24 * time.Hour)
+		require.NoError(t, s.GraphUpsertRelationship(reversed))
+
+		got, err = s.GraphRelationship(alice.Name, bob.Name)
+		require.NoError(t, err)
+		assert.Equal(t, reversed.Descriptions, got.Descriptions)
+		assert.InDelta(t, reversed.Weight, got.Weight, 0.001)
+		assert.WithinDuration(t, aliceBob.CreatedAt, got.CreatedAt, time.Second)
+
+		counts, err := s.GraphCountEntitiesRelationships([]string{alice.Name})
+		require.NoError(t, err)
+		assert.Equal(t, 1, counts[alice.Name])
+	})
+
+	t.Run("Upsert replaces an existing relationship", func(t *testing.T) {
+		s := seed(t)
+		updated := aliceBob
+		updated.Weight = 1.5
+		updated.Descriptions = "Alice works with Bob.<SEP>Alice and Bob share an office."
+		updated.Keywords = []string{"colleague", "team", "office"}
+		updated.SourceIDs = "source-1<SEP>source-4"
+		updated.CreatedAt = createdAt.Add(24 * time.Hour)
+		updated.UpdatedAt = createdAt.Add(24 * time.Hour)
+		updated.UpdateCount = 2
+		require.NoError(t, s.GraphUpsertRelationship(updated))
+
+		// The creation time of the relationship is kept.
+		got, err := s.GraphRelationship(alice.Name, bob.Name)
+		require.NoError(t, err)
+		updated.CreatedAt = aliceBob.CreatedAt
+		assertRelationship(t, updated, got)
+	})
+
+	t.Run("Upsert clears the fields left unset", func(t *testing.T) {
+		s := seed(t)
+		cleared := golightrag.GraphEntity{
+			Name:         alice.Name,
+			Type:         alice.Type,
+			Descriptions: alice.Descriptions,
+			SourceIDs:    alice.SourceIDs,
+			CreatedAt:    alice.CreatedAt,
+		}
+		require.NoError(t, s.GraphUpsertEntity(cleared))
+
+		// The fields the caller didn't set aren't kept from the existing entity.
+		got, err := s.GraphEntity(alice.Name)
+		require.NoError(t, err)
+		assertEntity(t, cleared, got)
+
+		clearedRelationship := golightrag.GraphRelationship{
+			SourceEntity: aliceBob.SourceEntity,
+			TargetEntity: aliceBob.TargetEntity,
+			Weight:       aliceBob.Weight,
+			Descriptions: aliceBob.Descriptions,
+			SourceIDs:    aliceBob.SourceIDs,
+			CreatedAt:    aliceBob.CreatedAt,
+		}
+		require.NoError(t, s.GraphUpsertRelationship(clearedRelationship))
+
+		gotRelationship, err := s.GraphRelationship(alice.Name, bob.Name)
+		require.NoError(t, err)
+		assertRelationship(t, clearedRelationship, gotRelationship)
+	})
+
+	t.Run("Batch relationships omit missing pairs", func(t *testing.T) {
+		s := seed(t)
+		got, err := s.GraphRelationships([][2]string{
+			{alice.Name, bob.Name},
+			{acme.Name, bob.Name},
+			{alice.Name, acme.Name},
+		})
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assertRelationship(t, aliceBob, got[alice.Name+"-"+bob.Name])
+
+		// Keys and entities follow the requested order of the pair.
+		reversed := bobAcme
+		reversed.SourceEntity, reversed.TargetEntity = acme.Name, bob.Name
+		assertRelationship(t, reversed, got[acme.Name+"-"+bob.Name])
+
+		got, err = s.GraphRelationships(nil)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("Count relationships", func(t *testing.T) {
+		s := seed(t)
+		counts, err := s.GraphCountEntitiesRelationships([]string{alice.Name, bob.Name, acme.Name, "MISSING"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, counts[alice.Name])
+		assert.Equal(t, 2, counts[bob.Name])
+		assert.Equal(t, 1, counts[acme.Name])
+		assert.NotContains(t, counts, "MISSING")
+	})
+
+	t.Run("Related entities in both directions", func(t *testing.T) {
+		s := seed(t)
+		related, err := s.GraphRelatedEntities([]string{alice.Name, bob.Name, "MISSING"})
+		require.NoError(t, err)
+		assert.NotContains(t, related, "MISSING")
+
+		require.Len(t, related[alice.Name], 1)
+		assertEntity(t, bob, related[alice.Name][0])
+
+		names := make([]string, 0, len(related[bob.Name]))
+		for _, entity := range related[bob.Name] {
+			names = append(names, entity.Name)
+		}
+		assert.ElementsMatch(t, []string{alice.Name, acme.Name}, names)
+	})
+
+	t.Run("Entities by alias", func(t *testing.T) {
+		s, ok := seed(t).(golightrag.GraphAliasStorage)
+		if !ok {
+			t.Skip("storage doesn't implement GraphAliasStorage")
+		}
+		got, err := s.GraphEntitiesByAlias([]string{"ACME INC", "ACME", "MISSING"})
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assertEntity(t, acme, got["ACME INC"])
+	})
+
+	t.Run("List entities and relationships", func(t *testing.T) {
+		s, ok := seed(t).(golightrag.GraphListStorage)
+		if !ok {
+			t.Skip("storage doesn't implement GraphListStorage")
+		}
+		entities, err := s.GraphAllEntities()
+		require.NoError(t, err)
+		require.Len(t, entities, 3)
+		byName := make(map[string]golightrag.GraphEntity)
+		for _, entity := range entities {
+			byName[entity.Name] = entity
+		}
+		for _, want := range []golightrag.GraphEntity{alice, bob, acme} {
+			assertEntity(t, want, byName[want.Name])
+		}
+
+		relationships, err := s.GraphAllRelationships()
+		require.NoError(t, err)
+		require.Len(t, relationships, 2)
+		byPair := make(map[string]golightrag.GraphRelationship)
+		for _, relationship := range relationships {
+			byPair[relationship.SourceEntity+"-"+relationship.TargetEntity] = relationship
+		}
+		assertRelationship(t, aliceBob, byPair["ALICE-BOB"])
+		assertRelationship(t, bobAcme, byPair["BOB-ACME"])
+	})
+}
+
+// TestVectorStorage runs the VectorStorage conformance suite against the storages returned by
+// newStorage, which must be empty and return at least two results per query.
+func TestVectorStorage(t *testing.T, newStorage func(t *testing.T) golightrag.VectorStorage) {
+	t.Helper()
+
+	t.Run("Empty storage returns no results", func(t *testing.T) {
+		s := newStorage(t)
+		entities, err := s.VectorQueryEntity("anything")
+		require.NoError(t, err)
+		assert.Empty(t, entities)
+
+		relationships, err := s.VectorQueryRelationship("anything")
+		require.NoError(t, err)
+		assert.Empty(t, relationships)
+	})
+
+	t.Run("Query entities by content", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.VectorUpsertEntity("ALICE", "ALICE an engineer who writes software"))
+		require.NoError(t, s.VectorUpsertEntity("ACME", "ACME a company that sells anvils"))
+
+		got, err := s.VectorQueryEntity("ACME a company that sells anvils")
+		require.NoError(t, err)
+		require.NotEmpty(t, got)
+		assert.Equal(t, "ACME", got[0])
+	})
+
+	t.Run("Upsert replaces an existing entity", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.VectorUpsertEntity("ALICE", "ALICE an engineer who writes software"))
+		require.NoError(t, s.VectorUpsertEntity("ACME", "ACME a company that sells anvils"))
+		require.NoError(t, s.VectorUpsertEntity("ALICE", "ALICE a painter of landscapes"))
+
+		got, err := s.VectorQueryEntity("ALICE a painter of landscapes")
+		require.NoError(t, err)
+		require.NotEmpty(t, got)
+		assert.Equal(t, "ALICE", got[0])
+		assert.Len(t, got, 2, "Expected the upsert not to duplicate the entity")
+	})
+
+	t.Run("Query relationships keeps their direction", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.VectorUpsertRelationship("ALICE", "BOB", "ALICE BOB colleagues on the same team"))
+		require.NoError(t, s.VectorUpsertRelationship("BOB", "ACME", "BOB ACME employment at a company"))
+		require.NoError(t, s.VectorUpsertRelationship("ALICE", "BOB", "ALICE BOB friends since school"))
+
+		got, err := s.VectorQueryRelationship("ALICE BOB friends since school")
+		require.NoError(t, err)
+		require.NotEmpty(t, got)
+		assert.Equal(t, [2]string{"ALICE", "BOB"}, got[0])
+		assert.Len(t, got, 2, "Expected the upsert not to duplicate the relationship")
+	})
+
+	t.Run("Query filtered by metadata", func(t *testing.T) {
+		s, ok := newStorage(t).(golightrag.VectorFilterStorage)
+		if !ok {
+			t.Skip("storage doesn't implement VectorFilterStorage")
+		}
+		require.NoError(t, s.VectorUpsertEntityWithMetadata("ALICE", "ALICE an engineer who writes software",
+			map[string][]string{"tenant": {"acme"}, "year": {"2023", "2024"}}))
+		require.NoError(t, s.VectorUpsertEntityWithMetadata("BOB", "BOB an engineer who writes software",
+			map[string][]string{"tenant": {"globex"}, "year": {"2024"}}))
+		require.NoError(t, s.VectorUpsertRelationshipWithMetadata("ALICE", "BOB", "ALICE BOB colleagues",
+			map[string][]string{"tenant": {"acme"}}))
+		require.NoError(t, s.VectorUpsertRelationshipWithMetadata("BOB", "ACME", "BOB ACME colleagues",
+			map[string][]string{"tenant": {"globex"}}))
+
+		entities, err := s.VectorQueryEntityWithFilter("an engineer who writes software",
+			golightrag.MetadataFilter{"tenant": "acme", "year": "2024"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ALICE"}, entities)
+
+		entities, err = s.VectorQueryEntityWithFilter("an engineer who writes software",
+			golightrag.MetadataFilter{"year": "2024"})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"ALICE", "BOB"}, entities)
+
+		entities, err = s.VectorQueryEntityWithFilter("an engineer who writes software",
+			golightrag.MetadataFilter{"year": "2022"})
+		require.NoError(t, err)
+		assert.Empty(t, entities)
+
+		relationships, err := s.VectorQueryRelationshipWithFilter("colleagues",
+			golightrag.MetadataFilter{"tenant": "globex"})
+		require.NoError(t, err)
+		assert.Equal(t, [][2]string{{"BOB", "ACME"}}, relationships)
+	})
+}
+
+// TestKeyValueStorage runs the KeyValueStorage conformance suite against the storages returned by
+// newStorage, which must be empty.
+func TestKeyValueStorage(t *testing.T, newStorage func(t *testing.T) golightrag.KeyValueStorage) {
+	t.Helper()
+
+	sources := []golightrag.Source{
+		{
+			ID:         "doc-chunk-0",
+			Content:    "First chunk.",
+			DocumentID: "doc",
+			Origin:     golightrag.Origin{Type: golightrag.SourceTypeURL, Location: "https://example.com/doc"},
+			Metadata:   map[string]string{"author": "alice", "year": "2024"},
+		},
+		{ID: "doc-chunk-1", Content: "Second chunk."},
+	}
+
+	t.Run("Missing source returns ErrSourceNotFound", func(t *testing.T) {
+		s := newStorage(t)
+		_, err := s.KVSource("missing")
+		require.ErrorIs(t, err, golightrag.ErrSourceNotFound)
+	})
+
+	t.Run("Source round trip", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.KVUpsertSources(sources))
+
+		for _, source := range sources {
+			got, err := s.KVSource(source.ID)
+			require.NoError(t, err)
+			assert.Equal(t, source.ID, got.ID)
+			assert.Equal(t, source.Content, got.Content)
+			assert.Equal(t, source.DocumentID, got.DocumentID)
+			assert.Equal(t, source.Origin, got.Origin)
+			assert.Equal(t, source.Metadata, got.Metadata)
+		}
+	})
+
+	t.Run("Upsert replaces an existing source", func(t *testing.T) {
+		s := newStorage(t)
+		require.NoError(t, s.KVUpsertSources(sources))
+		require.NoError(t, s.KVUpsertSources([]golightrag.Source{{ID: sources[0].ID, Content: "Updated chunk."}}))
+
+		// The document of the source is replaced too.
+		got, err := s.KVSource(sources[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Updated chunk.", got.Content)
+		assert.Empty(t, got.DocumentID)
+		assert.Empty(t, got.Origin)
+		assert.Empty(t, got.Metadata)
+
+		got, err = s.KVSource(sources[1].ID)
+		require.NoError(t, err)
+		assert.Equal(t, sources[1].Content, got.Content)
+	})
+
+	t.Run("Unprocessed markers are kept apart from sources", func(t *testing.T) {
+		s := newStorage(t)
+		_, err := s.KVUnprocessed(sources[0].ID)
+		require.Error(t, err)
+
+		require.NoError(t, s.KVUpsertSources(sources))
+		require.NoError(t, s.KVUpsertUnprocessed(sources[:1]))
+
+		marker, err := s.KVUnprocessed(sources[0].ID)
+		require.NoError(t, err)
+		assert.NotEmpty(t, marker)
+
+		got, err := s.KVSource(sources[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, sources[0].Content, got.Content)
+	})
+
+	t.Run("Claims by entities", func(t *testing.T) {
+		s, ok := newStorage(t).(golightrag.ClaimStorage)
+		if !ok {
+			t.Skip("storage doesn't implement ClaimStorage")
+		}
+		acquisition := golightrag.Claim{
+			ID:          "doc-chunk-0-claim-0",
+			Subject:     "ACME",
+			Object:      "WIDGETS INC",
+			Type:        "ACQUISITION",
+			Status:      golightrag.ClaimStatusTrue,
+			Description: "Acme acquired Widgets Inc.",
+			StartDate:   time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:     time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+			SourceText:  "Acme completed the acquisition of Widgets Inc on March 1, 2021.",
+			SourceID:    "doc-chunk-0",
+		}
+		fine := golightrag.Claim{
+			ID:          "doc-chunk-1-claim-0",
+			Subject:     "ACME",
+			Type:        "REGULATORY_FINE",
+			Status:      golightrag.ClaimStatusSuspected,
+			Description: "Acme may be fined.",
+			SourceID:    "doc-chunk-1",
+		}
+		require.NoError(t, s.KVUpsertClaims([]golightrag.Claim{acquisition, fine}))
+
+		got, err := s.KVClaimsByEntities([]string{"ACME", "WIDGETS INC", "MISSING"})
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.ElementsMatch(t, []golightrag.Claim{acquisition, fine}, got["ACME"])
+		assert.Equal(t, []golightrag.Claim{acquisition}, got["WIDGETS INC"])
+
+		// Upserting a claim with the same ID replaces it, and unlinks it from its previous object.
+		acquisition.Object = ""
+		acquisition.Status = golightrag.ClaimStatusFalse
+		require.NoError(t, s.KVUpsertClaims([]golightrag.Claim{acquisition}))
+
+		got, err = s.KVClaimsByEntities([]string{"ACME", "WIDGETS INC"})
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.ElementsMatch(t, []golightrag.Claim{acquisition, fine}, got["ACME"])
+	})
+
+	t.Run("Communities round trip", func(t *testing.T) {
+		s, ok := newStorage(t).(golightrag.CommunityStorage)
+		if !ok {
+			t.Skip("storage doesn't implement CommunityStorage")
+		}
+		updatedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		company := golightrag.Community{
+			ID:       "community-0",
+			Entities: []string{"ACME", "ALICE", "BOB"},
+			Title:    "Acme and its employees",
+			Summary:  "Alice and Bob work at Acme.",
+			Rating:   6.5,
+			Findings: []golightrag.CommunityFinding{
+				{Summary: "Acme employs Bob", Explanation: "Bob works at Acme as a designer."},
+			},
+			Hash:      "hash-0",
+			UpdatedAt: updatedAt,
+		}
+		team := golightrag.Community{
+			ID:        "community-1",
+			Level:     1,
+			Parent:    company.ID,
+			Entities:  []string{"ALICE", "BOB"},
+			Title:     "Alice and Bob",
+			Hash:      "hash-1",
+			UpdatedAt: updatedAt,
+		}
+		require.NoError(t, s.KVUpsertCommunities([]golightrag.Community{company, team}))
+
+		got, err := s.KVCommunities()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []golightrag.Community{company, team}, got)
+
+		team.Summary = "Alice works with Bob."
+		require.NoError(t, s.KVUpsertCommunities([]golightrag.Community{team}))
+		require.NoError(t, s.KVDeleteCommunities([]string{company.ID, "missing"}))
+
+		got, err = s.KVCommunities()
+		require.NoError(t, err)
+		assert.Equal(t, []golightrag.Community{team}, got)
+	})
+
+	t.Run("Change logs are appended in order", func(t *testing.T) {
+		s, ok := newStorage(t).(golightrag.ChangeLogStorage)
+		if !ok {
+			t.Skip("storage doesn't implement ChangeLogStorage")
+		}
+		changedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		created := golightrag.Change{
+			Key:         "entity:ALICE",
+			Entity:      "ALICE",
+			SourceID:    "doc-chunk-0",
+			Description: "Alice is an engineer.",
+			Time:        changedAt,
+		}
+		updated := golightrag.Change{
+			Key:                 "entity:ALICE",
+			Entity:              "ALICE",
+			SourceID:            "doc-chunk-1",
+			PreviousDescription: "Alice is an engineer.",
+			Description:         "Alice is an engineer.<SEP>Alice leads the team.",
+			UpdateCount:         1,
+			Time:                changedAt.Add(time.Minute),
+		}
+		relationship := golightrag.Change{
+			Key:          "relationship:ALICE<SEP>BOB",
+			SourceEntity: "ALICE",
+			TargetEntity: "BOB",
+			SourceID:     "doc-chunk-1",
+			Description:  "Alice works with Bob.",
+			Time:         changedAt.Add(time.Minute),
+		}
+		require.NoError(t, s.KVAppendChanges([]golightrag.Change{created}))
+		require.NoError(t, s.KVAppendChanges([]golightrag.Change{updated, relationship}))
+
+		got, err := s.KVChanges(created.Key)
+		require.NoError(t, err)
+		assert.Equal(t, []golightrag.Change{created, updated}, got)
+
+		got, err = s.KVChanges(relationship.Key)
+		require.NoError(t, err)
+		assert.Equal(t, []golightrag.Change{relationship}, got)
+
+		got, err = s.KVChanges("entity:MISSING")
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+}
+
+func assertEntity(t *testing.T, want, got golightrag.GraphEntity) {
+	t.Helper()
+	assert.Equal(t, want.Name, got.Name)
+	assert.Equal(t, want.Type, got.Type)
+	assert.Equal(t, want.Descriptions, got.Descriptions)
+	assert.Equal(t, want.SourceIDs, got.SourceIDs)
+	assert.Equal(t, want.Aliases, got.Aliases)
+	assert.Equal(t, want.Properties, got.Properties)
+	assert.InDelta(t, want.PageRank, got.PageRank, 1e-9)
+	assert.InDelta(t, want.Betweenness, got.Betweenness, 1e-9)
+	assert.Equal(t, want.Metadata, got.Metadata)
+	assert.WithinDuration(t, want.CreatedAt, got.CreatedAt, time.Second)
+	assert.WithinDuration(t, want.UpdatedAt, got.UpdatedAt, time.Second)
+	assert.Equal(t, want.UpdateCount, got.UpdateCount)
+}
+
+func assertRelationship(t *testing.T, want, got golightrag.GraphRelationship) {
+	t.Helper()
+	assert.Equal(t, want.SourceEntity, got.SourceEntity)
+	assert.Equal(t, want.TargetEntity, got.TargetEntity)
+	assert.InDelta(t, want.Weight, got.Weight, 0.001)
+	assert.Equal(t, want.Descriptions, got.Descriptions)
+	assert.Equal(t, want.Keywords, got.Keywords)
+	assert.Equal(t, want.SourceIDs, got.SourceIDs)
+	assert.Equal(t, want.Properties, got.Properties)
+	assert.True(t, want.ValidFrom.Equal(got.ValidFrom), "want valid from %s, got %s", want.ValidFrom, got.ValidFrom)
+	assert.True(t, want.ValidTo.Equal(got.ValidTo), "want valid to %s, got %s", want.ValidTo, got.ValidTo)
+	if assert.Len(t, got.History, len(want.History)) {
+		for i, version := range want.History {
+			assert.Equal(t, version.Descriptions, got.History[i].Descriptions)
+			assert.Equal(t, version.SourceIDs, got.History[i].SourceIDs)
+			assert.True(t, version.ValidFrom.Equal(got.History[i].ValidFrom))
+			assert.True(t, version.ValidTo.Equal(got.History[i].ValidTo))
+			assert.WithinDuration(t, version.CreatedAt, got.History[i].CreatedAt, time.Second)
+		}
+	}
+	assert.Equal(t, want.Metadata, got.Metadata)
+	assert.WithinDuration(t, want.CreatedAt, got.CreatedAt, time.Second)
+	assert.WithinDuration(t, want.UpdatedAt, got.UpdatedAt, time.Second)
+	assert.Equal(t, want.UpdateCount, got.UpdateCount)
+}
+
+// TestWorkspaces checks that the workspaces of a storage are isolated from each other and from
+// the default workspace. newWorkspaces returns the function opening a workspace of an empty
+// storage, such as its Workspace method, the empty workspace being the default one. The data of
+// the interfaces the storage implements among GraphStorage, VectorStorage, KeyValueStorage and
+// golightrag.ChangeLogStorage is checked.
+func TestWorkspaces[S any](t *testing.T, newWorkspaces func(t *testing.T) func(workspace string) (S, error)) {
+	t.Helper()
+
+	workspaces := []string{"", "acme", "globex"}
+	open := func(t *testing.T) map[string]any {
+		t.Helper()
+		workspace := newWorkspaces(t)
+		result := make(map[string]any, len(workspaces))
+		for _, name := range workspaces {
+			s, err := workspace(name)
+			require.NoError(t, err)
+			result[name] = s
+		}
+		return result
+	}
+
+	t.Run("Invalid workspace names are rejected", func(t *testing.T) {
+		workspace := newWorkspaces(t)
+		for _, name := range []string{"acme corp", "acme:1", "acme-1"} {
+			_, err := workspace(name)
+			assert.Error(t, err, "Expected an error for workspace %q", name)
+		}
+	})
+
+	t.Run("Graph data is isolated", func(t *testing.T) {
+		storages := open(t)
+		if _, ok := storages[""].(golightrag.GraphStorage); !ok {
+			t.Skip("storage doesn't implement GraphStorage")
+		}
+		for _, name := range workspaces {
+			s := storages[name].(golightrag.GraphStorage)
+			for _, entity := range []string{"ALICE", "BOB"} {
+				require.NoError(t, s.GraphUpsertEntity(golightrag.GraphEntity{
+					Name:         entity,
+					Type:         "PERSON",
+					Descriptions: entity + " in workspace " + name,
+					SourceIDs:    "source-1",
+				}))
+			}
+			require.NoError(t, s.GraphUpsertRelationship(golightrag.GraphRelationship{
+				SourceEntity: "ALICE",
+				TargetEntity: "BOB",
+				Weight:       1,
+				Descriptions: "Alice works with Bob in workspace " + name,
+				Keywords:     []string{"colleague"},
+				SourceIDs:    "source-1",
+			}))
+		}
+		acme := storages["acme"].(golightrag.GraphStorage)
+		require.NoError(t, acme.GraphUpsertEntity(golightrag.GraphEntity{
+			Name:         "CAROL",
+			Type:         "PERSON",
+			Descriptions: "Carol is only in acme.",
+			SourceIDs:    "source-2",
+		}))
+		require.NoError(t, acme.GraphUpsertRelationship(golightrag.GraphRelationship{
+			SourceEntity: "ALICE",
+			TargetEntity: "CAROL",
+			Weight:       1,
+			Descriptions: "Alice knows Carol.",
+			Keywords:     []string{"acquaintance"},
+			SourceIDs:    "source-2",
+		}))
+
+		for _, name := range workspaces {
+			s := storages[name].(golightrag.GraphStorage)
+			alice, err := s.GraphEntity("ALICE")
+			require.NoError(t, err)
+			assert.Equal(t, "ALICE in workspace "+name, alice.Descriptions)
+
+			relationship, err := s.GraphRelationship("ALICE", "BOB")
+			require.NoError(t, err)
+			assert.Equal(t, "Alice works with Bob in workspace "+name, relationship.Descriptions)
+
+			counts, err := s.GraphCountEntitiesRelationships([]string{"ALICE"})
+			require.NoError(t, err)
+			related, err := s.GraphRelatedEntities([]string{"ALICE"})
+			require.NoError(t, err)
+			if name == "acme" {
+				assert.Equal(t, 2, counts["ALICE"])
+				assert.Len(t, related["ALICE"], 2)
+				continue
+			}
+			assert.Equal(t, 1, counts["ALICE"], "Expected workspace %q not to count acme relationships", name)
+			assert.Len(t, related["ALICE"], 1, "Expected workspace %q not to relate acme entities", name)
+			_, err = s.GraphEntity("CAROL")
+			require.ErrorIs(t, err, golightrag.ErrEntityNotFound, "Expected workspace %q not to see CAROL", name)
+			_, err = s.GraphRelationship("ALICE", "CAROL")
+			require.ErrorIs(t, err, golightrag.ErrRelationshipNotFound)
+		}
+	})
+
+	t.Run("Vector data is isolated", func(t *testing.T) {
+		storages := open(t)
+		if _, ok := storages[""].(golightrag.VectorStorage); !ok {
+			t.Skip("storage doesn't implement VectorStorage")
+		}
+		acme := storages["acme"].(golightrag.VectorStorage)
+		require.NoError(t, acme.VectorUpsertEntity("ACME", "ACME a company that sells anvils"))
+		require.NoError(t, acme.VectorUpsertRelationship("ALICE", "ACME", "ALICE ACME employment"))
+
+		for _, name := range workspaces {
+			s := storages[name].(golightrag.VectorStorage)
+			entities, err := s.VectorQueryEntity("ACME a company that sells anvils")
+			require.NoError(t, err)
+			relationships, err := s.VectorQueryRelationship("ALICE ACME employment")
+			require.NoError(t, err)
+			if name == "acme" {
+				assert.Equal(t, []string{"ACME"}, entities)
+				assert.Equal(t, [][2]string{{"ALICE", "ACME"}}, relationships)
+				continue
+			}
+			assert.Empty(t, entities, "Expected workspace %q not to see acme entities", name)
+			assert.Empty(t, relationships, "Expected workspace %q not to see acme relationships", name)
+		}
+	})
+
+	t.Run("Key-value data is isolated", func(t *testing.T) {
+		storages := open(t)
+		if _, ok := storages[""].(golightrag.KeyValueStorage); !ok {
+			t.Skip("storage doesn't implement KeyValueStorage")
+		}
+		for _, name := range workspaces {
+			s := storages[name].(golightrag.KeyValueStorage)
+			require.NoError(t, s.KVUpsertSources([]golightrag.Source{
+				{ID: "doc-chunk-0", Content: "Chunk of workspace " + name, DocumentID: "doc-" + name},
+			}))
+		}
+		acme := storages["acme"].(golightrag.KeyValueStorage)
+		require.NoError(t, acme.KVUpsertSources([]golightrag.Source{{ID: "acme-chunk-0", Content: "Acme chunk."}}))
+		if changeLog, ok := acme.(golightrag.ChangeLogStorage); ok {
+			require.NoError(t, changeLog.KVAppendChanges([]golightrag.Change{
+				{Key: "entity:ACME", Entity: "ACME", Description: "Acme is a company."},
+			}))
+		}
+
+		for _, name := range workspaces {
+			s := storages[name].(golightrag.KeyValueStorage)
+			source, err := s.KVSource("doc-chunk-0")
+			require.NoError(t, err)
+			assert.Equal(t, "Chunk of workspace "+name, source.Content)
+			assert.Equal(t, "doc-"+name, source.DocumentID)
+			if name == "acme" {
+				continue
+			}
+			_, err = s.KVSource("acme-chunk-0")
+			require.ErrorIs(t, err, golightrag.ErrSourceNotFound, "Expected workspace %q not to see acme sources", name)
+			if changeLog, ok := s.(golightrag.ChangeLogStorage); ok {
+				changes, err := changeLog.KVChanges("entity:ACME")
+				require.NoError(t, err)
+				assert.Empty(t, changes, "Expected workspace %q not to see acme changes", name)
+			}
+		}
+	})
+
+	t.Run("Source IDs don't collide with other keys", func(t *testing.T) {
+		storages := open(t)
+		if _, ok := storages[""].(golightrag.KeyValueStorage); !ok {
+			t.Skip("storage doesn't implement KeyValueStorage")
+		}
+		defaultStorage := storages[""].(golightrag.KeyValueStorage)
+		acme := storages["acme"].(golightrag.KeyValueStorage)
+		require.NoError(t, acme.KVUpsertSources([]golightrag.Source{{ID: "chunk-0", Content: "Acme chunk."}}))
+		require.NoError(t, acme.KVUpsertUnprocessed([]golightrag.Source{{ID: "chunk-0"}}))
+		if changeLog, ok := acme.(golightrag.ChangeLogStorage); ok {
+			require.NoError(t, changeLog.KVAppendChanges([]golightrag.Change{
+				{Key: "entity:ACME", Entity: "ACME", Description: "Acme is a company."},
+			}))
+		}
+
+		// IDs of the default workspace that look like the keys of other workspaces and types.
+		ids := []string{
+			"workspace:acme:chunk-0", "workspace:acme:source:chunk-0", "workspace_acme_chunk-0",
+			"unprocessed:chunk-0", "claim:claim-1", "changes:entity:ACME", "communities",
+		}
+		sources := make([]golightrag.Source, len(ids))
+		for i, id := range ids {
+			sources[i] = golightrag.Source{ID: id, Content: "Default chunk " + id}
+		}
+		require.NoError(t, defaultStorage.KVUpsertSources(sources))
+
+		for _, id := range ids {
+			source, err := defaultStorage.KVSource(id)
+			require.NoError(t, err)
+			assert.Equal(t, "Default chunk "+id, source.Content)
+			_, err = acme.KVSource(id)
+			require.ErrorIs(t, err, golightrag.ErrSourceNotFound, "Expected acme not to see source %q", id)
+			_, err = defaultStorage.KVUnprocessed(id)
+			require.Error(t, err, "Expected source %q not to be marked unprocessed", id)
+		}
+
+		source, err := acme.KVSource("chunk-0")
+		require.NoError(t, err)
+		assert.Equal(t, "Acme chunk.", source.Content)
+		_, err = acme.KVUnprocessed("chunk-0")
+		require.NoError(t, err)
+		_, err = defaultStorage.KVUnprocessed("chunk-0")
+		require.Error(t, err, "Expected the default workspace not to see acme unprocessed sources")
+
+		if changeLog, ok := acme.(golightrag.ChangeLogStorage); ok {
+			changes, err := changeLog.KVChanges("entity:ACME")
+			require.NoError(t, err)
+			assert.Len(t, changes, 1)
+			changes, err = defaultStorage.(golightrag.ChangeLogStorage).KVChanges("entity:ACME")
+			require.NoError(t, err)
+			assert.Empty(t, changes)
+		}
+		if communities, ok := defaultStorage.(golightrag.CommunityStorage); ok {
+			_, err := communities.KVCommunities()
+			require.NoError(t, err, "Expected the communities not to collide with a source")
+		}
+		if claims, ok := defaultStorage.(golightrag.ClaimStorage); ok {
+			require.NoError(t, claims.KVUpsertClaims([]golightrag.Claim{
+				{ID: "claim-1", Subject: "ACME", Type: "FRAUD", Status: golightrag.ClaimStatusTrue},
+			}))
+			source, err := defaultStorage.KVSource("claim:claim-1")
+			require.NoError(t, err)
+			assert.Equal(t, "Default chunk claim:claim-1", source.Content)
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// workspacePattern matches the workspace names, which become part of the bucket, key,
+// collection, label and table names of the storages.
+var workspacePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+// validateWorkspace returns an error if name can't be used as a workspace. The empty name is
+// the default workspace.
+func validateWorkspace(name string) error {
+	if name != "" && !workspacePattern.MatchString(name) {
+		return fmt.Errorf("invalid workspace %q: must be 1 to 64 letters, digits or underscores", name)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package golightrag
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// validityDateLayouts are the layouts of the validity dates asked to the LLM, from the most
+// precise.
+var validityDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// WithAsOf restricts the Query context to what was valid at t: relationships are kept if their
+// current or a previous version was valid at t, with the description of that version, and the
+// entities and sources retrieved through the other relationships are left out. Entities found
+// by name or vector search have no validity of their own, so they are kept.
+func WithAsOf(t time.Time) Option {
+	return option("WithAsOf", callQuery, func(o *options) {
+		o.asOf = t
+	})
+}
+
+// parseDate parses a validity date, returning the zero time for unknown or invalid dates.
+// Dates less precise than a day start their period, or end it if end is true, so "2015" ends
+// on December 31, 2015.
+func parseDate(date *string, end bool) time.Time {
+	if date == nil {
+		return time.Time{}
+	}
+	value := strings.TrimSpace(*date)
+	for i, layout := range validityDateLayouts {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if end {
+			switch i {
+			case 1:
+				t = t.AddDate(0, 1, -1)
+			case 2:
+				t = t.AddDate(1, 0, -1)
+			}
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// validAt reports whether the period from validFrom to validTo contains t.
+func validAt(validFrom, validTo, t time.Time) bool {
+	if !validFrom.IsZero() && t.Before(validFrom) {
+		return false
+	}
+	// ValidTo is inclusive of its whole day.
+	return validTo.IsZero() || t.Before(validTo.AddDate(0, 0, 1))
+}
+
+// relationshipAsOf returns the version of relationship valid at t, the current one first, and
+// whether there is one.
+func relationshipAsOf(relationship GraphRelationship, t time.Time) (GraphRelationship, bool) {
+	if validAt(relationship.ValidFrom, relationship.ValidTo, t) {
+		return relationship, true
+	}
+	for _, version := range slices.Backward(relationship.History) {
+		if validAt(version.ValidFrom, version.ValidTo, t) {
+			relationship.Descriptions = version.Descriptions
+			relationship.SourceIDs = version.SourceIDs
+			relationship.ValidFrom = version.ValidFrom
+			relationship.ValidTo = version.ValidTo
+			return relationship, true
+		}
+	}
+	return GraphRelationship{}, false
+}
+
+// relationshipsAsOf returns the versions of the relationships valid at t, or the relationships
+// unchanged if t is zero.
+func relationshipsAsOf(relationships map[string]GraphRelationship, t time.Time) map[string]GraphRelationship {
+	if t.IsZero() {
+		return relationships
+	}
+	result := make(map[string]GraphRelationship, len(relationships))
+	for key, relationship := range relationships {
+		if version, ok := relationshipAsOf(relationship, t); ok {
+			result[key] = version
+		}
+	}
+	return result
+}
+
+// mergeValidity returns the validity of a relationship merged with the extracted relationships,
+// which take the period stated by the last of them that states one.
+func mergeValidity(validFrom, validTo time.Time, relationships []GraphRelationship) (time.Time, time.Time) {
+	for _, relationship := range slices.Backward(relationships) {
+		if !relationship.ValidFrom.IsZero() || !relationship.ValidTo.IsZero() {
+			return relationship.ValidFrom, relationship.ValidTo
+		}
+	}
+	return validFrom, validTo
+}
+
+// relationshipHistory returns the history of existing with the version it's replaced by merged
+// recorded, if merged changes its validity. Merges that only add to the description don't
+// record a version, since the merged description still holds for the same period, so the
+// history grows with the periods of the relationship rather than with its merges.
+func relationshipHistory(existing, merged GraphRelationship) []RelationshipVersion {
+	if existing.ValidFrom.Equal(merged.ValidFrom) && existing.ValidTo.Equal(merged.ValidTo) {
+		return existing.History
+	}
+	// Relationships stored before UpdatedAt was tracked only have their CreatedAt, if any.
+	recordedAt := existing.UpdatedAt
+	if recordedAt.IsZero() {
+		recordedAt = existing.CreatedAt
+	}
+	return append(slices.Clone(existing.History), RelationshipVersion{
+		Descriptions: existing.Descriptions,
+		SourceIDs:    existing.SourceIDs,
+		ValidFrom:    existing.ValidFrom,
+		ValidTo:      existing.ValidTo,
+		CreatedAt:    recordedAt,
+	})
+}
@@ -0,0 +1,210 @@
+package golightrag
+
+import (
+	"maps"
+	"strings"
+	"sync"
+
+	llmod "github.com/MegaGrindStone/go-light-rag/llm"
+)
+
+// Operation identifies the pipeline step an LLM call was made for.
+type Operation string
+
+// Defines the operations whose usage is recorded by a UsageCollector.
+const (
+	OperationExtraction        Operation = "extraction"
+	OperationGlean             Operation = "glean"
+	OperationGleanDecision     Operation = "glean_decision"
+	OperationSummarization     Operation = "summarization"
+	OperationKeywordExtraction Operation = "keyword_extraction"
+	OperationSemanticChunking  Operation = "semantic_chunking"
+	OperationEntityResolution  Operation = "entity_resolution"
+	OperationClaimExtraction   Operation = "claim_extraction"
+	OperationCommunityReport   Operation = "community_report"
+	OperationCommunityQuery    Operation = "community_query"
+)
+
+// UsageEvent describes the usage of a single LLM call.
+type UsageEvent struct {
+	Operation Operation
+	Usage     llmod.Usage
+	// Estimated is true if the LLM didn't report its usage and the tokens were counted
+	// with the collector's tokenizer instead.
+	Estimated bool
+	// Cost is the cost of the call in US dollars, or zero if the model has no known price.
+	Cost   float64
+	Priced bool
+}
+
+// UsageStats aggregates the usage of a number of LLM calls.
+type UsageStats struct {
+	Calls            int
+	PromptTokens     int
+	CompletionTokens int
+	// Cost is the estimated cost in US dollars of the calls whose model has a known price.
+	Cost float64
+	// EstimatedCalls is the number of calls whose tokens were counted locally.
+	EstimatedCalls int
+	// UnpricedCalls is the number of calls whose model has no known price, and so
+	// aren't included in Cost.
+	UnpricedCalls int
+}
+
+// UsageReport is a snapshot of the usage recorded by a UsageCollector.
+type UsageReport struct {
+	Total      UsageStats
+	Operations map[Operation]UsageStats
+	Models     map[string]UsageStats
+}
+
+// UsageCollector aggregates the token usage and cost of LLM calls per operation and model.
+// The zero value is ready to use and prices models with llm.DefaultPrices.
+// It's safe for concurrent use.
+type UsageCollector struct {
+	// Prices is used to estimate the cost of each call. If nil, llm.DefaultPrices is used.
+	Prices llmod.PriceTable
+	// Tokenizer counts the tokens of calls to LLMs that don't report their usage.
+	// If nil, the default tokenizer is used.
+	Tokenizer llmod.Tokenizer
+	// OnUsage, if set, is called after every LLM call.
+	OnUsage func(UsageEvent)
+
+	mu     sync.Mutex
+	total  UsageStats
+	ops    map[Operation]UsageStats
+	models map[string]UsageStats
+}
+
+type trackedLLM struct {
+	op        Operation
+	llm       LLM
+	collector *UsageCollector
+}
+
+// Track returns an LLM that records the usage of every call to llm under op.
+// It's used internally for each pipeline stage, and can be used to record the usage of
+// LLMs called outside of Insert and Query, such as the LLM of the semantic chunking handler:
+//
+//	handler.Semantic{LLM: collector.Track(golightrag.OperationSemanticChunking, llm)}
+func (c *UsageCollector) Track(op Operation, llm LLM) LLM {
+	return trackedLLM{op: op, llm: llm, collector: c}
+}
+
+// Report returns the usage recorded so far.
+func (c *UsageCollector) Report() UsageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return UsageReport{
+		Total:      c.total,
+		Operations: maps.Clone(c.ops),
+		Models:     maps.Clone(c.models),
+	}
+}
+
+// Reset discards the usage recorded so far.
+func (c *UsageCollector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.total = UsageStats{}
+	c.ops = nil
+	c.models = nil
+}
+
+func (c *UsageCollector) record(op Operation, prompt, response string, usage llmod.Usage) llmod.Usage {
+	ev := UsageEvent{Operation: op, Usage: usage}
+	if usage.TotalTokens() == 0 {
+		// The LLM doesn't report usage, count the tokens ourselves. Counting errors are ignored,
+		// a missing estimate shouldn't fail the call.
+		ev.Estimated = true
+		ev.Usage.PromptTokens, _ = llmod.CountTokens(c.Tokenizer, prompt)
+		ev.Usage.CompletionTokens, _ = llmod.CountTokens(c.Tokenizer, response)
+	}
+
+	prices := c.Prices
+	if prices == nil {
+		prices = llmod.DefaultPrices
+	}
+	ev.Cost, ev.Priced = prices.Cost(ev.Usage)
+
+	c.mu.Lock()
+	if c.ops == nil {
+		c.ops = make(map[Operation]UsageStats)
+		c.models = make(map[string]UsageStats)
+	}
+	c.total = c.total.add(ev)
+	c.ops[op] = c.ops[op].add(ev)
+	c.models[ev.Usage.Model] = c.models[ev.Usage.Model].add(ev)
+	c.mu.Unlock()
+
+	if c.OnUsage != nil {
+		c.OnUsage(ev)
+	}
+	return ev.Usage
+}
+
+func (s UsageStats) add(ev UsageEvent) UsageStats {
+	s.Calls++
+	s.PromptTokens += ev.Usage.PromptTokens
+	s.CompletionTokens += ev.Usage.CompletionTokens
+	s.Cost += ev.Cost
+	if ev.Estimated {
+		s.EstimatedCalls++
+	}
+	if !ev.Priced {
+		s.UnpricedCalls++
+	}
+	return s
+}
+
+// Chat calls the wrapped LLM and records the usage of the call. Failed calls aren't recorded.
+func (t trackedLLM) Chat(messages []string) (string, error) {
+	res, _, err := t.ChatWithUsage(messages)
+	return res, err
+}
+
+// ChatWithUsage calls the wrapped LLM, records the usage of the call and returns it, so
+// collectors can be nested.
+func (t trackedLLM) ChatWithUsage(messages []string) (string, llmod.Usage, error) {
+	return t.do(strings.Join(messages, "\n"), func() (string, llmod.Usage, error) {
+		return llmod.ChatWithUsage(t.llm, messages)
+	})
+}
+
+// SupportsStructuredOutput reports whether the wrapped LLM supports structured output.
+func (t trackedLLM) SupportsStructuredOutput() bool {
+	return llmod.SupportsStructuredOutput(t.llm)
+}
+
+// ChatStructured calls the wrapped LLM with schema and records the usage of the call.
+func (t trackedLLM) ChatStructured(messages []string, schema llmod.Schema) (string, llmod.Usage, error) {
+	return t.do(strings.Join(messages, "\n"), func() (string, llmod.Usage, error) {
+		return llmod.ChatStructured(t.llm, messages, schema)
+	})
+}
+
+// Complete calls the wrapped LLM with req and records the usage of the call.
+func (t trackedLLM) Complete(req llmod.ChatRequest) (llmod.ChatResponse, error) {
+	prompt := make([]string, 0, len(req.Messages)+1)
+	if req.System != "" {
+		prompt = append(prompt, req.System)
+	}
+	for _, msg := range req.Messages {
+		prompt = append(prompt, msg.Content)
+	}
+	res, usage, err := t.do(strings.Join(prompt, "\n"), func() (string, llmod.Usage, error) {
+		res, err := llmod.Complete(t.llm, req)
+		return res.Content, res.Usage, err
+	})
+	return llmod.ChatResponse{Content: res, Usage: usage}, err
+}
+
+func (t trackedLLM) do(prompt string, call func() (string, llmod.Usage, error)) (string, llmod.Usage, error) {
+	res, usage, err := call()
+	if err != nil {
+		return "", llmod.Usage{}, err
+	}
+	return res, t.collector.record(t.op, prompt, res, usage), nil
+}
@@ -0,0 +1,132 @@
+package golightrag_test
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+
+	golightrag "github.com/MegaGrindStone/go-light-rag"
+	"github.com/MegaGrindStone/go-light-rag/llm"
+)
+
+// MockUsageLLM is a MockLLM that reports a fixed usage for every call.
+type MockUsageLLM struct {
+	*MockLLM
+	usage llm.Usage
+}
+
+func (m *MockUsageLLM) ChatWithUsage(messages []string) (string, llm.Usage, error) {
+	res, err := m.Chat(messages)
+	return res, m.usage, err
+}
+
+func TestUsageCollector(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("Insert records usage per operation", func(t *testing.T) {
+		mockLLM := &MockUsageLLM{
+			MockLLM: &MockLLM{
+				chatResponse: `{"entities": [{"entity_name": "ENTITY1", "entity_type": "PERSON", ` +
+					`"entity_description": "Entity1"}], "relationships": []}`,
+				chatCalls: make([][]string, 0),
+			},
+			usage: llm.Usage{Model: "gpt-4o-mini-2024-07-18", PromptTokens: 1000, CompletionTokens: 100},
+		}
+		handler := &MockDocumentHandler{
+			sources: []golightrag.Source{
+				{Content: "Test content", TokenSize: 2, OrderIndex: 0},
+			},
+			entityExtractionPromptData: golightrag.EntityExtractionPromptData{
+				Goal:        "Extract entities",
+				EntityTypes: []string{"PERSON"},
+				Language:    "English",
+			},
+			maxRetries:  1,
+			gleanCount:  1,
+			maxTokenLen: 1000,
+		}
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+
+		var events []golightrag.UsageEvent
+		collector := &golightrag.UsageCollector{
+			OnUsage: func(ev golightrag.UsageEvent) { events = append(events, ev) },
+		}
+
+		doc := golightrag.Document{ID: "test-doc", Content: "Test content"}
+		if err := golightrag.Insert(doc, handler, storage, mockLLM, logger, golightrag.WithUsage(collector)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		report := collector.Report()
+		calls := len(mockLLM.chatCalls)
+		if report.Total.Calls != calls || len(events) != calls {
+			t.Errorf("Expected %d calls and events, got %d calls and %d events", calls, report.Total.Calls, len(events))
+		}
+		if report.Operations[golightrag.OperationExtraction].Calls != 1 {
+			t.Errorf("Expected 1 extraction call, got %+v", report.Operations)
+		}
+		if report.Operations[golightrag.OperationGlean].Calls != 1 {
+			t.Errorf("Expected 1 glean call, got %+v", report.Operations)
+		}
+		if report.Total.PromptTokens != 1000*calls || report.Total.CompletionTokens != 100*calls {
+			t.Errorf("Expected reported tokens to be summed, got %+v", report.Total)
+		}
+		// gpt-4o-mini costs $0.15 per million prompt tokens and $0.60 per million completion tokens.
+		expectedCost := float64(calls) * (1000*0.15 + 100*0.60) / 1e6
+		if math.Abs(report.Total.Cost-expectedCost) > 1e-12 {
+			t.Errorf("Expected cost %f, got %f", expectedCost, report.Total.Cost)
+		}
+		if report.Models["gpt-4o-mini-2024-07-18"].Calls != calls {
+			t.Errorf("Expected all calls to be recorded for the model, got %+v", report.Models)
+		}
+	})
+
+	t.Run("Query estimates usage of LLMs without usage reporting", func(t *testing.T) {
+		mockLLM := &MockLLM{
+			chatResponse: `{"high_level_keywords": ["Knowledge"], "low_level_keywords": ["Entity1"]}`,
+		}
+		storage := &MockStorage{
+			entities:      make(map[string]golightrag.GraphEntity),
+			relationships: make(map[string]golightrag.GraphRelationship),
+		}
+		handler := &MockQueryHandler{}
+
+		collector := &golightrag.UsageCollector{Tokenizer: wordTokenizer{}}
+		conversations := []golightrag.QueryConversation{
+			{Role: golightrag.RoleUser, Message: "Tell me about Entity1"},
+		}
+		if _, err := golightrag.Query(conversations, handler, storage, mockLLM, logger,
+			golightrag.WithUsage(collector)); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		stats := collector.Report().Operations[golightrag.OperationKeywordExtraction]
+		if stats.Calls != 1 || stats.EstimatedCalls != 1 || stats.UnpricedCalls != 1 {
+			t.Errorf("Expected 1 estimated, unpriced call, got %+v", stats)
+		}
+		if stats.PromptTokens == 0 || stats.CompletionTokens != 4 {
+			t.Errorf("Expected tokens to be counted with the tokenizer, got %+v", stats)
+		}
+
+		collector.Reset()
+		if report := collector.Report(); report.Total.Calls != 0 || len(report.Operations) != 0 {
+			t.Errorf("Expected an empty report after reset, got %+v", report)
+		}
+	})
+}
+
+// wordTokenizer counts whitespace separated words as tokens.
+type wordTokenizer struct{}
+
+func (wordTokenizer) Encode(text string) ([]int, error) {
+	ids := make([]int, 0)
+	for range strings.Fields(text) {
+		ids = append(ids, len(ids))
+	}
+	return ids, nil
+}
@@ -0,0 +1,43 @@
+package golightrag
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WorkspaceStorage is implemented by storages that can keep the data of several workspaces,
+// such as the knowledge bases of different tenants, strictly apart while sharing the same
+// backends. WithWorkspace selects the workspace of a call.
+type WorkspaceStorage interface {
+	// InWorkspace returns the storage scoped to the workspace, which sees only the data stored
+	// through it. The empty workspace is the default one, holding the data stored without a
+	// workspace.
+	InWorkspace(workspace string) (Storage, error)
+}
+
+// WithWorkspace runs Insert, InsertBatch, InsertChunk, InsertChunks, ProcessUnprocessedChunk,
+// Query, UpdateCommunities or QueryCommunities in the workspace, with the storage's
+// WorkspaceStorage.
+// The empty workspace uses the storage as given.
+func WithWorkspace(workspace string) Option {
+	return option("WithWorkspace", callsAll, func(o *options) {
+		o.workspace = workspace
+	})
+}
+
+// workspaceStorage returns storage scoped to the workspace selected by WithWorkspace, or storage
+// itself if none is.
+func (o options) workspaceStorage(storage Storage) (Storage, error) {
+	if o.workspace == "" {
+		return storage, nil
+	}
+	workspaceStorage, ok := storage.(WorkspaceStorage)
+	if !ok {
+		return nil, errors.New("workspace requires a storage implementing WorkspaceStorage")
+	}
+	scoped, err := workspaceStorage.InWorkspace(o.workspace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workspace %q: %w", o.workspace, err)
+	}
+	return scoped, nil
+}